@@ -0,0 +1,661 @@
+// Achievements: completion streaks, levels from cumulative points and
+// badges awarded when a completion reaches one of their goals.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadgeNotFound is wrapped by errors reporting a missing badge.
+var ErrBadgeNotFound = errors.New("badge not found")
+
+// BadgeKind is what a badge's threshold counts.
+type BadgeKind string
+
+const (
+	// BadgeCompletions counts approved completions, of one task or of all.
+	BadgeCompletions BadgeKind = "completions"
+	// BadgeStreak counts consecutive days with a completion, of one task or
+	// of any.
+	BadgeStreak BadgeKind = "streak"
+	// BadgePoints counts cumulative points; see earnedPoints.
+	BadgePoints BadgeKind = "points"
+)
+
+func ParseBadgeKind(value string) (BadgeKind, error) {
+	switch kind := BadgeKind(strings.TrimSpace(value)); kind {
+	case BadgeCompletions, BadgeStreak, BadgePoints:
+		return kind, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("unknown badge kind %q: use completions, streak or points", value)}
+}
+
+// Badge is a goal and, once reached, the award. Built-in badges have no
+// owner and apply to everyone; users can add their own.
+type Badge struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Kind        BadgeKind `json:"kind"`
+	Threshold   int       `json:"threshold"`
+	// TaskID limits completions and streak badges to one task.
+	TaskID    *int       `json:"task_id"`
+	TaskName  string     `json:"task_name,omitempty"`
+	BuiltIn   bool       `json:"built_in"`
+	AwardedAt *time.Time `json:"awarded_at"`
+}
+
+// Streak is a run of consecutive days, in the server's time zone, with at
+// least one approved completion. Current counts back from today, or from
+// yesterday while today is still open.
+type Streak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+type TaskStreak struct {
+	TaskID   int    `json:"task_id"`
+	TaskName string `json:"task_name"`
+	Streak   Streak `json:"streak"`
+}
+
+// Level is derived from cumulative points: reaching level n takes
+// levelThreshold(n) points.
+type Level struct {
+	Level  int `json:"level"`
+	Points int `json:"points"`
+	// LevelPoints and NextLevelPoints are the thresholds of this level and
+	// the next.
+	LevelPoints     int `json:"level_points"`
+	NextLevelPoints int `json:"next_level_points"`
+}
+
+// Achievements is everything the achievements panel shows.
+type Achievements struct {
+	Level       Level         `json:"level"`
+	Streak      Streak        `json:"streak"`
+	TaskStreaks []*TaskStreak `json:"task_streaks"`
+	Badges      []*Badge      `json:"badges"`
+}
+
+// levelThreshold is the points needed for level: 0, 100, 300, 600, 1000 and
+// so on, each level taking 100 more than the one before. Thresholds too
+// large for an int are math.MaxInt.
+func levelThreshold(level int) int {
+	if level > 1 && level-1 > math.MaxInt/50/level {
+		return math.MaxInt
+	}
+	return 50 * level * (level - 1)
+}
+
+// Gained and Span are the progress through the current level.
+func (level Level) Gained() int { return level.Points - level.LevelPoints }
+func (level Level) Span() int   { return level.NextLevelPoints - level.LevelPoints }
+
+// levelFor solves levelThreshold(level) <= points for the highest level:
+// 50n(n-1) <= points is n(n-1) <= points/50, which holds while
+// (2n-1)^2 <= 4(points/50)+1.
+func levelFor(points int) Level {
+	level := 1
+	if points > 0 {
+		level = (isqrt(4*(points/50)+1) + 1) / 2
+	}
+	return Level{Level: level, Points: points, LevelPoints: levelThreshold(level), NextLevelPoints: levelThreshold(level + 1)}
+}
+
+// isqrt is the largest r with r*r <= value, for value >= 0. The float
+// estimate is corrected in both directions, since float64 cannot hold
+// every int.
+func isqrt(value int) int {
+	root := int(math.Sqrt(float64(value)))
+	for root > 0 && root > value/root {
+		root--
+	}
+	for root+1 <= value/(root+1) {
+		root++
+	}
+	return root
+}
+
+// earnedPoints is what levels and points badges count: the points of
+// approved completions, less those of completions since removed. Other
+// adjustments and what was spent on rewards do not count, so a balance
+// corrected by hand never earns a level or badge.
+func earnedPoints(ctx context.Context, querier rowQuerier, userID int) (int, error) {
+	var points int
+	err := querier.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(points), 0) FROM point_entries
+		WHERE user_id = ? AND (kind = ? OR (kind = ? AND completion_id IS NOT NULL))`,
+		userID, EntryEarn, EntryAdjust).Scan(&points)
+	return points, err
+}
+
+// streakFrom measures the streak of a set of days, each the local midnight
+// of a day with a completion.
+func streakFrom(days map[time.Time]bool, now time.Time) Streak {
+	sorted := make([]time.Time, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	var streak Streak
+	run := 0
+	for i, day := range sorted {
+		if i > 0 && sorted[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		streak.Longest = max(streak.Longest, run)
+	}
+
+	day := startOfDay(now)
+	if !days[day] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		streak.Current++
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak
+}
+
+// completionDays returns the days on which userID has approved completions,
+// grouped by task. Overall streaks use the union of all tasks.
+func completionDays(ctx context.Context, querier queryer, userID int) (map[int]map[time.Time]bool, error) {
+	rows, err := querier.QueryContext(ctx, `
+		SELECT completion.task_id, completion.completed_at
+		FROM completions completion
+		WHERE completion.user_id = ? AND `+approvedCompletionsSQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := map[int]map[time.Time]bool{}
+	for rows.Next() {
+		var taskID int
+		var completedAt time.Time
+		if err := rows.Scan(&taskID, &completedAt); err != nil {
+			return nil, err
+		}
+		if days[taskID] == nil {
+			days[taskID] = map[time.Time]bool{}
+		}
+		days[taskID][startOfDay(completedAt.In(time.Local))] = true
+	}
+	return days, rows.Err()
+}
+
+func allDays(days map[int]map[time.Time]bool) map[time.Time]bool {
+	union := map[time.Time]bool{}
+	for _, taskDays := range days {
+		for day := range taskDays {
+			union[day] = true
+		}
+	}
+	return union
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// badgeColumns is selected by every query that builds a Badge with
+// scanBadge, from badgesFromSQL.
+const badgeColumns = `badge.id, badge.name, badge.description, badge.kind, badge.threshold, badge.task_id,
+	(SELECT name FROM tasks WHERE tasks.id = badge.task_id), badge.user_id IS NULL, award.awarded_at`
+
+// badgesFromSQL joins badges to the viewing user's awards, whose ID is bound
+// first.
+const badgesFromSQL = `badges badge
+	LEFT JOIN user_badges award ON award.badge_id = badge.id AND award.user_id = ?`
+
+// visibleBadgesSQL matches the built-in badges and userID's own.
+const visibleBadgesSQL = `(badge.user_id IS NULL OR badge.user_id = ?)`
+
+func scanBadge(row rowScanner) (*Badge, error) {
+	badge := &Badge{}
+	var taskID sql.NullInt64
+	var taskName sql.NullString
+	var awardedAt sql.NullTime
+	if err := row.Scan(&badge.ID, &badge.Name, &badge.Description, &badge.Kind, &badge.Threshold, &taskID,
+		&taskName, &badge.BuiltIn, &awardedAt); err != nil {
+		return nil, err
+	}
+	badge.TaskID = nullableID(taskID)
+	badge.TaskName = taskName.String
+	if awardedAt.Valid {
+		badge.AwardedAt = &awardedAt.Time
+	}
+	return badge, nil
+}
+
+// GetBadges lists the badges userID can earn, awarded ones first.
+func GetBadges(ctx context.Context, querier queryer, userID int) ([]*Badge, error) {
+	rows, err := querier.QueryContext(ctx, `
+		SELECT `+badgeColumns+`
+		FROM `+badgesFromSQL+`
+		WHERE `+visibleBadgesSQL+`
+		ORDER BY badge.kind, badge.threshold, badge.id`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := []*Badge{}
+	for rows.Next() {
+		badge, err := scanBadge(rows)
+		if err != nil {
+			return nil, err
+		}
+		badges = append(badges, badge)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(badges, func(i, j int) bool { return badges[i].AwardedAt != nil && badges[j].AwardedAt == nil })
+	return badges, nil
+}
+
+// progress is how far userID has come toward each kind of badge.
+type progress struct {
+	completions map[int]int
+	days        map[int]map[time.Time]bool
+	points      int
+	now         time.Time
+}
+
+func loadProgress(ctx context.Context, transaction *sql.Tx, userID int) (*progress, error) {
+	days, err := completionDays(ctx, transaction, userID)
+	if err != nil {
+		return nil, err
+	}
+	points, err := earnedPoints(ctx, transaction, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := &progress{completions: map[int]int{}, days: days, points: points, now: time.Now()}
+	rows, err := transaction.QueryContext(ctx, `
+		SELECT completion.task_id, COUNT(*)
+		FROM completions completion
+		WHERE completion.user_id = ? AND `+approvedCompletionsSQL+`
+		GROUP BY completion.task_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var taskID, count int
+		if err := rows.Scan(&taskID, &count); err != nil {
+			return nil, err
+		}
+		current.completions[taskID] = count
+	}
+	return current, rows.Err()
+}
+
+// reached reports whether badge's goal is met. Streak badges look at the
+// longest streak, so a badge earned once is never out of reach again.
+func (current *progress) reached(badge *Badge) bool {
+	switch badge.Kind {
+	case BadgeCompletions:
+		if badge.TaskID != nil {
+			return current.completions[*badge.TaskID] >= badge.Threshold
+		}
+		total := 0
+		for _, count := range current.completions {
+			total += count
+		}
+		return total >= badge.Threshold
+	case BadgeStreak:
+		days := allDays(current.days)
+		if badge.TaskID != nil {
+			days = current.days[*badge.TaskID]
+		}
+		return streakFrom(days, current.now).Longest >= badge.Threshold
+	case BadgePoints:
+		return current.points >= badge.Threshold
+	}
+	return false
+}
+
+// awardBadges awards userID every badge whose goal has now been reached and
+// returns the new ones. It runs in the transaction that records an approved
+// completion, so a badge is awarded with the completion that earned it.
+func awardBadges(ctx context.Context, transaction *sql.Tx, userID int) ([]*Badge, error) {
+	badges, err := GetBadges(ctx, transaction, userID)
+	if err != nil {
+		return nil, err
+	}
+	var current *progress
+	awarded := []*Badge{}
+	for _, badge := range badges {
+		if badge.AwardedAt != nil {
+			continue
+		}
+		if current == nil {
+			if current, err = loadProgress(ctx, transaction, userID); err != nil {
+				return nil, err
+			}
+		}
+		if !current.reached(badge) {
+			continue
+		}
+		awardedAt := current.now
+		if _, err := transaction.ExecContext(ctx, "INSERT INTO user_badges (badge_id, user_id, awarded_at) VALUES (?, ?, ?)",
+			badge.ID, userID, awardedAt); err != nil {
+			return nil, err
+		}
+		badge.AwardedAt = &awardedAt
+		awarded = append(awarded, badge)
+	}
+	return awarded, nil
+}
+
+// GetAchievements returns userID's level, streaks and badges.
+func GetAchievements(ctx context.Context, db *Database, userID int) (*Achievements, error) {
+	points, err := earnedPoints(ctx, db.Conn, userID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := completionDays(ctx, db.Conn, userID)
+	if err != nil {
+		return nil, err
+	}
+	badges, err := GetBadges(ctx, db.Conn, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	achievements := &Achievements{Level: levelFor(points), Streak: streakFrom(allDays(days), now), TaskStreaks: []*TaskStreak{}, Badges: badges}
+	tasks, err := GetTasks(db, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		streak := streakFrom(days[task.ID], now)
+		if streak.Current > 0 {
+			achievements.TaskStreaks = append(achievements.TaskStreaks, &TaskStreak{TaskID: task.ID, TaskName: task.Name, Streak: streak})
+		}
+	}
+	sort.SliceStable(achievements.TaskStreaks, func(i, j int) bool {
+		return achievements.TaskStreaks[i].Streak.Current > achievements.TaskStreaks[j].Streak.Current
+	})
+	return achievements, nil
+}
+
+// CreateBadge adds a badge of userID's own and awards it straight away if
+// its goal has already been reached.
+func CreateBadge(ctx context.Context, db *Database, userID int, badge *Badge) (*Badge, error) {
+	badge.Name = strings.TrimSpace(badge.Name)
+	if badge.Name == "" {
+		return nil, &ValidationError{"badge name cannot be empty"}
+	}
+	kind, err := ParseBadgeKind(string(badge.Kind))
+	if err != nil {
+		return nil, err
+	}
+	if badge.Threshold < 1 {
+		return nil, &ValidationError{"a badge threshold must be at least 1"}
+	}
+	if badge.TaskID != nil {
+		if kind == BadgePoints {
+			return nil, &ValidationError{"points badges cannot be limited to a task"}
+		}
+		if _, err := taskRole(ctx, db.Conn, userID, *badge.TaskID); err != nil {
+			if errors.Is(err, ErrTaskNotFound) {
+				return nil, &ValidationError{fmt.Sprintf("task not found: %d", *badge.TaskID)}
+			}
+			return nil, err
+		}
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.ExecContext(ctx, `
+		INSERT INTO badges (user_id, name, description, kind, threshold, task_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, badge.Name, strings.TrimSpace(badge.Description), kind, badge.Threshold, badge.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := awardBadges(ctx, transaction, userID); err != nil {
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	return GetBadge(db, userID, int(id))
+}
+
+func GetBadge(db *Database, userID, badgeID int) (*Badge, error) {
+	badge, err := scanBadge(db.Conn.QueryRow(`
+		SELECT `+badgeColumns+`
+		FROM `+badgesFromSQL+`
+		WHERE badge.id = ? AND `+visibleBadgesSQL, userID, badgeID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrBadgeNotFound, badgeID)
+	}
+	return badge, err
+}
+
+// DeleteBadge removes one of userID's own badges, awarded or not. Built-in
+// badges cannot be removed.
+func DeleteBadge(ctx context.Context, db *Database, userID, badgeID int) error {
+	badge, err := GetBadge(db, userID, badgeID)
+	if err != nil {
+		return err
+	}
+	if badge.BuiltIn {
+		return &PermissionError{"built-in badges cannot be removed"}
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.ExecContext(ctx, "DELETE FROM user_badges WHERE badge_id = ?", badgeID); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, "DELETE FROM badges WHERE id = ?", badgeID); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+var achievementsTemplate = template.Must(template.New("achievements").Parse(`
+        <div id="achievements">
+            {{with .Achievements}}
+            <p>
+                <strong>Level {{.Level.Level}}</strong>
+                <progress value="{{.Level.Gained}}" max="{{.Level.Span}}"></progress>
+                {{.Level.Points}} / {{.Level.NextLevelPoints}} points
+            </p>
+            <p>Streak: {{.Streak.Current}} days (longest {{.Streak.Longest}})</p>
+            {{range .TaskStreaks}}
+            <div class="streak">{{.TaskName}}: {{.Streak.Current}} days (longest {{.Streak.Longest}})</div>
+            {{end}}
+            <h3>Badges</h3>
+            {{range .Badges}}
+            <div class="badge{{if not .AwardedAt}} locked{{end}}">
+                {{if .AwardedAt}}🏅{{else}}🔒{{end}} <strong>{{.Name}}</strong>
+                {{if .Description}}- {{.Description}}{{end}}
+                {{if .AwardedAt}}<span class="awarded">{{.AwardedAt.Format "2006-01-02"}}</span>{{end}}
+                {{if not .BuiltIn}}<button hx-delete="/badges/{{.ID}}" hx-target="#badge-error" hx-confirm="Remove the {{.Name}} badge?">Remove</button>{{end}}
+            </div>
+            {{end}}
+            {{end}}
+            <span id="badge-error" class="error"></span>
+            <form hx-post="/badges" hx-target="#badge-error">
+                <input type="text" name="name" placeholder="New badge" required>
+                <select name="kind">
+                    <option value="completions">completions</option>
+                    <option value="streak">day streak</option>
+                    <option value="points">points</option>
+                </select>
+                <input type="number" name="threshold" min="1" placeholder="Goal" required>
+                <select name="task">
+                    <option value="">Any task</option>
+                    {{range .Tasks}}<option value="{{.ID}}">{{.Name}}</option>{{end}}
+                </select>
+                <input type="text" name="description" placeholder="Description">
+                <button type="submit">Add Badge</button>
+            </form>
+        </div>`))
+
+func handleAchievements(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		userID := currentUserID(request)
+		achievements, err := GetAchievements(request.Context(), appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tasks, err := GetTasks(appState.db, userID, TaskFilter{})
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		achievementsTemplate.Execute(writer, map[string]any{"Achievements": achievements, "Tasks": tasks})
+	}
+}
+
+func handleAddBadge(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		threshold, err := strconv.Atoi(request.FormValue("threshold"))
+		if err != nil {
+			http.Error(writer, "goal must be a whole number", http.StatusUnprocessableEntity)
+			return
+		}
+		badge := &Badge{
+			Name:        request.FormValue("name"),
+			Description: request.FormValue("description"),
+			Kind:        BadgeKind(request.FormValue("kind")),
+			Threshold:   threshold,
+		}
+		badge.TaskID, err = parseIDField("task", request.FormValue("task"))
+		if err == nil {
+			_, err = CreateBadge(request.Context(), appState.db, currentUserID(request), badge)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+func handleDeleteBadge(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		badgeID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid badge ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := DeleteBadge(request.Context(), appState.db, currentUserID(request), badgeID); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+type badgeRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Threshold   int    `json:"threshold"`
+	TaskID      *int   `json:"task_id"`
+}
+
+func handleAPIGetAchievements(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		achievements, err := GetAchievements(request.Context(), appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, achievements)
+	}
+}
+
+func handleAPIListBadges(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		badges, err := GetBadges(request.Context(), appState.db.Conn, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, badges)
+	}
+}
+
+func handleAPICreateBadge(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body badgeRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		badge, err := CreateBadge(request.Context(), appState.db, currentUserID(request), &Badge{
+			Name:        body.Name,
+			Description: body.Description,
+			Kind:        BadgeKind(body.Kind),
+			Threshold:   body.Threshold,
+			TaskID:      body.TaskID,
+		})
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, badge)
+	}
+}
+
+func handleAPIDeleteBadge(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		badgeID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteBadge(request.Context(), appState.db, currentUserID(request), badgeID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLevelFor(t *testing.T) {
+	tests := []struct {
+		points int
+		want   int
+	}{
+		{-50, 1},
+		{0, 1},
+		{99, 1},
+		{100, 2},
+		{299, 2},
+		{300, 3},
+		{600, 4},
+		{999, 4},
+		{1000, 5},
+		{50 * 1000 * 999, 1000},
+		{50*1000*999 - 1, 999},
+	}
+	for _, test := range tests {
+		level := levelFor(test.points)
+		if level.Level != test.want {
+			t.Errorf("levelFor(%d) = %d, want %d", test.points, level.Level, test.want)
+		}
+		if level.LevelPoints > max(test.points, 0) || level.NextLevelPoints <= test.points {
+			t.Errorf("levelFor(%d): %d points is outside [%d, %d)", test.points, test.points, level.LevelPoints, level.NextLevelPoints)
+		}
+	}
+}
+
+func TestLevelForHugeBalances(t *testing.T) {
+	done := make(chan Level, 1)
+	go func() { done <- levelFor(math.MaxInt) }()
+	select {
+	case level := <-done:
+		if level.LevelPoints > math.MaxInt || level.LevelPoints < 0 || level.NextLevelPoints < level.LevelPoints {
+			t.Errorf("levelFor(MaxInt) = %+v", level)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("levelFor(MaxInt) did not return")
+	}
+	if got := levelThreshold(math.MaxInt / 2); got != math.MaxInt {
+		t.Errorf("levelThreshold of a huge level = %d, want math.MaxInt", got)
+	}
+}
+
+func TestIsqrt(t *testing.T) {
+	for _, value := range []int{0, 1, 2, 3, 4, 15, 16, 17, 1<<52 + 1, 1<<62 - 1, math.MaxInt} {
+		root := isqrt(value)
+		if root*root > value || root+1 <= value/(root+1) {
+			t.Errorf("isqrt(%d) = %d", value, root)
+		}
+	}
+	// Perfect squares and the values just below them are where a float
+	// estimate rounds the wrong way.
+	for _, root := range []int{3037000499, 94906265} {
+		if got := isqrt(root * root); got != root {
+			t.Errorf("isqrt(%d^2) = %d", root, got)
+		}
+		if got := isqrt(root*root - 1); got != root-1 {
+			t.Errorf("isqrt(%d^2-1) = %d", root, got)
+		}
+	}
+}
+
+func TestAdjustmentsEarnNoLevels(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	member := newTestUser(t, db, "member")
+	newTestGroup(t, db, owner, map[*User]Role{member: RoleMember})
+	task := newTestTask(t, db, member.ID, &Task{Points: 150})
+
+	if _, err := AdjustPoints(ctx, db, owner.ID, member.ID, maxAdjustment, "bonus"); err != nil {
+		t.Fatal(err)
+	}
+	achievements, err := GetAchievements(ctx, db, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if achievements.Level.Level != 1 || achievements.Level.Points != 0 {
+		t.Errorf("after an adjustment: level %d with %d points, want level 1 with 0", achievements.Level.Level, achievements.Level.Points)
+	}
+
+	if _, err := CompleteTask(ctx, db, member.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if achievements, err = GetAchievements(ctx, db, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	if achievements.Level.Level != 2 || achievements.Level.Points != 150 {
+		t.Errorf("after a completion: level %d with %d points, want level 2 with 150", achievements.Level.Level, achievements.Level.Points)
+	}
+}
@@ -0,0 +1,439 @@
+// JSON REST API served under /api/v1 alongside the htmx endpoints.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIError is the body of every non-2xx API response:
+// {"error": {"status": 404, "code": "not_found", "message": "..."}}.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+func writeAPIError(writer http.ResponseWriter, status int, code, message string) {
+	writeJSON(writer, status, apiErrorEnvelope{Error: APIError{Status: status, Code: code, Message: message}})
+}
+
+// errorStatus is the status code for a domain error, shared by the HTML
+// handlers and writeAPIErrorFrom.
+func errorStatus(err error) int {
+	var validationError *ValidationError
+	var ruleError *CompletionRuleError
+	var permissionError *PermissionError
+	switch {
+	case errors.Is(err, ErrTaskNotFound), errors.Is(err, ErrCompletionNotFound), errors.Is(err, ErrProjectNotFound),
+		errors.Is(err, ErrTokenNotFound), errors.Is(err, ErrGroupNotFound), errors.Is(err, ErrMemberNotFound),
+		errors.Is(err, ErrInviteNotFound), errors.Is(err, ErrRewardNotFound), errors.Is(err, ErrBadgeNotFound),
+		errors.Is(err, ErrGoalNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &validationError):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &ruleError), errors.Is(err, ErrInsufficientPoints):
+		return http.StatusConflict
+	case errors.As(err, &permissionError):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// writeAPIErrorFrom maps domain errors onto status codes.
+func writeAPIErrorFrom(writer http.ResponseWriter, err error) {
+	var ruleError *CompletionRuleError
+	switch status := errorStatus(err); status {
+	case http.StatusNotFound:
+		writeAPIError(writer, status, "not_found", err.Error())
+	case http.StatusUnprocessableEntity:
+		writeAPIError(writer, status, "validation_failed", err.Error())
+	case http.StatusForbidden:
+		writeAPIError(writer, status, "forbidden", err.Error())
+	case http.StatusConflict:
+		if !errors.As(err, &ruleError) {
+			writeAPIError(writer, status, "insufficient_points", err.Error())
+			return
+		}
+		if ruleError.RetryAt != nil {
+			seconds := int(time.Until(*ruleError.RetryAt).Seconds()) + 1
+			writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		writeAPIError(writer, http.StatusConflict, "completion_not_allowed", err.Error())
+	default:
+		log.Printf("API request failed: %v", err)
+		writeAPIError(writer, http.StatusInternalServerError, "internal_error", "internal server error")
+	}
+}
+
+// decodeJSONBody rejects unknown fields so that typos are reported rather
+// than silently ignored.
+func decodeJSONBody(writer http.ResponseWriter, request *http.Request, destination any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(destination); err != nil {
+		writeAPIError(writer, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// pathID parses the {id} wildcard of the matched route.
+func pathID(writer http.ResponseWriter, request *http.Request) (int, bool) {
+	id, err := strconv.Atoi(request.PathValue("id"))
+	if err != nil || id < 1 {
+		writeAPIError(writer, http.StatusBadRequest, "invalid_request", "invalid id: "+request.PathValue("id"))
+		return 0, false
+	}
+	return id, true
+}
+
+// taskRequest is the body of task create and update calls. Omitted fields
+// are left unchanged; a project_id of 0 removes the task from its project,
+// a group_id of 0 makes it personal again, an empty due_at clears the due
+// date and an empty recurrence stops the task repeating.
+type taskRequest struct {
+	Name      *string   `json:"name"`
+	Points    *int      `json:"points"`
+	Notes     *string   `json:"notes"`
+	ProjectID *int      `json:"project_id"`
+	GroupID   *int      `json:"group_id"`
+	Tags      *[]string `json:"tags"`
+	DueAt     *string   `json:"due_at"`
+	Priority  *Priority `json:"priority"`
+	// Recurrence accepts an RRULE or a shorthand such as "every 3 days".
+	Recurrence       *string           `json:"recurrence"`
+	MaxCompletions   *int              `json:"max_completions"`
+	CompletionPeriod *CompletionPeriod `json:"completion_period"`
+	CooldownMinutes  *int              `json:"cooldown_minutes"`
+	OneShot          *bool             `json:"one_shot"`
+	RequiresApproval *bool             `json:"requires_approval"`
+	// AssigneeID of 0 unassigns the task.
+	AssigneeID *int `json:"assignee_id"`
+}
+
+// applyTo copies the optional fields that live on the tasks row.
+func (body taskRequest) applyTo(task *Task) error {
+	if body.Name != nil {
+		task.Name = *body.Name
+	}
+	if body.Points != nil {
+		task.Points = *body.Points
+	}
+	if body.Notes != nil {
+		task.Notes = *body.Notes
+	}
+	if body.ProjectID != nil {
+		task.ProjectID = body.ProjectID
+		if *body.ProjectID == 0 {
+			task.ProjectID = nil
+		}
+	}
+	if body.GroupID != nil {
+		task.GroupID = body.GroupID
+		if *body.GroupID == 0 {
+			task.GroupID = nil
+		}
+	}
+	if body.Priority != nil {
+		task.Priority = *body.Priority
+	}
+	if body.Recurrence != nil {
+		task.Recurrence = *body.Recurrence
+	}
+	if body.MaxCompletions != nil {
+		task.MaxCompletions = *body.MaxCompletions
+	}
+	if body.CompletionPeriod != nil {
+		task.CompletionPeriod = *body.CompletionPeriod
+	}
+	if body.CooldownMinutes != nil {
+		task.CooldownMinutes = *body.CooldownMinutes
+	}
+	if body.OneShot != nil {
+		task.OneShot = *body.OneShot
+	}
+	if body.RequiresApproval != nil {
+		task.RequiresApproval = *body.RequiresApproval
+	}
+	if body.AssigneeID != nil {
+		task.AssigneeID = body.AssigneeID
+		if *body.AssigneeID == 0 {
+			task.AssigneeID = nil
+		}
+	}
+	if body.DueAt != nil {
+		task.DueAt = nil
+		if *body.DueAt != "" {
+			due, err := time.Parse(time.RFC3339, *body.DueAt)
+			if err != nil {
+				return &ValidationError{fmt.Sprintf("invalid due_at %q: use RFC 3339", *body.DueAt)}
+			}
+			task.DueAt = &due
+		}
+	}
+	return nil
+}
+
+type completionRequest struct {
+	TaskID      int        `json:"task_id"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func handleAPIListTasks(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		filter, err := parseTaskFilter(request)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		tasks, err := GetTasks(appState.db, currentUserID(request), filter)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if tasks == nil {
+			tasks = []*Task{}
+		}
+		writeJSON(writer, http.StatusOK, tasks)
+	}
+}
+
+func handleAPICreateTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body taskRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		task := &Task{UserID: currentUserID(request)}
+		if err := body.applyTo(task); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if body.Tags != nil {
+			task.Tags = *body.Tags
+		}
+
+		if err := SaveNewTask(request.Context(), appState.db, task); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if task.GroupID != nil || task.AssigneeID != nil {
+			// Reload for the names of the group and assignee.
+			saved, err := GetTask(appState.db, task.UserID, task.ID)
+			if err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+			task = saved
+		}
+
+		writer.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%d", task.ID))
+		writeJSON(writer, http.StatusCreated, task)
+	}
+}
+
+func handleAPIGetTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		task, err := GetTask(appState.db, currentUserID(request), taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, task)
+	}
+}
+
+func handleAPIUpdateTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		var body taskRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		userID := currentUserID(request)
+		task, err := GetTask(appState.db, userID, taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		if err := body.applyTo(task); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		// Check the tags before saving the task, so that a rejected tag
+		// does not leave the rest of the change behind.
+		if body.Tags != nil {
+			if _, err := NormalizeTags(*body.Tags); err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+		}
+		if err := UpdateTask(request.Context(), appState.db, userID, task); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if body.Tags != nil {
+			if err := SetTaskTags(request.Context(), appState.db, userID, taskID, *body.Tags); err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+		}
+		// Reload for the tags and the names of the group and assignee.
+		if task, err = GetTask(appState.db, userID, taskID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, task)
+	}
+}
+
+func handleAPIDeleteTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteTask(request.Context(), appState.db, currentUserID(request), taskID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAPICompleteTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		completion, err := CompleteTask(request.Context(), appState.db, currentUserID(request), taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.Header().Set("Location", fmt.Sprintf("/api/v1/completions/%d", completion.ID))
+		writeJSON(writer, http.StatusCreated, completion)
+	}
+}
+
+func handleAPIListCompletions(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		completions, err := GetCompletions(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if completions == nil {
+			completions = []*Completion{}
+		}
+		writeJSON(writer, http.StatusOK, completions)
+	}
+}
+
+// handleAPICreateCompletion records a completion at an explicit time, e.g.
+// to backfill history. Use POST /api/v1/tasks/{id}/complete for "now".
+func handleAPICreateCompletion(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body completionRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		completedAt := time.Now()
+		if body.CompletedAt != nil {
+			completedAt = *body.CompletedAt
+		}
+
+		completion, err := CreateCompletion(request.Context(), appState.db, currentUserID(request), body.TaskID, completedAt)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.Header().Set("Location", fmt.Sprintf("/api/v1/completions/%d", completion.ID))
+		writeJSON(writer, http.StatusCreated, completion)
+	}
+}
+
+func handleAPIGetCompletion(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		completionID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		completion, err := GetCompletion(appState.db, currentUserID(request), completionID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, completion)
+	}
+}
+
+func handleAPIDeleteCompletion(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		completionID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteCompletion(request.Context(), appState.db, currentUserID(request), completionID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAPIClearCompletions(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := ClearCompletions(request.Context(), appState.db, currentUserID(request)); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAPINotFound keeps unknown /api paths from falling through to the
+// HTML home page.
+func handleAPINotFound() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writeAPIError(writer, http.StatusNotFound, "not_found", "no such endpoint: "+request.URL.Path)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestAppState serves a fresh database with the default settings.
+func newTestAppState(t *testing.T) *AppState {
+	t.Helper()
+	return &AppState{config: DefaultConfig(), db: newTestDatabase(t)}
+}
+
+// newTestToken issues an API token with scope for user.
+func newTestToken(t *testing.T, db *Database, user *User, scope TokenScope) string {
+	t.Helper()
+	token, err := CreateAPIToken(context.Background(), db, user.ID, "test", scope)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	return token.Token
+}
+
+// serveAPI sends one request through the router, authenticated with token
+// when it is not empty.
+func serveAPI(appState *AppState, token, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	newRouter(appState).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAPITasks(t *testing.T) {
+	appState := newTestAppState(t)
+	token := newTestToken(t, appState.db, newTestUser(t, appState.db, "alice"), ScopeFull)
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"POST", "/api/v1/tasks", `{"name": "Dishes", "points": 5}`, http.StatusCreated, `"name":"Dishes"`},
+		{"POST", "/api/v1/tasks", `{"name": ""}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"POST", "/api/v1/tasks", `{"name": "Typo", "pionts": 5}`, http.StatusBadRequest, "invalid_request"},
+		{"POST", "/api/v1/tasks", `{"name": `, http.StatusBadRequest, "invalid JSON body"},
+		{"GET", "/api/v1/tasks", "", http.StatusOK, `"name":"Dishes"`},
+		{"GET", "/api/v1/tasks/1", "", http.StatusOK, `"points":5`},
+		{"GET", "/api/v1/tasks/99", "", http.StatusNotFound, "not_found"},
+		{"GET", "/api/v1/tasks/zero", "", http.StatusBadRequest, "invalid id"},
+		{"PATCH", "/api/v1/tasks/1", `{"notes": "after dinner"}`, http.StatusOK, `"notes":"after dinner"`},
+		{"PATCH", "/api/v1/tasks/1", `{"due_at": "tomorrow"}`, http.StatusUnprocessableEntity, "RFC 3339"},
+		{"PATCH", "/api/v1/tasks/1", `{"name": "Renamed", "tags": ["a,b"]}`, http.StatusUnprocessableEntity, "comma"},
+		{"GET", "/api/v1/tasks/1", "", http.StatusOK, `"name":"Dishes"`},
+		{"POST", "/api/v1/tasks/1/complete", "", http.StatusCreated, `"points":5`},
+		{"GET", "/api/v1/completions", "", http.StatusOK, `"task_id":1`},
+		{"DELETE", "/api/v1/completions/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/v1/completions/1", "", http.StatusNotFound, "not_found"},
+		{"DELETE", "/api/v1/tasks/1", "", http.StatusNoContent, ""},
+		{"GET", "/api/v1/tasks/1", "", http.StatusNotFound, "not_found"},
+		{"GET", "/api/v1/nothing", "", http.StatusNotFound, "no such endpoint"},
+	}
+	for _, test := range tests {
+		recorder := serveAPI(appState, token, test.method, test.path, test.body)
+		if recorder.Code != test.wantStatus || !strings.Contains(recorder.Body.String(), test.wantBody) {
+			t.Errorf("%s %s: got %d %s, want %d containing %q",
+				test.method, test.path, recorder.Code, recorder.Body, test.wantStatus, test.wantBody)
+		}
+	}
+}
+
+func TestAPIRequiresCredentials(t *testing.T) {
+	appState := newTestAppState(t)
+	for _, token := range []string{"", "tt_not-a-token"} {
+		recorder := serveAPI(appState, token, "GET", "/api/v1/tasks", "")
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d, want 401", token, recorder.Code)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: 3", ErrTaskNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: 3", ErrRewardNotFound), http.StatusNotFound},
+		{&ValidationError{"bad"}, http.StatusUnprocessableEntity},
+		{fmt.Errorf("saving: %w", &ValidationError{"bad"}), http.StatusUnprocessableEntity},
+		{&CompletionRuleError{Message: "too soon"}, http.StatusConflict},
+		{fmt.Errorf("%w: 5 short", ErrInsufficientPoints), http.StatusConflict},
+		{&PermissionError{"no"}, http.StatusForbidden},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if got := errorStatus(test.err); got != test.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
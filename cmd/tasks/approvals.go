@@ -0,0 +1,264 @@
+// Completion approval: tasks that need an approver's sign-off before their
+// completions count, and tasks assigned to one member of a group.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CompletionStatus tracks a completion through review.
+type CompletionStatus string
+
+const (
+	// StatusApproved completions count toward points and history. Tasks
+	// that do not require approval record them directly.
+	StatusApproved CompletionStatus = "approved"
+	// StatusPending completions wait for an approver.
+	StatusPending CompletionStatus = "pending"
+	// StatusRejected completions are kept for the record but never count.
+	StatusRejected CompletionStatus = "rejected"
+)
+
+// approvedCompletionsSQL is the condition, on the "completion" alias, that
+// anything totalling completions must apply.
+const approvedCompletionsSQL = `completion.status = 'approved'`
+
+// completionStatus decides how a completion of task by userID, who holds
+// role on it, is recorded. Approvers, the admins and owner of the task's
+// group, never wait for their own completions; everyone else needs
+// approval when the task asks for it. An assigned task can only be
+// completed by its assignee or an approver.
+func completionStatus(task *Task, userID int, role Role) (CompletionStatus, error) {
+	if !role.atLeast(RoleMember) {
+		return "", permissionDenied(role, "complete tasks")
+	}
+	approver := role.atLeast(RoleAdmin)
+	if task.AssigneeID != nil && *task.AssigneeID != userID && !approver {
+		return "", &PermissionError{fmt.Sprintf("%s is assigned to %s", task.Name, task.AssigneeName)}
+	}
+	if task.RequiresApproval && !approver {
+		return StatusPending, nil
+	}
+	return StatusApproved, nil
+}
+
+// checkAssignee validates a task's assignee: the owner of a personal task,
+// or someone in the task's group who may complete tasks.
+func checkAssignee(ctx context.Context, transaction *sql.Tx, task *Task) error {
+	if task.AssigneeID == nil {
+		return nil
+	}
+	if task.GroupID == nil {
+		if *task.AssigneeID != task.UserID {
+			return &ValidationError{"personal tasks can only be assigned to their owner"}
+		}
+		return nil
+	}
+	role, err := groupRole(ctx, transaction, *task.AssigneeID, *task.GroupID)
+	if err != nil || !role.atLeast(RoleMember) {
+		return &ValidationError{fmt.Sprintf("user %d is not a member of the task's group who can complete tasks", *task.AssigneeID)}
+	}
+	return nil
+}
+
+// releaseAssignments unassigns a group's tasks from a member who can no
+// longer complete them.
+func releaseAssignments(ctx context.Context, db *Database, groupID, memberID int) error {
+	_, err := db.Conn.ExecContext(ctx, "UPDATE tasks SET assignee_id = NULL WHERE group_id = ? AND assignee_id = ?",
+		groupID, memberID)
+	return err
+}
+
+// GetPendingCompletions lists the completions awaiting approval that
+// userID can see: their own and those of their groups' tasks.
+func GetPendingCompletions(db *Database, userID int) ([]*Completion, error) {
+	rows, err := db.Conn.Query(`
+		SELECT `+completionColumns+`
+		FROM completions completion
+		LEFT JOIN tasks task ON completion.task_id = task.id
+		WHERE completion.status = 'pending' AND `+visibleCompletionsSQL+`
+		ORDER BY completion.id`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	completions := []*Completion{}
+	for rows.Next() {
+		completion, err := scanCompletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		completions = append(completions, completion)
+	}
+	return completions, rows.Err()
+}
+
+// ReviewCompletion approves or rejects a pending completion. Only the
+// admins and owner of the task's group may review. Approving moves a
+// repeating task on to its next occurrence, and rejecting a one-shot
+// task's completion returns the task to the list so it can be done again.
+func ReviewCompletion(ctx context.Context, db *Database, userID, completionID int, approve bool) (*Completion, error) {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	var taskID int
+	var status CompletionStatus
+	completion := &Completion{ID: completionID}
+	err = transaction.QueryRowContext(ctx, `
+		SELECT completion.task_id, completion.status, completion.user_id, completion.points, completion.completed_at, task.name
+		FROM completions completion
+		JOIN tasks task ON task.id = completion.task_id
+		WHERE completion.id = ?`, completionID).Scan(&taskID, &status, &completion.UserID, &completion.Points, &completion.CompletedAt, &completion.TaskName)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrCompletionNotFound, completionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	completion.TaskID = taskID
+	role, err := taskRole(ctx, transaction, userID, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %d", ErrCompletionNotFound, completionID)
+	}
+	if !role.atLeast(RoleAdmin) {
+		return nil, permissionDenied(role, "review completions")
+	}
+	if status != StatusPending {
+		return nil, &ValidationError{fmt.Sprintf("completion %d is already %s", completionID, status)}
+	}
+
+	status = StatusRejected
+	if approve {
+		status = StatusApproved
+	}
+	if _, err := transaction.ExecContext(ctx, "UPDATE completions SET status = ?, reviewed_by = ?, reviewed_at = ? WHERE id = ?",
+		status, userID, time.Now(), completionID); err != nil {
+		return nil, err
+	}
+	if approve {
+		// The points go to whoever completed the task, not the reviewer.
+		if err := recordEarning(ctx, transaction, completion); err != nil {
+			return nil, err
+		}
+		if _, err := awardBadges(ctx, transaction, completion.UserID); err != nil {
+			return nil, err
+		}
+		task, err := scanTask(transaction.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM tasks task WHERE task.id = ?", taskID))
+		if err != nil {
+			return nil, err
+		}
+		if err := advanceRecurrence(ctx, transaction, task, completion); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET archived_at = NULL WHERE id = ? AND one_shot = 1", taskID); err != nil {
+			return nil, err
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	return GetCompletion(db, userID, completionID)
+}
+
+var pendingTemplate = template.Must(template.New("pending").Parse(`
+        <div id="pending">
+            {{range .}}
+            <div class="completion">
+                {{.TaskName}} ({{.Points}} pts) - {{.CompletedAt.Format "2006-01-02 15:04"}} by {{.Username}}
+                {{if .CanReview}}
+                <button hx-post="/completion/approve/{{.ID}}" hx-swap="none">Approve</button>
+                <button hx-post="/completion/reject/{{.ID}}" hx-swap="none">Reject</button>
+                {{end}}
+            </div>
+            {{else}}
+            <p>Nothing is waiting for approval.</p>
+            {{end}}
+        </div>`))
+
+// pendingRow is a pending completion with whether the viewer may review it.
+type pendingRow struct {
+	*Completion
+	CanReview bool
+}
+
+func handlePendingCompletions(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		userID := currentUserID(request)
+		completions, err := GetPendingCompletions(appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rows := make([]pendingRow, 0, len(completions))
+		for _, completion := range completions {
+			role, err := taskRole(request.Context(), appState.db.Conn, userID, completion.TaskID)
+			rows = append(rows, pendingRow{Completion: completion, CanReview: err == nil && role.atLeast(RoleAdmin)})
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		pendingTemplate.Execute(writer, rows)
+	}
+}
+
+// handleReviewCompletion serves the approve and reject buttons.
+func handleReviewCompletion(approve bool) func(*AppState) http.HandlerFunc {
+	return func(appState *AppState) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			completionID, err := strconv.Atoi(request.PathValue("id"))
+			if err != nil {
+				http.Error(writer, "Invalid completion ID", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := ReviewCompletion(request.Context(), appState.db, currentUserID(request), completionID, approve); err != nil {
+				http.Error(writer, err.Error(), errorStatus(err))
+				return
+			}
+
+			writer.Header().Set("HX-Trigger", "taskChange")
+			writer.Write([]byte(""))
+		}
+	}
+}
+
+func handleAPIListPendingCompletions(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		completions, err := GetPendingCompletions(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, completions)
+	}
+}
+
+func handleAPIReviewCompletion(approve bool) func(*AppState) http.HandlerFunc {
+	return func(appState *AppState) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			completionID, ok := pathID(writer, request)
+			if !ok {
+				return
+			}
+
+			completion, err := ReviewCompletion(request.Context(), appState.db, currentUserID(request), completionID, approve)
+			if err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+			writeJSON(writer, http.StatusOK, completion)
+		}
+	}
+}
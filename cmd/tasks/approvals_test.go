@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompletionStatus(t *testing.T) {
+	assignee := 7
+	tests := []struct {
+		name    string
+		task    Task
+		userID  int
+		role    Role
+		want    CompletionStatus
+		wantErr bool
+	}{
+		{"plain task", Task{}, 1, RoleMember, StatusApproved, false},
+		{"viewer", Task{}, 1, RoleViewer, "", true},
+		{"member needs approval", Task{RequiresApproval: true}, 1, RoleMember, StatusPending, false},
+		{"admin approves themselves", Task{RequiresApproval: true}, 1, RoleAdmin, StatusApproved, false},
+		{"assignee", Task{AssigneeID: &assignee}, assignee, RoleMember, StatusApproved, false},
+		{"someone else's assignment", Task{AssigneeID: &assignee}, 1, RoleMember, "", true},
+		{"owner covers an assignment", Task{AssigneeID: &assignee, RequiresApproval: true}, 1, RoleOwner, StatusApproved, false},
+	}
+	for _, test := range tests {
+		got, err := completionStatus(&test.task, test.userID, test.role)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: got %q, %v; want %q, error %v", test.name, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	admin := newTestUser(t, db, "admin")
+	member := newTestUser(t, db, "member")
+	group := newTestGroup(t, db, owner, map[*User]Role{admin: RoleAdmin, member: RoleMember})
+	task := newTestTask(t, db, owner.ID, &Task{Name: "Bins", Points: 4, GroupID: &group.ID, RequiresApproval: true})
+	balance := func(user *User) int {
+		t.Helper()
+		ledger, err := GetLedger(db, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ledger.Balance
+	}
+	complete := func() *Completion {
+		t.Helper()
+		completion, err := CompleteTask(ctx, db, member.ID, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if completion.Status != StatusPending {
+			t.Fatalf("member's completion is %s, want pending", completion.Status)
+		}
+		return completion
+	}
+
+	approved := complete()
+	if balance(member) != 0 {
+		t.Error("a pending completion earned points")
+	}
+	var permission *PermissionError
+	if _, err := ReviewCompletion(ctx, db, member.ID, approved.ID, true); !errors.As(err, &permission) {
+		t.Errorf("member reviewing: got %v, want a PermissionError", err)
+	}
+	if _, err := ReviewCompletion(ctx, db, admin.ID, approved.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if balance(member) != 4 || balance(admin) != 0 {
+		t.Errorf("after approval member has %d and admin %d, want 4 and 0", balance(member), balance(admin))
+	}
+	var validation *ValidationError
+	if _, err := ReviewCompletion(ctx, db, owner.ID, approved.ID, false); !errors.As(err, &validation) {
+		t.Errorf("reviewing twice: got %v, want a ValidationError", err)
+	}
+
+	rejected := complete()
+	if _, err := ReviewCompletion(ctx, db, owner.ID, rejected.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if balance(member) != 4 {
+		t.Errorf("a rejected completion changed the balance to %d", balance(member))
+	}
+
+	pending, err := GetPendingCompletions(db, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d completions still pending after review", len(pending))
+	}
+}
+
+func TestRejectedOneShotReturns(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	member := newTestUser(t, db, "member")
+	group := newTestGroup(t, db, owner, map[*User]Role{member: RoleMember})
+	task := newTestTask(t, db, owner.ID, &Task{GroupID: &group.ID, RequiresApproval: true, OneShot: true})
+
+	completion, err := CompleteTask(ctx, db, member.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteTask(ctx, db, member.ID, task.ID); err == nil {
+		t.Fatal("an archived one-shot task was completed again")
+	}
+	if _, err := ReviewCompletion(ctx, db, owner.ID, completion.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteTask(ctx, db, member.ID, task.ID); err != nil {
+		t.Errorf("completing after the rejection: %v", err)
+	}
+}
+
+func TestRejectedRecurringCompletionKeepsDueDate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	member := newTestUser(t, db, "member")
+	group := newTestGroup(t, db, owner, map[*User]Role{member: RoleMember})
+	due := time.Now().Add(-time.Hour).Truncate(time.Second)
+	task := newTestTask(t, db, owner.ID, &Task{GroupID: &group.ID, RequiresApproval: true, Recurrence: "FREQ=DAILY", DueAt: &due})
+	dueAt := func() time.Time {
+		t.Helper()
+		saved, err := GetTask(db, owner.ID, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.DueAt == nil {
+			t.Fatal("the task lost its due date")
+		}
+		return *saved.DueAt
+	}
+
+	completion, err := CompleteTask(ctx, db, member.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dueAt(); !got.Equal(due) {
+		t.Errorf("a pending completion moved the due date to %v", got)
+	}
+	if _, err := ReviewCompletion(ctx, db, owner.ID, completion.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := dueAt(); !got.Equal(due) {
+		t.Errorf("after the rejection the task is due %v, want %v", got, due)
+	}
+
+	if completion, err = CompleteTask(ctx, db, member.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReviewCompletion(ctx, db, owner.ID, completion.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dueAt(), due.AddDate(0, 0, 1); !got.Equal(want) {
+		t.Errorf("after the approval the task is due %v, want %v", got, want)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	first := newTestUser(t, db, "first")
+	second := newTestUser(t, db, "second")
+	viewer := newTestUser(t, db, "viewer")
+	group := newTestGroup(t, db, owner, map[*User]Role{first: RoleMember, second: RoleMember, viewer: RoleViewer})
+
+	var validation *ValidationError
+	if err := SaveNewTask(ctx, db, &Task{UserID: owner.ID, Name: "Watch", GroupID: &group.ID, AssigneeID: &viewer.ID}); !errors.As(err, &validation) {
+		t.Errorf("assigning a viewer: got %v, want a ValidationError", err)
+	}
+	task := newTestTask(t, db, owner.ID, &Task{GroupID: &group.ID, AssigneeID: &first.ID})
+
+	var permission *PermissionError
+	if _, err := CompleteTask(ctx, db, second.ID, task.ID); !errors.As(err, &permission) {
+		t.Errorf("completing someone else's assignment: got %v, want a PermissionError", err)
+	}
+	if _, err := CompleteTask(ctx, db, first.ID, task.ID); err != nil {
+		t.Errorf("assignee completing: %v", err)
+	}
+
+	if err := SetMemberRole(ctx, db, owner.ID, group.ID, first.ID, RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteTask(ctx, db, second.ID, task.ID); err != nil {
+		t.Errorf("demoting the assignee should release the task: %v", err)
+	}
+}
+
+func TestEditFormOfGroupTask(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	owner := newTestUser(t, appState.db, "owner")
+	member := newTestUser(t, appState.db, "member")
+	creator := newTestUser(t, appState.db, "creator")
+	group := newTestGroup(t, appState.db, owner, map[*User]Role{member: RoleMember})
+	project, err := CreateProject(ctx, appState.db, creator.ID, "Secret plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := newTestTask(t, appState.db, owner.ID, &Task{Name: "Bins", GroupID: &group.ID})
+	// The task was filed in its creator's project, and the creator is no
+	// longer in the group.
+	if _, err := appState.db.Conn.Exec("UPDATE tasks SET user_id = ?, project_id = ? WHERE id = ?", creator.ID, project.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie, session, err := CreateSession(ctx, appState.db, owner, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		recorder := httptest.NewRecorder()
+		newRouter(appState).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve("GET", fmt.Sprintf("/task/%d/edit", task.ID), "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("edit form: got %d %s", recorder.Code, recorder.Body)
+	}
+	if !strings.Contains(recorder.Body.String(), ">member</option>") {
+		t.Errorf("edit form does not offer the group's members:\n%s", recorder.Body)
+	}
+	if strings.Contains(recorder.Body.String(), "Secret plans") {
+		t.Errorf("edit form shows the creator's projects:\n%s", recorder.Body)
+	}
+
+	form := url.Values{"name": {"Recycling"}, "points": {"0"}, "csrf_token": {session.CSRFToken}}
+	if recorder := serve("PATCH", fmt.Sprintf("/task/%d", task.ID), form.Encode()); recorder.Code != http.StatusOK {
+		t.Fatalf("save: got %d %s", recorder.Code, recorder.Body)
+	}
+	saved, err := GetTask(appState.db, owner.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "Recycling" || saved.ProjectID == nil || *saved.ProjectID != project.ID {
+		t.Errorf("saved task = %+v, want it renamed and still in project %d", saved, project.ID)
+	}
+}
@@ -0,0 +1,955 @@
+// CalDAV (RFC 4791) collection of tasks as VTODO resources, so reminders
+// apps can list, edit and tick off tasks. Clients sign in with the account
+// name and an API token as the password.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	davRoot       = "/dav/"
+	davCollection = "/dav/tasks/"
+
+	nsDAV            = "DAV:"
+	nsCalDAV         = "urn:ietf:params:xml:ns:caldav"
+	nsCalendarServer = "http://calendarserver.org/ns/"
+
+	// maxCalendarObjectBytes bounds a PUT; one to-do is a few hundred bytes.
+	maxCalendarObjectBytes = 1 << 20
+)
+
+// davPrefixes are the prefixes multistatus responses declare.
+var davPrefixes = map[string]string{nsDAV: "d", nsCalDAV: "c", nsCalendarServer: "cs"}
+
+var calendarDataName = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+
+// calendarObject is a task as the CalDAV collection serves it.
+type calendarObject struct {
+	Task *Task
+	// Name is the last segment of the resource's URL.
+	Name string
+	UID  string
+	Data string
+	ETag string
+}
+
+func (object *calendarObject) href() string {
+	return davCollection + url.PathEscape(object.Name)
+}
+
+// render builds the resource and its ETag. DTSTAMP is the creation time
+// rather than now so that an unchanged task keeps its ETag.
+func (object *calendarObject) render() {
+	w := &icsWriter{}
+	w.property("BEGIN", "VCALENDAR")
+	w.property("VERSION", "2.0")
+	w.property("PRODID", icsProductID)
+	writeVTODO(w, object.Task, object.UID, object.Task.CreatedAt)
+	w.property("END", "VCALENDAR")
+	object.Data = w.String()
+	sum := sha256.Sum256([]byte(object.Data))
+	object.ETag = `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// defaultObjectName names the resource of a task created here.
+func defaultObjectName(taskID int) string {
+	return fmt.Sprintf("task-%d.ics", taskID)
+}
+
+// GetCalendarObjects returns every task userID can see, active and
+// archived, as calendar objects. Trashed tasks are left out, so clients
+// drop them.
+func GetCalendarObjects(ctx context.Context, db *Database, userID int) ([]*calendarObject, error) {
+	tasks, err := GetTasks(db, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	archived, err := GetTasks(db, userID, TaskFilter{Archived: true})
+	if err != nil {
+		return nil, err
+	}
+
+	visible, args := visibleTasksSQL(userID, RoleViewer)
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT task.id, task.ical_uid, task.ical_name
+		FROM tasks task
+		WHERE task.deleted = 0 AND (task.ical_uid IS NOT NULL OR task.ical_name IS NOT NULL) AND `+visible, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type names struct{ uid, name *string }
+	chosen := make(map[int]names)
+	for rows.Next() {
+		var taskID int
+		var entry names
+		if err := rows.Scan(&taskID, &entry.uid, &entry.name); err != nil {
+			return nil, err
+		}
+		chosen[taskID] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	objects := make([]*calendarObject, 0, len(tasks)+len(archived))
+	for _, task := range append(tasks, archived...) {
+		object := &calendarObject{Task: task, Name: defaultObjectName(task.ID), UID: taskUID(task.ID)}
+		if entry, ok := chosen[task.ID]; ok {
+			if entry.uid != nil {
+				object.UID = *entry.uid
+			}
+			if entry.name != nil {
+				object.Name = *entry.name
+			}
+		}
+		object.render()
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func findCalendarObject(objects []*calendarObject, name string) *calendarObject {
+	for _, object := range objects {
+		if object.Name == name {
+			return object
+		}
+	}
+	return nil
+}
+
+// collectionTag changes whenever any resource is added, changed or removed,
+// which lets clients skip listing an unchanged collection.
+func collectionTag(objects []*calendarObject) string {
+	hash := sha256.New()
+	for _, object := range objects {
+		fmt.Fprintf(hash, "%s %s\n", object.Name, object.ETag)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:12]) + `"`
+}
+
+// icsProperty is one content line of an iCalendar document.
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// parseICSLines unfolds data and splits it into content lines.
+func parseICSLines(data string) ([]*icsProperty, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+	var properties []*icsProperty
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		// The value starts at the first colon outside a quoted parameter.
+		colon, quoted := -1, false
+		for index, character := range line {
+			if character == '"' {
+				quoted = !quoted
+			} else if character == ':' && !quoted {
+				colon = index
+				break
+			}
+		}
+		if colon < 0 {
+			return nil, &ValidationError{fmt.Sprintf("invalid iCalendar line: %q", line)}
+		}
+		parts := strings.Split(line[:colon], ";")
+		property := &icsProperty{Name: strings.ToUpper(parts[0]), Params: map[string]string{}, Value: line[colon+1:]}
+		for _, param := range parts[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			property.Params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+		properties = append(properties, property)
+	}
+	return properties, nil
+}
+
+var icsUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func icsUnescape(value string) string {
+	return icsUnescaper.Replace(value)
+}
+
+// splitICSList splits a TEXT list at the commas that are not escaped.
+func splitICSList(value string) []string {
+	var items []string
+	var item strings.Builder
+	for index := 0; index < len(value); index++ {
+		switch {
+		case value[index] == '\\' && index+1 < len(value):
+			item.WriteByte(value[index])
+			item.WriteByte(value[index+1])
+			index++
+		case value[index] == ',':
+			items = append(items, icsUnescape(item.String()))
+			item.Reset()
+		default:
+			item.WriteByte(value[index])
+		}
+	}
+	return append(items, icsUnescape(item.String()))
+}
+
+// parseICSTime reads a DATE-TIME in UTC, in a TZID or floating in local
+// time, or an all-day DATE at local midnight.
+func parseICSTime(property *icsProperty) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(property.Value, "Z") {
+		location = time.UTC
+	} else if loaded, err := time.LoadLocation(property.Params["TZID"]); err == nil && property.Params["TZID"] != "" {
+		location = loaded
+	}
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if moment, err := time.ParseInLocation(layout, property.Value, location); err == nil {
+			return moment, nil
+		}
+	}
+	return time.Time{}, &ValidationError{fmt.Sprintf("invalid %s: %s", property.Name, property.Value)}
+}
+
+// todoPriority maps RFC 5545's 1 (highest) to 9 onto priorities; 0 is none.
+func todoPriority(value string) (Priority, error) {
+	number, err := strconv.Atoi(value)
+	switch {
+	case err != nil || number < 0 || number > 9:
+		return PriorityNone, &ValidationError{fmt.Sprintf("invalid PRIORITY: %s", value)}
+	case number == 0:
+		return PriorityNone, nil
+	case number <= 4:
+		return PriorityHigh, nil
+	case number == 5:
+		return PriorityMedium, nil
+	}
+	return PriorityLow, nil
+}
+
+// calendarTodo is what a client may change about a task.
+type calendarTodo struct {
+	UID        string
+	Summary    string
+	Notes      string
+	DueAt      *time.Time
+	Priority   Priority
+	Tags       []string
+	Recurrence string
+	Completed  bool
+}
+
+// ParseCalendarTodo reads the VTODO a client PUT. Properties of nested
+// components such as VALARM, and everything this app has no field for,
+// are ignored.
+func ParseCalendarTodo(data string) (*calendarTodo, error) {
+	properties, err := parseICSLines(data)
+	if err != nil {
+		return nil, err
+	}
+	var todo *calendarTodo
+	var nesting []string
+	for _, property := range properties {
+		switch property.Name {
+		case "BEGIN":
+			nesting = append(nesting, strings.ToUpper(property.Value))
+			if strings.EqualFold(property.Value, "VTODO") {
+				if todo != nil {
+					return nil, &ValidationError{"a calendar object holds one VTODO"}
+				}
+				todo = &calendarTodo{Tags: []string{}}
+			}
+			continue
+		case "END":
+			if len(nesting) > 0 {
+				nesting = nesting[:len(nesting)-1]
+			}
+			continue
+		}
+		if todo == nil || len(nesting) == 0 || nesting[len(nesting)-1] != "VTODO" {
+			continue
+		}
+		switch property.Name {
+		case "UID":
+			todo.UID = property.Value
+		case "SUMMARY":
+			todo.Summary = icsUnescape(property.Value)
+		case "DESCRIPTION":
+			todo.Notes = icsUnescape(property.Value)
+		case "DUE":
+			due, err := parseICSTime(property)
+			if err != nil {
+				return nil, err
+			}
+			todo.DueAt = &due
+		case "PRIORITY":
+			if todo.Priority, err = todoPriority(property.Value); err != nil {
+				return nil, err
+			}
+		case "CATEGORIES":
+			todo.Tags = append(todo.Tags, splitICSList(property.Value)...)
+		case "RRULE":
+			todo.Recurrence = property.Value
+		case "STATUS":
+			todo.Completed = strings.EqualFold(property.Value, "COMPLETED")
+		case "COMPLETED":
+			todo.Completed = true
+		}
+	}
+	if todo == nil {
+		return nil, &ValidationError{"only VTODO resources can be stored"}
+	}
+	if todo.UID == "" {
+		return nil, &ValidationError{"the VTODO has no UID"}
+	}
+	return todo, nil
+}
+
+// ErrPreconditionFailed is returned when If-Match or If-None-Match does not
+// hold, typically because another client changed the task first.
+var ErrPreconditionFailed = errors.New("the resource has changed")
+
+// checkPreconditions applies a request's If-Match and If-None-Match to the
+// resource currently at its URL, nil when there is none.
+func checkPreconditions(request *http.Request, object *calendarObject) error {
+	if match := request.Header.Get("If-Match"); match != "" {
+		if object == nil || (match != "*" && match != object.ETag) {
+			return ErrPreconditionFailed
+		}
+	}
+	if request.Header.Get("If-None-Match") == "*" && object != nil {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// PutCalendarObject creates or updates the task at name from todo.
+// Marking it completed calls CompleteTask, and marking an archived task
+// as needing action again unarchives it. created reports a new task.
+//
+// A reminder is done once, so tasks created by a client are one-shot
+// unless they repeat. Each PUT runs in one transaction, so a new task
+// that fails to complete leaves nothing behind for the client to find
+// under another name, and an edit whose completion breaks a rule is not
+// saved either. An update re-reads the task inside the transaction and
+// applies precondition to it, so that two clients sending the same ETag
+// cannot both get through.
+func PutCalendarObject(ctx context.Context, db *Database, userID int, object *calendarObject, name string, todo *calendarTodo, precondition func(*calendarObject) error) (created bool, err error) {
+	if object == nil {
+		transaction, err := db.Conn.BeginTx(ctx, nil)
+		if err != nil {
+			return false, err
+		}
+		defer transaction.Rollback()
+
+		task := &Task{UserID: userID, Name: todo.Summary, Notes: todo.Notes, DueAt: todo.DueAt,
+			Priority: todo.Priority, Recurrence: todo.Recurrence, Tags: todo.Tags, OneShot: todo.Recurrence == ""}
+		if err := insertTask(ctx, transaction, task); err != nil {
+			return false, err
+		}
+		if _, err := transaction.ExecContext(ctx,
+			"UPDATE tasks SET ical_uid = ?, ical_name = ? WHERE id = ?", todo.UID, name, task.ID); err != nil {
+			return false, err
+		}
+		if todo.Completed {
+			if _, err := recordCompletion(ctx, transaction, userID, task.ID, time.Now()); err != nil {
+				return false, err
+			}
+		}
+		return true, transaction.Commit()
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer transaction.Rollback()
+
+	// Touch the row first so that this transaction holds the write lock
+	// before it reads the task; a concurrent PUT then sees this one's
+	// changes and a new ETag.
+	if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET id = id WHERE id = ?", object.Task.ID); err != nil {
+		return false, err
+	}
+	current, err := reloadCalendarObject(ctx, transaction, object)
+	if err != nil {
+		return false, err
+	}
+	if err := precondition(current); err != nil {
+		return false, err
+	}
+	if current == nil {
+		return false, fmt.Errorf("%w: %d", ErrTaskNotFound, object.Task.ID)
+	}
+	stored := current.Task
+
+	task := *stored
+	task.Name, task.Notes, task.Priority = todo.Summary, todo.Notes, todo.Priority
+	task.DueAt = todo.DueAt
+	// Without a due date the rule is not sent, so its absence says nothing.
+	if todo.Recurrence != "" || stored.DueAt != nil {
+		if task.Recurrence, err = NormalizeRecurrence(todo.Recurrence); err != nil {
+			return false, err
+		}
+	}
+	// A repeating task is never shown completed: completing it moves it on
+	// to its next occurrence. A to-do marked completed with another due
+	// date is one whose occurrence has already been completed, sent again
+	// by a client that has not seen the change; it completes nothing and
+	// leaves the schedule where it is.
+	completes := todo.Completed && stored.ArchivedAt == nil
+	if completes && stored.Recurrence != "" && !sameTime(todo.DueAt, stored.DueAt) {
+		completes = false
+		task.DueAt = stored.DueAt
+	}
+	if task.Name != stored.Name || task.Notes != stored.Notes || task.Priority != stored.Priority ||
+		task.Recurrence != stored.Recurrence || !sameTime(task.DueAt, stored.DueAt) {
+		if err := updateTask(ctx, transaction, userID, &task); err != nil {
+			return false, err
+		}
+	}
+	tags, err := NormalizeTags(todo.Tags)
+	if err != nil {
+		return false, err
+	}
+	if strings.Join(tags, ",") != strings.Join(stored.Tags, ",") {
+		if err := authorizeTask(ctx, transaction, userID, task.ID, RoleOwner, "tag tasks"); err != nil {
+			return false, err
+		}
+		if err := setTaskTags(ctx, transaction, userID, task.ID, tags); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	case completes:
+		if _, err := recordCompletion(ctx, transaction, userID, task.ID, time.Now()); err != nil {
+			return false, err
+		}
+	case !todo.Completed && stored.ArchivedAt != nil:
+		if err := authorizeTask(ctx, transaction, userID, task.ID, RoleOwner, "unarchive tasks"); err != nil {
+			return false, err
+		}
+		if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET archived_at = NULL WHERE id = ?", task.ID); err != nil {
+			return false, err
+		}
+	}
+	return false, transaction.Commit()
+}
+
+// reloadCalendarObject reads object's task again within transaction and
+// renders it under the same name and UID. It returns nil when the task
+// has been deleted since.
+func reloadCalendarObject(ctx context.Context, transaction *sql.Tx, object *calendarObject) (*calendarObject, error) {
+	task, err := scanTask(transaction.QueryRowContext(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks task
+		WHERE task.id = ? AND task.deleted = 0`, object.Task.ID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := transaction.QueryContext(ctx, `
+		SELECT tags.name
+		FROM task_tags
+		JOIN tags ON tags.id = task_tags.tag_id
+		WHERE task_tags.task_id = ?
+		ORDER BY tags.name`, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		task.Tags = append(task.Tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	current := &calendarObject{Task: task, Name: object.Name, UID: object.UID}
+	current.render()
+	return current, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// davResource is one response of a multistatus: its properties as XML,
+// or nil for a resource that does not exist.
+type davResource struct {
+	href  string
+	props map[xml.Name]string
+}
+
+// propNames collects the names of the properties a request asks for.
+type propNames []xml.Name
+
+func (names *propNames) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			*names = append(*names, token.Name)
+			if err := decoder.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type propfindRequest struct {
+	Prop     *propNames `xml:"DAV: prop"`
+	PropName *struct{}  `xml:"DAV: propname"`
+}
+
+type compFilter struct {
+	Name        string       `xml:"name,attr"`
+	CompFilters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters []struct {
+		Name         string    `xml:"name,attr"`
+		IsNotDefined *struct{} `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	} `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+type reportRequest struct {
+	XMLName xml.Name
+	Prop    *propNames `xml:"DAV: prop"`
+	Filter  struct {
+		CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+// matches applies a calendar-query filter. Only the component and whether
+// COMPLETED is defined are checked, which covers what reminders apps ask
+// for; other conditions match everything.
+func (filter *compFilter) matches(object *calendarObject) bool {
+	if filter.Name == "" || len(filter.CompFilters) == 0 {
+		return true
+	}
+	for _, todoFilter := range filter.CompFilters {
+		if todoFilter.Name != "VTODO" {
+			continue
+		}
+		for _, propFilter := range todoFilter.PropFilters {
+			if propFilter.Name == "COMPLETED" && (propFilter.IsNotDefined != nil) == (object.Task.ArchivedAt != nil) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func escapeXML(value string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(value))
+	return buffer.String()
+}
+
+func hrefXML(href string) string {
+	return "<d:href>" + escapeXML(href) + "</d:href>"
+}
+
+func principalResource(user *User) *davResource {
+	return &davResource{href: davRoot, props: map[xml.Name]string{
+		{Space: nsDAV, Local: "resourcetype"}:           "<d:collection/><d:principal/>",
+		{Space: nsDAV, Local: "displayname"}:            escapeXML(user.Username),
+		{Space: nsDAV, Local: "current-user-principal"}: hrefXML(davRoot),
+		{Space: nsDAV, Local: "principal-URL"}:          hrefXML(davRoot),
+		{Space: nsCalDAV, Local: "calendar-home-set"}:   hrefXML(davRoot),
+	}}
+}
+
+func collectionResource(objects []*calendarObject, scope TokenScope) *davResource {
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if scope.allows(ScopeFull) {
+		privileges += "<d:privilege><d:write/></d:privilege><d:privilege><d:write-content/></d:privilege>" +
+			"<d:privilege><d:bind/></d:privilege><d:privilege><d:unbind/></d:privilege>"
+	}
+	return &davResource{href: davCollection, props: map[xml.Name]string{
+		{Space: nsDAV, Local: "resourcetype"}:                        "<d:collection/><c:calendar/>",
+		{Space: nsDAV, Local: "displayname"}:                         "Tasks",
+		{Space: nsDAV, Local: "current-user-principal"}:              hrefXML(davRoot),
+		{Space: nsDAV, Local: "owner"}:                               hrefXML(davRoot),
+		{Space: nsDAV, Local: "current-user-privilege-set"}:          privileges,
+		{Space: nsCalendarServer, Local: "getctag"}:                  escapeXML(collectionTag(objects)),
+		{Space: nsCalDAV, Local: "supported-calendar-component-set"}: `<c:comp name="VTODO"/>`,
+		{Space: nsDAV, Local: "supported-report-set"}: "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>",
+	}}
+}
+
+func objectResource(object *calendarObject) *davResource {
+	return &davResource{href: object.href(), props: map[xml.Name]string{
+		{Space: nsDAV, Local: "resourcetype"}:   "",
+		{Space: nsDAV, Local: "getetag"}:        escapeXML(object.ETag),
+		{Space: nsDAV, Local: "getcontenttype"}: "text/calendar; charset=utf-8; component=VTODO",
+		calendarDataName:                        escapeXML(object.Data),
+	}}
+}
+
+// propertyXML writes an element for name around inner, which is already
+// XML. Names outside the declared namespaces bring their own.
+func propertyXML(name xml.Name, inner string) string {
+	prefix, ok := davPrefixes[name.Space]
+	if !ok {
+		return fmt.Sprintf(`<%s xmlns="%s"/>`, name.Local, escapeXML(name.Space))
+	}
+	if inner == "" {
+		return fmt.Sprintf("<%s:%s/>", prefix, name.Local)
+	}
+	return fmt.Sprintf("<%s:%s>%s</%s:%s>", prefix, name.Local, inner, prefix, name.Local)
+}
+
+// writeMultistatus answers with the requested properties of each resource,
+// or all of them but calendar-data when requested is nil. Properties a
+// resource does not have are listed as not found; namesOnly leaves out
+// the values.
+func writeMultistatus(writer http.ResponseWriter, resources []*davResource, requested []xml.Name, namesOnly bool) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	body.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="` + nsCalDAV + `" xmlns:cs="` + nsCalendarServer + `">`)
+	for _, resource := range resources {
+		names := requested
+		if names == nil {
+			for name := range resource.props {
+				if name != calendarDataName {
+					names = append(names, name)
+				}
+			}
+			sort.Slice(names, func(i, j int) bool {
+				return names[i].Space+names[i].Local < names[j].Space+names[j].Local
+			})
+		}
+		var found, missing strings.Builder
+		for _, name := range names {
+			value, ok := resource.props[name]
+			if namesOnly {
+				value = ""
+			}
+			if ok {
+				found.WriteString(propertyXML(name, value))
+			} else {
+				missing.WriteString(propertyXML(name, ""))
+			}
+		}
+		body.WriteString("<d:response>" + hrefXML(resource.href))
+		if resource.props == nil {
+			body.WriteString("<d:status>HTTP/1.1 404 Not Found</d:status></d:response>")
+			continue
+		}
+		if found.Len() > 0 {
+			body.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if missing.Len() > 0 {
+			body.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+		body.WriteString("</d:response>")
+	}
+	body.WriteString("</d:multistatus>\n")
+
+	writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	writer.WriteHeader(http.StatusMultiStatus)
+	writer.Write([]byte(body.String()))
+}
+
+// davAuthenticate signs in a CalDAV request with HTTP Basic, the account
+// name and one of its API tokens, or with a bearer token.
+func davAuthenticate(ctx context.Context, db *Database, request *http.Request) (*APIToken, *User, error) {
+	username, secret, basic := request.BasicAuth()
+	if !basic {
+		var ok bool
+		if secret, ok = bearerToken(request); !ok {
+			return nil, nil, sql.ErrNoRows
+		}
+	}
+	token, user, err := AuthenticateAPIToken(ctx, db, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if basic && username != user.Username {
+		return nil, nil, sql.ErrNoRows
+	}
+	return token, user, nil
+}
+
+// davMethods are the methods the CalDAV routes answer.
+const davMethods = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+
+// handleCalDAV serves the principal and calendar home at /dav/, the task
+// collection at /dav/tasks/ and its resources below it.
+func handleCalDAV(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodOptions {
+			writer.Header().Set("DAV", "1, 3, calendar-access")
+			writer.Header().Set("Allow", davMethods)
+			return
+		}
+
+		ctx := request.Context()
+		token, user, err := davAuthenticate(ctx, appState.db, request)
+		if errors.Is(err, sql.ErrNoRows) {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="Tasks", charset="UTF-8"`)
+			http.Error(writer, "sign in with your username and an API token as the password", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		required := ScopeRead
+		if request.Method == http.MethodPut || request.Method == http.MethodDelete {
+			required = ScopeFull
+		}
+		if !token.Scope.allows(required) {
+			http.Error(writer, fmt.Sprintf("a %s token cannot %s tasks over CalDAV", token.Scope, strings.ToLower(request.Method)), http.StatusForbidden)
+			return
+		}
+
+		objects, err := GetCalendarObjects(ctx, appState.db, user.ID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		path := request.URL.Path
+		switch {
+		case path == davRoot:
+			serveDAVPrincipal(writer, request, user, objects, token.Scope)
+		case path == davCollection || path == strings.TrimSuffix(davCollection, "/"):
+			serveDAVCollection(writer, request, objects, token.Scope)
+		case strings.HasPrefix(path, davCollection) && !strings.Contains(path[len(davCollection):], "/"):
+			serveDAVObject(writer, request, appState.db, user, objects, path[len(davCollection):])
+		default:
+			http.NotFound(writer, request)
+		}
+	}
+}
+
+// readPropfind returns the properties a PROPFIND asks for, nil for all of
+// them, and its depth.
+func readPropfind(writer http.ResponseWriter, request *http.Request) (requested []xml.Name, namesOnly bool, depth string, ok bool) {
+	depth = request.Header.Get("Depth")
+	if depth == "" {
+		depth = "infinity"
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return nil, false, "", false
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, false, depth, true
+	}
+	var propfind propfindRequest
+	if err := xml.Unmarshal(body, &propfind); err != nil {
+		http.Error(writer, "invalid PROPFIND body: "+err.Error(), http.StatusBadRequest)
+		return nil, false, "", false
+	}
+	if propfind.Prop != nil {
+		requested = *propfind.Prop
+	}
+	return requested, propfind.PropName != nil, depth, true
+}
+
+func serveDAVPrincipal(writer http.ResponseWriter, request *http.Request, user *User, objects []*calendarObject, scope TokenScope) {
+	if request.Method != "PROPFIND" {
+		writer.Header().Set("Allow", "OPTIONS, PROPFIND")
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requested, namesOnly, depth, ok := readPropfind(writer, request)
+	if !ok {
+		return
+	}
+	resources := []*davResource{principalResource(user)}
+	if depth != "0" {
+		resources = append(resources, collectionResource(objects, scope))
+	}
+	writeMultistatus(writer, resources, requested, namesOnly)
+}
+
+func serveDAVCollection(writer http.ResponseWriter, request *http.Request, objects []*calendarObject, scope TokenScope) {
+	switch request.Method {
+	case "PROPFIND":
+		requested, namesOnly, depth, ok := readPropfind(writer, request)
+		if !ok {
+			return
+		}
+		resources := []*davResource{collectionResource(objects, scope)}
+		if depth != "0" {
+			for _, object := range objects {
+				resources = append(resources, objectResource(object))
+			}
+		}
+		writeMultistatus(writer, resources, requested, namesOnly)
+	case "REPORT":
+		var report reportRequest
+		body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+		if err == nil {
+			err = xml.Unmarshal(body, &report)
+		}
+		if err != nil {
+			http.Error(writer, "invalid REPORT body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var requested []xml.Name
+		if report.Prop != nil {
+			requested = *report.Prop
+		}
+		var resources []*davResource
+		switch report.XMLName {
+		case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+			for _, object := range objects {
+				if report.Filter.CompFilter.matches(object) {
+					resources = append(resources, objectResource(object))
+				}
+			}
+		case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+			for _, href := range report.Hrefs {
+				resource := &davResource{href: href}
+				if parsed, err := url.Parse(href); err == nil {
+					if name, ok := strings.CutPrefix(parsed.Path, davCollection); ok {
+						if object := findCalendarObject(objects, name); object != nil {
+							resource = objectResource(object)
+						}
+					}
+				}
+				resources = append(resources, resource)
+			}
+		default:
+			http.Error(writer, "unsupported report "+report.XMLName.Local, http.StatusForbidden)
+			return
+		}
+		writeMultistatus(writer, resources, requested, false)
+	default:
+		writer.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveDAVObject(writer http.ResponseWriter, request *http.Request, db *Database, user *User, objects []*calendarObject, name string) {
+	object := findCalendarObject(objects, name)
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+		if object == nil {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		writer.Header().Set("ETag", object.ETag)
+		writer.Write([]byte(object.Data))
+	case "PROPFIND":
+		if object == nil {
+			http.NotFound(writer, request)
+			return
+		}
+		requested, namesOnly, _, ok := readPropfind(writer, request)
+		if !ok {
+			return
+		}
+		writeMultistatus(writer, []*davResource{objectResource(object)}, requested, namesOnly)
+	case http.MethodPut:
+		if err := checkPreconditions(request, object); err != nil {
+			http.Error(writer, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(request.Body, maxCalendarObjectBytes+1))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxCalendarObjectBytes {
+			http.Error(writer, "calendar object too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		todo, err := ParseCalendarTodo(string(body))
+		if err == nil && object == nil {
+			if existing := findCalendarObjectByUID(objects, todo.UID); existing != nil {
+				err = &ValidationError{fmt.Sprintf("UID %s is already used by %s", todo.UID, existing.href())}
+			}
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		created, err := PutCalendarObject(request.Context(), db, user.ID, object, name, todo, func(current *calendarObject) error {
+			return checkPreconditions(request, current)
+		})
+		if errors.Is(err, ErrPreconditionFailed) {
+			http.Error(writer, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		// No ETag is sent: the stored resource is rendered from the task,
+		// not kept byte for byte, so clients must fetch it again.
+		if created {
+			writer.WriteHeader(http.StatusCreated)
+		} else {
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodDelete:
+		if object == nil {
+			http.NotFound(writer, request)
+			return
+		}
+		if err := checkPreconditions(request, object); err != nil {
+			http.Error(writer, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err := DeleteTask(request.Context(), db, user.ID, object.Task.ID); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.Header().Set("Allow", davMethods)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func findCalendarObjectByUID(objects []*calendarObject, uid string) *calendarObject {
+	for _, object := range objects {
+		if object.UID == uid {
+			return object
+		}
+	}
+	return nil
+}
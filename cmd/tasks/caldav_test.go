@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// davStatuses maps each href of a multistatus body to the status of its
+// response, or of its first propstat.
+func davStatuses(t *testing.T, body string) map[string]string {
+	t.Helper()
+	var multistatus struct {
+		Responses []struct {
+			Href      string `xml:"DAV: href"`
+			Status    string `xml:"DAV: status"`
+			Propstats []struct {
+				Status string `xml:"DAV: status"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.Unmarshal([]byte(body), &multistatus); err != nil {
+		t.Fatalf("invalid multistatus: %v\n%s", err, body)
+	}
+	statuses := map[string]string{}
+	for _, response := range multistatus.Responses {
+		status := response.Status
+		if status == "" && len(response.Propstats) > 0 {
+			status = response.Propstats[0].Status
+		}
+		statuses[response.Href] = strings.TrimPrefix(status, "HTTP/1.1 ")
+	}
+	return statuses
+}
+
+// serveDAV sends a CalDAV request signed in as username with token.
+func serveDAV(appState *AppState, username, token, method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.SetBasicAuth(username, token)
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	newRouter(appState).ServeHTTP(recorder, request)
+	return recorder
+}
+
+// vtodo is a calendar object as a client would PUT it.
+func vtodo(uid, summary, status string) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//test//EN", "BEGIN:VTODO", "UID:" + uid, "SUMMARY:" + summary}
+	if status != "" {
+		lines = append(lines, "STATUS:"+status)
+	}
+	lines = append(lines, "BEGIN:VALARM", "ACTION:DISPLAY", "END:VALARM", "END:VTODO", "END:VCALENDAR", "")
+	return strings.Join(lines, "\r\n")
+}
+
+func TestCalDAVPropfind(t *testing.T) {
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	full := newTestToken(t, appState.db, user, ScopeFull)
+	read := newTestToken(t, appState.db, user, ScopeRead)
+	newTestTask(t, appState.db, user.ID, &Task{Name: "Dishes"})
+	newTestTask(t, appState.db, user.ID, &Task{Name: "Laundry"})
+
+	etagOnly := `<d:propfind xmlns:d="DAV:" xmlns:a="http://apple.com/ns/ical/"><d:prop><d:getetag/><a:calendar-color/></d:prop></d:propfind>`
+	tests := []struct {
+		name       string
+		username   string
+		token      string
+		path       string
+		depth      string
+		body       string
+		wantStatus int
+		want       map[string]string
+		contains   string
+		excludes   string
+	}{
+		{"principal", "alice", full, "/dav/", "0", "", http.StatusMultiStatus,
+			map[string]string{"/dav/": "200 OK"}, "<c:calendar-home-set><d:href>/dav/</d:href>", ""},
+		{"principal and home", "alice", full, "/dav/", "1", "", http.StatusMultiStatus,
+			map[string]string{"/dav/": "200 OK", "/dav/tasks/": "200 OK"}, "<d:write/>", ""},
+		{"read token cannot write", "alice", read, "/dav/tasks/", "0", "", http.StatusMultiStatus,
+			map[string]string{"/dav/tasks/": "200 OK"}, "<d:read/>", "<d:write/>"},
+		{"collection and objects", "alice", full, "/dav/tasks/", "1", etagOnly, http.StatusMultiStatus,
+			map[string]string{"/dav/tasks/": "404 Not Found", "/dav/tasks/task-1.ics": "200 OK", "/dav/tasks/task-2.ics": "200 OK"},
+			`<calendar-color xmlns="http://apple.com/ns/ical/"/>`, "BEGIN:VCALENDAR"},
+		{"property names", "alice", full, "/dav/tasks/task-1.ics", "0", `<d:propfind xmlns:d="DAV:"><d:propname/></d:propfind>`, http.StatusMultiStatus,
+			map[string]string{"/dav/tasks/task-1.ics": "200 OK"}, "<d:getetag/>", "BEGIN:VCALENDAR"},
+		{"missing object", "alice", full, "/dav/tasks/task-9.ics", "0", "", http.StatusNotFound, nil, "", ""},
+		{"invalid body", "alice", full, "/dav/tasks/", "0", "<d:propfind", http.StatusBadRequest, nil, "", ""},
+		{"wrong username", "bob", full, "/dav/", "0", "", http.StatusUnauthorized, nil, "", ""},
+		{"no credentials", "", "", "/dav/", "0", "", http.StatusUnauthorized, nil, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveDAV(appState, test.username, test.token, "PROPFIND", test.path, map[string]string{"Depth": test.depth}, test.body)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, test.wantStatus)
+			}
+			if test.want == nil {
+				return
+			}
+			body := recorder.Body.String()
+			statuses := davStatuses(t, body)
+			if len(statuses) != len(test.want) {
+				t.Errorf("responses = %v, want %v", statuses, test.want)
+			}
+			for href, status := range test.want {
+				if statuses[href] != status {
+					t.Errorf("%s: status %q, want %q", href, statuses[href], status)
+				}
+			}
+			if test.contains != "" && !strings.Contains(body, test.contains) {
+				t.Errorf("body has no %s:\n%s", test.contains, body)
+			}
+			if test.excludes != "" && strings.Contains(body, test.excludes) {
+				t.Errorf("body has %s:\n%s", test.excludes, body)
+			}
+		})
+	}
+}
+
+func TestCalDAVReport(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	token := newTestToken(t, appState.db, user, ScopeRead)
+	newTestTask(t, appState.db, user.ID, &Task{Name: "Dishes"})
+	done := newTestTask(t, appState.db, user.ID, &Task{Name: "Taxes", OneShot: true})
+	if _, err := CompleteTask(ctx, appState.db, user.ID, done.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(filter string) string {
+		return `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/><c:calendar-data/></d:prop>
+			<c:filter><c:comp-filter name="VCALENDAR">` + filter + `</c:comp-filter></c:filter></c:calendar-query>`
+	}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       map[string]string
+	}{
+		{"every to-do", query(`<c:comp-filter name="VTODO"/>`), http.StatusMultiStatus,
+			map[string]string{"/dav/tasks/task-1.ics": "200 OK", "/dav/tasks/task-2.ics": "200 OK"}},
+		{"open to-dos", query(`<c:comp-filter name="VTODO"><c:prop-filter name="COMPLETED"><c:is-not-defined/></c:prop-filter></c:comp-filter>`),
+			http.StatusMultiStatus, map[string]string{"/dav/tasks/task-1.ics": "200 OK"}},
+		{"completed to-dos", query(`<c:comp-filter name="VTODO"><c:prop-filter name="COMPLETED"/></c:comp-filter>`),
+			http.StatusMultiStatus, map[string]string{"/dav/tasks/task-2.ics": "200 OK"}},
+		{"events only", query(`<c:comp-filter name="VEVENT"/>`), http.StatusMultiStatus, map[string]string{}},
+		{"multiget", `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop>
+			<d:href>/dav/tasks/task-2.ics</d:href><d:href>/dav/tasks/gone.ics</d:href></c:calendar-multiget>`,
+			http.StatusMultiStatus, map[string]string{"/dav/tasks/task-2.ics": "200 OK", "/dav/tasks/gone.ics": "404 Not Found"}},
+		{"unsupported report", `<d:sync-collection xmlns:d="DAV:"/>`, http.StatusForbidden, nil},
+		{"invalid body", "not xml", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveDAV(appState, "alice", token, "REPORT", "/dav/tasks/", map[string]string{"Depth": "1"}, test.body)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, test.wantStatus)
+			}
+			if test.want == nil {
+				return
+			}
+			statuses := davStatuses(t, recorder.Body.String())
+			if len(statuses) != len(test.want) {
+				t.Errorf("responses = %v, want %v", statuses, test.want)
+			}
+			for href, status := range test.want {
+				if statuses[href] != status {
+					t.Errorf("%s: status %q, want %q", href, statuses[href], status)
+				}
+			}
+		})
+	}
+}
+
+func TestCalDAVPutAndDelete(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	token := newTestToken(t, appState.db, user, ScopeFull)
+	readToken := newTestToken(t, appState.db, user, ScopeRead)
+	const path = "/dav/tasks/ABC-123.ics"
+
+	// etag fetches the resource's current ETag.
+	etag := func() string {
+		t.Helper()
+		recorder := serveDAV(appState, "alice", token, "GET", path, nil, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, recorder.Code)
+		}
+		return recorder.Header().Get("ETag")
+	}
+	object := func() *calendarObject {
+		t.Helper()
+		objects, err := GetCalendarObjects(ctx, appState.db, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return findCalendarObject(objects, "ABC-123.ics")
+	}
+
+	steps := []struct {
+		name       string
+		token      string
+		method     string
+		path       string
+		headers    map[string]string
+		body       string
+		wantStatus int
+	}{
+		{"read token cannot create", readToken, "PUT", path, nil, vtodo("abc-123", "Buy milk", ""), http.StatusForbidden},
+		{"not a to-do", token, "PUT", path, nil, strings.ReplaceAll(vtodo("abc-123", "Buy milk", ""), "VTODO", "VEVENT"), http.StatusUnprocessableEntity},
+		{"create", token, "PUT", path, map[string]string{"If-None-Match": "*"}, vtodo("abc-123", "Buy milk", ""), http.StatusCreated},
+		{"create again", token, "PUT", path, map[string]string{"If-None-Match": "*"}, vtodo("abc-123", "Buy milk", ""), http.StatusPreconditionFailed},
+		{"UID taken by another resource", token, "PUT", "/dav/tasks/other.ics", nil, vtodo("abc-123", "Buy milk", ""), http.StatusUnprocessableEntity},
+		{"stale ETag", token, "PUT", path, map[string]string{"If-Match": `"stale"`}, vtodo("abc-123", "Buy oat milk", ""), http.StatusPreconditionFailed},
+		{"If-Match on a missing resource", token, "PUT", "/dav/tasks/missing.ics", map[string]string{"If-Match": "*"}, vtodo("missing", "Ghost", ""), http.StatusPreconditionFailed},
+	}
+	for _, step := range steps {
+		recorder := serveDAV(appState, "alice", step.token, step.method, step.path, step.headers, step.body)
+		if recorder.Code != step.wantStatus {
+			t.Fatalf("%s: got %d %s, want %d", step.name, recorder.Code, recorder.Body, step.wantStatus)
+		}
+	}
+	created := object()
+	if created == nil || created.UID != "abc-123" || created.Task.Name != "Buy milk" || !created.Task.OneShot {
+		t.Fatalf("created object = %+v", created)
+	}
+
+	// Only the current ETag updates the task, and only once.
+	current := etag()
+	rename := serveDAV(appState, "alice", token, "PUT", path, map[string]string{"If-Match": current}, vtodo("abc-123", "Buy oat milk", ""))
+	if rename.Code != http.StatusNoContent {
+		t.Fatalf("rename: got %d %s", rename.Code, rename.Body)
+	}
+	if name := object().Task.Name; name != "Buy oat milk" {
+		t.Errorf("name after rename = %q", name)
+	}
+	if again := serveDAV(appState, "alice", token, "PUT", path, map[string]string{"If-Match": current}, vtodo("abc-123", "Buy milk", "COMPLETED")); again.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with the old ETag: got %d, want 412", again.Code)
+	}
+	complete := serveDAV(appState, "alice", token, "PUT", path, map[string]string{"If-Match": etag()}, vtodo("abc-123", "Buy oat milk", "COMPLETED"))
+	if complete.Code != http.StatusNoContent {
+		t.Fatalf("complete: got %d %s", complete.Code, complete.Body)
+	}
+	if task := object().Task; task.ArchivedAt == nil {
+		t.Error("completing a one-shot to-do did not archive its task")
+	}
+
+	// A to-do created completed is recorded in the same request.
+	recorder := serveDAV(appState, "alice", token, "PUT", "/dav/tasks/done.ics", nil, vtodo("done-1", "Return library book", "COMPLETED"))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create completed: got %d %s", recorder.Code, recorder.Body)
+	}
+	objects, err := GetCalendarObjects(ctx, appState.db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done := findCalendarObject(objects, "done.ics"); done == nil || done.Task.ArchivedAt == nil || done.UID != "done-1" {
+		t.Errorf("created completed object = %+v", done)
+	}
+
+	// An edit whose completion breaks the task's rules is not saved.
+	cooldown := findCalendarObject(objects, "done.ics").Task
+	cooldown.OneShot, cooldown.CooldownMinutes = false, 60
+	if err := UpdateTask(ctx, appState.db, user.ID, cooldown); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnarchiveTask(ctx, appState.db, user.ID, cooldown.ID); err != nil {
+		t.Fatal(err)
+	}
+	recorder = serveDAV(appState, "alice", token, "PUT", "/dav/tasks/done.ics", nil, vtodo("done-1", "Return both books", "COMPLETED"))
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("complete during cooldown: got %d %s, want 409", recorder.Code, recorder.Body)
+	}
+	if task, err := GetTask(appState.db, user.ID, cooldown.ID); err != nil || task.Name != "Return library book" {
+		t.Errorf("task after a rejected completion = %+v, %v", task, err)
+	}
+
+	// The ETag is checked again inside the transaction: a PUT whose
+	// precondition held when the request began loses to an edit saved
+	// before its transaction.
+	snapshot := object()
+	if err := UpdateTaskNotes(ctx, appState.db, user.ID, snapshot.Task.ID, "from another client"); err != nil {
+		t.Fatal(err)
+	}
+	todo, err := ParseCalendarTodo(vtodo("abc-123", "Buy soy milk", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifMatch := func(current *calendarObject) error {
+		if current == nil || current.ETag != snapshot.ETag {
+			return ErrPreconditionFailed
+		}
+		return nil
+	}
+	if _, err := PutCalendarObject(ctx, appState.db, user.ID, snapshot, snapshot.Name, todo, ifMatch); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("PUT over a newer edit: got %v, want ErrPreconditionFailed", err)
+	}
+	if task := object().Task; task.Name != "Buy oat milk" || task.Notes != "from another client" {
+		t.Errorf("task after the refused PUT = %q, %q", task.Name, task.Notes)
+	}
+
+	deletes := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"stale ETag", map[string]string{"If-Match": `"stale"`}, http.StatusPreconditionFailed},
+		{"current ETag", map[string]string{"If-Match": etag()}, http.StatusNoContent},
+		{"already gone", nil, http.StatusNotFound},
+	}
+	taskID := object().Task.ID
+	for _, step := range deletes {
+		if recorder := serveDAV(appState, "alice", token, "DELETE", path, step.headers, ""); recorder.Code != step.wantStatus {
+			t.Errorf("DELETE %s: got %d %s, want %d", step.name, recorder.Code, recorder.Body, step.wantStatus)
+		}
+	}
+	if _, err := GetTask(appState.db, user.ID, taskID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("deleted task: got %v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestCalDAVCompletesEachOccurrenceOnce(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	token := newTestToken(t, appState.db, user, ScopeFull)
+	due := time.Now().Add(-time.Hour).Truncate(time.Second)
+	task := newTestTask(t, appState.db, user.ID, &Task{Name: "Water plants", Points: 3, Recurrence: "FREQ=DAILY", DueAt: &due})
+	path := "/dav/tasks/" + defaultObjectName(task.ID)
+
+	recorder := serveDAV(appState, "alice", token, "GET", path, nil, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET: got %d", recorder.Code)
+	}
+	done := strings.Replace(recorder.Body.String(), "STATUS:NEEDS-ACTION", "STATUS:COMPLETED", 1)
+
+	// A client without If-Match sends the completed occurrence twice.
+	for round := 0; round < 2; round++ {
+		if recorder := serveDAV(appState, "alice", token, "PUT", path, nil, done); recorder.Code != http.StatusNoContent {
+			t.Fatalf("PUT %d: got %d %s", round+1, recorder.Code, recorder.Body)
+		}
+	}
+	var completions int
+	if err := appState.db.Conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM completions WHERE task_id = ?", task.ID).Scan(&completions); err != nil {
+		t.Fatal(err)
+	}
+	if completions != 1 {
+		t.Errorf("%d completions recorded, want 1", completions)
+	}
+	saved, err := GetTask(appState.db, user.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := due.AddDate(0, 0, 1); saved.DueAt == nil || !saved.DueAt.Equal(want) {
+		t.Errorf("due %v after completing one occurrence, want %v", saved.DueAt, want)
+	}
+}
@@ -0,0 +1,313 @@
+// SVG charts of the completion history, drawn on the server so the page
+// needs no chart library: points over time, points per task and a yearly
+// heatmap of completions.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth  = 640
+	chartHeight = 220
+	// chartMargin leaves room for the axis labels.
+	chartMargin = 36
+	// maxChartTasks is how many tasks the bar chart shows.
+	maxChartTasks = 10
+	heatmapCell   = 11
+	heatmapGap    = 2
+)
+
+// heatmapColours go from no completions to the busiest days.
+var heatmapColours = []string{"#ebedf0", "#9be9a8", "#40c463", "#30a14e", "#216e39"}
+
+// svgWriter accumulates an SVG document. Text passed to text and title is
+// escaped.
+type svgWriter struct {
+	strings.Builder
+}
+
+func newSVG(width, height int, label string) *svgWriter {
+	svg := &svgWriter{}
+	fmt.Fprintf(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s" font-family="sans-serif" font-size="11">`,
+		width, height, width, height, template.HTMLEscapeString(label))
+	return svg
+}
+
+func (svg *svgWriter) text(x, y float64, anchor, content string) {
+	fmt.Fprintf(svg, `<text x="%.1f" y="%.1f" text-anchor="%s" fill="#555">%s</text>`, x, y, anchor, template.HTMLEscapeString(content))
+}
+
+// title adds a tooltip to the element opened before it, which the caller
+// then closes.
+func (svg *svgWriter) title(content string) {
+	fmt.Fprintf(svg, `<title>%s</title>`, template.HTMLEscapeString(content))
+}
+
+func (svg *svgWriter) close() string {
+	svg.WriteString(`</svg>`)
+	return svg.String()
+}
+
+// empty draws a placeholder message for charts with nothing to show.
+func (svg *svgWriter) empty(width, height int) string {
+	svg.text(float64(width)/2, float64(height)/2, "middle", "No completions yet")
+	return svg.close()
+}
+
+// pointsChartSVG draws a line chart of the points earned per bucket.
+func pointsChartSVG(stats *Stats) string {
+	svg := newSVG(chartWidth, chartHeight, fmt.Sprintf("Points per %s", stats.Period))
+	if stats.Totals.Completions == 0 {
+		return svg.empty(chartWidth, chartHeight)
+	}
+
+	maximum := 1
+	for _, bucket := range stats.Buckets {
+		maximum = max(maximum, bucket.Totals.Points)
+	}
+	plotWidth := float64(chartWidth - 2*chartMargin)
+	plotHeight := float64(chartHeight - 2*chartMargin)
+	step := plotWidth / float64(max(len(stats.Buckets)-1, 1))
+	x := func(index int) float64 { return chartMargin + float64(index)*step }
+	y := func(points int) float64 {
+		return chartMargin + plotHeight - float64(points)/float64(maximum)*plotHeight
+	}
+
+	// Axes and the labels at their ends.
+	fmt.Fprintf(svg, `<path d="M%d %d V%.1f H%.1f" fill="none" stroke="#999"/>`, chartMargin, chartMargin, y(0), x(len(stats.Buckets)-1))
+	svg.text(chartMargin-4, y(maximum)+4, "end", strconv.Itoa(maximum))
+	svg.text(chartMargin-4, y(0)+4, "end", "0")
+	svg.text(x(0), y(0)+16, "start", bucketLabel(stats.Period, stats.Buckets[0].Start))
+	svg.text(x(len(stats.Buckets)-1), y(0)+16, "end", bucketLabel(stats.Period, stats.Buckets[len(stats.Buckets)-1].Start))
+
+	points := make([]string, len(stats.Buckets))
+	for index, bucket := range stats.Buckets {
+		points[index] = fmt.Sprintf("%.1f,%.1f", x(index), y(bucket.Totals.Points))
+	}
+	fmt.Fprintf(svg, `<polyline points="%s" fill="none" stroke="#30a14e" stroke-width="2"/>`, strings.Join(points, " "))
+	for index, bucket := range stats.Buckets {
+		fmt.Fprintf(svg, `<circle cx="%.1f" cy="%.1f" r="3" fill="#30a14e">`, x(index), y(bucket.Totals.Points))
+		svg.title(fmt.Sprintf("%s: %d points from %d completions", bucketLabel(stats.Period, bucket.Start), bucket.Totals.Points, bucket.Totals.Completions))
+		svg.WriteString(`</circle>`)
+	}
+	return svg.close()
+}
+
+// tasksChartSVG draws a horizontal bar per task, most points first.
+func tasksChartSVG(stats *Stats) string {
+	tasks := stats.Tasks[:min(len(stats.Tasks), maxChartTasks)]
+	const rowHeight, labelWidth, padding = 24, 160, 4
+	height := max(chartHeight/2, len(tasks)*rowHeight+2*padding)
+	svg := newSVG(chartWidth, height, "Points per task")
+	if len(tasks) == 0 {
+		return svg.empty(chartWidth, height)
+	}
+
+	maximum := max(1, tasks[0].Totals.Points)
+	barSpace := float64(chartWidth - labelWidth - chartMargin)
+	for index, task := range tasks {
+		top := float64(index*rowHeight + padding)
+		width := max(1, float64(task.Totals.Points)/float64(maximum)*barSpace)
+		name := task.TaskName
+		if runes := []rune(name); len(runes) > 24 {
+			name = string(runes[:23]) + "…"
+		}
+		svg.text(labelWidth-6, top+15, "end", name)
+		fmt.Fprintf(svg, `<rect x="%d" y="%.1f" width="%.1f" height="%d" fill="#40c463">`, labelWidth, top+3, width, rowHeight-6)
+		svg.title(fmt.Sprintf("%s: %d points from %d completions", task.TaskName, task.Totals.Points, task.Totals.Completions))
+		svg.WriteString(`</rect>`)
+		svg.text(labelWidth+width+4, top+15, "start", strconv.Itoa(task.Totals.Points))
+	}
+	return svg.close()
+}
+
+// heatmapSVG draws one square per day of year, a column per week starting
+// on Monday, shaded by how many completions the day had.
+func heatmapSVG(history []historyEntry, year int) string {
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	next := first.AddDate(1, 0, 0)
+	// Days are keyed by date, as midnight is not always a valid time.
+	counts := map[string]int{}
+	busiest := 0
+	for _, entry := range history {
+		day := entry.CompletedAt.Format(time.DateOnly)
+		counts[day]++
+		busiest = max(busiest, counts[day])
+	}
+
+	const left, top = 30, 18
+	origin := weekStart(first)
+	days := int(math.Round(next.Sub(origin).Hours() / 24))
+	weeks := (days + 6) / 7
+	cell := heatmapCell + heatmapGap
+	width := left + weeks*cell
+	height := top + 7*cell
+	svg := newSVG(width, height, fmt.Sprintf("Completions in %d", year))
+
+	for row, name := range map[int]string{0: "Mon", 2: "Wed", 4: "Fri"} {
+		svg.text(left-4, float64(top+row*cell+heatmapCell-1), "end", name)
+	}
+	// Days are counted from the Monday before New Year rather than
+	// subtracted, so daylight saving changes cannot shift a cell.
+	offset := -1
+	for day := origin; day.Before(next); day = day.AddDate(0, 0, 1) {
+		offset++
+		if day.Before(first) {
+			continue
+		}
+		column, row := offset/7, offset%7
+		x, y := left+column*cell, top+row*cell
+		if day.Day() == 1 {
+			svg.text(float64(x), top-6, "start", day.Format("Jan"))
+		}
+
+		// The busiest day gets the darkest shade and single completions the
+		// lightest one after empty.
+		count := counts[day.Format(time.DateOnly)]
+		shade := 0
+		if count > 0 {
+			shade = 1 + (count-1)*(len(heatmapColours)-2)/max(busiest-1, 1)
+		}
+		fmt.Fprintf(svg, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s">`, x, y, heatmapCell, heatmapCell, heatmapColours[shade])
+		plural := "s"
+		if count == 1 {
+			plural = ""
+		}
+		svg.title(fmt.Sprintf("%d completion%s on %s", count, plural, day.Format(time.DateOnly)))
+		svg.WriteString(`</rect>`)
+	}
+	return svg.close()
+}
+
+// parseChartYear reads the year query parameter, defaulting to this year.
+func parseChartYear(query url.Values, now time.Time) (int, error) {
+	value := query.Get("year")
+	if value == "" {
+		return now.Year(), nil
+	}
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1970 || year > 9999 {
+		return 0, &ValidationError{"invalid year: " + value}
+	}
+	return year, nil
+}
+
+func loadYearHistory(request *http.Request, appState *AppState, year int) ([]historyEntry, error) {
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	return loadHistory(request.Context(), appState.db, currentUserID(request), first, first.AddDate(1, 0, 0))
+}
+
+func writeSVG(writer http.ResponseWriter, svg string) {
+	writer.Header().Set("Content-Type", "image/svg+xml")
+	// Charts change with every completion.
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Write([]byte(svg))
+}
+
+// handleStatsChart serves a chart drawn from GetStats over the range in the
+// query string, like the Stats panel.
+func handleStatsChart(draw func(*Stats) string) func(*AppState) http.HandlerFunc {
+	return func(appState *AppState) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			statsRange, err := parseStatsRange(request.URL.Query(), time.Now())
+			if err != nil {
+				http.Error(writer, err.Error(), errorStatus(err))
+				return
+			}
+			stats, err := GetStats(request.Context(), appState.db, currentUserID(request), statsRange)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSVG(writer, draw(stats))
+		}
+	}
+}
+
+func handleHeatmap(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		year, err := parseChartYear(request.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		history, err := loadYearHistory(request, appState, year)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSVG(writer, heatmapSVG(history, year))
+	}
+}
+
+var chartsTemplate = template.Must(template.New("charts").Parse(`
+        <div id="charts">
+            <form hx-get="/charts" hx-target="#charts" hx-swap="outerHTML" hx-trigger="change">
+                <select name="period">
+                    <option value="day"{{if eq .Period "day"}} selected{{end}}>Last 30 days</option>
+                    <option value="week"{{if eq .Period "week"}} selected{{end}}>Last 12 weeks</option>
+                    <option value="month"{{if eq .Period "month"}} selected{{end}}>Last 12 months</option>
+                </select>
+                <select name="year">
+                    {{range .Years}}<option value="{{.}}"{{if eq . $.Year}} selected{{end}}>{{.}}</option>{{end}}
+                </select>
+            </form>
+            <h3>Points per {{.Period}}</h3>
+            {{.Points}}
+            <h3>Points per task</h3>
+            {{.Tasks}}
+            <h3>Completions in {{.Year}}</h3>
+            {{.Heatmap}}
+        </div>`))
+
+// handleCharts renders the Charts panel with the SVGs inline, so their
+// tooltips work.
+func handleCharts(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		now := time.Now()
+		statsRange, err := parseStatsRange(request.URL.Query(), now)
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		year, err := parseChartYear(request.URL.Query(), now)
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		stats, err := GetStats(request.Context(), appState.db, currentUserID(request), statsRange)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		history, err := loadYearHistory(request, appState, year)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		years := []int{}
+		for shown := now.Year(); shown > now.Year()-5; shown-- {
+			years = append(years, shown)
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		chartsTemplate.Execute(writer, map[string]any{
+			"Period":  statsRange.Period,
+			"Year":    year,
+			"Years":   years,
+			"Points":  template.HTML(pointsChartSVG(stats)),
+			"Tasks":   template.HTML(tasksChartSVG(stats)),
+			"Heatmap": template.HTML(heatmapSVG(history, year)),
+		})
+	}
+}
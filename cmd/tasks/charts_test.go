@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// svgElements checks that svg is well-formed XML and counts its elements by
+// name.
+func svgElements(t *testing.T, svg string) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return counts
+		}
+		if err != nil {
+			t.Fatalf("malformed SVG: %v\n%s", err, svg)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			counts[start.Name.Local]++
+		}
+	}
+}
+
+func TestPointsChartSVG(t *testing.T) {
+	start := localTime(2024, 3, 4, 0)
+	tests := []struct {
+		name        string
+		points      []int
+		wantCircles int
+	}{
+		{"nothing", []int{0, 0, 0}, 0},
+		{"one bucket", []int{4}, 1},
+		{"a week", []int{1, 0, 3, 8, 0, 0, 2}, 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &Stats{Period: StatsDay}
+			for index, points := range test.points {
+				stats.Buckets = append(stats.Buckets, &StatsBucket{Start: start.AddDate(0, 0, index), Totals: Totals{Completions: min(points, 1), Points: points}})
+				stats.Totals.Completions += min(points, 1)
+			}
+			svg := pointsChartSVG(stats)
+			elements := svgElements(t, svg)
+			if elements["circle"] != test.wantCircles {
+				t.Errorf("%d points drawn, want %d", elements["circle"], test.wantCircles)
+			}
+			if test.wantCircles == 0 && !strings.Contains(svg, "No completions yet") {
+				t.Error("an empty chart has no placeholder")
+			}
+		})
+	}
+}
+
+func TestTasksChartSVG(t *testing.T) {
+	stats := &Stats{}
+	for index := 0; index < maxChartTasks+3; index++ {
+		stats.Tasks = append(stats.Tasks, &TaskStats{TaskID: index + 1, TaskName: fmt.Sprintf("<Task & %d> with a rather long name", index), Totals: Totals{Completions: 1, Points: 20 - index}})
+	}
+	svg := tasksChartSVG(stats)
+	if got := svgElements(t, svg)["rect"]; got != maxChartTasks {
+		t.Errorf("%d bars, want %d", got, maxChartTasks)
+	}
+	if !strings.Contains(svg, "&lt;Task &amp; 0&gt; with a rathe…") {
+		t.Error("long task names are not escaped and shortened")
+	}
+}
+
+func TestHeatmapSVG(t *testing.T) {
+	tests := []struct {
+		year     int
+		wantDays int
+	}{
+		{2023, 365},
+		{2024, 366},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.year), func(t *testing.T) {
+			busy := time.Date(test.year, time.March, 6, 9, 0, 0, 0, time.Local)
+			quiet := time.Date(test.year, time.December, 31, 22, 0, 0, 0, time.Local)
+			history := []historyEntry{{CompletedAt: busy}, {CompletedAt: busy.Add(time.Hour)}, {CompletedAt: busy.Add(2 * time.Hour)}, {CompletedAt: quiet}}
+			svg := heatmapSVG(history, test.year)
+			if got := svgElements(t, svg)["rect"]; got != test.wantDays {
+				t.Errorf("%d days drawn, want %d", got, test.wantDays)
+			}
+			wantTitles := []string{
+				fmt.Sprintf(`fill="%s"><title>3 completions on %d-03-06</title>`, heatmapColours[len(heatmapColours)-1], test.year),
+				fmt.Sprintf(`fill="%s"><title>1 completion on %d-12-31</title>`, heatmapColours[1], test.year),
+				fmt.Sprintf(`fill="%s"><title>0 completions on %d-01-01</title>`, heatmapColours[0], test.year),
+			}
+			for _, want := range wantTitles {
+				if !strings.Contains(svg, want) {
+					t.Errorf("heatmap is missing %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseChartYear(t *testing.T) {
+	now := localTime(2024, 3, 6, 12)
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 2024, false},
+		{"2019", 2019, false},
+		{"1969", 0, true},
+		{"next", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseChartYear(url.Values{"year": {test.value}}, now)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("parseChartYear(%q) = %d, %v; want %d, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Command line subcommands that run instead of the HTTP server.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runMigrateCommand implements `migrate status|up [version]|down [steps]`.
+func runMigrateCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status|up [version]|down [steps]")
+	}
+
+	switch args[0] {
+	case "status":
+		states, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			status := "pending"
+			switch {
+			case state.Unknown:
+				status = "unknown"
+			case state.Drifted:
+				status = "modified"
+			case state.Applied:
+				status = "applied " + state.AppliedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(output, "%4d  %-40s  %s\n", state.Version, state.Name, status)
+		}
+		return nil
+
+	case "up":
+		target := 0
+		if len(args) > 1 {
+			version, err := strconv.Atoi(args[1])
+			if err != nil || version < 1 {
+				return fmt.Errorf("invalid target version: %s", args[1])
+			}
+			target = version
+		}
+		if err := db.MigrateUp(ctx, target); err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "migrations applied")
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			count, err := strconv.Atoi(args[1])
+			if err != nil || count < 1 {
+				return fmt.Errorf("invalid step count: %s", args[1])
+			}
+			steps = count
+		}
+		if err := db.MigrateDown(ctx, steps); err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "migrations reverted")
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+// commandUser finds the account an export or import acts for: the named
+// one, or the only one when the database has a single account.
+func commandUser(ctx context.Context, db *Database, username string) (int, error) {
+	var userID int
+	if username != "" {
+		err := db.Conn.QueryRowContext(ctx, "SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("no such user: %s", username)
+		}
+		return userID, err
+	}
+
+	var count int
+	if err := db.Conn.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MIN(id), 0) FROM users").Scan(&count, &userID); err != nil {
+		return 0, err
+	}
+	switch count {
+	case 0:
+		return 0, fmt.Errorf("there are no accounts yet: register one or run create-user first")
+	case 1:
+		return userID, nil
+	}
+	return 0, fmt.Errorf("there are %d accounts: choose one with -user", count)
+}
+
+// runExportCommand implements `export [-user name] [file]`, writing to
+// standard output when no file is given.
+func runExportCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("export", flag.ContinueOnError)
+	username := flagSet.String("user", "", "account to export (default: the only account)")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 1 {
+		return fmt.Errorf("usage: export [-user name] [file]")
+	}
+
+	userID, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+	export, err := ExportData(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if flagSet.NArg() == 1 {
+		file, err := os.Create(flagSet.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// runImportCommand implements `import [-user name] [-mode merge|replace] file`.
+func runImportCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("import", flag.ContinueOnError)
+	username := flagSet.String("user", "", "account to import into (default: the only account)")
+	modeValue := flagSet.String("mode", string(ImportMerge), "merge into the existing data or replace it")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return fmt.Errorf("usage: import [-user name] [-mode merge|replace] file")
+	}
+	mode, err := ParseImportMode(*modeValue)
+	if err != nil {
+		return err
+	}
+
+	userID, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var export Export
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&export); err != nil {
+		return fmt.Errorf("reading %s: %w", flagSet.Arg(0), err)
+	}
+
+	report, err := ImportData(ctx, db, userID, &export, mode)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "imported (%s): %d projects, %d tasks, %d completions, %d point entries, %d rewards, %d redemptions, %d goals, %d badges\n",
+		report.Mode, report.Projects, report.Tasks, report.Completions, report.PointEntries, report.Rewards, report.Redemptions, report.Goals, report.Badges)
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(output, "skipped %s\n", skipped)
+	}
+	return nil
+}
+
+// runExportCompletionsCommand implements `export-completions [-user name]
+// [-format csv|tsv] [-from date] [-to date] [-task id] [-tz zone]
+// [-date-format name] [file]`, taking the same options as the download link.
+func runExportCompletionsCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("export-completions", flag.ContinueOnError)
+	username := flagSet.String("user", "", "account whose completions to export (default: the only account)")
+	query := url.Values{}
+	for _, option := range []struct{ name, parameter, usage string }{
+		{"format", "format", "csv or tsv"},
+		{"from", "from", "first day to include, YYYY-MM-DD"},
+		{"to", "to", "last day to include, YYYY-MM-DD"},
+		{"task", "task", "only completions of this task ID"},
+		{"tz", "tz", "time zone for dates, e.g. Europe/Berlin (default: local)"},
+		{"date-format", "date_format", "rfc3339, datetime, date, us, eu or unix"},
+	} {
+		flagSet.Func(option.name, option.usage, func(value string) error {
+			query.Set(option.parameter, value)
+			return nil
+		})
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 1 {
+		return fmt.Errorf("usage: export-completions [flags] [file]")
+	}
+	export, err := ParseCompletionExport(query)
+	if err != nil {
+		return err
+	}
+
+	userID, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+	if flagSet.NArg() == 1 {
+		file, err := os.Create(flagSet.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	return WriteCompletionsCSV(ctx, db, userID, export, output)
+}
+
+// runImportTasksCommand implements `import-tasks -source name [-user name]
+// [-dry-run] file`, reading a file exported from another task manager.
+func runImportTasksCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("import-tasks", flag.ContinueOnError)
+	sourceValue := flagSet.String("source", "", "todoist-csv, todoist-json, taskwarrior or todotxt")
+	username := flagSet.String("user", "", "account to import into (default: the only account)")
+	dryRun := flagSet.Bool("dry-run", false, "report what would be created without creating it")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		return fmt.Errorf("usage: import-tasks -source name [-user name] [-dry-run] file")
+	}
+	source, err := ParseImportSource(*sourceValue)
+	if err != nil {
+		return err
+	}
+
+	userID, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := ImportFromSource(ctx, db, userID, source, data, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "created"
+	if report.DryRun {
+		verb = "would create"
+	}
+	for _, task := range report.Tasks {
+		fmt.Fprintf(output, "%s %q", verb, task.Name)
+		if task.Priority != PriorityNone {
+			fmt.Fprintf(output, " priority %s", task.Priority)
+		}
+		if len(task.Tags) > 0 {
+			fmt.Fprintf(output, " tags %s", strings.Join(task.Tags, ", "))
+		}
+		if len(task.CompletedAt) > 0 {
+			fmt.Fprintf(output, " completed %s", task.CompletedAt[len(task.CompletedAt)-1].Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Fprintln(output)
+	}
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(output, "skipped %s\n", skipped)
+	}
+	fmt.Fprintf(output, "%s %d tasks and %d completions\n", verb, len(report.Tasks), report.Completions)
+	return nil
+}
+
+// runCreateUserCommand implements `create-user name`, reading the password
+// from the first line of standard input so that it stays out of the shell
+// history. It works whether or not registration is open.
+func runCreateUserCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: create-user name < password")
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	user, err := CreateUser(ctx, db, args[0], strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "created account %s (id %d)\n", user.Username, user.ID)
+	return nil
+}
+
+// runClaimLegacyDataCommand implements `claim-legacy-data [-user name]`,
+// giving an account the data recorded before accounts existed.
+func runClaimLegacyDataCommand(ctx context.Context, db *Database, args []string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("claim-legacy-data", flag.ContinueOnError)
+	username := flagSet.String("user", "", "account to give the data to (default: the only account)")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 0 {
+		return fmt.Errorf("usage: claim-legacy-data [-user name]")
+	}
+
+	userID, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+	claimed, err := ClaimLegacyData(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	for _, table := range legacyTables {
+		fmt.Fprintf(output, "%-14s %d\n", table, claimed[table])
+	}
+	return nil
+}
+
+// runOpenAPICommand implements `openapi print|validate`.
+func runOpenAPICommand(ctx context.Context, args []string, output io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: openapi print|validate")
+	}
+
+	switch args[0] {
+	case "print":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(BuildOpenAPIDocument())
+	case "validate":
+		return RunOpenAPIValidation(ctx, output)
+	}
+
+	return fmt.Errorf("unknown openapi command: %s", args[0])
+}
@@ -0,0 +1,301 @@
+// Runtime configuration from flags, TASKS_* environment variables and an
+// optional config file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the server and subcommands need. Values are
+// resolved in increasing order of precedence: built-in defaults, the config
+// file, TASKS_* environment variables, then command-line flags.
+type Config struct {
+	DatabasePath    string
+	ListenAddress   string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	LogLevel        string
+	// TrashRetention is how long deleted tasks stay restorable before
+	// they are purged automatically. Zero keeps them forever.
+	TrashRetention   time.Duration
+	TrashPurgePolicy string
+	// SessionLifetime is how long a sign in lasts without activity.
+	SessionLifetime time.Duration
+	// SecureCookies marks the session cookie Secure so browsers only send
+	// it over HTTPS (and to localhost).
+	SecureCookies bool
+	// Registration lets visitors create accounts at /register. With it
+	// off, accounts are created with the create-user command.
+	Registration bool
+}
+
+// DefaultConfig matches the behaviour of the tracker before it was
+// configurable, so existing installs keep using the same database.
+func DefaultConfig() Config {
+	return Config{
+		DatabasePath:     filepath.Join("..", "sqlite_db", "task_tracker.db"),
+		ListenAddress:    ":8080",
+		MaxOpenConns:     10,
+		MaxIdleConns:     5,
+		ConnMaxLifetime:  time.Hour,
+		ReadTimeout:      15 * time.Second,
+		WriteTimeout:     30 * time.Second,
+		IdleTimeout:      2 * time.Minute,
+		LogLevel:         "info",
+		TrashRetention:   30 * 24 * time.Hour,
+		TrashPurgePolicy: string(PurgeKeepCompletions),
+		SessionLifetime:  7 * 24 * time.Hour,
+		SecureCookies:    true,
+		Registration:     true,
+	}
+}
+
+// configSetting describes one key. The same name is used in config files,
+// as a flag (with dashes) and as an environment variable (upper case with a
+// TASKS_ prefix).
+type configSetting struct {
+	key   string
+	usage string
+	apply func(config *Config, value string) error
+}
+
+func stringSetting(key, usage string, field func(*Config) *string) configSetting {
+	return configSetting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		*field(config) = value
+		return nil
+	}}
+}
+
+func intSetting(key, usage string, field func(*Config) *int) configSetting {
+	return configSetting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", key, value)
+		}
+		*field(config) = parsed
+		return nil
+	}}
+}
+
+func durationSetting(key, usage string, field func(*Config) *time.Duration) configSetting {
+	return configSetting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", key, value)
+		}
+		*field(config) = parsed
+		return nil
+	}}
+}
+
+func boolSetting(key, usage string, field func(*Config) *bool) configSetting {
+	return configSetting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", key, value)
+		}
+		*field(config) = parsed
+		return nil
+	}}
+}
+
+var configSettings = []configSetting{
+	stringSetting("db_path", "path to the SQLite database file", func(c *Config) *string { return &c.DatabasePath }),
+	stringSetting("listen_address", "address the HTTP server listens on", func(c *Config) *string { return &c.ListenAddress }),
+	intSetting("max_open_conns", "maximum open database connections", func(c *Config) *int { return &c.MaxOpenConns }),
+	intSetting("max_idle_conns", "maximum idle database connections", func(c *Config) *int { return &c.MaxIdleConns }),
+	durationSetting("conn_max_lifetime", "maximum lifetime of a database connection", func(c *Config) *time.Duration { return &c.ConnMaxLifetime }),
+	durationSetting("read_timeout", "HTTP read timeout", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationSetting("write_timeout", "HTTP write timeout", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationSetting("idle_timeout", "HTTP keep-alive idle timeout", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	stringSetting("log_level", "log level: debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	durationSetting("trash_retention", "how long deleted tasks are kept before purging, 0 to keep forever", func(c *Config) *time.Duration { return &c.TrashRetention }),
+	stringSetting("trash_purge_policy", "what purging does with completions: keep or delete", func(c *Config) *string { return &c.TrashPurgePolicy }),
+	durationSetting("session_lifetime", "how long a sign in lasts without activity", func(c *Config) *time.Duration { return &c.SessionLifetime }),
+	boolSetting("secure_cookies", "only send the session cookie over HTTPS and to localhost", func(c *Config) *bool { return &c.SecureCookies }),
+	boolSetting("registration", "let visitors create accounts at /register", func(c *Config) *bool { return &c.Registration }),
+}
+
+func findConfigSetting(key string) (configSetting, bool) {
+	for _, setting := range configSettings {
+		if setting.key == key {
+			return setting, true
+		}
+	}
+	return configSetting{}, false
+}
+
+// LoadConfig resolves the configuration for args (without the program
+// name) and returns the arguments left over after the flags, which name
+// the subcommand to run.
+func LoadConfig(args []string) (Config, []string, error) {
+	config := DefaultConfig()
+
+	flagSet := flag.NewFlagSet("tasks", flag.ContinueOnError)
+	configPath := flagSet.String("config", "", "path to a TOML or YAML config file (env TASKS_CONFIG)")
+	flagValues := make(map[string]*string)
+	for _, setting := range configSettings {
+		envName := "TASKS_" + strings.ToUpper(setting.key)
+		flagValues[setting.key] = flagSet.String(strings.ReplaceAll(setting.key, "_", "-"), "",
+			fmt.Sprintf("%s (env %s)", setting.usage, envName))
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return config, nil, err
+	}
+
+	if *configPath == "" {
+		*configPath = os.Getenv("TASKS_CONFIG")
+	}
+	if *configPath != "" {
+		values, err := readConfigFile(*configPath)
+		if err != nil {
+			return config, nil, err
+		}
+		if err := applyConfigValues(&config, values, *configPath); err != nil {
+			return config, nil, err
+		}
+	}
+
+	environment := make(map[string]string)
+	for _, setting := range configSettings {
+		if value, ok := os.LookupEnv("TASKS_" + strings.ToUpper(setting.key)); ok {
+			environment[setting.key] = value
+		}
+	}
+	if err := applyConfigValues(&config, environment, "environment"); err != nil {
+		return config, nil, err
+	}
+
+	flags := make(map[string]string)
+	flagSet.Visit(func(visited *flag.Flag) {
+		key := strings.ReplaceAll(visited.Name, "-", "_")
+		if value, ok := flagValues[key]; ok {
+			flags[key] = *value
+		}
+	})
+	if err := applyConfigValues(&config, flags, "flags"); err != nil {
+		return config, nil, err
+	}
+
+	if _, err := config.slogLevel(); err != nil {
+		return config, nil, err
+	}
+	if _, err := ParsePurgePolicy(config.TrashPurgePolicy); err != nil {
+		return config, nil, err
+	}
+	return config, flagSet.Args(), nil
+}
+
+func applyConfigValues(config *Config, values map[string]string, source string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		setting, ok := findConfigSetting(key)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", source, key)
+		}
+		if err := setting.apply(config, values[key]); err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+	}
+	return nil
+}
+
+// readConfigFile parses a flat config file. Files ending in .yaml or .yml
+// use `key: value` lines, anything else is read as TOML `key = value`
+// lines. Nested tables and mappings are not supported.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	separator := "="
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		separator = ":"
+	}
+	return parseConfigLines(file, separator, path)
+}
+
+func parseConfigLines(reader io.Reader, separator, path string) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: tables are not supported", path, lineNumber)
+		}
+
+		key, value, found := strings.Cut(line, separator)
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key %s value", path, lineNumber, separator)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// stripConfigComment removes a trailing # comment that is not inside quotes.
+func stripConfigComment(line string) string {
+	var quote rune
+	for index, character := range line {
+		switch {
+		case quote != 0 && character == quote:
+			quote = 0
+		case quote == 0 && (character == '"' || character == '\''):
+			quote = character
+		case quote == 0 && character == '#':
+			return line[:index]
+		}
+	}
+	return line
+}
+
+func (config Config) slogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return level, fmt.Errorf("invalid log level %q", config.LogLevel)
+	}
+	return level, nil
+}
+
+// configureLogging routes the standard logger through slog at the
+// configured level.
+func configureLogging(config Config) {
+	level, _ := config.slogLevel()
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	directory := t.TempDir()
+	tomlPath := filepath.Join(directory, "tasks.toml")
+	yamlPath := filepath.Join(directory, "tasks.yaml")
+	writeFile := func(path, contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(tomlPath, "# server\nlisten_address = \":9000\"\nmax_open_conns = 3 # small\nread_timeout = '5s'\n")
+	writeFile(yamlPath, "---\nlisten_address: \":9100\"\nregistration: false\n")
+
+	tests := []struct {
+		name        string
+		environment map[string]string
+		args        []string
+		check       func(Config) bool
+		wantArgs    []string
+	}{
+		{"defaults", nil, nil, func(config Config) bool { return config == DefaultConfig() }, []string{}},
+		{"toml file", nil, []string{"-config", tomlPath},
+			func(config Config) bool {
+				return config.ListenAddress == ":9000" && config.MaxOpenConns == 3 && config.ReadTimeout == 5*time.Second
+			}, []string{}},
+		{"yaml file from the environment", map[string]string{"TASKS_CONFIG": yamlPath}, nil,
+			func(config Config) bool { return config.ListenAddress == ":9100" && !config.Registration }, []string{}},
+		{"environment beats the file", map[string]string{"TASKS_LISTEN_ADDRESS": ":9200"}, []string{"-config", tomlPath},
+			func(config Config) bool { return config.ListenAddress == ":9200" && config.MaxOpenConns == 3 }, []string{}},
+		{"flags beat the environment", map[string]string{"TASKS_LISTEN_ADDRESS": ":9200"}, []string{"-listen-address", ":9300", "migrate", "status"},
+			func(config Config) bool { return config.ListenAddress == ":9300" }, []string{"migrate", "status"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.environment {
+				t.Setenv(key, value)
+			}
+			config, args, err := LoadConfig(test.args)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if !test.check(config) {
+				t.Errorf("unexpected config %+v", config)
+			}
+			if strings.Join(args, " ") != strings.Join(test.wantArgs, " ") {
+				t.Errorf("args = %q, want %q", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	directory := t.TempDir()
+	unknown := filepath.Join(directory, "unknown.toml")
+	table := filepath.Join(directory, "table.toml")
+	os.WriteFile(unknown, []byte("colour = \"blue\"\n"), 0o600)
+	os.WriteFile(table, []byte("[server]\nlisten_address = \":9000\"\n"), 0o600)
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"bad integer", []string{"-max-open-conns", "many"}, "max_open_conns"},
+		{"bad duration", []string{"-read-timeout", "soon"}, "read_timeout"},
+		{"bad boolean", []string{"-secure-cookies", "perhaps"}, "secure_cookies"},
+		{"bad log level", []string{"-log-level", "chatty"}, "invalid log level"},
+		{"bad purge policy", []string{"-trash-purge-policy", "shred"}, "shred"},
+		{"unknown file setting", []string{"-config", unknown}, `unknown setting "colour"`},
+		{"tables", []string{"-config", table}, "tables are not supported"},
+		{"missing file", []string{"-config", filepath.Join(directory, "missing.toml")}, "failed to open config file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := LoadConfig(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("LoadConfig(%q) error = %v, want one containing %q", test.args, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStripConfigComment(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"key = value", "key = value"},
+		{"key = value # note", "key = value "},
+		{`key = "a # b"`, `key = "a # b"`},
+		{`key = 'a # b' # note`, `key = 'a # b' `},
+		{"# whole line", ""},
+	}
+	for _, test := range tests {
+		if got := stripConfigComment(test.line); got != test.want {
+			t.Errorf("stripConfigComment(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
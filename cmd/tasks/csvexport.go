@@ -0,0 +1,236 @@
+// CSV and TSV export of the completion history for spreadsheets.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	// Windows builds have no zoneinfo of their own to load tz from.
+	_ "time/tzdata"
+)
+
+// CSVFormat is the delimiter style of a completion export.
+type CSVFormat string
+
+const (
+	FormatCSV CSVFormat = "csv"
+	FormatTSV CSVFormat = "tsv"
+)
+
+func (format CSVFormat) contentType() string {
+	if format == FormatTSV {
+		return "text/tab-separated-values; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// csvDateFormats are the layouts date_format may name. Spreadsheets read
+// datetime without help; unix is seconds since the epoch.
+var csvDateFormats = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"datetime": time.DateTime,
+	"date":     time.DateOnly,
+	"us":       "01/02/2006 15:04",
+	"eu":       "02/01/2006 15:04",
+	"unix":     "",
+}
+
+// CompletionExport selects and formats the rows of a completion export.
+type CompletionExport struct {
+	Format CSVFormat
+	// From and To bound the completion dates, both included, in Location.
+	From, To time.Time
+	TaskID   *int
+	Location *time.Location
+	// DateFormat is one of the names in csvDateFormats.
+	DateFormat string
+}
+
+// ParseCompletionExport reads the format, from, to, task, tz and
+// date_format parameters shared by the download link and the command.
+func ParseCompletionExport(query url.Values) (*CompletionExport, error) {
+	export := &CompletionExport{Format: CSVFormat(query.Get("format")), Location: time.Local, DateFormat: query.Get("date_format")}
+	switch export.Format {
+	case "":
+		export.Format = FormatCSV
+	case FormatCSV, FormatTSV:
+	default:
+		return nil, &ValidationError{fmt.Sprintf("invalid format %q: use csv or tsv", export.Format)}
+	}
+	if export.DateFormat == "" {
+		export.DateFormat = "datetime"
+	} else if _, ok := csvDateFormats[export.DateFormat]; !ok {
+		return nil, &ValidationError{fmt.Sprintf("invalid date_format %q: use rfc3339, datetime, date, us, eu or unix", export.DateFormat)}
+	}
+	if value := query.Get("tz"); value != "" {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return nil, &ValidationError{fmt.Sprintf("unknown time zone %q", value)}
+		}
+		export.Location = location
+	}
+	for _, bound := range []struct {
+		name        string
+		destination *time.Time
+	}{{"from", &export.From}, {"to", &export.To}} {
+		if value := query.Get(bound.name); value != "" {
+			date, err := time.ParseInLocation(time.DateOnly, value, export.Location)
+			if err != nil {
+				return nil, &ValidationError{fmt.Sprintf("invalid %s date, use YYYY-MM-DD: %s", bound.name, value)}
+			}
+			*bound.destination = date
+		}
+	}
+	if !export.From.IsZero() && !export.To.IsZero() && export.To.Before(export.From) {
+		return nil, &ValidationError{"from must not be after to"}
+	}
+	taskID, err := parseIDField("task", query.Get("task"))
+	if err != nil {
+		return nil, err
+	}
+	export.TaskID = taskID
+	return export, nil
+}
+
+func (export *CompletionExport) formatTime(moment time.Time) string {
+	moment = moment.In(export.Location)
+	if export.DateFormat == "unix" {
+		return strconv.FormatInt(moment.Unix(), 10)
+	}
+	return moment.Format(csvDateFormats[export.DateFormat])
+}
+
+// Filename names the download after its range.
+func (export *CompletionExport) Filename() string {
+	name := "completions"
+	if !export.From.IsZero() {
+		name += "-from-" + export.From.Format(time.DateOnly)
+	}
+	if !export.To.IsZero() {
+		name += "-to-" + export.To.Format(time.DateOnly)
+	}
+	return name + "." + string(export.Format)
+}
+
+// csvHeader names the columns WriteCompletionsCSV writes.
+var csvHeader = []string{"completion_id", "completed_at", "task_id", "task", "user", "points"}
+
+// csvFormulaPrefixes start cells a spreadsheet would run as a formula,
+// including the tab and carriage return that some strip before looking.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvText quotes a cell that a spreadsheet would otherwise read as a
+// formula with a leading apostrophe, so a task named =HYPERLINK(...) is
+// shown as text rather than run when the export is opened.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// queryExportedCompletions selects the approved completions userID can
+// see, the same ones the Completions list shows, in the export's range and
+// oldest first.
+func queryExportedCompletions(ctx context.Context, db *Database, userID int, export *CompletionExport) (*sql.Rows, error) {
+	query := `
+		SELECT ` + completionColumns + `
+		FROM completions completion
+		LEFT JOIN tasks task ON completion.task_id = task.id
+		WHERE ` + approvedCompletionsSQL + ` AND ` + visibleCompletionsSQL
+	args := []any{userID, userID}
+	if !export.From.IsZero() {
+		query += ` AND julianday(completion.completed_at) >= julianday(?)`
+		args = append(args, export.From)
+	}
+	if !export.To.IsZero() {
+		query += ` AND julianday(completion.completed_at) < julianday(?)`
+		args = append(args, export.To.AddDate(0, 0, 1))
+	}
+	if export.TaskID != nil {
+		query += ` AND completion.task_id = ?`
+		args = append(args, *export.TaskID)
+	}
+	query += ` ORDER BY julianday(completion.completed_at), completion.id`
+	return db.Conn.QueryContext(ctx, query, args...)
+}
+
+// writeCompletionsCSV writes rows from queryExportedCompletions under
+// csvHeader as they are read, and closes rows.
+func writeCompletionsCSV(export *CompletionExport, rows *sql.Rows, output io.Writer) error {
+	defer rows.Close()
+
+	writer := csv.NewWriter(output)
+	if export.Format == FormatTSV {
+		writer.Comma = '\t'
+	}
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for rows.Next() {
+		completion, err := scanCompletion(rows)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write([]string{
+			strconv.Itoa(completion.ID),
+			export.formatTime(completion.CompletedAt),
+			strconv.Itoa(completion.TaskID),
+			csvText(completion.TaskName),
+			csvText(completion.Username),
+			strconv.Itoa(completion.Points),
+		}); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteCompletionsCSV writes the export of the completions userID can see
+// to output.
+func WriteCompletionsCSV(ctx context.Context, db *Database, userID int, export *CompletionExport, output io.Writer) error {
+	rows, err := queryExportedCompletions(ctx, db, userID, export)
+	if err != nil {
+		return err
+	}
+	return writeCompletionsCSV(export, rows, output)
+}
+
+// handleAPIExportCompletions streams the completion history. A failed
+// query is still an error response; once rows are being sent, a failure
+// is only logged and cuts the download short.
+func handleAPIExportCompletions(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		export, err := ParseCompletionExport(request.URL.Query())
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		rows, err := queryExportedCompletions(request.Context(), appState.db, currentUserID(request), export)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", export.Format.contentType())
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename()))
+		if err := writeCompletionsCSV(export, rows, writer); err != nil {
+			log.Printf("Failed to export completions: %v", err)
+		}
+	}
+}
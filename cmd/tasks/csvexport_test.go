@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCompletionExport(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantErr  bool
+		filename string
+	}{
+		{"", false, "completions.csv"},
+		{"format=tsv&from=2024-01-01&to=2024-01-31", false, "completions-from-2024-01-01-to-2024-01-31.tsv"},
+		{"tz=Europe/Berlin&date_format=unix&task=3", false, "completions.csv"},
+		{"format=xlsx", true, ""},
+		{"date_format=julian", true, ""},
+		{"tz=Mars/Olympus", true, ""},
+		{"from=01/02/2024", true, ""},
+		{"from=2024-02-01&to=2024-01-01", true, ""},
+		{"task=three", true, ""},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		export, err := ParseCompletionExport(query)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: error = %v, want error %v", test.query, err, test.wantErr)
+			continue
+		}
+		if err == nil && export.Filename() != test.filename {
+			t.Errorf("%q: filename = %q, want %q", test.query, export.Filename(), test.filename)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"Dishes", "Dishes"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1 push-ups", "'+1 push-ups"},
+		{"-5 minutes", "'-5 minutes"},
+		{"@home", "'@home"},
+		{"Mail @home", "Mail @home"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+	}
+	for _, test := range tests {
+		if got := csvText(test.value); got != test.want {
+			t.Errorf("csvText(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestWriteCompletionsCSV(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	dishes := newTestTask(t, db, user.ID, &Task{Name: "Dishes"})
+	formula := newTestTask(t, db, user.ID, &Task{Name: "=1+1"})
+
+	// Completions are added out of order, so IDs do not follow the dates,
+	// and in different zones, so local clock times do not either.
+	days := []struct {
+		task *Task
+		at   time.Time
+	}{
+		{dishes, time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)},
+		{formula, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{dishes, time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)},
+		{formula, time.Date(2024, 3, 2, 12, 0, 0, 0, time.FixedZone("AEST", 10*60*60))},
+		{dishes, time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, day := range days {
+		if _, err := CreateCompletion(ctx, db, user.ID, day.task.ID, day.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  [][]string // completed_at and task of each row
+	}{
+		{"everything oldest first", "date_format=date&tz=UTC", [][]string{
+			{"2024-03-01", "'=1+1"}, {"2024-03-02", "'=1+1"}, {"2024-03-02", "Dishes"}, {"2024-03-03", "Dishes"}, {"2024-04-01", "Dishes"}}},
+		{"range", "date_format=date&tz=UTC&from=2024-03-02&to=2024-03-31", [][]string{
+			{"2024-03-02", "'=1+1"}, {"2024-03-02", "Dishes"}, {"2024-03-03", "Dishes"}}},
+		{"range in another zone", "date_format=datetime&tz=Australia/Brisbane&from=2024-03-02&to=2024-03-02", [][]string{
+			{"2024-03-02 12:00:00", "'=1+1"}, {"2024-03-02 19:00:00", "Dishes"}}},
+		{"one task as tsv", "format=tsv&date_format=unix&task=" + strconv.Itoa(formula.ID), [][]string{
+			{"1709283600", "'=1+1"}, {"1709344800", "'=1+1"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			export, err := ParseCompletionExport(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output bytes.Buffer
+			if err := WriteCompletionsCSV(ctx, db, user.ID, export, &output); err != nil {
+				t.Fatal(err)
+			}
+			reader := csv.NewReader(&output)
+			if export.Format == FormatTSV {
+				reader.Comma = '\t'
+			}
+			records, err := reader.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+				t.Errorf("header = %v", records[0])
+			}
+			var got [][]string
+			for _, record := range records[1:] {
+				got = append(got, []string{record[1], record[3]})
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("rows = %v, want %v", got, test.want)
+			}
+			for index := range got {
+				if got[index][0] != test.want[index][0] || got[index][1] != test.want[index][1] {
+					t.Errorf("rows = %v, want %v", got, test.want)
+					break
+				}
+			}
+		})
+	}
+}
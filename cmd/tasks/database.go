@@ -6,6 +6,7 @@ import (
     "time"
     "os"
     "fmt"
+    "path/filepath"
     _ "modernc.org/sqlite" // Changed import from mattn/go-sqlite3
 )
 
@@ -13,14 +14,19 @@ type Database struct {
     Conn *sql.DB
 }
 
-func NewDatabase() (*Database, error) {
-    // Ensure sqlite_db directory exists
-    if err := os.MkdirAll("../sqlite_db", 0755); err != nil {
+func NewDatabase(config Config) (*Database, error) {
+    // Ensure the database directory exists
+    if err := os.MkdirAll(filepath.Dir(config.DatabasePath), 0755); err != nil {
         return nil, fmt.Errorf("failed to create database directory: %v", err)
     }
 
-    // Remove WAL mode as it's not needed with modernc/sqlite
-    databaseConnection, err := sql.Open("sqlite", "../sqlite_db/task_tracker.db")
+    // Remove WAL mode as it's not needed with modernc/sqlite.
+    // busy_timeout makes concurrent writers wait for each other instead of
+    // failing with "database is locked". _time_format stores times as
+    // "2006-01-02 15:04:05.999999999-07:00", which SQLite's date functions
+    // read, rather than as Go prints them; compare them in SQL through
+    // julianday, as the offsets differ.
+    databaseConnection, err := sql.Open("sqlite", config.DatabasePath+"?_pragma=busy_timeout(5000)&_time_format=sqlite")
     if err != nil {
         return nil, err
     }
@@ -35,9 +41,9 @@ func NewDatabase() (*Database, error) {
     }
 
     // Set connection pool settings
-    databaseConnection.SetMaxOpenConns(10)
-    databaseConnection.SetMaxIdleConns(5)
-    databaseConnection.SetConnMaxLifetime(time.Hour)
+    databaseConnection.SetMaxOpenConns(config.MaxOpenConns)
+    databaseConnection.SetMaxIdleConns(config.MaxIdleConns)
+    databaseConnection.SetConnMaxLifetime(config.ConnMaxLifetime)
 
     return &Database{Conn: databaseConnection}, nil
 }
@@ -46,90 +52,8 @@ func (db *Database) Close() error {
     return db.Conn.Close()
 }
 
-func (db *Database) Initialize(ctx context.Context) error {
-    // Create tables within transaction
-    transaction, err := db.Conn.BeginTx(ctx, nil)
-    if (err != nil) {
-        return err
-    }
-    defer transaction.Rollback()
-
-    // Create tasks table with deleted flag and notes
-    if _, err := transaction.ExecContext(ctx, `
-        CREATE TABLE IF NOT EXISTS tasks (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            name TEXT NOT NULL,
-            points INTEGER NOT NULL DEFAULT 0,
-            notes TEXT,
-            created_at DATETIME NOT NULL,
-            deleted BOOLEAN NOT NULL DEFAULT 0
-        );`); err != nil {
-        return err
-    }
-
-    // Create completions table
-    if _, err := transaction.ExecContext(ctx, `
-        CREATE TABLE IF NOT EXISTS completions (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            task_id INTEGER NOT NULL,
-            completed_at DATETIME NOT NULL,
-            points INTEGER NOT NULL,
-            FOREIGN KEY(task_id) REFERENCES tasks(id)
-        );`); err != nil {
-        return err
-    }
-
-    return transaction.Commit()
-}
-
-// Add migration function to add deleted column if it doesn't exist
-func (db *Database) Migrate(ctx context.Context) error {
-    // Ensure all necessary migrations are applied
-    migrations := []struct {
-        query string
-    }{
-        {
-            query: `
-                CREATE TABLE IF NOT EXISTS tasks (
-                    id INTEGER PRIMARY KEY AUTOINCREMENT,
-                    name TEXT NOT NULL,
-                    points INTEGER NOT NULL DEFAULT 0,
-                    notes TEXT,
-                    created_at DATETIME NOT NULL,
-                    deleted BOOLEAN NOT NULL DEFAULT 0
-                );`,
-        },
-        {
-            query: `
-                CREATE TABLE IF NOT EXISTS completions (
-                    id INTEGER PRIMARY KEY AUTOINCREMENT,
-                    task_id INTEGER NOT NULL,
-                    completed_at DATETIME NOT NULL,
-                    points INTEGER NOT NULL,
-                    FOREIGN KEY(task_id) REFERENCES tasks(id)
-                );`,
-        },
-        // Add more migrations as needed
-    }
-
-    transaction, err := db.Conn.BeginTx(ctx, nil)
-    if err != nil {
-        return err
-    }
-    defer transaction.Rollback()
-
-    for _, migration := range migrations {
-        _, err := transaction.ExecContext(ctx, migration.query)
-        if err != nil {
-            return err
-        }
-    }
-
-    return transaction.Commit()
-}
-
 // Modify InsertTask to include notes
-func (db *Database) InsertTask(ctx context.Context, name string, points *int, notes string) (int64, error) {
+func (db *Database) InsertTask(ctx context.Context, userID int, name string, points *int, notes string) (int64, error) {
     var taskPoints int
     if points != nil {
         taskPoints = *points
@@ -138,10 +62,10 @@ func (db *Database) InsertTask(ctx context.Context, name string, points *int, no
     }
 
     result, err := db.Conn.ExecContext(ctx, `
-        INSERT INTO tasks (name, points, notes, created_at)
-        VALUES (?, ?, ?, ?)`,
+        INSERT INTO tasks (user_id, name, points, notes, created_at)
+        VALUES (?, ?, ?, ?, ?)`,
 
-        name, taskPoints, notes, time.Now(),
+        userID, name, taskPoints, notes, time.Now(),
     )
     if err != nil {
         return 0, err
@@ -151,28 +75,11 @@ func (db *Database) InsertTask(ctx context.Context, name string, points *int, no
 }
 
 // Add DeleteTask method to Database struct
-func (db *Database) DeleteTask(ctx context.Context, taskID int) error {
-    result, err := db.Conn.ExecContext(ctx, 
-        "UPDATE tasks SET deleted = 1 WHERE id = ?", 
-        taskID)
-    if err != nil {
-        return err
-    }
-
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return err
-    }
-    if rows == 0 {
-        return fmt.Errorf("task not found: %d", taskID)
-    }
-    return nil
+func (db *Database) DeleteTask(ctx context.Context, userID, taskID int) error {
+    return DeleteTask(ctx, db, userID, taskID)
 }
 
 // Add DeleteCompletion method to Database struct
-func (db *Database) DeleteCompletion(ctx context.Context, completionID int) error {
-    _, err := db.Conn.ExecContext(ctx, 
-        "DELETE FROM completions WHERE id = ?", 
-        completionID)
-    return err
+func (db *Database) DeleteCompletion(ctx context.Context, userID, completionID int) error {
+    return DeleteCompletion(ctx, db, userID, completionID)
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Full strength password hashing would dominate the run time.
+	passwordIterations = 1000
+	os.Exit(m.Run())
+}
+
+// newTestDatabase opens a fresh database with every migration applied.
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "tasks.db")
+	db, err := NewDatabase(config)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+// newTestUser registers username with a throwaway password.
+func newTestUser(t *testing.T, db *Database, username string) *User {
+	t.Helper()
+	user, err := CreateUser(context.Background(), db, username, "correct horse battery")
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return user
+}
+
+// newTestTask saves task for userID, naming it when it has no name.
+func newTestTask(t *testing.T, db *Database, userID int, task *Task) *Task {
+	t.Helper()
+	task.UserID = userID
+	if task.Name == "" {
+		task.Name = "Test task"
+	}
+	if err := SaveNewTask(context.Background(), db, task); err != nil {
+		t.Fatalf("SaveNewTask: %v", err)
+	}
+	return task
+}
+
+// newTestGroup makes a group owned by owner and adds each of members with
+// its role through an invite.
+func newTestGroup(t *testing.T, db *Database, owner *User, members map[*User]Role) *Group {
+	t.Helper()
+	ctx := context.Background()
+	group, err := CreateGroup(ctx, db, owner.ID, "Household")
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	for member, role := range members {
+		invite, err := CreateInvite(ctx, db, owner.ID, group.ID, role)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		if _, err := AcceptInvite(ctx, db, member.ID, invite.Token); err != nil {
+			t.Fatalf("AcceptInvite: %v", err)
+		}
+	}
+	return group
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != len(migrations) {
+		t.Fatalf("got %d migration states, want %d", len(states), len(migrations))
+	}
+	for _, state := range states {
+		if !state.Applied || state.Drifted || state.Unknown {
+			t.Errorf("migration %d: applied %v, drifted %v, unknown %v", state.Version, state.Applied, state.Drifted, state.Unknown)
+		}
+	}
+
+	if err := db.MigrateDown(ctx, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	states, err = db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.Applied {
+			t.Errorf("migration %d still applied after reverting everything", state.Version)
+		}
+	}
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate after MigrateDown: %v", err)
+	}
+}
+
+func TestMigrationVersionsIncrease(t *testing.T) {
+	for index := 1; index < len(migrations); index++ {
+		if migrations[index].Version <= migrations[index-1].Version {
+			t.Errorf("migration %d follows %d", migrations[index].Version, migrations[index-1].Version)
+		}
+	}
+}
+
+func TestMigrationsStoreTimesInOneFormat(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	if err := db.MigrateDown(ctx, latestMigrationVersion()-16); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		stored, want string
+	}{
+		{"2024-03-01 10:00:00.5 +0100 CET m=+0.012345678", "2024-03-01 09:00:00.5+00:00"},
+		{"2024-03-01 00:30:00 -0500 EST", "2024-03-01 05:30:00+00:00"},
+		{"2024-03-01 09:00:00.123456789 +0000 UTC", "2024-03-01 09:00:00.123456789+00:00"},
+		{"2024-03-01 09:00:00", "2024-03-01 09:00:00+00:00"},
+		{"2024-03-01 09:00:00.250+02:00", "2024-03-01 07:00:00.25+00:00"},
+	}
+	for _, test := range tests {
+		if _, err := db.Conn.ExecContext(ctx, "INSERT INTO completions (task_id, completed_at, points) VALUES (1, ?, 0)", test.stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := newTestUser(t, db, "alice")
+	if _, err := db.Conn.ExecContext(ctx, "UPDATE users SET created_at = ? WHERE id = ?", "2024-03-01 10:00:00 +0100 CET", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, "SELECT CAST(completed_at AS TEXT) FROM completions ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for _, test := range tests {
+		var got string
+		if !rows.Next() {
+			t.Fatal("missing completion")
+		}
+		if err := rows.Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%q became %q, want %q", test.stored, got, test.want)
+		}
+	}
+
+	var created string
+	if err := db.Conn.QueryRowContext(ctx, "SELECT CAST(created_at AS TEXT) FROM users WHERE id = ?", user.ID).Scan(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created != "2024-03-01 10:00:00+01:00" {
+		t.Errorf("user created at %q, want %q", created, "2024-03-01 10:00:00+01:00")
+	}
+}
@@ -0,0 +1,210 @@
+// Due dates, priorities and task list ordering.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Priority ranks tasks from PriorityNone to PriorityHigh.
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityNone:   "none",
+	PriorityLow:    "low",
+	PriorityMedium: "medium",
+	PriorityHigh:   "high",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority accepts either the number or the name of a priority.
+func ParsePriority(value string) (Priority, error) {
+	if value == "" {
+		return PriorityNone, nil
+	}
+	for priority, name := range priorityNames {
+		if name == value {
+			return priority, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || Priority(number) < PriorityNone || Priority(number) > PriorityHigh {
+		return PriorityNone, &ValidationError{fmt.Sprintf("invalid priority %q: use none, low, medium or high", value)}
+	}
+	return Priority(number), nil
+}
+
+// DueView selects tasks by due date relative to now.
+type DueView string
+
+const (
+	DueAny DueView = ""
+	// DueOverdue is every task whose due time has passed.
+	DueOverdue DueView = "overdue"
+	// DueToday is every task due on the current calendar day, including
+	// ones already overdue today.
+	DueToday DueView = "today"
+	// DueUpcoming is every task due after today.
+	DueUpcoming DueView = "upcoming"
+	// DueNone is every task without a due date.
+	DueNone DueView = "none"
+)
+
+func ParseDueView(value string) (DueView, error) {
+	switch view := DueView(value); view {
+	case DueAny, DueOverdue, DueToday, DueUpcoming, DueNone:
+		return view, nil
+	}
+	return DueAny, &ValidationError{fmt.Sprintf("invalid due view %q: use overdue, today, upcoming or none", value)}
+}
+
+// TaskSort names an ordering of the task list. Each has a default
+// direction that ?order= can override.
+type TaskSort string
+
+const (
+	// SortCreated lists the oldest tasks first.
+	SortCreated TaskSort = "created"
+	// SortDue lists the soonest due first. Tasks without a due date are
+	// always last.
+	SortDue TaskSort = "due"
+	// SortPriority lists the highest priority first.
+	SortPriority TaskSort = "priority"
+	// SortPoints lists the most valuable first.
+	SortPoints TaskSort = "points"
+)
+
+func ParseTaskSort(value string) (TaskSort, error) {
+	switch sortBy := TaskSort(value); sortBy {
+	case "":
+		return SortCreated, nil
+	case SortCreated, SortDue, SortPriority, SortPoints:
+		return sortBy, nil
+	}
+	return SortCreated, &ValidationError{fmt.Sprintf("invalid sort %q: use created, due, priority or points", value)}
+}
+
+func startOfDay(moment time.Time) time.Time {
+	year, month, day := moment.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, moment.Location())
+}
+
+// dueStatus classifies a task for display: "overdue", "today", "upcoming"
+// or "" when it has no due date.
+func dueStatus(task *Task, now time.Time) DueView {
+	if task.DueAt == nil {
+		return DueAny
+	}
+	due := task.DueAt.In(now.Location())
+	switch {
+	case due.Before(now):
+		return DueOverdue
+	case due.Before(startOfDay(now).AddDate(0, 0, 1)):
+		return DueToday
+	}
+	return DueUpcoming
+}
+
+func filterTasksByDue(tasks []*Task, view DueView, now time.Time) []*Task {
+	if view == DueAny {
+		return tasks
+	}
+
+	tomorrow := startOfDay(now).AddDate(0, 0, 1)
+	filtered := tasks[:0]
+	for _, task := range tasks {
+		var keep bool
+		switch view {
+		case DueNone:
+			keep = task.DueAt == nil
+		case DueOverdue:
+			keep = task.DueAt != nil && task.DueAt.Before(now)
+		case DueToday:
+			keep = task.DueAt != nil && !task.DueAt.Before(startOfDay(now)) && task.DueAt.Before(tomorrow)
+		case DueUpcoming:
+			keep = task.DueAt != nil && !task.DueAt.Before(tomorrow)
+		}
+		if keep {
+			filtered = append(filtered, task)
+		}
+	}
+	return filtered
+}
+
+// sortTasks orders tasks in place by ascending value, or descending when
+// requested. Ties keep creation order.
+func sortTasks(tasks []*Task, sortBy TaskSort, descending bool) {
+	compare := func(a, b *Task) int { return a.ID - b.ID }
+	switch sortBy {
+	case SortDue:
+		compare = func(a, b *Task) int { return a.DueAt.Compare(*b.DueAt) }
+	case SortPriority:
+		compare = func(a, b *Task) int { return int(a.Priority - b.Priority) }
+	case SortPoints:
+		compare = func(a, b *Task) int { return a.Points - b.Points }
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if sortBy == SortDue && (a.DueAt == nil || b.DueAt == nil) {
+			return a.DueAt != nil && b.DueAt == nil
+		}
+		if descending {
+			return compare(a, b) > 0
+		}
+		return compare(a, b) < 0
+	})
+}
+
+// parseDueField reads a datetime-local form value in the server's time
+// zone, where "" means no due date.
+func parseDueField(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02"} {
+		if due, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &due, nil
+		}
+	}
+	return nil, &ValidationError{fmt.Sprintf("invalid due date %q", value)}
+}
+
+// parseTaskOrdering reads the ?due=, ?sort= and ?order= parameters shared by
+// the task list and the API.
+func parseTaskOrdering(query url.Values, filter *TaskFilter) error {
+	var err error
+	if filter.Due, err = ParseDueView(query.Get("due")); err != nil {
+		return err
+	}
+	if filter.Sort, err = ParseTaskSort(query.Get("sort")); err != nil {
+		return err
+	}
+	switch query.Get("order") {
+	case "":
+		filter.Descending = filter.Sort == SortPriority || filter.Sort == SortPoints
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return &ValidationError{fmt.Sprintf("invalid order %q: use asc or desc", query.Get("order"))}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Priority
+		wantErr bool
+	}{
+		{"", PriorityNone, false},
+		{"high", PriorityHigh, false},
+		{"1", PriorityLow, false},
+		{"4", PriorityNone, true},
+		{"-1", PriorityNone, true},
+		{"urgent", PriorityNone, true},
+	}
+	for _, test := range tests {
+		got, err := ParsePriority(test.value)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("ParsePriority(%q) = %v, %v; want %v, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// hours is an offset for dueTasks.
+func hours(count int) *time.Duration {
+	duration := time.Duration(count) * time.Hour
+	return &duration
+}
+
+// dueTasks are numbered in creation order; a nil offset has no due date.
+func dueTasks(now time.Time, offsets ...*time.Duration) []*Task {
+	tasks := make([]*Task, len(offsets))
+	for index, offset := range offsets {
+		tasks[index] = &Task{ID: index + 1}
+		if offset != nil {
+			due := now.Add(*offset)
+			tasks[index].DueAt = &due
+		}
+	}
+	return tasks
+}
+
+func taskIDs(tasks []*Task) string {
+	ids := make([]string, len(tasks))
+	for index, task := range tasks {
+		ids[index] = strconv.Itoa(task.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestFilterTasksByDue(t *testing.T) {
+	now := localTime(2024, 3, 6, 12)
+
+	tests := []struct {
+		view DueView
+		want string
+	}{
+		{DueAny, "1 2 3 4 5"},
+		{DueOverdue, "1 2"},
+		{DueToday, "2 3"},
+		{DueUpcoming, "4"},
+		{DueNone, "5"},
+	}
+	for _, test := range tests {
+		// Yesterday, this morning, this evening, tomorrow and undated.
+		tasks := dueTasks(now, hours(-24), hours(-2), hours(6), hours(24), nil)
+		if got := taskIDs(filterTasksByDue(tasks, test.view, now)); got != test.want {
+			t.Errorf("filterTasksByDue(%q) = %s, want %s", test.view, got, test.want)
+		}
+	}
+}
+
+func TestSortTasks(t *testing.T) {
+	now := localTime(2024, 3, 6, 12)
+	tests := []struct {
+		sortBy     TaskSort
+		descending bool
+		want       string
+	}{
+		{SortCreated, false, "1 2 3 4"},
+		{SortCreated, true, "4 3 2 1"},
+		{SortDue, false, "3 1 4 2"},
+		{SortDue, true, "4 1 3 2"},
+		{SortPriority, true, "2 4 1 3"},
+		{SortPoints, false, "3 1 2 4"},
+	}
+	for _, test := range tests {
+		tasks := dueTasks(now, hours(2), nil, hours(1), hours(3))
+		for index, task := range tasks {
+			task.Priority = []Priority{PriorityLow, PriorityHigh, PriorityNone, PriorityHigh}[index]
+			task.Points = []int{5, 5, 1, 9}[index]
+		}
+		sortTasks(tasks, test.sortBy, test.descending)
+		if got := taskIDs(tasks); got != test.want {
+			t.Errorf("sortTasks(%s, descending %v) = %s, want %s", test.sortBy, test.descending, got, test.want)
+		}
+	}
+}
+
+func TestParseTaskOrdering(t *testing.T) {
+	tests := []struct {
+		query          string
+		wantSort       TaskSort
+		wantDescending bool
+		wantErr        bool
+	}{
+		{"", SortCreated, false, false},
+		{"sort=points", SortPoints, true, false},
+		{"sort=points&order=asc", SortPoints, false, false},
+		{"sort=due&order=desc&due=upcoming", SortDue, true, false},
+		{"sort=sideways", "", false, true},
+		{"order=up", "", false, true},
+		{"due=someday", "", false, true},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var filter TaskFilter
+		err = parseTaskOrdering(query, &filter)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseTaskOrdering(%q) error = %v, want error %v", test.query, err, test.wantErr)
+			continue
+		}
+		if err == nil && (filter.Sort != test.wantSort || filter.Descending != test.wantDescending) {
+			t.Errorf("parseTaskOrdering(%q) = %s descending %v, want %s descending %v",
+				test.query, filter.Sort, filter.Descending, test.wantSort, test.wantDescending)
+		}
+	}
+}
+
+func TestParseDueField(t *testing.T) {
+	for value, want := range map[string]time.Time{
+		"2024-03-06T09:30": time.Date(2024, 3, 6, 9, 30, 0, 0, time.Local),
+		"2024-03-06":       time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local),
+	} {
+		got, err := parseDueField(value)
+		if err != nil || got == nil || !got.Equal(want) {
+			t.Errorf("parseDueField(%q) = %v, %v; want %s", value, got, err, want)
+		}
+	}
+	if got, err := parseDueField(""); got != nil || err != nil {
+		t.Errorf("parseDueField(\"\") = %v, %v; want no due date", got, err)
+	}
+	if _, err := parseDueField("next week"); err == nil {
+		t.Error("parseDueField accepted \"next week\"")
+	}
+}
@@ -0,0 +1,675 @@
+// Export and import of a user's data as a versioned JSON document, for
+// moving it between machines.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// exportFormat names the document so an import can reject other JSON.
+const exportFormat = "go_tasks"
+
+// exportVersion is bumped whenever the document changes incompatibly.
+// Imports accept this version and every one before it.
+const exportVersion = 1
+
+// maxImportBytes bounds the body of an import request.
+const maxImportBytes = 64 << 20
+
+// Export is everything a user owns: their projects, personal tasks
+// including archived and deleted ones, their completions, ledger, personal
+// rewards, redemptions, goals and custom badges. Group tasks and rewards,
+// and redemptions of group rewards, belong to the group and are left out.
+// IDs are those of the exporting database; an import maps them to new
+// ones.
+type Export struct {
+	Format        string                `json:"format"`
+	Version       int                   `json:"version"`
+	SchemaVersion int                   `json:"schema_version"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Username      string                `json:"username"`
+	Projects      []*Project            `json:"projects"`
+	Tasks         []*Task               `json:"tasks"`
+	Completions   []*ExportedCompletion `json:"completions"`
+	PointEntries  []*LedgerEntry        `json:"point_entries"`
+	Rewards       []*Reward             `json:"rewards"`
+	Redemptions   []*ExportedRedemption `json:"redemptions"`
+	Goals         []*Goal               `json:"goals"`
+	Badges        []*Badge              `json:"badges"`
+}
+
+// ExportedCompletion is a completion as stored. TaskName is the task's
+// current name, or the one it had when it was purged.
+type ExportedCompletion struct {
+	ID          int              `json:"id"`
+	TaskID      int              `json:"task_id"`
+	TaskName    string           `json:"task_name"`
+	CompletedAt time.Time        `json:"completed_at"`
+	Points      int              `json:"points"`
+	Status      CompletionStatus `json:"status"`
+	ReviewedAt  *time.Time       `json:"reviewed_at,omitempty"`
+}
+
+type ExportedRedemption struct {
+	ID         int       `json:"id"`
+	RewardID   int       `json:"reward_id"`
+	RewardName string    `json:"reward_name"`
+	Cost       int       `json:"cost"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// ImportMode says what happens to the data a user already has.
+type ImportMode string
+
+const (
+	// ImportMerge adds the document to the existing data, reusing
+	// projects with the same name.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace deletes everything an export would contain first.
+	ImportReplace ImportMode = "replace"
+)
+
+func ParseImportMode(value string) (ImportMode, error) {
+	switch mode := ImportMode(value); mode {
+	case "":
+		return ImportMerge, nil
+	case ImportMerge, ImportReplace:
+		return mode, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("invalid mode %q: use merge or replace", value)}
+}
+
+// ImportReport counts what an import created. Skipped describes records
+// that referred to something outside the document.
+type ImportReport struct {
+	Mode         ImportMode `json:"mode"`
+	Projects     int        `json:"projects"`
+	Tasks        int        `json:"tasks"`
+	Completions  int        `json:"completions"`
+	PointEntries int        `json:"point_entries"`
+	Rewards      int        `json:"rewards"`
+	Redemptions  int        `json:"redemptions"`
+	Goals        int        `json:"goals"`
+	Badges       int        `json:"badges"`
+	Skipped      []string   `json:"skipped"`
+}
+
+// ExportData collects userID's data into a document.
+func ExportData(ctx context.Context, db *Database, userID int) (*Export, error) {
+	user, err := GetUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &Export{
+		Format:        exportFormat,
+		Version:       exportVersion,
+		SchemaVersion: latestMigrationVersion(),
+		ExportedAt:    time.Now().UTC(),
+		Username:      user.Username,
+		Completions:   []*ExportedCompletion{},
+		PointEntries:  []*LedgerEntry{},
+		Rewards:       []*Reward{},
+		Redemptions:   []*ExportedRedemption{},
+		Goals:         []*Goal{},
+		Badges:        []*Badge{},
+	}
+
+	if export.Projects, err = GetProjects(db, userID); err != nil {
+		return nil, err
+	}
+	if export.Tasks, err = exportTasks(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if err := exportCompletions(ctx, db, userID, export); err != nil {
+		return nil, err
+	}
+	ledger, err := GetLedger(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The ledger lists the newest entry first; imports replay it in order.
+	for index := len(ledger.Entries) - 1; index >= 0; index-- {
+		export.PointEntries = append(export.PointEntries, ledger.Entries[index])
+	}
+	rewards, err := GetRewards(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reward := range rewards {
+		if reward.GroupID == nil {
+			export.Rewards = append(export.Rewards, reward)
+		}
+	}
+	if err := exportRedemptions(ctx, db, userID, export); err != nil {
+		return nil, err
+	}
+	if err := exportGoals(ctx, db, userID, export); err != nil {
+		return nil, err
+	}
+	badges, err := GetBadges(ctx, db.Conn, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, badge := range badges {
+		if !badge.BuiltIn {
+			badge.AwardedAt = nil
+			export.Badges = append(export.Badges, badge)
+		}
+	}
+	return export, nil
+}
+
+func exportTasks(ctx context.Context, db *Database, userID int) ([]*Task, error) {
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+taskColumns+` FROM tasks task WHERE task.user_id = ? AND task.group_id IS NULL ORDER BY task.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []*Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, loadTaskTags(db, tasks)
+}
+
+// exportCompletions adds userID's completions of their personal tasks and
+// of tasks that have since been purged, in every status.
+func exportCompletions(ctx context.Context, db *Database, userID int, export *Export) error {
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT completion.id, completion.task_id, COALESCE(task.name, completion.task_name, ''), completion.completed_at,
+			completion.points, completion.status, completion.reviewed_at
+		FROM completions completion
+		LEFT JOIN tasks task ON completion.task_id = task.id
+		WHERE completion.user_id = ? AND (task.id IS NULL OR (task.user_id = completion.user_id AND task.group_id IS NULL))
+		ORDER BY completion.id`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		completion := &ExportedCompletion{}
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&completion.ID, &completion.TaskID, &completion.TaskName, &completion.CompletedAt,
+			&completion.Points, &completion.Status, &reviewedAt); err != nil {
+			return err
+		}
+		if reviewedAt.Valid {
+			completion.ReviewedAt = &reviewedAt.Time
+		}
+		export.Completions = append(export.Completions, completion)
+	}
+	return rows.Err()
+}
+
+func exportRedemptions(ctx context.Context, db *Database, userID int, export *Export) error {
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT id, reward_id, reward_name, cost, redeemed_at FROM redemptions
+		WHERE user_id = ? AND id NOT IN (`+groupRedemptionsSQL+`)
+		ORDER BY id`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		redemption := &ExportedRedemption{}
+		if err := rows.Scan(&redemption.ID, &redemption.RewardID, &redemption.RewardName, &redemption.Cost, &redemption.RedeemedAt); err != nil {
+			return err
+		}
+		export.Redemptions = append(export.Redemptions, redemption)
+	}
+	return rows.Err()
+}
+
+func exportGoals(ctx context.Context, db *Database, userID int, export *Export) error {
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+goalColumns+` FROM goals goal WHERE goal.user_id = ? ORDER BY goal.id`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		goal, err := scanGoal(rows)
+		if err != nil {
+			return err
+		}
+		export.Goals = append(export.Goals, goal)
+	}
+	return rows.Err()
+}
+
+// check rejects documents this version cannot read.
+func (export *Export) check() error {
+	if export.Format != exportFormat {
+		return &ValidationError{fmt.Sprintf("not a %s export: format is %q", exportFormat, export.Format)}
+	}
+	if export.Version < 1 || export.Version > exportVersion {
+		return &ValidationError{fmt.Sprintf("unsupported export version %d: this server reads versions 1 to %d", export.Version, exportVersion)}
+	}
+	return nil
+}
+
+// groupRedemptionsSQL matches the redemptions of group rewards, which
+// stay with the group like its tasks do.
+const groupRedemptionsSQL = `SELECT redemption_id FROM point_entries WHERE group_id IS NOT NULL AND redemption_id IS NOT NULL`
+
+// clearUserData deletes everything ExportData would export for userID, so
+// a replacing import starts from nothing. Group tasks keep their project
+// columns cleared rather than pointing at deleted projects, and the points
+// earned and spent in groups are kept along with their completions and
+// redemptions.
+func clearUserData(ctx context.Context, transaction *sql.Tx, userID int) error {
+	statements := []string{
+		"UPDATE tasks SET project_id = NULL WHERE project_id IN (SELECT id FROM projects WHERE user_id = ?1)",
+		"DELETE FROM task_tags WHERE task_id IN (SELECT id FROM tasks WHERE user_id = ?1 AND group_id IS NULL)",
+		`DELETE FROM completions WHERE task_id IN (SELECT id FROM tasks WHERE user_id = ?1 AND group_id IS NULL)
+			OR (user_id = ?1 AND task_id NOT IN (SELECT id FROM tasks))`,
+		"DELETE FROM user_badges WHERE user_id = ?1 OR badge_id IN (SELECT id FROM badges WHERE user_id = ?1)",
+		"DELETE FROM badges WHERE user_id = ?1",
+		"DELETE FROM goals WHERE user_id = ?1",
+		"DELETE FROM redemptions WHERE user_id = ?1 AND id NOT IN (" + groupRedemptionsSQL + ")",
+		"DELETE FROM point_entries WHERE user_id = ?1 AND group_id IS NULL",
+		"DELETE FROM rewards WHERE user_id = ?1 AND group_id IS NULL",
+		"DELETE FROM tasks WHERE user_id = ?1 AND group_id IS NULL",
+		"DELETE FROM projects WHERE user_id = ?1",
+		"DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM task_tags)",
+	}
+	for _, statement := range statements {
+		if _, err := transaction.ExecContext(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportData adds the document's data to userID's in one transaction, so
+// a failed import changes nothing. Every record gets a new ID and the
+// references between records are mapped to them. Completions of tasks the
+// document does not contain keep their task name under task_id 0, the way
+// completions of purged tasks do; goals and badges about such tasks are
+// skipped. Records userID already has, such as those of an earlier import
+// of the same document, are not added again.
+//
+// The ledger is not taken from the document, which anyone can edit.
+// Approved completions of the imported tasks earn their points again, up
+// to what the task is worth, imported redemptions are paid for, and only
+// the deductions among the adjustments are kept. Badges are awarded afresh
+// for the imported history.
+func ImportData(ctx context.Context, db *Database, userID int, export *Export, mode ImportMode) (*ImportReport, error) {
+	if err := export.check(); err != nil {
+		return nil, err
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	if mode == ImportReplace {
+		if err := clearUserData(ctx, transaction, userID); err != nil {
+			return nil, err
+		}
+	}
+	report := &ImportReport{Mode: mode, Skipped: []string{}}
+	insert := func(query string, args ...any) (int, error) {
+		result, err := transaction.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		id, err := result.LastInsertId()
+		return int(id), err
+	}
+	mapped := func(ids map[int]int, id *int) *int {
+		if id == nil {
+			return nil
+		}
+		if newID, ok := ids[*id]; ok {
+			return &newID
+		}
+		return nil
+	}
+
+	projects := map[int]int{}
+	for _, project := range export.Projects {
+		var id int
+		err := transaction.QueryRowContext(ctx, "SELECT id FROM projects WHERE user_id = ? AND name = ?", userID, project.Name).Scan(&id)
+		if err == sql.ErrNoRows {
+			id, err = insert("INSERT INTO projects (name, created_at, user_id) VALUES (?, ?, ?)", project.Name, project.CreatedAt, userID)
+			report.Projects++
+		}
+		if err != nil {
+			return nil, err
+		}
+		projects[project.ID] = id
+	}
+
+	existingTasks, err := importKeys(ctx, transaction,
+		"SELECT id, name, created_at FROM tasks WHERE user_id = ? AND group_id IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	tasks := map[int]int{}
+	taskPoints := map[int]int{}
+	for _, task := range export.Tasks {
+		if err := task.Validate(); err != nil {
+			return nil, fmt.Errorf("task %d: %w", task.ID, err)
+		}
+		tags, err := NormalizeTags(task.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("task %d: %w", task.ID, err)
+		}
+		recurrence, err := NormalizeRecurrence(task.Recurrence)
+		if err != nil {
+			return nil, fmt.Errorf("task %d: %w", task.ID, err)
+		}
+		taskPoints[task.ID] = task.Points
+		if id, ok := existingTasks[importKey(task.Name, task.CreatedAt)]; ok {
+			tasks[task.ID] = id
+			continue
+		}
+		// A personal task can only be assigned to its owner.
+		var assigneeID *int
+		if task.AssigneeID != nil {
+			assigneeID = &userID
+		}
+		id, err := insert(`
+			INSERT INTO tasks (user_id, name, points, notes, created_at, deleted, deleted_at, project_id, due_at, priority, recurrence,
+				max_completions, completion_period, cooldown_minutes, one_shot, archived_at, requires_approval, assignee_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, task.Name, task.Points, task.Notes, task.CreatedAt, task.DeletedAt != nil, task.DeletedAt, mapped(projects, task.ProjectID),
+			task.DueAt, task.Priority, recurrence, task.MaxCompletions, task.CompletionPeriod, task.CooldownMinutes, task.OneShot,
+			task.ArchivedAt, task.RequiresApproval, assigneeID)
+		if err != nil {
+			return nil, err
+		}
+		if err := setTaskTags(ctx, transaction, userID, id, tags); err != nil {
+			return nil, err
+		}
+		tasks[task.ID] = id
+		report.Tasks++
+	}
+
+	for _, completion := range export.Completions {
+		status := completion.Status
+		switch status {
+		case "":
+			status = StatusApproved
+		case StatusApproved, StatusPending, StatusRejected:
+		default:
+			return nil, &ValidationError{fmt.Sprintf("completion %d: unknown status %q", completion.ID, status)}
+		}
+		taskID, taskName := tasks[completion.TaskID], sql.NullString{}
+		if taskID == 0 {
+			taskName = sql.NullString{String: completion.TaskName, Valid: true}
+		}
+		var existing int
+		err := transaction.QueryRowContext(ctx, `
+			SELECT id FROM completions
+			WHERE user_id = ? AND task_id = ? AND julianday(completed_at) = julianday(?) AND (task_id != 0 OR task_name = ?)`,
+			userID, taskID, completion.CompletedAt, taskName).Scan(&existing)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		// A completion cannot have earned more than its task is worth, and
+		// earns nothing without one. Imported completions are backfilled,
+		// so like those recorded through CreateCompletion they earn
+		// nothing when dated more than backdateGrace ago.
+		points := 0
+		if taskID != 0 && !completion.CompletedAt.Before(time.Now().Add(-backdateGrace)) {
+			points = min(max(0, completion.Points), taskPoints[completion.TaskID])
+		}
+		id, err := insert("INSERT INTO completions (task_id, completed_at, points, task_name, user_id, status, reviewed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			taskID, completion.CompletedAt, points, taskName, userID, status, completion.ReviewedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Completions++
+		if taskID != 0 && status == StatusApproved && points != 0 {
+			earning := &Completion{ID: id, TaskID: taskID, UserID: userID, Points: points, TaskName: completion.TaskName}
+			if err := recordEarning(ctx, transaction, earning); err != nil {
+				return nil, err
+			}
+			report.PointEntries++
+		}
+	}
+
+	existingRewards, err := importKeys(ctx, transaction,
+		"SELECT id, name, created_at FROM rewards WHERE user_id = ? AND group_id IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	rewards := map[int]int{}
+	for _, reward := range export.Rewards {
+		if reward.Name == "" || reward.Cost < 1 {
+			return nil, &ValidationError{fmt.Sprintf("reward %d: needs a name and a cost of at least 1", reward.ID)}
+		}
+		if id, ok := existingRewards[importKey(reward.Name, reward.CreatedAt)]; ok {
+			rewards[reward.ID] = id
+			continue
+		}
+		id, err := insert("INSERT INTO rewards (user_id, name, cost, created_at) VALUES (?, ?, ?, ?)", userID, reward.Name, reward.Cost, reward.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rewards[reward.ID] = id
+		report.Rewards++
+	}
+
+	existingRedemptions, err := importKeys(ctx, transaction,
+		"SELECT id, reward_name, redeemed_at FROM redemptions WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, redemption := range export.Redemptions {
+		if redemption.Cost < 1 {
+			return nil, &ValidationError{fmt.Sprintf("redemption %d: cost must be at least 1", redemption.ID)}
+		}
+		if _, ok := existingRedemptions[importKey(redemption.RewardName, redemption.RedeemedAt)]; ok {
+			continue
+		}
+		id, err := insert("INSERT INTO redemptions (reward_id, user_id, reward_name, cost, redeemed_at) VALUES (?, ?, ?, ?, ?)",
+			rewards[redemption.RewardID], userID, redemption.RewardName, redemption.Cost, redemption.RedeemedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Redemptions++
+		if _, err := insert(`
+			INSERT INTO point_entries (user_id, kind, points, reason, redemption_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			userID, EntrySpend, -redemption.Cost, "redeemed "+redemption.RewardName, id, redemption.RedeemedAt); err != nil {
+			return nil, err
+		}
+		report.PointEntries++
+	}
+
+	existingAdjustments, err := importKeys(ctx, transaction,
+		"SELECT id, reason || ' ' || points, created_at FROM point_entries WHERE user_id = ? AND kind = 'adjust'", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range export.PointEntries {
+		switch entry.Kind {
+		case EntryEarn, EntrySpend:
+			// Rebuilt above from the completions and redemptions.
+			continue
+		case EntryAdjust:
+			// Reversals of removed completions go with the earnings
+			// they reverse, which are not in the export either.
+			if entry.CompletionID != nil {
+				continue
+			}
+		default:
+			return nil, &ValidationError{fmt.Sprintf("point entry %d: unknown kind %q", entry.ID, entry.Kind)}
+		}
+		// Users can only take points off their own balance.
+		if entry.Points >= 0 {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("point entry %d: only deductions are imported", entry.ID))
+			continue
+		}
+		if _, ok := existingAdjustments[importKey(fmt.Sprintf("%s %d", entry.Reason, entry.Points), entry.CreatedAt)]; ok {
+			continue
+		}
+		if _, err := insert(`
+			INSERT INTO point_entries (user_id, kind, points, reason, created_by, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			userID, EntryAdjust, max(entry.Points, -maxAdjustment), entry.Reason, userID, entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		report.PointEntries++
+	}
+
+	for _, goal := range export.Goals {
+		taskID := mapped(tasks, goal.TaskID)
+		if goal.TaskID != nil && taskID == nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("goal %d: task %d is not in the export", goal.ID, *goal.TaskID))
+			continue
+		}
+		kind, err := ParseGoalKind(string(goal.Kind))
+		if err != nil {
+			return nil, err
+		}
+		period, err := ParseStatsPeriod(string(goal.Period))
+		if err != nil {
+			return nil, err
+		}
+		if goal.Target < 1 {
+			return nil, &ValidationError{fmt.Sprintf("goal %d: target must be at least 1", goal.ID)}
+		}
+		var existing int
+		err = transaction.QueryRowContext(ctx, "SELECT 1 FROM goals WHERE user_id = ? AND kind = ? AND target = ? AND period = ? AND task_id IS ?",
+			userID, kind, goal.Target, period, taskID).Scan(&existing)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		if _, err := insert("INSERT INTO goals (user_id, kind, target, period, task_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			userID, kind, goal.Target, period, taskID, goal.CreatedAt); err != nil {
+			return nil, err
+		}
+		report.Goals++
+	}
+
+	for _, badge := range export.Badges {
+		taskID := mapped(tasks, badge.TaskID)
+		if badge.TaskID != nil && taskID == nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("badge %d: task %d is not in the export", badge.ID, *badge.TaskID))
+			continue
+		}
+		kind, err := ParseBadgeKind(string(badge.Kind))
+		if err != nil {
+			return nil, err
+		}
+		if badge.Name == "" || badge.Threshold < 1 {
+			return nil, &ValidationError{fmt.Sprintf("badge %d: needs a name and a threshold of at least 1", badge.ID)}
+		}
+		var existing int
+		err = transaction.QueryRowContext(ctx, "SELECT 1 FROM badges WHERE user_id = ? AND name = ? AND kind = ? AND threshold = ? AND task_id IS ?",
+			userID, badge.Name, kind, badge.Threshold, taskID).Scan(&existing)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		if _, err := insert("INSERT INTO badges (user_id, name, description, kind, threshold, task_id) VALUES (?, ?, ?, ?, ?, ?)",
+			userID, badge.Name, badge.Description, kind, badge.Threshold, taskID); err != nil {
+			return nil, err
+		}
+		report.Badges++
+	}
+
+	if _, err := awardBadges(ctx, transaction, userID); err != nil {
+		return nil, err
+	}
+	return report, transaction.Commit()
+}
+
+// importKey identifies a record by its name and time, which an import
+// keeps, where its ID does not.
+func importKey(name string, at time.Time) string {
+	return fmt.Sprintf("%s\x00%d", name, at.UnixNano())
+}
+
+// importKeys runs query, which selects an ID, a name and a time for
+// userID, and maps the importKey of each row to its ID. The times are
+// compared in Go because they are stored in more than one layout.
+func importKeys(ctx context.Context, transaction *sql.Tx, query string, userID int) (map[string]int, error) {
+	rows, err := transaction.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[string]int{}
+	for rows.Next() {
+		var id int
+		var name string
+		var at time.Time
+		if err := rows.Scan(&id, &name, &at); err != nil {
+			return nil, err
+		}
+		keys[importKey(name, at)] = id
+	}
+	return keys, rows.Err()
+}
+
+// handleAPIExport downloads the signed in user's data as an attachment.
+func handleAPIExport(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		export, err := ExportData(request.Context(), appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		filename := fmt.Sprintf("tasks-%s-%s.json", export.Username, export.ExportedAt.Format(time.DateOnly))
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		writeJSON(writer, http.StatusOK, export)
+	}
+}
+
+// handleAPIImport reads an export document from the body. Exports can be
+// far larger than other request bodies, so it has its own size limit.
+func handleAPIImport(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		mode, err := ParseImportMode(request.URL.Query().Get("mode"))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		var export Export
+		decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxImportBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&export); err != nil {
+			writeAPIError(writer, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+
+		report, err := ImportData(request.Context(), appState.db, currentUserID(request), &export, mode)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, report)
+	}
+}
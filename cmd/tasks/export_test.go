@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// exportCounts summarises a document by how many of each record it holds.
+func exportCounts(export *Export) map[string]int {
+	return map[string]int{
+		"projects":      len(export.Projects),
+		"tasks":         len(export.Tasks),
+		"completions":   len(export.Completions),
+		"point_entries": len(export.PointEntries),
+		"rewards":       len(export.Rewards),
+		"redemptions":   len(export.Redemptions),
+		"goals":         len(export.Goals),
+		"badges":        len(export.Badges),
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+
+	project, err := CreateProject(ctx, db, alice.ID, "Home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := newTestTask(t, db, alice.ID, &Task{Name: "Dishes", Points: 10, ProjectID: &project.ID, Recurrence: "FREQ=DAILY"})
+	if err := SetTaskTags(ctx, db, alice.ID, task.ID, []string{"kitchen"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteTask(ctx, db, alice.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	reward, err := CreateReward(ctx, db, alice.ID, "Film night", 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemReward(ctx, db, alice.ID, reward.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateGoal(ctx, db, alice.ID, &Goal{Kind: GoalCompletions, Target: 1, Period: StatsDay, TaskID: &task.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateBadge(ctx, db, alice.ID, &Badge{Name: "Clean", Kind: BadgeCompletions, Threshold: 1, TaskID: &task.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := ExportData(ctx, db, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := exportCounts(original)
+	for name, count := range want {
+		if count == 0 {
+			t.Fatalf("the export has no %s to round-trip", name)
+		}
+	}
+	// The document goes through JSON, as it does between machines.
+	encoded, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merging the same document again adds nothing.
+	for round, mode := range []ImportMode{ImportMerge, ImportMerge, ImportReplace} {
+		document := &Export{}
+		if err := json.Unmarshal(encoded, document); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ImportData(ctx, db, bob.ID, document, mode); err != nil {
+			t.Fatalf("%s import: %v", mode, err)
+		}
+		imported, err := ExportData(ctx, db, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, count := range exportCounts(imported) {
+			if count != want[name] {
+				t.Errorf("after %s import %d: %d %s, want %d", mode, round+1, count, name, want[name])
+			}
+		}
+	}
+
+	imported, err := ExportData(ctx, db, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, source := imported.Tasks[0], original.Tasks[0]
+	if got.Name != source.Name || got.Points != source.Points || got.Recurrence != source.Recurrence ||
+		len(got.Tags) != 1 || got.Tags[0] != "kitchen" || got.ProjectID == nil || *got.ProjectID != imported.Projects[0].ID {
+		t.Errorf("imported task = %+v, want a copy of %+v", got, source)
+	}
+	if imported.Goals[0].TaskID == nil || *imported.Goals[0].TaskID != got.ID {
+		t.Errorf("imported goal points at task %v, want %d", imported.Goals[0].TaskID, got.ID)
+	}
+	if aliceLedger, err := GetLedger(db, alice.ID); err != nil {
+		t.Fatal(err)
+	} else if bobLedger, err := GetLedger(db, bob.ID); err != nil {
+		t.Fatal(err)
+	} else if bobLedger.Balance != aliceLedger.Balance {
+		t.Errorf("imported balance = %d, want %d", bobLedger.Balance, aliceLedger.Balance)
+	}
+}
+
+func TestImportRebuildsLedger(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	alice := newTestUser(t, db, "alice")
+	group := newTestGroup(t, db, owner, map[*User]Role{alice: RoleMember})
+	task := newTestTask(t, db, alice.ID, &Task{Name: "Dishes", Points: 10})
+	groupTask := newTestTask(t, db, owner.ID, &Task{Name: "Bins", Points: 7, GroupID: &group.ID})
+	if _, err := CompleteTask(ctx, db, alice.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	groupCompletion, err := CompleteTask(ctx, db, alice.ID, groupTask.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AdjustPoints(ctx, db, alice.ID, alice.ID, -3, "spilled"); err != nil {
+		t.Fatal(err)
+	}
+	balance := func() int {
+		t.Helper()
+		ledger, err := GetLedger(db, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ledger.Balance
+	}
+	if got := balance(); got != 14 {
+		t.Fatalf("balance before the import = %d, want 14", got)
+	}
+
+	document, err := ExportData(ctx, db, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An edited document cannot mint points.
+	document.PointEntries = append(document.PointEntries, &LedgerEntry{ID: 99, Kind: EntryAdjust, Points: 1000000, Reason: "gift"})
+	document.Completions[0].Points = 1000000
+	report, err := ImportData(ctx, db, alice.ID, document, ImportReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Skipped) != 1 {
+		t.Errorf("skipped = %v, want the added points", report.Skipped)
+	}
+	if got := balance(); got != 14 {
+		t.Errorf("balance after replacing = %d, want 14", got)
+	}
+
+	// Back-dated completions and completions of tasks missing from the
+	// document earn nothing, as backfilled ones do not.
+	backdated := *document.Completions[0]
+	backdated.ID, backdated.CompletedAt = 100, time.Now().AddDate(0, 0, -2)
+	orphaned := *document.Completions[0]
+	orphaned.ID, orphaned.TaskID, orphaned.Points = 101, 0, 1000000
+	document.Completions = []*ExportedCompletion{&backdated, &orphaned}
+	if _, err := ImportData(ctx, db, alice.ID, document, ImportMerge); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 14 {
+		t.Errorf("balance after importing old and orphaned completions = %d, want 14", got)
+	}
+	var points int
+	if err := db.Conn.QueryRowContext(ctx, "SELECT COALESCE(SUM(points), 0) FROM completions WHERE user_id = ? AND (task_id = 0 OR julianday(completed_at) < julianday(?))",
+		alice.ID, time.Now().Add(-time.Hour)).Scan(&points); err != nil {
+		t.Fatal(err)
+	}
+	if points != 0 {
+		t.Errorf("old and orphaned completions were recorded with %d points, want 0", points)
+	}
+
+	// The group task's points stay tied to its completion.
+	if err := DeleteCompletion(ctx, db, owner.ID, groupCompletion.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 7 {
+		t.Errorf("balance after deleting the group completion = %d, want 7", got)
+	}
+}
+
+func TestImportRejectsDocuments(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	newTestTask(t, db, user.ID, &Task{})
+
+	tests := []struct {
+		name   string
+		export Export
+	}{
+		{"other format", Export{Format: "todo.txt", Version: 1}},
+		{"newer version", Export{Format: exportFormat, Version: exportVersion + 1}},
+		{"invalid task", Export{Format: exportFormat, Version: 1, Tasks: []*Task{{ID: 1, Name: ""}}}},
+		{"unknown completion status", Export{Format: exportFormat, Version: 1, Completions: []*ExportedCompletion{{ID: 1, Status: "lost"}}}},
+		{"free reward", Export{Format: exportFormat, Version: 1, Rewards: []*Reward{{ID: 1, Name: "Cake"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ImportData(ctx, db, user.ID, &test.export, ImportReplace)
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			// The failed import must have changed nothing.
+			data, err := ExportData(ctx, db, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data.Tasks) != 1 {
+				t.Errorf("%d tasks after a failed import, want 1", len(data.Tasks))
+			}
+		})
+	}
+
+	if _, err := ParseImportMode("overwrite"); err == nil {
+		t.Error("ParseImportMode accepted overwrite")
+	}
+}
@@ -0,0 +1,396 @@
+// Goals: targets of points or completions per day, week or month, with
+// progress and a record of past periods computed from the completions.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrGoalNotFound is wrapped by errors reporting a missing goal.
+var ErrGoalNotFound = errors.New("goal not found")
+
+// goalHistoryPeriods is how many past periods a goal's history shows.
+const goalHistoryPeriods = 12
+
+// GoalKind is what a goal's target counts.
+type GoalKind string
+
+const (
+	GoalPoints      GoalKind = "points"
+	GoalCompletions GoalKind = "completions"
+)
+
+func ParseGoalKind(value string) (GoalKind, error) {
+	switch kind := GoalKind(strings.TrimSpace(value)); kind {
+	case GoalPoints, GoalCompletions:
+		return kind, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("unknown goal kind %q: use points or completions", value)}
+}
+
+type Goal struct {
+	ID     int         `json:"id"`
+	Kind   GoalKind    `json:"kind"`
+	Target int         `json:"target"`
+	Period StatsPeriod `json:"period"`
+	// TaskID limits the goal to one task's completions.
+	TaskID    *int      `json:"task_id"`
+	TaskName  string    `json:"task_name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Description reads the goal back, e.g. "Earn 50 points per day".
+func (goal *Goal) Description() string {
+	if goal.Kind == GoalPoints {
+		if goal.TaskID != nil {
+			return fmt.Sprintf("Earn %d points from %s per %s", goal.Target, goal.TaskName, goal.Period)
+		}
+		return fmt.Sprintf("Earn %d points per %s", goal.Target, goal.Period)
+	}
+	what := "tasks"
+	if goal.TaskID != nil {
+		what = goal.TaskName
+	}
+	return fmt.Sprintf("Complete %s %dx per %s", what, goal.Target, goal.Period)
+}
+
+// GoalPeriod is how a goal went in one period.
+type GoalPeriod struct {
+	Start time.Time `json:"start"`
+	Value int       `json:"value"`
+	Met   bool      `json:"met"`
+}
+
+// GoalProgress is a goal with the current period's progress and the
+// periods before it, most recent first, back to when the goal was set.
+type GoalProgress struct {
+	Goal        *Goal         `json:"goal"`
+	Description string        `json:"description"`
+	Current     GoalPeriod    `json:"current"`
+	Percent     int           `json:"percent"`
+	History     []*GoalPeriod `json:"history"`
+}
+
+// measure counts what goal targets among the history entries.
+func (goal *Goal) measure(entries []historyEntry) int {
+	value := 0
+	for _, entry := range entries {
+		if goal.TaskID != nil && entry.TaskID != *goal.TaskID {
+			continue
+		}
+		if goal.Kind == GoalPoints {
+			value += entry.Points
+		} else {
+			value++
+		}
+	}
+	return value
+}
+
+// goalColumns is selected by every query that builds a Goal with scanGoal.
+// Queries must alias the goals table as "goal".
+const goalColumns = `goal.id, goal.kind, goal.target, goal.period, goal.task_id,
+	(SELECT name FROM tasks WHERE tasks.id = goal.task_id), goal.created_at`
+
+func scanGoal(row rowScanner) (*Goal, error) {
+	goal := &Goal{}
+	var taskID sql.NullInt64
+	var taskName sql.NullString
+	if err := row.Scan(&goal.ID, &goal.Kind, &goal.Target, &goal.Period, &taskID, &taskName, &goal.CreatedAt); err != nil {
+		return nil, err
+	}
+	goal.TaskID = nullableID(taskID)
+	goal.TaskName = taskName.String
+	return goal, nil
+}
+
+func GetGoal(db *Database, userID, goalID int) (*Goal, error) {
+	goal, err := scanGoal(db.Conn.QueryRow(`SELECT `+goalColumns+` FROM goals goal WHERE goal.id = ? AND goal.user_id = ?`, goalID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrGoalNotFound, goalID)
+	}
+	return goal, err
+}
+
+// GetGoalProgress returns userID's goals with their progress, oldest goal
+// first. Like the statistics, it counts the user's own approved
+// completions in the server's time zone.
+func GetGoalProgress(ctx context.Context, db *Database, userID int) ([]*GoalProgress, error) {
+	rows, err := db.Conn.QueryContext(ctx, `SELECT `+goalColumns+` FROM goals goal WHERE goal.user_id = ? ORDER BY goal.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var goals []*Goal
+	for rows.Next() {
+		goal, err := scanGoal(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		goals = append(goals, goal)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	earliest := now
+	for _, goal := range goals {
+		earliest = minTime(earliest, goal.Period.add(goal.Period.start(now), -goalHistoryPeriods))
+	}
+	history, err := loadHistory(ctx, db, userID, earliest, now.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	progress := []*GoalProgress{}
+	for _, goal := range goals {
+		progress = append(progress, goalProgress(goal, history, now))
+	}
+	return progress, nil
+}
+
+func minTime(left, right time.Time) time.Time {
+	if right.Before(left) {
+		return right
+	}
+	return left
+}
+
+// goalProgress measures goal in the current period and in each past period
+// since the one it was set in.
+func goalProgress(goal *Goal, history []historyEntry, now time.Time) *GoalProgress {
+	measure := func(start time.Time) GoalPeriod {
+		end := goal.Period.add(start, 1)
+		var entries []historyEntry
+		for _, entry := range history {
+			if !entry.CompletedAt.Before(start) && entry.CompletedAt.Before(end) {
+				entries = append(entries, entry)
+			}
+		}
+		value := goal.measure(entries)
+		return GoalPeriod{Start: start, Value: value, Met: value >= goal.Target}
+	}
+
+	current := goal.Period.start(now)
+	progress := &GoalProgress{Goal: goal, Description: goal.Description(), Current: measure(current), History: []*GoalPeriod{}}
+	progress.Percent = min(100, progress.Current.Value*100/goal.Target)
+	created := goal.Period.start(goal.CreatedAt.In(now.Location()))
+	for count := 1; count <= goalHistoryPeriods; count++ {
+		start := goal.Period.add(current, -count)
+		if start.Before(created) {
+			break
+		}
+		period := measure(start)
+		progress.History = append(progress.History, &period)
+	}
+	return progress
+}
+
+// CreateGoal sets a new goal for userID.
+func CreateGoal(ctx context.Context, db *Database, userID int, goal *Goal) (*Goal, error) {
+	kind, err := ParseGoalKind(string(goal.Kind))
+	if err != nil {
+		return nil, err
+	}
+	period, err := ParseStatsPeriod(string(goal.Period))
+	if err != nil {
+		return nil, err
+	}
+	if goal.Target < 1 {
+		return nil, &ValidationError{"a goal target must be at least 1"}
+	}
+	if goal.TaskID != nil {
+		if _, err := taskRole(ctx, db.Conn, userID, *goal.TaskID); err != nil {
+			if errors.Is(err, ErrTaskNotFound) {
+				return nil, &ValidationError{fmt.Sprintf("task not found: %d", *goal.TaskID)}
+			}
+			return nil, err
+		}
+	}
+
+	result, err := db.Conn.ExecContext(ctx, "INSERT INTO goals (user_id, kind, target, period, task_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, kind, goal.Target, period, goal.TaskID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetGoal(db, userID, int(id))
+}
+
+func DeleteGoal(ctx context.Context, db *Database, userID, goalID int) error {
+	result, err := db.Conn.ExecContext(ctx, "DELETE FROM goals WHERE id = ? AND user_id = ?", goalID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrGoalNotFound, goalID)
+	}
+	return nil
+}
+
+var goalsTemplate = template.Must(template.New("goals").Funcs(template.FuncMap{"bucketLabel": bucketLabel}).Parse(`
+        <div id="goals">
+            {{range .Goals}}
+            <div class="goal">
+                <strong>{{.Description}}</strong>
+                <progress value="{{.Current.Value}}" max="{{.Goal.Target}}"></progress>
+                {{.Current.Value}} / {{.Goal.Target}}{{if .Current.Met}} ✓{{end}}
+                <button hx-delete="/goals/{{.Goal.ID}}" hx-target="#goal-error" hx-confirm="Remove this goal?">Remove</button>
+                {{if .History}}
+                <div class="goal-history">
+                    {{$goal := .Goal}}
+                    {{range .History}}<span class="{{if .Met}}met{{else}}missed{{end}}" title="{{bucketLabel $goal.Period .Start}}: {{.Value}} / {{$goal.Target}}">{{if .Met}}✓{{else}}✗{{end}}</span>{{end}}
+                </div>
+                {{end}}
+            </div>
+            {{else}}
+            <p>No goals yet.</p>
+            {{end}}
+            <span id="goal-error" class="error"></span>
+            <form hx-post="/goals" hx-target="#goal-error">
+                <select name="kind">
+                    <option value="points">Earn points</option>
+                    <option value="completions">Complete tasks</option>
+                </select>
+                <input type="number" name="target" min="1" placeholder="Target" required>
+                <select name="period">
+                    <option value="day">per day</option>
+                    <option value="week">per week</option>
+                    <option value="month">per month</option>
+                </select>
+                <select name="task">
+                    <option value="">from any task</option>
+                    {{range .Tasks}}<option value="{{.ID}}">from {{.Name}}</option>{{end}}
+                </select>
+                <button type="submit">Add Goal</button>
+            </form>
+        </div>`))
+
+func handleGoals(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		userID := currentUserID(request)
+		goals, err := GetGoalProgress(request.Context(), appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tasks, err := GetTasks(appState.db, userID, TaskFilter{})
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		goalsTemplate.Execute(writer, map[string]any{"Goals": goals, "Tasks": tasks})
+	}
+}
+
+func handleAddGoal(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		target, err := strconv.Atoi(request.FormValue("target"))
+		if err != nil {
+			http.Error(writer, "target must be a whole number", http.StatusUnprocessableEntity)
+			return
+		}
+		goal := &Goal{Kind: GoalKind(request.FormValue("kind")), Target: target, Period: StatsPeriod(request.FormValue("period"))}
+		goal.TaskID, err = parseIDField("task", request.FormValue("task"))
+		if err == nil {
+			_, err = CreateGoal(request.Context(), appState.db, currentUserID(request), goal)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+func handleDeleteGoal(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		goalID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid goal ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := DeleteGoal(request.Context(), appState.db, currentUserID(request), goalID); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+type goalRequest struct {
+	Kind   string `json:"kind"`
+	Target int    `json:"target"`
+	Period string `json:"period"`
+	TaskID *int   `json:"task_id"`
+}
+
+func handleAPIListGoals(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		goals, err := GetGoalProgress(request.Context(), appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, goals)
+	}
+}
+
+func handleAPICreateGoal(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body goalRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		goal, err := CreateGoal(request.Context(), appState.db, currentUserID(request), &Goal{
+			Kind:   GoalKind(body.Kind),
+			Target: body.Target,
+			Period: StatsPeriod(body.Period),
+			TaskID: body.TaskID,
+		})
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, goal)
+	}
+}
+
+func handleAPIDeleteGoal(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		goalID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteGoal(request.Context(), appState.db, currentUserID(request), goalID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGoalDescription(t *testing.T) {
+	taskID := 3
+	tests := []struct {
+		goal Goal
+		want string
+	}{
+		{Goal{Kind: GoalPoints, Target: 50, Period: StatsDay}, "Earn 50 points per day"},
+		{Goal{Kind: GoalPoints, Target: 20, Period: StatsWeek, TaskID: &taskID, TaskName: "Dishes"}, "Earn 20 points from Dishes per week"},
+		{Goal{Kind: GoalCompletions, Target: 3, Period: StatsMonth}, "Complete tasks 3x per month"},
+		{Goal{Kind: GoalCompletions, Target: 3, Period: StatsWeek, TaskID: &taskID, TaskName: "Exercise"}, "Complete Exercise 3x per week"},
+	}
+	for _, test := range tests {
+		if got := test.goal.Description(); got != test.want {
+			t.Errorf("Description() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestGoalProgress(t *testing.T) {
+	now := localTime(2024, 3, 20, 12) // a Wednesday
+	exercise := 1
+	history := []historyEntry{
+		{TaskID: exercise, Points: 5, CompletedAt: localTime(2024, 2, 27, 9)},
+		{TaskID: exercise, Points: 5, CompletedAt: localTime(2024, 3, 5, 9)},
+		{TaskID: exercise, Points: 5, CompletedAt: localTime(2024, 3, 6, 9)},
+		{TaskID: 2, Points: 1, CompletedAt: localTime(2024, 3, 7, 9)},
+		{TaskID: exercise, Points: 5, CompletedAt: localTime(2024, 3, 12, 9)},
+		{TaskID: exercise, Points: 5, CompletedAt: localTime(2024, 3, 18, 9)},
+		{TaskID: 2, Points: 3, CompletedAt: localTime(2024, 3, 20, 8)},
+	}
+	tests := []struct {
+		name        string
+		goal        Goal
+		wantValue   int
+		wantPercent int
+		wantHistory []bool
+	}{
+		{"weekly task completions", Goal{Kind: GoalCompletions, Target: 2, Period: StatsWeek, TaskID: &exercise, CreatedAt: localTime(2024, 3, 1, 12)},
+			1, 50, []bool{false, true, false}},
+		{"weekly completions of anything", Goal{Kind: GoalCompletions, Target: 2, Period: StatsWeek, CreatedAt: localTime(2024, 2, 26, 12)},
+			2, 100, []bool{false, true, false}},
+		{"daily points", Goal{Kind: GoalPoints, Target: 2, Period: StatsDay, CreatedAt: localTime(2024, 3, 17, 12)},
+			3, 100, []bool{false, true, false}},
+		{"monthly points beyond the target", Goal{Kind: GoalPoints, Target: 10, Period: StatsMonth, CreatedAt: localTime(2024, 1, 10, 12)},
+			24, 100, []bool{false, false}},
+		{"set today", Goal{Kind: GoalPoints, Target: 10, Period: StatsDay, CreatedAt: now},
+			3, 30, []bool{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			progress := goalProgress(&test.goal, history, now)
+			if progress.Current.Value != test.wantValue || progress.Percent != test.wantPercent {
+				t.Errorf("current = %d (%d%%), want %d (%d%%)", progress.Current.Value, progress.Percent, test.wantValue, test.wantPercent)
+			}
+			var met []bool
+			for _, period := range progress.History {
+				met = append(met, period.Met)
+			}
+			if len(met) != len(test.wantHistory) {
+				t.Fatalf("history = %v, want %v", met, test.wantHistory)
+			}
+			for index := range met {
+				if met[index] != test.wantHistory[index] {
+					t.Errorf("history = %v, want %v", met, test.wantHistory)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCreateGoal(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+	own := newTestTask(t, db, alice.ID, &Task{Name: "Exercise"})
+	other := newTestTask(t, db, bob.ID, &Task{})
+
+	tests := []struct {
+		name    string
+		goal    Goal
+		wantErr bool
+	}{
+		{"points per day", Goal{Kind: GoalPoints, Target: 50, Period: StatsDay}, false},
+		{"one task per week", Goal{Kind: GoalCompletions, Target: 3, Period: StatsWeek, TaskID: &own.ID}, false},
+		{"zero target", Goal{Kind: GoalPoints, Target: 0, Period: StatsDay}, true},
+		{"unknown kind", Goal{Kind: "distance", Target: 5, Period: StatsDay}, true},
+		{"unknown period", Goal{Kind: GoalPoints, Target: 5, Period: "year"}, true},
+		{"someone else's task", Goal{Kind: GoalCompletions, Target: 1, Period: StatsDay, TaskID: &other.ID}, true},
+	}
+	for _, test := range tests {
+		goal, err := CreateGoal(ctx, db, alice.ID, &test.goal)
+		var validation *ValidationError
+		if test.wantErr {
+			if !errors.As(err, &validation) {
+				t.Errorf("%s: got %v, want a ValidationError", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if time.Since(goal.CreatedAt) > time.Minute || (goal.TaskID != nil && goal.TaskName != "Exercise") {
+			t.Errorf("%s: got %+v", test.name, goal)
+		}
+	}
+
+	if err := DeleteGoal(ctx, db, bob.ID, 1); !errors.Is(err, ErrGoalNotFound) {
+		t.Errorf("deleting someone else's goal: got %v, want ErrGoalNotFound", err)
+	}
+}
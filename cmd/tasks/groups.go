@@ -0,0 +1,853 @@
+// Groups: households or teams that share a pool of tasks, with roles that
+// decide who may manage, complete or only view them.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrGroupNotFound is wrapped by errors reporting a group that does not
+	// exist or that the user is not a member of.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrMemberNotFound is wrapped by errors reporting a missing member.
+	ErrMemberNotFound = errors.New("group member not found")
+	// ErrInviteNotFound is returned for unknown, used and expired invites.
+	ErrInviteNotFound = errors.New("invite not found, already used or expired")
+)
+
+// Role is a member's standing in a group. Each role may do everything the
+// roles below it may.
+type Role string
+
+const (
+	// RoleViewer sees the group's tasks and history.
+	RoleViewer Role = "viewer"
+	// RoleMember also completes tasks.
+	RoleMember Role = "member"
+	// RoleAdmin also deletes anyone's completions and invites people.
+	RoleAdmin Role = "admin"
+	// RoleOwner also creates, edits and deletes tasks and manages members.
+	// Every group has exactly one owner, its creator.
+	RoleOwner Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleMember: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// atLeast reports whether r includes the permissions of minimum.
+func (r Role) atLeast(minimum Role) bool {
+	return roleRanks[r] >= roleRanks[minimum]
+}
+
+// rolesAtLeast lists minimum and every role above it as query arguments.
+func rolesAtLeast(minimum Role) []any {
+	var roles []any
+	for role, rank := range roleRanks {
+		if rank >= roleRanks[minimum] {
+			roles = append(roles, string(role))
+		}
+	}
+	return roles
+}
+
+// ParseMemberRole parses a role that can be given to someone else. Owner
+// is not one: ownership stays with the group's creator.
+func ParseMemberRole(value string) (Role, error) {
+	switch role := Role(value); role {
+	case RoleAdmin, RoleMember, RoleViewer:
+		return role, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("invalid role %q: use admin, member or viewer", value)}
+}
+
+// PermissionError reports an action the user's role does not allow.
+type PermissionError struct {
+	Message string
+}
+
+func (e *PermissionError) Error() string {
+	return e.Message
+}
+
+func permissionDenied(role Role, action string) error {
+	return &PermissionError{fmt.Sprintf("a group %s cannot %s", role, action)}
+}
+
+// inviteLifetime is how long an unused invite link stays valid.
+const inviteLifetime = 7 * 24 * time.Hour
+
+type Group struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is the requesting user's role in the group.
+	Role Role `json:"role"`
+}
+
+type GroupMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type GroupInvite struct {
+	ID        int       `json:"id"`
+	GroupID   int       `json:"group_id"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Token is the secret part of the invite link, returned only when the
+	// invite is created.
+	Token string `json:"token,omitempty"`
+	// Path is the invite link relative to the server.
+	Path string `json:"path,omitempty"`
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// groupRole returns userID's role in a group, or ErrGroupNotFound when
+// they are not a member.
+func groupRole(ctx context.Context, querier rowQuerier, userID, groupID int) (Role, error) {
+	var role Role
+	err := querier.QueryRowContext(ctx, "SELECT role FROM group_members WHERE group_id = ? AND user_id = ?",
+		groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: %d", ErrGroupNotFound, groupID)
+	}
+	return role, err
+}
+
+// authorizeGroup checks that userID holds at least minimum in a group.
+func authorizeGroup(ctx context.Context, querier rowQuerier, userID, groupID int, minimum Role, action string) error {
+	role, err := groupRole(ctx, querier, userID, groupID)
+	if err != nil {
+		return err
+	}
+	if !role.atLeast(minimum) {
+		return permissionDenied(role, action)
+	}
+	return nil
+}
+
+// taskRole returns userID's role on a task: owner of their personal tasks,
+// or their role in the group a shared task belongs to. Tasks the user
+// cannot see are reported as not found.
+func taskRole(ctx context.Context, querier rowQuerier, userID, taskID int) (Role, error) {
+	var ownerID, groupID sql.NullInt64
+	var role sql.NullString
+	err := querier.QueryRowContext(ctx, `
+		SELECT task.user_id, task.group_id, member.role
+		FROM tasks task
+		LEFT JOIN group_members member ON member.group_id = task.group_id AND member.user_id = ?
+		WHERE task.id = ?`, userID, taskID).Scan(&ownerID, &groupID, &role)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	switch {
+	case err == sql.ErrNoRows:
+	case groupID.Valid && role.Valid:
+		return Role(role.String), nil
+	case !groupID.Valid && int(ownerID.Int64) == userID:
+		return RoleOwner, nil
+	}
+	return "", fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+}
+
+// authorizeTask checks that userID holds at least minimum on a task.
+func authorizeTask(ctx context.Context, querier rowQuerier, userID, taskID int, minimum Role, action string) error {
+	role, err := taskRole(ctx, querier, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if !role.atLeast(minimum) {
+		return permissionDenied(role, action)
+	}
+	return nil
+}
+
+// visibleTasksSQL is a condition on the "task" alias matching userID's
+// personal tasks and the tasks of groups where they hold at least minimum.
+func visibleTasksSQL(userID int, minimum Role) (string, []any) {
+	roles := rolesAtLeast(minimum)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(roles)), ", ")
+	condition := `((task.group_id IS NULL AND task.user_id = ?) OR task.group_id IN (
+		SELECT group_id FROM group_members WHERE user_id = ? AND role IN (` + placeholders + `)))`
+	return condition, append([]any{userID, userID}, roles...)
+}
+
+// visibleCompletionsSQL is a condition on the "completion" alias matching
+// userID's own completions and every completion of their groups' tasks.
+const visibleCompletionsSQL = `(completion.user_id = ? OR completion.task_id IN (
+	SELECT task.id FROM tasks task
+	JOIN group_members member ON member.group_id = task.group_id
+	WHERE member.user_id = ?))`
+
+// CreateGroup starts a group with userID as its owner.
+func CreateGroup(ctx context.Context, db *Database, userID int, name string) (*Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{"group name cannot be empty"}
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	group := &Group{Name: name, CreatedAt: time.Now(), Role: RoleOwner}
+	result, err := transaction.ExecContext(ctx, "INSERT INTO task_groups (name, created_at) VALUES (?, ?)",
+		group.Name, group.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	group.ID = int(id)
+
+	if _, err := transaction.ExecContext(ctx,
+		"INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)",
+		group.ID, userID, RoleOwner, group.CreatedAt); err != nil {
+		return nil, err
+	}
+	return group, transaction.Commit()
+}
+
+// GetGroups lists the groups userID belongs to with their role in each.
+func GetGroups(db *Database, userID int) ([]*Group, error) {
+	rows, err := db.Conn.Query(`
+		SELECT task_groups.id, task_groups.name, task_groups.created_at, member.role
+		FROM task_groups
+		JOIN group_members member ON member.group_id = task_groups.id
+		WHERE member.user_id = ?
+		ORDER BY task_groups.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*Group{}
+	for rows.Next() {
+		group := &Group{}
+		if err := rows.Scan(&group.ID, &group.Name, &group.CreatedAt, &group.Role); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroupMembers lists the members of a group userID belongs to.
+func GetGroupMembers(ctx context.Context, db *Database, userID, groupID int) ([]*GroupMember, error) {
+	if _, err := groupRole(ctx, db.Conn, userID, groupID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT users.id, users.username, member.role, member.joined_at
+		FROM group_members member
+		JOIN users ON users.id = member.user_id
+		WHERE member.group_id = ?
+		ORDER BY users.username`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*GroupMember{}
+	for rows.Next() {
+		member := &GroupMember{}
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// CreateInvite makes a one-time invite link that joins a group with role.
+// Admins may invite members and viewers; only the owner may invite admins.
+func CreateInvite(ctx context.Context, db *Database, userID, groupID int, role Role) (*GroupInvite, error) {
+	role, err := ParseMemberRole(string(role))
+	if err != nil {
+		return nil, err
+	}
+	inviterRole, err := groupRole(ctx, db.Conn, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if !inviterRole.atLeast(RoleAdmin) {
+		return nil, permissionDenied(inviterRole, "invite people")
+	}
+	if role == RoleAdmin && inviterRole != RoleOwner {
+		return nil, permissionDenied(inviterRole, "invite admins")
+	}
+
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	invite := &GroupInvite{
+		GroupID:   groupID,
+		Role:      role,
+		CreatedAt: now,
+		ExpiresAt: now.Add(inviteLifetime),
+		Token:     secret,
+		Path:      "/invite?token=" + url.QueryEscape(secret),
+	}
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO group_invites (group_id, token_hash, role, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		groupID, hashToken(secret), role, userID, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	invite.ID = int(id)
+	return invite, nil
+}
+
+// AcceptInvite adds userID to the invite's group and uses the invite up.
+func AcceptInvite(ctx context.Context, db *Database, userID int, token string) (*Group, error) {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	var inviteID int
+	var expiresAt time.Time
+	group := &Group{}
+	err = transaction.QueryRowContext(ctx, `
+		SELECT invite.id, invite.role, invite.expires_at, task_groups.id, task_groups.name, task_groups.created_at
+		FROM group_invites invite
+		JOIN task_groups ON task_groups.id = invite.group_id
+		WHERE invite.token_hash = ?`, hashToken(token)).Scan(
+		&inviteID, &group.Role, &expiresAt, &group.ID, &group.Name, &group.CreatedAt)
+	if err == sql.ErrNoRows || (err == nil && !time.Now().Before(expiresAt)) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := groupRole(ctx, transaction, userID, group.ID); err == nil {
+		return nil, &ValidationError{fmt.Sprintf("you are already a member of %s", group.Name)}
+	} else if !errors.Is(err, ErrGroupNotFound) {
+		return nil, err
+	}
+
+	if _, err := transaction.ExecContext(ctx, "DELETE FROM group_invites WHERE id = ?", inviteID); err != nil {
+		return nil, err
+	}
+	if _, err := transaction.ExecContext(ctx,
+		"INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)",
+		group.ID, userID, group.Role, time.Now()); err != nil {
+		return nil, err
+	}
+	return group, transaction.Commit()
+}
+
+// SetMemberRole changes another member's role. Only the owner may do so.
+func SetMemberRole(ctx context.Context, db *Database, userID, groupID, memberID int, role Role) error {
+	role, err := ParseMemberRole(string(role))
+	if err != nil {
+		return err
+	}
+	if err := authorizeGroup(ctx, db.Conn, userID, groupID, RoleOwner, "change roles"); err != nil {
+		return err
+	}
+	if memberID == userID {
+		return &ValidationError{"the owner's role cannot be changed"}
+	}
+
+	result, err := db.Conn.ExecContext(ctx, "UPDATE group_members SET role = ? WHERE group_id = ? AND user_id = ?",
+		role, groupID, memberID)
+	if err != nil {
+		return err
+	}
+	if err := checkMemberAffected(result, memberID); err != nil || role.atLeast(RoleMember) {
+		return err
+	}
+	return releaseAssignments(ctx, db, groupID, memberID)
+}
+
+// RemoveMember takes someone out of a group. The owner may remove anyone
+// else and every other member may remove themselves.
+func RemoveMember(ctx context.Context, db *Database, userID, groupID, memberID int) error {
+	role, err := groupRole(ctx, db.Conn, userID, groupID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case memberID == userID && role == RoleOwner:
+		return &ValidationError{"the owner cannot leave their group"}
+	case memberID != userID && role != RoleOwner:
+		return permissionDenied(role, "remove members")
+	}
+
+	result, err := db.Conn.ExecContext(ctx, "DELETE FROM group_members WHERE group_id = ? AND user_id = ?",
+		groupID, memberID)
+	if err != nil {
+		return err
+	}
+	if err := checkMemberAffected(result, memberID); err != nil {
+		return err
+	}
+	return releaseAssignments(ctx, db, groupID, memberID)
+}
+
+func checkMemberAffected(result sql.Result, memberID int) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrMemberNotFound, memberID)
+	}
+	return nil
+}
+
+// checkGroupField validates the group chosen for a task: the user must own
+// it, since only owners manage a group's tasks.
+func checkGroupField(ctx context.Context, transaction *sql.Tx, userID int, groupID *int) error {
+	if groupID == nil {
+		return nil
+	}
+	err := authorizeGroup(ctx, transaction, userID, *groupID, RoleOwner, "add tasks")
+	if errors.Is(err, ErrGroupNotFound) {
+		return &ValidationError{fmt.Sprintf("group not found: %d", *groupID)}
+	}
+	return err
+}
+
+// parseGroupField reads a group select value, where "" means personal.
+func parseGroupField(value string) (*int, error) {
+	return parseIDField("group", value)
+}
+
+var groupsTemplate = template.Must(template.New("groups").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>Groups - Task Tracker</title>
+</head>
+<body>
+	<h1>Groups</h1>
+	<p><a href="/">Back to tasks</a></p>
+	{{if .Invite}}
+	<p>Send this one-time link to the person joining as {{.Invite.Role}}; it expires {{.Invite.ExpiresAt.Format "2006-01-02 15:04"}}:</p>
+	<pre>{{.BaseURL}}{{.Invite.Path}}</pre>
+	{{end}}
+	{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+	<form method="post" action="/groups">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<label>Name <input type="text" name="name" required></label>
+		<button type="submit">Create group</button>
+	</form>
+	{{range .Groups}}
+	{{$group := .Group}}
+	<h2>{{.Group.Name}} <small>({{.Group.Role}})</small></h2>
+	<table>
+		{{range .Members}}
+		<tr>
+			<td>{{.Username}}</td>
+			<td>{{.Role}}</td>
+			<td>
+				{{if and (eq $.UserID .UserID) (ne .Role "owner")}}
+				<form method="post" action="/groups/{{$group.ID}}/members/{{.UserID}}/remove">
+					<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+					<button type="submit">Leave</button>
+				</form>
+				{{end}}
+			</td>
+		</tr>
+		{{end}}
+	</table>
+	{{if eq .Group.Role "owner"}}
+	{{range .Members}}{{if ne .Role "owner"}}
+	<form method="post" action="/groups/{{$group.ID}}/members/{{.UserID}}/role">
+		<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+		{{.Username}}:
+		<select name="role">
+			<option value="admin" {{if eq .Role "admin"}}selected{{end}}>admin</option>
+			<option value="member" {{if eq .Role "member"}}selected{{end}}>member</option>
+			<option value="viewer" {{if eq .Role "viewer"}}selected{{end}}>viewer</option>
+		</select>
+		<button type="submit">Change role</button>
+		<button type="submit" formaction="/groups/{{$group.ID}}/members/{{.UserID}}/remove">Remove</button>
+	</form>
+	{{end}}{{end}}
+	{{end}}
+	{{if or (eq .Group.Role "owner") (eq .Group.Role "admin")}}
+	<form method="post" action="/groups/{{.Group.ID}}/invites">
+		<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+		<select name="role">
+			<option value="member">member</option>
+			<option value="viewer">viewer</option>
+			{{if eq .Group.Role "owner"}}<option value="admin">admin</option>{{end}}
+		</select>
+		<button type="submit">Create invite link</button>
+	</form>
+	{{end}}
+	{{else}}
+	<p>You are not in any groups yet.</p>
+	{{end}}
+</body>
+</html>`))
+
+// groupsPage is the data rendered into the groups page.
+type groupsPage struct {
+	UserID    int
+	CSRFToken string
+	BaseURL   string
+	Groups    []groupWithMembers
+	Invite    *GroupInvite
+	Error     string
+}
+
+type groupWithMembers struct {
+	Group   *Group
+	Members []*GroupMember
+}
+
+// requestBaseURL rebuilds the scheme and host the request was sent to, for
+// links that are copied out of the page.
+func requestBaseURL(request *http.Request) string {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host
+}
+
+func renderGroupsPage(appState *AppState, writer http.ResponseWriter, request *http.Request, status int, page groupsPage) {
+	session := sessionFromContext(request.Context())
+	groups, err := GetGroups(appState.db, session.User.ID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, group := range groups {
+		members, err := GetGroupMembers(request.Context(), appState.db, session.User.ID, group.ID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Groups = append(page.Groups, groupWithMembers{Group: group, Members: members})
+	}
+	page.UserID = session.User.ID
+	page.CSRFToken = session.CSRFToken
+	page.BaseURL = requestBaseURL(request)
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+	groupsTemplate.Execute(writer, page)
+}
+
+// renderGroupsError re-renders the groups page with a failed action's
+// message, or reports unexpected errors as they are.
+func renderGroupsError(appState *AppState, writer http.ResponseWriter, request *http.Request, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		http.Error(writer, err.Error(), status)
+		return
+	}
+	renderGroupsPage(appState, writer, request, status, groupsPage{Error: err.Error()})
+}
+
+func handleGroupsPage(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		renderGroupsPage(appState, writer, request, http.StatusOK, groupsPage{})
+	}
+}
+
+func handleCreateGroup(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if _, err := CreateGroup(request.Context(), appState.db, currentUserID(request), request.FormValue("name")); err != nil {
+			renderGroupsError(appState, writer, request, err)
+			return
+		}
+		http.Redirect(writer, request, "/groups", http.StatusSeeOther)
+	}
+}
+
+// handleCreateInvite shows the new invite link on the re-rendered page,
+// the only time it is visible.
+func handleCreateInvite(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid group ID", http.StatusBadRequest)
+			return
+		}
+
+		invite, err := CreateInvite(request.Context(), appState.db, currentUserID(request), groupID,
+			Role(request.FormValue("role")))
+		if err != nil {
+			renderGroupsError(appState, writer, request, err)
+			return
+		}
+		renderGroupsPage(appState, writer, request, http.StatusOK, groupsPage{Invite: invite})
+	}
+}
+
+// memberFromPath reads the {id} and {member} wildcards.
+func memberFromPath(request *http.Request) (int, int, error) {
+	groupID, err := strconv.Atoi(request.PathValue("id"))
+	if err != nil {
+		return 0, 0, err
+	}
+	memberID, err := strconv.Atoi(request.PathValue("member"))
+	return groupID, memberID, err
+}
+
+func handleSetMemberRole(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, memberID, err := memberFromPath(request)
+		if err != nil {
+			http.Error(writer, "Invalid group or member ID", http.StatusBadRequest)
+			return
+		}
+
+		err = SetMemberRole(request.Context(), appState.db, currentUserID(request), groupID, memberID,
+			Role(request.FormValue("role")))
+		if err != nil {
+			renderGroupsError(appState, writer, request, err)
+			return
+		}
+		http.Redirect(writer, request, "/groups", http.StatusSeeOther)
+	}
+}
+
+func handleRemoveMember(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, memberID, err := memberFromPath(request)
+		if err != nil {
+			http.Error(writer, "Invalid group or member ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := RemoveMember(request.Context(), appState.db, currentUserID(request), groupID, memberID); err != nil {
+			renderGroupsError(appState, writer, request, err)
+			return
+		}
+		http.Redirect(writer, request, "/groups", http.StatusSeeOther)
+	}
+}
+
+var inviteTemplate = template.Must(template.New("invite").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>Join a group - Task Tracker</title>
+</head>
+<body>
+	<h1>Join a group</h1>
+	{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+	{{if .CSRFToken}}
+	<form method="post" action="/invite">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<p>You have been invited to share a group's tasks.</p>
+		<button type="submit">Accept invite</button>
+	</form>
+	{{else}}
+	<p><a href="/">Sign in</a> or <a href="/register">create an account</a>, then open this link again to join.</p>
+	{{end}}
+</body>
+</html>`))
+
+// handleInvitePage is public so that people without an account are told
+// how to join instead of being refused.
+func handleInvitePage(_ *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		page := map[string]string{"Token": request.URL.Query().Get("token")}
+		if session := sessionFromContext(request.Context()); session != nil {
+			page["CSRFToken"] = session.CSRFToken
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		inviteTemplate.Execute(writer, page)
+	}
+}
+
+func handleAcceptInvite(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		token := request.FormValue("token")
+		if _, err := AcceptInvite(request.Context(), appState.db, currentUserID(request), token); err != nil {
+			status := errorStatus(err)
+			if errors.Is(err, ErrInviteNotFound) {
+				status = http.StatusNotFound
+			}
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			writer.WriteHeader(status)
+			inviteTemplate.Execute(writer, map[string]string{"Error": err.Error()})
+			return
+		}
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+	}
+}
+
+type groupRequest struct {
+	Name string `json:"name"`
+}
+
+type inviteRequest struct {
+	Role Role `json:"role"`
+}
+
+type acceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+type memberRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+func handleAPIListGroups(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groups, err := GetGroups(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, groups)
+	}
+}
+
+func handleAPICreateGroup(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body groupRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		group, err := CreateGroup(request.Context(), appState.db, currentUserID(request), body.Name)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, group)
+	}
+}
+
+func handleAPIListMembers(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		members, err := GetGroupMembers(request.Context(), appState.db, currentUserID(request), groupID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, members)
+	}
+}
+
+// apiMemberFromPath reads the {id} and {member} wildcards, writing the
+// error response itself when they are invalid.
+func apiMemberFromPath(writer http.ResponseWriter, request *http.Request) (int, int, bool) {
+	groupID, memberID, err := memberFromPath(request)
+	if err != nil {
+		writeAPIError(writer, http.StatusBadRequest, "invalid_request", "invalid group or member id")
+		return 0, 0, false
+	}
+	return groupID, memberID, true
+}
+
+func handleAPISetMemberRole(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, memberID, ok := apiMemberFromPath(writer, request)
+		if !ok {
+			return
+		}
+		var body memberRoleRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		userID := currentUserID(request)
+		if err := SetMemberRole(request.Context(), appState.db, userID, groupID, memberID, body.Role); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		members, err := GetGroupMembers(request.Context(), appState.db, userID, groupID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, members)
+	}
+}
+
+func handleAPIRemoveMember(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, memberID, ok := apiMemberFromPath(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := RemoveMember(request.Context(), appState.db, currentUserID(request), groupID, memberID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAPICreateInvite(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		groupID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+		var body inviteRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		invite, err := CreateInvite(request.Context(), appState.db, currentUserID(request), groupID, body.Role)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, invite)
+	}
+}
+
+func handleAPIAcceptInvite(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body acceptInviteRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		group, err := AcceptInvite(request.Context(), appState.db, currentUserID(request), body.Token)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, group)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role, minimum Role
+		want          bool
+	}{
+		{RoleOwner, RoleAdmin, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleMember, RoleAdmin, false},
+		{RoleViewer, RoleMember, false},
+		{RoleViewer, RoleViewer, true},
+		{"", RoleViewer, false},
+	}
+	for _, test := range tests {
+		if got := test.role.atLeast(test.minimum); got != test.want {
+			t.Errorf("%q.atLeast(%q) = %v, want %v", test.role, test.minimum, got, test.want)
+		}
+	}
+}
+
+func TestGroupTaskPermissions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	admin := newTestUser(t, db, "admin")
+	member := newTestUser(t, db, "member")
+	viewer := newTestUser(t, db, "viewer")
+	outsider := newTestUser(t, db, "outsider")
+	group := newTestGroup(t, db, owner, map[*User]Role{admin: RoleAdmin, member: RoleMember, viewer: RoleViewer})
+	task := newTestTask(t, db, owner.ID, &Task{Name: "Bins", GroupID: &group.ID})
+
+	// Each want is "", "permission" or "not found".
+	tests := []struct {
+		user                           *User
+		wantSee, wantComplete, wantTag string
+	}{
+		{owner, "", "", ""},
+		{admin, "", "", "permission"},
+		{member, "", "", "permission"},
+		{viewer, "", "permission", "permission"},
+		{outsider, "not found", "not found", "not found"},
+	}
+	check := func(user *User, action string, err error, want string) {
+		t.Helper()
+		var permission *PermissionError
+		switch {
+		case want == "" && err != nil,
+			want == "permission" && !errors.As(err, &permission),
+			want == "not found" && !errors.Is(err, ErrTaskNotFound):
+			t.Errorf("%s %s: got %v, want %q", user.Username, action, err, want)
+		}
+	}
+	for _, test := range tests {
+		_, err := GetTask(db, test.user.ID, task.ID)
+		check(test.user, "see", err, test.wantSee)
+		_, err = CompleteTask(ctx, db, test.user.ID, task.ID)
+		check(test.user, "complete", err, test.wantComplete)
+		check(test.user, "tag", SetTaskTags(ctx, db, test.user.ID, task.ID, []string{"weekly"}), test.wantTag)
+	}
+
+	var permission *PermissionError
+	if err := SaveNewTask(ctx, db, &Task{UserID: admin.ID, Name: "Sneaky", GroupID: &group.ID}); !errors.As(err, &permission) {
+		t.Errorf("admin adding a group task: got %v, want a PermissionError", err)
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	admin := newTestUser(t, db, "admin")
+	member := newTestUser(t, db, "member")
+	group := newTestGroup(t, db, owner, map[*User]Role{admin: RoleAdmin, member: RoleMember})
+
+	var permission *PermissionError
+	var validation *ValidationError
+	tests := []struct {
+		name    string
+		run     func() error
+		wantErr any
+	}{
+		{"member invites", func() error { _, err := CreateInvite(ctx, db, member.ID, group.ID, RoleViewer); return err }, &permission},
+		{"admin invites a member", func() error { _, err := CreateInvite(ctx, db, admin.ID, group.ID, RoleMember); return err }, nil},
+		{"admin invites an admin", func() error { _, err := CreateInvite(ctx, db, admin.ID, group.ID, RoleAdmin); return err }, &permission},
+		{"owner invites an owner", func() error { _, err := CreateInvite(ctx, db, owner.ID, group.ID, RoleOwner); return err }, &validation},
+		{"admin changes a role", func() error { return SetMemberRole(ctx, db, admin.ID, group.ID, member.ID, RoleViewer) }, &permission},
+		{"owner changes their own role", func() error { return SetMemberRole(ctx, db, owner.ID, group.ID, owner.ID, RoleAdmin) }, &validation},
+		{"owner demotes a member", func() error { return SetMemberRole(ctx, db, owner.ID, group.ID, member.ID, RoleViewer) }, nil},
+		{"admin removes a member", func() error { return RemoveMember(ctx, db, admin.ID, group.ID, member.ID) }, &permission},
+		{"owner leaves", func() error { return RemoveMember(ctx, db, owner.ID, group.ID, owner.ID) }, &validation},
+		{"member leaves", func() error { return RemoveMember(ctx, db, member.ID, group.ID, member.ID) }, nil},
+		{"former member looks", func() error { _, err := GetGroupMembers(ctx, db, member.ID, group.ID); return err }, ErrGroupNotFound},
+	}
+	for _, test := range tests {
+		err := test.run()
+		switch want := test.wantErr.(type) {
+		case nil:
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		case error:
+			if !errors.Is(err, want) {
+				t.Errorf("%s: got %v, want %v", test.name, err, want)
+			}
+		default:
+			if !errors.As(err, want) {
+				t.Errorf("%s: got %T %v, want %T", test.name, err, err, want)
+			}
+		}
+	}
+}
+
+func TestInvites(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	first := newTestUser(t, db, "first")
+	second := newTestUser(t, db, "second")
+	group, err := CreateGroup(ctx, db, owner.ID, "Household")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, err := CreateInvite(ctx, db, owner.ID, group.ID, RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptInvite(ctx, db, owner.ID, invite.Token); err == nil {
+		t.Error("the owner joined their own group again")
+	}
+	joined, err := AcceptInvite(ctx, db, first.ID, invite.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.ID != group.ID || joined.Role != RoleMember {
+		t.Errorf("joined %+v, want %s as a member", joined, group.Name)
+	}
+	if _, err := AcceptInvite(ctx, db, second.ID, invite.Token); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("reusing an invite: got %v, want ErrInviteNotFound", err)
+	}
+
+	expired, err := CreateInvite(ctx, db, owner.ID, group.ID, RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Conn.ExecContext(ctx, "UPDATE group_invites SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Minute), expired.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptInvite(ctx, db, second.ID, expired.Token); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("expired invite: got %v, want ErrInviteNotFound", err)
+	}
+}
@@ -0,0 +1,210 @@
+// iCalendar (RFC 5545) feed of tasks as VTODO and completions as VEVENT
+// entries, for calendar apps to subscribe to with an API token in the URL.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const icsProductID = "-//go_tasks//Task Tracker//EN"
+
+// icsUIDDomain qualifies UIDs. UIDs are built from IDs alone, so a renamed
+// or rescheduled task updates the entry clients already have.
+const icsUIDDomain = "go_tasks"
+
+// feedCompletionDays is how far back the feed lists completions.
+const feedCompletionDays = 365
+
+// icsWriter builds an iCalendar document with CRLF line endings and lines
+// folded at 75 octets.
+type icsWriter struct {
+	builder strings.Builder
+}
+
+func (w *icsWriter) property(name, value string) {
+	line, limit := name+":"+value, 75
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.builder.WriteString(line[:cut] + "\r\n ")
+		// Continuation lines start with the space.
+		line, limit = line[cut:], 74
+	}
+	w.builder.WriteString(line + "\r\n")
+}
+
+// text writes a TEXT property, escaping what RFC 5545 reserves.
+func (w *icsWriter) text(name, value string) {
+	w.property(name, icsEscape(value))
+}
+
+func (w *icsWriter) time(name string, moment time.Time) {
+	w.property(name, moment.UTC().Format("20060102T150405Z"))
+}
+
+func (w *icsWriter) String() string {
+	return w.builder.String()
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icsEscape(value string) string {
+	return icsEscaper.Replace(value)
+}
+
+func taskUID(taskID int) string {
+	return fmt.Sprintf("task-%d@%s", taskID, icsUIDDomain)
+}
+
+func completionUID(completionID int) string {
+	return fmt.Sprintf("completion-%d@%s", completionID, icsUIDDomain)
+}
+
+// icsPriorities maps priorities onto RFC 5545's 1 (highest) to 9; none
+// leaves the property out.
+var icsPriorities = map[Priority]int{PriorityHigh: 1, PriorityMedium: 5, PriorityLow: 9}
+
+// writeVTODO writes task as a to-do with uid. Archived tasks are completed; a
+// repeating task's due date starts its RRULE.
+func writeVTODO(w *icsWriter, task *Task, uid string, stamp time.Time) {
+	w.property("BEGIN", "VTODO")
+	w.property("UID", uid)
+	w.time("DTSTAMP", stamp)
+	w.time("CREATED", task.CreatedAt)
+	w.text("SUMMARY", task.Name)
+	if task.Notes != "" {
+		w.text("DESCRIPTION", task.Notes)
+	}
+	if task.DueAt != nil {
+		w.time("DTSTART", *task.DueAt)
+		w.time("DUE", *task.DueAt)
+		if task.Recurrence != "" {
+			w.property("RRULE", task.Recurrence)
+		}
+	}
+	if priority, ok := icsPriorities[task.Priority]; ok {
+		w.property("PRIORITY", fmt.Sprint(priority))
+	}
+	if len(task.Tags) > 0 {
+		escaped := make([]string, len(task.Tags))
+		for index, tag := range task.Tags {
+			escaped[index] = icsEscape(tag)
+		}
+		w.property("CATEGORIES", strings.Join(escaped, ","))
+	}
+	if task.ArchivedAt != nil {
+		w.property("STATUS", "COMPLETED")
+		w.time("COMPLETED", *task.ArchivedAt)
+	} else {
+		w.property("STATUS", "NEEDS-ACTION")
+	}
+	w.property("END", "VTODO")
+}
+
+// writeVEVENT writes a completion as an event at the moment it happened,
+// related to its task's to-do. A date-time DTSTART without DTEND is an
+// instant, which is what a completion is.
+func writeVEVENT(w *icsWriter, completion *Completion, stamp time.Time) {
+	w.property("BEGIN", "VEVENT")
+	w.property("UID", completionUID(completion.ID))
+	w.time("DTSTAMP", stamp)
+	w.time("DTSTART", completion.CompletedAt)
+	w.text("SUMMARY", fmt.Sprintf("✓ %s (%d pts)", completion.TaskName, completion.Points))
+	w.text("DESCRIPTION", "Completed by "+completion.Username)
+	w.property("RELATED-TO", taskUID(completion.TaskID))
+	w.property("TRANSP", "TRANSPARENT")
+	w.property("END", "VEVENT")
+}
+
+// CalendarFeed renders user's calendar: every task they can see, active
+// and archived, and the approved completions of the last
+// feedCompletionDays days.
+func CalendarFeed(db *Database, user *User) (string, error) {
+	tasks, err := GetTasks(db, user.ID, TaskFilter{})
+	if err != nil {
+		return "", err
+	}
+	archived, err := GetTasks(db, user.ID, TaskFilter{Archived: true})
+	if err != nil {
+		return "", err
+	}
+	completions, err := GetCompletions(db, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	w := &icsWriter{}
+	w.property("BEGIN", "VCALENDAR")
+	w.property("VERSION", "2.0")
+	w.property("PRODID", icsProductID)
+	w.property("CALSCALE", "GREGORIAN")
+	w.property("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", "Tasks ("+user.Username+")")
+	for _, task := range append(tasks, archived...) {
+		writeVTODO(w, task, taskUID(task.ID), now)
+	}
+	since := now.AddDate(0, 0, -feedCompletionDays)
+	for _, completion := range completions {
+		if completion.CompletedAt.After(since) {
+			writeVEVENT(w, completion, now)
+		}
+	}
+	w.property("END", "VCALENDAR")
+	return w.String(), nil
+}
+
+// handleCalendarFeed serves /calendar/{token}.ics. Calendar apps cannot
+// send headers, so the API token is the last path segment instead. Only
+// read tokens are accepted: subscription URLs end up in calendar apps,
+// their sync logs and shared calendars, and a full token there would
+// let anyone who sees it change the account.
+func handleCalendarFeed(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		secret, ok := strings.CutSuffix(request.PathValue("feed"), ".ics")
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		token, user, err := AuthenticateAPIToken(request.Context(), appState.db, secret)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(writer, request)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if token.Scope != ScopeRead {
+			http.Error(writer, fmt.Sprintf("a %s token cannot open the calendar feed: create a read token for it", token.Scope), http.StatusForbidden)
+			return
+		}
+
+		calendar, err := CalendarFeed(appState.db, user)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Write([]byte(calendar))
+	}
+}
+
+// feedURL is where a calendar app subscribes with token.
+func feedURL(request *http.Request, token string) string {
+	scheme := "http"
+	if request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host + "/calendar/" + token + ".ics"
+}
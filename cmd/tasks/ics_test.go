@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICSEscape(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"Dishes", "Dishes"},
+		{"Milk, eggs; bread", `Milk\, eggs\; bread`},
+		{`C:\tasks`, `C:\\tasks`},
+		{"line one\r\nline two\nthree", `line one\nline two\nthree`},
+	}
+	for _, test := range tests {
+		if got := icsEscape(test.value); got != test.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCalendarFeedScopes(t *testing.T) {
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	due := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	newTestTask(t, appState.db, user.ID, &Task{Name: "Dishes, daily", DueAt: &due, Recurrence: "FREQ=DAILY"})
+	done := newTestTask(t, appState.db, user.ID, &Task{Name: "Laundry"})
+	if _, err := CompleteTask(context.Background(), appState.db, user.ID, done.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"read token", newTestToken(t, appState.db, user, ScopeRead), http.StatusOK},
+		{"complete token", newTestToken(t, appState.db, user, ScopeComplete), http.StatusForbidden},
+		{"full token", newTestToken(t, appState.db, user, ScopeFull), http.StatusForbidden},
+		{"unknown token", "tt_unknown", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveAPI(appState, "", "GET", "/calendar/"+test.token+".ics", "")
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, test.wantStatus)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			body := recorder.Body.String()
+			for _, want := range []string{"BEGIN:VCALENDAR\r\n", "SUMMARY:Dishes\\, daily\r\n", "DUE:20240301T090000Z\r\n", "RRULE:FREQ=DAILY\r\n", "END:VCALENDAR\r\n"} {
+				if !strings.Contains(body, want) {
+					t.Errorf("feed has no %q:\n%s", want, body)
+				}
+			}
+			// A completion is an instant: DTSTART alone, since RFC 5545
+			// requires any DTEND to be later.
+			if !strings.Contains(body, "BEGIN:VEVENT\r\n") || strings.Contains(body, "DTEND") {
+				t.Errorf("feed should have a completion event without DTEND:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestTokensPageShowsFeedForReadTokens(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	cookie, session, err := CreateSession(ctx, appState.db, user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range []TokenScope{ScopeRead, ScopeComplete, ScopeFull} {
+		form := url.Values{"name": {string(scope)}, "scope": {string(scope)}, "csrf_token": {session.CSRFToken}}
+		request := httptest.NewRequest("POST", "/tokens", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		recorder := httptest.NewRecorder()
+		newRouter(appState).ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got %d", scope, recorder.Code)
+		}
+		shown := strings.Contains(recorder.Body.String(), "/calendar/tt_")
+		if shown != (scope == ScopeRead) {
+			t.Errorf("%s token: feed address shown %v", scope, shown)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// Importers for the files other task managers export: Todoist CSV
+// templates and JSON backups, Taskwarrior's `task export` and todo.txt.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportSource names the tool a file was exported from.
+type ImportSource string
+
+const (
+	SourceTodoistCSV  ImportSource = "todoist-csv"
+	SourceTodoistJSON ImportSource = "todoist-json"
+	SourceTaskwarrior ImportSource = "taskwarrior"
+	SourceTodoTxt     ImportSource = "todotxt"
+)
+
+// sourceParsers read each format into the report's tasks.
+var sourceParsers = map[ImportSource]func(data []byte, report *SourceImportReport) error{
+	SourceTodoistCSV:  parseTodoistCSV,
+	SourceTodoistJSON: parseTodoistJSON,
+	SourceTaskwarrior: parseTaskwarrior,
+	SourceTodoTxt:     parseTodoTxt,
+}
+
+func ParseImportSource(value string) (ImportSource, error) {
+	source := ImportSource(value)
+	if sourceParsers[source] == nil {
+		return "", &ValidationError{fmt.Sprintf("unknown source %q: use todoist-csv, todoist-json, taskwarrior or todotxt", value)}
+	}
+	return source, nil
+}
+
+// ImportedTask is one task read from another tool. Done tasks are created
+// as one-shot tasks and archived after their completion is recorded.
+type ImportedTask struct {
+	// TaskID is set once the task has been created.
+	TaskID      int         `json:"task_id,omitempty"`
+	Name        string      `json:"name"`
+	Notes       string      `json:"notes"`
+	Priority    Priority    `json:"priority"`
+	Tags        []string    `json:"tags"`
+	Done        bool        `json:"done"`
+	CompletedAt []time.Time `json:"completed_at"`
+}
+
+// SourceImportReport lists the tasks an import created, or would create
+// for a dry run, and the records it passed over.
+type SourceImportReport struct {
+	Source      ImportSource    `json:"source"`
+	DryRun      bool            `json:"dry_run"`
+	Tasks       []*ImportedTask `json:"tasks"`
+	Completions int             `json:"completions"`
+	Skipped     []string        `json:"skipped"`
+}
+
+// add queues a task, cleaning up what the other tool allows and this one
+// does not.
+func (report *SourceImportReport) add(task *ImportedTask) {
+	task.Name = strings.TrimSpace(task.Name)
+	task.Notes = strings.TrimSpace(task.Notes)
+	if task.Name == "" {
+		report.Skipped = append(report.Skipped, "a task without a title")
+		return
+	}
+	tags := make([]string, 0, len(task.Tags))
+	for _, tag := range task.Tags {
+		tags = append(tags, strings.ReplaceAll(tag, ",", " "))
+	}
+	task.Tags, _ = NormalizeTags(tags)
+	switch {
+	case task.Done && len(task.CompletedAt) == 0:
+		task.CompletedAt = []time.Time{time.Now()}
+	case task.CompletedAt == nil:
+		task.CompletedAt = []time.Time{}
+	}
+	report.Tasks = append(report.Tasks, task)
+	report.Completions += len(task.CompletedAt)
+}
+
+// ImportFromSource reads data exported from source and, unless dryRun is
+// set, creates its tasks and completions for userID the way the form and
+// the API do. Imported tasks earn no points. Every task is validated in
+// both modes, so a dry run fails where the import would. The import runs
+// in one transaction: a failure part way creates nothing.
+func ImportFromSource(ctx context.Context, db *Database, userID int, source ImportSource, data []byte, dryRun bool) (*SourceImportReport, error) {
+	report := &SourceImportReport{Source: source, DryRun: dryRun, Tasks: []*ImportedTask{}, Skipped: []string{}}
+	if err := sourceParsers[source](data, report); err != nil {
+		return nil, err
+	}
+	tasks := make([]*Task, len(report.Tasks))
+	for index, imported := range report.Tasks {
+		task := &Task{UserID: userID, Name: imported.Name, Notes: imported.Notes, Priority: imported.Priority, Tags: imported.Tags}
+		if err := task.Validate(); err != nil {
+			return nil, fmt.Errorf("task %d, %q: %w", index+1, imported.Name, err)
+		}
+		for _, completedAt := range imported.CompletedAt {
+			if err := checkCompletedAt(completedAt); err != nil {
+				return nil, fmt.Errorf("task %d, %q: %w", index+1, imported.Name, err)
+			}
+		}
+		tasks[index] = task
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	for index, imported := range report.Tasks {
+		task := tasks[index]
+		if err := insertTask(ctx, transaction, task); err != nil {
+			return nil, fmt.Errorf("task %d, %q: %w", index+1, imported.Name, err)
+		}
+		for _, completedAt := range imported.CompletedAt {
+			if _, err := createCompletion(ctx, transaction, userID, task.ID, completedAt); err != nil {
+				return nil, fmt.Errorf("task %d, %q: %w", index+1, imported.Name, err)
+			}
+		}
+		// Done tasks become one-shot only now, as their first completion
+		// would otherwise archive them before the rest are recorded.
+		if imported.Done {
+			if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET one_shot = 1, archived_at = ? WHERE id = ?", imported.CompletedAt[len(imported.CompletedAt)-1], task.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	for index, imported := range report.Tasks {
+		imported.TaskID = tasks[index].ID
+	}
+	return report, nil
+}
+
+// splitTags takes the words starting with one of the sigils out of text
+// as tags, e.g. Todoist's @labels or todo.txt's +projects and @contexts.
+func splitTags(text, sigils string) (string, []string) {
+	var words, tags []string
+	for _, word := range strings.Fields(text) {
+		if len(word) > 1 && strings.ContainsRune(sigils, rune(word[0])) {
+			tags = append(tags, word[1:])
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), tags
+}
+
+// parseTodoistCSV reads a Todoist CSV template export. Its priorities run
+// from 1 (highest) to 4 (none), labels are @words in the content, and note
+// rows belong to the task above them. It has no completed tasks.
+func parseTodoistCSV(data []byte, report *SourceImportReport) error {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return &ValidationError{fmt.Sprintf("invalid Todoist CSV: %v", err)}
+	}
+	columns := map[string]int{}
+	for index, name := range header {
+		columns[strings.ToUpper(strings.TrimSpace(name))] = index
+	}
+	if _, ok := columns["CONTENT"]; !ok {
+		return &ValidationError{"invalid Todoist CSV: no CONTENT column"}
+	}
+	field := func(record []string, name string) string {
+		if index, ok := columns[name]; ok && index < len(record) {
+			return strings.TrimSpace(record[index])
+		}
+		return ""
+	}
+
+	var current *ImportedTask
+	flush := func() {
+		if current != nil {
+			report.add(current)
+			current = nil
+		}
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ValidationError{fmt.Sprintf("invalid Todoist CSV: %v", err)}
+		}
+		switch strings.ToLower(field(record, "TYPE")) {
+		case "task", "":
+			flush()
+			name, tags := splitTags(field(record, "CONTENT"), "@")
+			current = &ImportedTask{Name: name, Notes: field(record, "DESCRIPTION"), Tags: tags}
+			switch field(record, "PRIORITY") {
+			case "1":
+				current.Priority = PriorityHigh
+			case "2":
+				current.Priority = PriorityMedium
+			case "3":
+				current.Priority = PriorityLow
+			}
+		case "note":
+			if current != nil {
+				current.Notes = strings.TrimSpace(current.Notes + "\n" + field(record, "CONTENT"))
+			}
+		default:
+			// Sections and metadata only arrange the tasks.
+			flush()
+		}
+	}
+	flush()
+	return nil
+}
+
+// todoistFlag reads the flags older backups store as 0 and 1.
+type todoistFlag bool
+
+func (flag *todoistFlag) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*flag = true
+	case "false", "0", "null":
+		*flag = false
+	default:
+		return fmt.Errorf("invalid flag %s", data)
+	}
+	return nil
+}
+
+// todoistItem covers both the items of a Sync API backup and the task
+// objects of the REST API. IDs are strings in newer backups and numbers in
+// older ones.
+type todoistItem struct {
+	ID          any         `json:"id"`
+	Content     string      `json:"content"`
+	Description string      `json:"description"`
+	Priority    int         `json:"priority"`
+	Labels      []string    `json:"labels"`
+	Checked     todoistFlag `json:"checked"`
+	IsCompleted todoistFlag `json:"is_completed"`
+	CompletedAt string      `json:"completed_at"`
+	IsDeleted   todoistFlag `json:"is_deleted"`
+}
+
+type todoistNote struct {
+	ItemID    any         `json:"item_id"`
+	Content   string      `json:"content"`
+	IsDeleted todoistFlag `json:"is_deleted"`
+}
+
+// parseTodoistJSON reads a Todoist JSON backup, either a Sync API dump
+// with items and notes or a plain array of tasks. Here priority 4 is the
+// highest and 1 none.
+func parseTodoistJSON(data []byte, report *SourceImportReport) error {
+	var backup struct {
+		Items []*todoistItem `json:"items"`
+		Notes []*todoistNote `json:"notes"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = decoder.Decode(&backup.Items)
+	} else {
+		err = decoder.Decode(&backup)
+	}
+	if err != nil {
+		return &ValidationError{fmt.Sprintf("invalid Todoist JSON: %v", err)}
+	}
+
+	notes := map[string][]string{}
+	for _, note := range backup.Notes {
+		if !bool(note.IsDeleted) && note.Content != "" {
+			id := fmt.Sprint(note.ItemID)
+			notes[id] = append(notes[id], note.Content)
+		}
+	}
+	for _, item := range backup.Items {
+		if bool(item.IsDeleted) {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("deleted task %q", item.Content))
+			continue
+		}
+		task := &ImportedTask{
+			Name:  item.Content,
+			Notes: strings.Join(append([]string{item.Description}, notes[fmt.Sprint(item.ID)]...), "\n"),
+			Tags:  item.Labels,
+			Done:  bool(item.Checked || item.IsCompleted) || item.CompletedAt != "",
+		}
+		switch item.Priority {
+		case 4:
+			task.Priority = PriorityHigh
+		case 3:
+			task.Priority = PriorityMedium
+		case 2:
+			task.Priority = PriorityLow
+		}
+		if item.CompletedAt != "" {
+			completedAt, err := time.Parse(time.RFC3339, item.CompletedAt)
+			if err != nil {
+				return &ValidationError{fmt.Sprintf("task %q: invalid completed_at %q", item.Content, item.CompletedAt)}
+			}
+			task.CompletedAt = []time.Time{completedAt}
+		}
+		report.add(task)
+	}
+	return nil
+}
+
+// taskwarriorTime is the layout of Taskwarrior's exported dates.
+const taskwarriorTime = "20060102T150405Z"
+
+type taskwarriorTask struct {
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	End         string   `json:"end"`
+	Priority    string   `json:"priority"`
+	Tags        []string `json:"tags"`
+	Annotations []struct {
+		Description string `json:"description"`
+	} `json:"annotations"`
+}
+
+// parseTaskwarrior reads the JSON array `task export` prints. Annotations
+// become the notes, and completed tasks are completed at their end date.
+// Deleted tasks and the templates recurring tasks are generated from are
+// skipped.
+func parseTaskwarrior(data []byte, report *SourceImportReport) error {
+	var tasks []*taskwarriorTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return &ValidationError{fmt.Sprintf("invalid Taskwarrior export: %v", err)}
+	}
+
+	for _, source := range tasks {
+		switch source.Status {
+		case "deleted":
+			report.Skipped = append(report.Skipped, fmt.Sprintf("deleted task %q", source.Description))
+			continue
+		case "recurring":
+			report.Skipped = append(report.Skipped, fmt.Sprintf("recurrence template %q", source.Description))
+			continue
+		}
+		task := &ImportedTask{Name: source.Description, Tags: source.Tags, Done: source.Status == "completed"}
+		var notes []string
+		for _, annotation := range source.Annotations {
+			notes = append(notes, annotation.Description)
+		}
+		task.Notes = strings.Join(notes, "\n")
+		switch source.Priority {
+		case "H":
+			task.Priority = PriorityHigh
+		case "M":
+			task.Priority = PriorityMedium
+		case "L":
+			task.Priority = PriorityLow
+		}
+		if task.Done && source.End != "" {
+			end, err := time.Parse(taskwarriorTime, source.End)
+			if err != nil {
+				return &ValidationError{fmt.Sprintf("task %q: invalid end %q", source.Description, source.End)}
+			}
+			task.CompletedAt = []time.Time{end}
+		}
+		report.add(task)
+	}
+	return nil
+}
+
+// parseTodoTxt reads a todo.txt file, one task per line. "x" marks a done
+// task, optionally followed by its completion date; (A) is high priority,
+// (B) medium and any other letter low. +projects and @contexts become
+// tags and key:value pairs move to the notes.
+func parseTodoTxt(data []byte, report *SourceImportReport) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		task := &ImportedTask{}
+		if rest, done := strings.CutPrefix(line, "x "); done {
+			task.Done = true
+			line = strings.TrimSpace(rest)
+			// The completion date comes first, then the creation date.
+			if date, rest, ok := strings.Cut(line, " "); ok {
+				if completedAt, err := time.ParseInLocation(time.DateOnly, date, time.Local); err == nil {
+					task.CompletedAt = []time.Time{completedAt}
+					line = rest
+				}
+			}
+		}
+		if len(line) > 3 && line[0] == '(' && line[2] == ')' && line[3] == ' ' {
+			task.Priority = todoTxtPriority(line[1])
+			line = line[4:]
+		}
+		if date, rest, ok := strings.Cut(line, " "); ok {
+			if _, err := time.Parse(time.DateOnly, date); err == nil {
+				line = rest
+			}
+		}
+
+		var words, notes []string
+		line, task.Tags = splitTags(line, "+@")
+		for _, word := range strings.Fields(line) {
+			key, value, ok := strings.Cut(word, ":")
+			switch {
+			case !ok || key == "" || value == "" || strings.HasPrefix(value, "//"):
+				words = append(words, word)
+			case key == "pri" && len(value) == 1:
+				task.Priority = todoTxtPriority(value[0])
+			default:
+				notes = append(notes, word)
+			}
+		}
+		task.Name = strings.Join(words, " ")
+		task.Notes = strings.Join(notes, "\n")
+		if task.Name == "" {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("line %d: no task text", lineNumber))
+			continue
+		}
+		report.add(task)
+	}
+	if err := scanner.Err(); err != nil {
+		return &ValidationError{fmt.Sprintf("invalid todo.txt: %v", err)}
+	}
+	return nil
+}
+
+func todoTxtPriority(letter byte) Priority {
+	switch {
+	case letter == 'A':
+		return PriorityHigh
+	case letter == 'B':
+		return PriorityMedium
+	case letter >= 'C' && letter <= 'Z':
+		return PriorityLow
+	}
+	return PriorityNone
+}
+
+// handleAPIImportFrom reads a file exported from {source} as the raw
+// request body. dry_run=true reports what would be created without
+// creating it.
+func handleAPIImportFrom(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		source, err := ParseImportSource(request.PathValue("source"))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		dryRun := false
+		if value := request.URL.Query().Get("dry_run"); value != "" {
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				writeAPIErrorFrom(writer, &ValidationError{"invalid dry_run: " + value})
+				return
+			}
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxImportBytes))
+		if err != nil {
+			writeAPIError(writer, http.StatusBadRequest, "invalid_request", fmt.Sprintf("reading body: %v", err))
+			return
+		}
+
+		report, err := ImportFromSource(request.Context(), appState.db, currentUserID(request), source, data, dryRun)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, report)
+	}
+}
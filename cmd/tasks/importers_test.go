@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		source      ImportSource
+		data        string
+		want        []ImportedTask
+		wantSkipped int
+	}{
+		{SourceTodoTxt, "(A) 2024-01-01 Call mum +family @phone due:2024-01-05\nx 2024-01-03 2024-01-01 Pay rent +home\n\n+tagonly", []ImportedTask{
+			{Name: "Call mum", Notes: "due:2024-01-05", Priority: PriorityHigh, Tags: []string{"family", "phone"}},
+			{Name: "Pay rent", Tags: []string{"home"}, Done: true},
+		}, 1},
+		{SourceTodoistCSV, "\ufeffTYPE,CONTENT,DESCRIPTION,PRIORITY\nsection,Trip,,\ntask,Plan trip @travel,Soon,1\nnote,Book hotel,,\ntask,Water plants,,4", []ImportedTask{
+			{Name: "Plan trip", Notes: "Soon\nBook hotel", Priority: PriorityHigh, Tags: []string{"travel"}},
+			{Name: "Water plants"},
+		}, 0},
+		{SourceTodoistJSON, `{"items": [{"id": "1", "content": "Bins", "priority": 2, "labels": ["home"], "checked": 1, "completed_at": "2024-02-01T10:00:00Z"},
+			{"id": 2, "content": "Gone", "is_deleted": true}], "notes": [{"item_id": "1", "content": "Tuesdays"}]}`, []ImportedTask{
+			{Name: "Bins", Notes: "Tuesdays", Priority: PriorityLow, Tags: []string{"home"}, Done: true},
+		}, 1},
+		{SourceTaskwarrior, `[{"description": "Taxes", "status": "completed", "end": "20240301T120000Z", "priority": "M", "annotations": [{"description": "use the form"}]},
+			{"description": "Stretch", "status": "recurring"}, {"description": "Old", "status": "deleted"}, {"description": "Read", "status": "pending", "tags": ["books"]}]`, []ImportedTask{
+			{Name: "Taxes", Notes: "use the form", Priority: PriorityMedium, Done: true},
+			{Name: "Read", Tags: []string{"books"}},
+		}, 2},
+	}
+	for _, test := range tests {
+		t.Run(string(test.source), func(t *testing.T) {
+			report := &SourceImportReport{}
+			if err := sourceParsers[test.source]([]byte(test.data), report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Skipped) != test.wantSkipped {
+				t.Errorf("skipped %v, want %d", report.Skipped, test.wantSkipped)
+			}
+			if len(report.Tasks) != len(test.want) {
+				t.Fatalf("got %d tasks, want %d", len(report.Tasks), len(test.want))
+			}
+			for index, want := range test.want {
+				got := report.Tasks[index]
+				if got.Name != want.Name || got.Notes != want.Notes || got.Priority != want.Priority || got.Done != want.Done ||
+					strings.Join(got.Tags, ",") != strings.Join(want.Tags, ",") {
+					t.Errorf("task %d = %+v, want %+v", index, got, want)
+				}
+				if want.Done && len(got.CompletedAt) != 1 {
+					t.Errorf("task %d completed at %v, want one completion", index, got.CompletedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSourcesRejectsBrokenFiles(t *testing.T) {
+	tests := []struct {
+		source ImportSource
+		data   string
+	}{
+		{SourceTodoistCSV, "NAME,DATE\nBins,today"},
+		{SourceTodoistJSON, `{"items": [{"content": "Bins", "completed_at": "yesterday"}]}`},
+		{SourceTaskwarrior, `{"description": "not an array"}`},
+		{SourceTaskwarrior, `[{"description": "Taxes", "status": "completed", "end": "2024-03-01"}]`},
+	}
+	for _, test := range tests {
+		err := sourceParsers[test.source]([]byte(test.data), &SourceImportReport{})
+		var validation *ValidationError
+		if !errors.As(err, &validation) {
+			t.Errorf("%s %q: got %v, want a ValidationError", test.source, test.data, err)
+		}
+	}
+}
+
+func TestImportFromSource(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	countTasks := func() int {
+		t.Helper()
+		data, err := ExportData(ctx, db, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(data.Tasks)
+	}
+
+	file := []byte("Call mum\nx 2024-01-03 Pay rent")
+	for _, dryRun := range []bool{true, false} {
+		report, err := ImportFromSource(ctx, db, user.ID, SourceTodoTxt, file, dryRun)
+		if err != nil {
+			t.Fatalf("dry run %v: %v", dryRun, err)
+		}
+		if len(report.Tasks) != 2 || report.Completions != 1 {
+			t.Errorf("dry run %v: report = %+v", dryRun, report)
+		}
+		want := 0
+		if !dryRun {
+			want = 2
+		}
+		if count := countTasks(); count != want {
+			t.Errorf("dry run %v: %d tasks, want %d", dryRun, count, want)
+		}
+	}
+
+	done, err := GetTask(db, user.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done.OneShot || done.ArchivedAt == nil {
+		t.Errorf("done task one-shot %v, archived %v; want an archived one-shot task", done.OneShot, done.ArchivedAt)
+	}
+	ledger, err := GetLedger(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.Balance != 0 {
+		t.Errorf("imported completions earned %d points", ledger.Balance)
+	}
+
+	// A completion in the future fails the whole file, also in a dry run.
+	future := []byte("Water plants\nx 2999-01-01 Launch rocket")
+	for _, dryRun := range []bool{true, false} {
+		_, err := ImportFromSource(ctx, db, user.ID, SourceTodoTxt, future, dryRun)
+		var validation *ValidationError
+		if !errors.As(err, &validation) {
+			t.Errorf("dry run %v: got %v, want a ValidationError", dryRun, err)
+		}
+	}
+	if count := countTasks(); count != 2 {
+		t.Errorf("%d tasks after the failed import, want 2", count)
+	}
+}
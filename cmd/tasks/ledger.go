@@ -0,0 +1,706 @@
+// Points ledger: every change to a user's points is an entry, so balances
+// can be explained. Points are earned by approved completions, spent on
+// rewards and corrected with manual adjustments. Entries for a group's
+// tasks and rewards carry its ID, since group rewards can only be paid
+// with points earned in the group.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRewardNotFound is wrapped by errors reporting a missing reward.
+	ErrRewardNotFound = errors.New("reward not found")
+	// ErrInsufficientPoints is wrapped when a balance cannot cover a
+	// redemption.
+	ErrInsufficientPoints = errors.New("not enough points")
+)
+
+// EntryKind says why a ledger entry was made.
+type EntryKind string
+
+const (
+	// EntryEarn credits the points of an approved completion.
+	EntryEarn EntryKind = "earn"
+	// EntrySpend debits the cost of a redeemed reward.
+	EntrySpend EntryKind = "spend"
+	// EntryAdjust is a manual correction, up or down, with a reason, or
+	// the reversal of the earning of a removed completion, which keeps
+	// the completion's ID.
+	EntryAdjust EntryKind = "adjust"
+)
+
+type LedgerEntry struct {
+	ID     int       `json:"id"`
+	UserID int       `json:"user_id"`
+	Kind   EntryKind `json:"kind"`
+	// Points is positive for credits and negative for debits.
+	Points       int    `json:"points"`
+	Reason       string `json:"reason"`
+	CompletionID *int   `json:"completion_id,omitempty"`
+	RedemptionID *int   `json:"redemption_id,omitempty"`
+	// GroupID is set for earnings from a group's tasks and spending on
+	// its rewards.
+	GroupID   *int      `json:"group_id,omitempty"`
+	CreatedBy *int      `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupBalance is what a user has earned on a group's tasks less what they
+// spent on its rewards: the most they can spend on its rewards.
+type GroupBalance struct {
+	GroupID   int    `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Balance   int    `json:"balance"`
+}
+
+// Ledger is a user's balance with the entries that make it up, newest
+// first.
+type Ledger struct {
+	Balance       int             `json:"balance"`
+	GroupBalances []*GroupBalance `json:"group_balances"`
+	Entries       []*LedgerEntry  `json:"entries"`
+}
+
+// Available is how much of the balance can be spent on reward.
+func (ledger *Ledger) Available(reward *Reward) int {
+	if reward.GroupID == nil {
+		return ledger.Balance
+	}
+	for _, group := range ledger.GroupBalances {
+		if group.GroupID == *reward.GroupID {
+			return min(group.Balance, ledger.Balance)
+		}
+	}
+	return 0
+}
+
+type Reward struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Cost int    `json:"cost"`
+	// GroupID is set for rewards offered to a whole group.
+	GroupID   *int      `json:"group_id"`
+	GroupName string    `json:"group_name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Redemption struct {
+	ID         int       `json:"id"`
+	RewardID   int       `json:"reward_id"`
+	RewardName string    `json:"reward_name"`
+	Cost       int       `json:"cost"`
+	Balance    int       `json:"balance"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// recordEarning credits an approved completion's points to whoever
+// completed it, in the task's group if it has one. Completions worth
+// nothing leave no entry.
+func recordEarning(ctx context.Context, executor execer, completion *Completion) error {
+	if completion.Points == 0 {
+		return nil
+	}
+	_, err := executor.ExecContext(ctx, `
+		INSERT INTO point_entries (user_id, kind, points, reason, completion_id, group_id, created_at)
+		VALUES (?, ?, ?, ?, ?, (SELECT group_id FROM tasks WHERE id = ?), ?)`,
+		completion.UserID, EntryEarn, completion.Points, "completed "+completion.TaskName, completion.ID, completion.TaskID, time.Now())
+	return err
+}
+
+// removeEarnings reverses the earn entries of the completions matched by
+// completionsWhere, so deleting a completion takes back its points rather
+// than leaving them to be earned again. The ledger keeps both entries: the
+// reversal is an adjustment naming the completion, in the same group as
+// the earning. Earnings already reversed are left alone.
+func removeEarnings(ctx context.Context, executor execer, completionsWhere string, args ...any) error {
+	_, err := executor.ExecContext(ctx, `
+		INSERT INTO point_entries (user_id, kind, points, reason, completion_id, group_id, created_at)
+		SELECT earning.user_id, ?, -earning.points, 'removed completion ' || earning.completion_id || ': ' || earning.reason,
+			earning.completion_id, earning.group_id, ?
+		FROM point_entries earning
+		WHERE earning.kind = ? AND earning.completion_id IN (SELECT id FROM completions WHERE `+completionsWhere+`)
+			AND NOT EXISTS (SELECT 1 FROM point_entries reversal
+				WHERE reversal.kind = ? AND reversal.completion_id = earning.completion_id)`,
+		append([]any{EntryAdjust, time.Now(), EntryEarn}, append(args, EntryAdjust)...)...)
+	return err
+}
+
+// pointBalance sums a user's ledger.
+func pointBalance(ctx context.Context, querier rowQuerier, userID int) (int, error) {
+	var balance int
+	err := querier.QueryRowContext(ctx, "SELECT COALESCE(SUM(points), 0) FROM point_entries WHERE user_id = ?", userID).Scan(&balance)
+	return balance, err
+}
+
+// groupPointBalance sums the entries of a user's ledger made in groupID.
+func groupPointBalance(ctx context.Context, querier rowQuerier, userID, groupID int) (int, error) {
+	var balance int
+	err := querier.QueryRowContext(ctx, "SELECT COALESCE(SUM(points), 0) FROM point_entries WHERE user_id = ? AND group_id = ?",
+		userID, groupID).Scan(&balance)
+	return balance, err
+}
+
+// GetLedger returns userID's balance and ledger entries.
+func GetLedger(db *Database, userID int) (*Ledger, error) {
+	balance, err := pointBalance(context.Background(), db.Conn, userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger := &Ledger{Balance: balance, GroupBalances: []*GroupBalance{}, Entries: []*LedgerEntry{}}
+
+	groupRows, err := db.Conn.Query(`
+		SELECT entry.group_id, task_groups.name, SUM(entry.points)
+		FROM point_entries entry
+		JOIN task_groups ON task_groups.id = entry.group_id
+		WHERE entry.user_id = ?
+		GROUP BY entry.group_id
+		ORDER BY task_groups.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer groupRows.Close()
+	for groupRows.Next() {
+		group := &GroupBalance{}
+		if err := groupRows.Scan(&group.GroupID, &group.GroupName, &group.Balance); err != nil {
+			return nil, err
+		}
+		ledger.GroupBalances = append(ledger.GroupBalances, group)
+	}
+	if err := groupRows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT id, user_id, kind, points, reason, completion_id, redemption_id, group_id, created_by, created_at
+		FROM point_entries
+		WHERE user_id = ?
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &LedgerEntry{}
+		var completionID, redemptionID, groupID, createdBy sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Kind, &entry.Points, &entry.Reason,
+			&completionID, &redemptionID, &groupID, &createdBy, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.CompletionID = nullableID(completionID)
+		entry.RedemptionID = nullableID(redemptionID)
+		entry.GroupID = nullableID(groupID)
+		entry.CreatedBy = nullableID(createdBy)
+		ledger.Entries = append(ledger.Entries, entry)
+	}
+	return ledger, rows.Err()
+}
+
+func nullableID(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	id := int(value.Int64)
+	return &id
+}
+
+// maxAdjustment bounds the size of a single manual adjustment either way.
+const maxAdjustment = 100000
+
+// AdjustPoints corrects targetID's balance by points, which may be
+// negative. Users may only take points off their own balance; group admins
+// and owners may also add to or take from their members'.
+func AdjustPoints(ctx context.Context, db *Database, userID, targetID, points int, reason string) (*LedgerEntry, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, &ValidationError{"an adjustment needs a reason"}
+	}
+	if points == 0 {
+		return nil, &ValidationError{"an adjustment cannot be zero points"}
+	}
+	if points > maxAdjustment || points < -maxAdjustment {
+		return nil, &ValidationError{fmt.Sprintf("an adjustment must be between -%d and %d points", maxAdjustment, maxAdjustment)}
+	}
+	if targetID == userID && points > 0 {
+		return nil, &PermissionError{"you can only take points off your own balance: ask an admin or owner of your group to add them"}
+	}
+	if targetID != userID {
+		var shared int
+		err := db.Conn.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM group_members admin
+			JOIN group_members member ON member.group_id = admin.group_id
+			WHERE admin.user_id = ? AND admin.role IN (?, ?) AND member.user_id = ?`,
+			userID, RoleAdmin, RoleOwner, targetID).Scan(&shared)
+		if err != nil {
+			return nil, err
+		}
+		if shared == 0 {
+			return nil, &PermissionError{"only admins and owners of a shared group can adjust someone else's points"}
+		}
+	}
+
+	entry := &LedgerEntry{UserID: targetID, Kind: EntryAdjust, Points: points, Reason: reason, CreatedBy: &userID, CreatedAt: time.Now()}
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO point_entries (user_id, kind, points, reason, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.UserID, entry.Kind, entry.Points, entry.Reason, userID, entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = int(id)
+	return entry, nil
+}
+
+// rewardColumns is selected by every query that builds a Reward with
+// scanReward. Queries must alias the rewards table as "reward".
+const rewardColumns = `reward.id, reward.name, reward.cost, reward.group_id,
+	(SELECT name FROM task_groups WHERE task_groups.id = reward.group_id), reward.created_at`
+
+func scanReward(row rowScanner) (*Reward, error) {
+	reward := &Reward{}
+	var groupID sql.NullInt64
+	var groupName sql.NullString
+	if err := row.Scan(&reward.ID, &reward.Name, &reward.Cost, &groupID, &groupName, &reward.CreatedAt); err != nil {
+		return nil, err
+	}
+	reward.GroupID = nullableID(groupID)
+	reward.GroupName = groupName.String
+	return reward, nil
+}
+
+// CreateReward adds a reward to userID's own catalog, or to a group's when
+// groupID is set, which takes an admin or the owner.
+func CreateReward(ctx context.Context, db *Database, userID int, name string, cost int, groupID *int) (*Reward, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{"reward name cannot be empty"}
+	}
+	if cost < 1 {
+		return nil, &ValidationError{"a reward must cost at least 1 point"}
+	}
+	if groupID != nil {
+		err := authorizeGroup(ctx, db.Conn, userID, *groupID, RoleAdmin, "offer rewards")
+		if errors.Is(err, ErrGroupNotFound) {
+			return nil, &ValidationError{fmt.Sprintf("group not found: %d", *groupID)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := db.Conn.ExecContext(ctx, "INSERT INTO rewards (user_id, group_id, name, cost, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, groupID, name, cost, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetReward(db, userID, int(id))
+}
+
+// visibleRewardsSQL matches userID's own rewards and their groups'.
+const visibleRewardsSQL = `((reward.group_id IS NULL AND reward.user_id = ?) OR reward.group_id IN (
+	SELECT group_id FROM group_members WHERE user_id = ?))`
+
+// GetRewards lists the rewards userID can redeem or see, cheapest first.
+func GetRewards(db *Database, userID int) ([]*Reward, error) {
+	rows, err := db.Conn.Query(`
+		SELECT `+rewardColumns+`
+		FROM rewards reward
+		WHERE `+visibleRewardsSQL+`
+		ORDER BY reward.cost, reward.name`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rewards := []*Reward{}
+	for rows.Next() {
+		reward, err := scanReward(rows)
+		if err != nil {
+			return nil, err
+		}
+		rewards = append(rewards, reward)
+	}
+	return rewards, rows.Err()
+}
+
+func GetReward(db *Database, userID, rewardID int) (*Reward, error) {
+	reward, err := scanReward(db.Conn.QueryRow(`
+		SELECT `+rewardColumns+`
+		FROM rewards reward
+		WHERE reward.id = ? AND `+visibleRewardsSQL, rewardID, userID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrRewardNotFound, rewardID)
+	}
+	return reward, err
+}
+
+// rewardRole is userID's role on a reward, like taskRole is for tasks.
+func rewardRole(ctx context.Context, querier rowQuerier, userID, rewardID int) (Role, error) {
+	var ownerID, groupID sql.NullInt64
+	var role sql.NullString
+	err := querier.QueryRowContext(ctx, `
+		SELECT reward.user_id, reward.group_id, member.role
+		FROM rewards reward
+		LEFT JOIN group_members member ON member.group_id = reward.group_id AND member.user_id = ?
+		WHERE reward.id = ?`, userID, rewardID).Scan(&ownerID, &groupID, &role)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	switch {
+	case err == sql.ErrNoRows:
+	case groupID.Valid && role.Valid:
+		return Role(role.String), nil
+	case !groupID.Valid && int(ownerID.Int64) == userID:
+		return RoleOwner, nil
+	}
+	return "", fmt.Errorf("%w: %d", ErrRewardNotFound, rewardID)
+}
+
+// DeleteReward removes a reward from the catalog. Past redemptions keep
+// its name and cost.
+func DeleteReward(ctx context.Context, db *Database, userID, rewardID int) error {
+	role, err := rewardRole(ctx, db.Conn, userID, rewardID)
+	if err != nil {
+		return err
+	}
+	if !role.atLeast(RoleAdmin) {
+		return permissionDenied(role, "remove rewards")
+	}
+	_, err = db.Conn.ExecContext(ctx, "DELETE FROM rewards WHERE id = ?", rewardID)
+	return err
+}
+
+// RedeemReward spends userID's points on a reward. A group's reward is
+// paid only from points earned on its tasks, so that points from personal
+// tasks, which anyone can make worth anything, cannot buy it. The balance
+// is checked and debited in one transaction, so concurrent redemptions
+// cannot overspend it.
+func RedeemReward(ctx context.Context, db *Database, userID, rewardID int) (*Redemption, error) {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	// Take the write lock before reading the balance, as CompleteTask does
+	// before reading the completion history.
+	if _, err := transaction.ExecContext(ctx, "UPDATE rewards SET id = id WHERE id = ?", rewardID); err != nil {
+		return nil, err
+	}
+	role, err := rewardRole(ctx, transaction, userID, rewardID)
+	if err != nil {
+		return nil, err
+	}
+	if !role.atLeast(RoleMember) {
+		return nil, permissionDenied(role, "redeem rewards")
+	}
+
+	redemption := &Redemption{RewardID: rewardID, RedeemedAt: time.Now()}
+	var groupID sql.NullInt64
+	if err := transaction.QueryRowContext(ctx, "SELECT name, cost, group_id FROM rewards WHERE id = ?", rewardID).Scan(
+		&redemption.RewardName, &redemption.Cost, &groupID); err != nil {
+		return nil, err
+	}
+	balance, err := pointBalance(ctx, transaction, userID)
+	if err != nil {
+		return nil, err
+	}
+	if balance < redemption.Cost {
+		return nil, fmt.Errorf("%w: %s costs %d and the balance is %d", ErrInsufficientPoints, redemption.RewardName, redemption.Cost, balance)
+	}
+	if groupID.Valid {
+		earned, err := groupPointBalance(ctx, transaction, userID, int(groupID.Int64))
+		if err != nil {
+			return nil, err
+		}
+		if earned < redemption.Cost {
+			return nil, fmt.Errorf("%w: %s costs %d and %d points are left from the group's tasks",
+				ErrInsufficientPoints, redemption.RewardName, redemption.Cost, earned)
+		}
+	}
+
+	result, err := transaction.ExecContext(ctx, `
+		INSERT INTO redemptions (reward_id, user_id, reward_name, cost, redeemed_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		rewardID, userID, redemption.RewardName, redemption.Cost, redemption.RedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	redemption.ID = int(id)
+	redemption.Balance = balance - redemption.Cost
+
+	if _, err := transaction.ExecContext(ctx, `
+		INSERT INTO point_entries (user_id, kind, points, reason, redemption_id, group_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, EntrySpend, -redemption.Cost, "redeemed "+redemption.RewardName, redemption.ID, nullableID(groupID), redemption.RedeemedAt); err != nil {
+		return nil, err
+	}
+	return redemption, transaction.Commit()
+}
+
+var pointsTemplate = template.Must(template.New("points").Funcs(templateFuncs).Parse(`
+        <div id="points">
+            <p><strong>Balance: {{.Ledger.Balance}} points</strong></p>
+            {{range .Ledger.GroupBalances}}
+            <p>{{.Balance}} of them earned in {{.GroupName}}, for its rewards</p>
+            {{end}}
+            <h3>Rewards</h3>
+            {{range .Rewards}}
+            <div class="reward">
+                {{.Name}} ({{.Cost}} pts){{if .GroupName}} <span class="group">from {{.GroupName}}</span>{{end}}
+                <button hx-post="/rewards/{{.ID}}/redeem" hx-target="#points-error" hx-swap="innerHTML"
+                        hx-confirm="Spend {{.Cost}} points on {{.Name}}?"{{if gt .Cost ($.Ledger.Available .)}} disabled{{end}}>Redeem</button>
+                <button hx-delete="/rewards/{{.ID}}" hx-target="#points-error" hx-swap="innerHTML">Remove</button>
+            </div>
+            {{else}}
+            <p>No rewards yet.</p>
+            {{end}}
+            <span id="points-error" class="error"></span>
+            <form hx-post="/rewards" hx-target="#points-error">
+                <input type="text" name="name" placeholder="New reward" required>
+                <input type="number" name="cost" min="1" placeholder="Cost" required>
+                {{if .AdminGroups}}
+                <select name="group">
+                    <option value="">Just me</option>
+                    {{range .AdminGroups}}<option value="{{.ID}}">For {{.Name}}</option>{{end}}
+                </select>
+                {{end}}
+                <button type="submit">Add Reward</button>
+            </form>
+            <form hx-post="/points/adjust" hx-target="#points-error">
+                <input type="number" name="points" max="-1" placeholder="-points" required>
+                <input type="text" name="reason" placeholder="Reason" required>
+                <button type="submit">Adjust</button>
+            </form>
+            <h3>History</h3>
+            {{range .Ledger.Entries}}
+            <div class="entry">{{.CreatedAt.Format "2006-01-02 15:04"}} {{if gt .Points 0}}+{{end}}{{.Points}} {{.Reason}}</div>
+            {{end}}
+        </div>`))
+
+// handlePoints renders the points panel: balance, rewards and ledger.
+func handlePoints(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		userID := currentUserID(request)
+		ledger, err := GetLedger(appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rewards, err := GetRewards(appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		groups, err := GetGroups(appState.db, userID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var adminGroups []*Group
+		for _, group := range groups {
+			if group.Role.atLeast(RoleAdmin) {
+				adminGroups = append(adminGroups, group)
+			}
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		pointsTemplate.Execute(writer, map[string]any{"Ledger": ledger, "Rewards": rewards, "AdminGroups": adminGroups})
+	}
+}
+
+// writePointsChange finishes the points panel's actions: errors are
+// swapped in next to the panel, and success refreshes it.
+func writePointsChange(writer http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(writer, err.Error(), errorStatus(err))
+		return
+	}
+	writer.Header().Set("HX-Trigger", "taskChange")
+	writer.Write([]byte(""))
+}
+
+func handleAddReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		cost, err := strconv.Atoi(request.FormValue("cost"))
+		if err != nil {
+			http.Error(writer, "cost must be a whole number", http.StatusUnprocessableEntity)
+			return
+		}
+		groupID, err := parseGroupField(request.FormValue("group"))
+		if err == nil {
+			_, err = CreateReward(request.Context(), appState.db, currentUserID(request), request.FormValue("name"), cost, groupID)
+		}
+		writePointsChange(writer, err)
+	}
+}
+
+func handleDeleteReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rewardID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid reward ID", http.StatusBadRequest)
+			return
+		}
+		writePointsChange(writer, DeleteReward(request.Context(), appState.db, currentUserID(request), rewardID))
+	}
+}
+
+func handleRedeemReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rewardID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid reward ID", http.StatusBadRequest)
+			return
+		}
+		_, err = RedeemReward(request.Context(), appState.db, currentUserID(request), rewardID)
+		writePointsChange(writer, err)
+	}
+}
+
+// handleAdjustPoints takes points off the signed in user's own balance;
+// adjusting other members is done through the API.
+func handleAdjustPoints(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		points, err := strconv.Atoi(request.FormValue("points"))
+		if err != nil {
+			http.Error(writer, "points must be a whole number", http.StatusUnprocessableEntity)
+			return
+		}
+		userID := currentUserID(request)
+		_, err = AdjustPoints(request.Context(), appState.db, userID, userID, points, request.FormValue("reason"))
+		writePointsChange(writer, err)
+	}
+}
+
+// adjustmentRequest is the body of a points adjustment. user_id defaults
+// to the caller.
+type adjustmentRequest struct {
+	UserID *int   `json:"user_id"`
+	Points int    `json:"points"`
+	Reason string `json:"reason"`
+}
+
+type rewardRequest struct {
+	Name    string `json:"name"`
+	Cost    int    `json:"cost"`
+	GroupID *int   `json:"group_id"`
+}
+
+func handleAPIGetPoints(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ledger, err := GetLedger(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, ledger)
+	}
+}
+
+func handleAPIAdjustPoints(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body adjustmentRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		userID := currentUserID(request)
+		targetID := userID
+		if body.UserID != nil {
+			targetID = *body.UserID
+		}
+		entry, err := AdjustPoints(request.Context(), appState.db, userID, targetID, body.Points, body.Reason)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, entry)
+	}
+}
+
+func handleAPIListRewards(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rewards, err := GetRewards(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, rewards)
+	}
+}
+
+func handleAPICreateReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body rewardRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		reward, err := CreateReward(request.Context(), appState.db, currentUserID(request), body.Name, body.Cost, body.GroupID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, reward)
+	}
+}
+
+func handleAPIDeleteReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rewardID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteReward(request.Context(), appState.db, currentUserID(request), rewardID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAPIRedeemReward(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rewardID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		redemption, err := RedeemReward(request.Context(), appState.db, currentUserID(request), rewardID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, redemption)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAdjustPoints(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	admin := newTestUser(t, db, "admin")
+	member := newTestUser(t, db, "member")
+	outsider := newTestUser(t, db, "outsider")
+	newTestGroup(t, db, owner, map[*User]Role{admin: RoleAdmin, member: RoleMember})
+
+	var validation *ValidationError
+	var permission *PermissionError
+	tests := []struct {
+		name    string
+		user    *User
+		target  *User
+		points  int
+		reason  string
+		wantErr any
+	}{
+		{"take off your own", member, member, -5, "typo", nil},
+		{"add to your own", member, member, 5, "bonus", &permission},
+		{"owner adds to their own", owner, owner, 5, "bonus", &permission},
+		{"admin adds to a member", admin, member, 50, "helped out", nil},
+		{"owner takes from an admin", owner, admin, -20, "correction", nil},
+		{"member adds to an admin", member, admin, 5, "thanks", &permission},
+		{"outsider adds to a member", outsider, member, 5, "gift", &permission},
+		{"largest adjustment", owner, member, maxAdjustment, "jackpot", nil},
+		{"too large", owner, member, maxAdjustment + 1, "jackpot", &validation},
+		{"too small", member, member, -maxAdjustment - 1, "wipe", &validation},
+		{"zero", member, member, 0, "nothing", &validation},
+		{"no reason", member, member, -1, " ", &validation},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry, err := AdjustPoints(ctx, db, test.user.ID, test.target.ID, test.points, test.reason)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatalf("AdjustPoints: %v", err)
+				}
+				if entry.UserID != test.target.ID || entry.Points != test.points || entry.Kind != EntryAdjust {
+					t.Errorf("got entry %+v", entry)
+				}
+				return
+			}
+			if !errors.As(err, test.wantErr) {
+				t.Errorf("got %T %v, want %T", err, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeletedCompletionsGiveBackPoints(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		remove func(db *Database, user *User, task *Task, completion *Completion) error
+	}{
+		{"delete one", func(db *Database, user *User, task *Task, completion *Completion) error {
+			return DeleteCompletion(ctx, db, user.ID, completion.ID)
+		}},
+		{"clear history", func(db *Database, user *User, task *Task, completion *Completion) error {
+			return ClearCompletions(ctx, db, user.ID)
+		}},
+		{"purge with completions", func(db *Database, user *User, task *Task, completion *Completion) error {
+			if err := DeleteTask(ctx, db, user.ID, task.ID); err != nil {
+				return err
+			}
+			return PurgeTask(ctx, db, user.ID, task.ID, PurgeDeleteCompletions)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			user := newTestUser(t, db, "alice")
+			task := newTestTask(t, db, user.ID, &Task{Points: 50, MaxCompletions: 1})
+			balance := func() int {
+				ledger, err := GetLedger(db, user.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ledger.Balance
+			}
+
+			completion, err := CompleteTask(ctx, db, user.ID, task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := AdjustPoints(ctx, db, user.ID, user.ID, -5, "spilled"); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.remove(db, user, task, completion); err != nil {
+				t.Fatal(err)
+			}
+			if got := balance(); got != -5 {
+				t.Errorf("balance after removing the completion = %d, want -5", got)
+			}
+			ledger, err := GetLedger(db, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ledger.Entries) != 3 || ledger.Entries[0].Kind != EntryAdjust || ledger.Entries[0].Points != -50 ||
+				ledger.Entries[0].CompletionID == nil || *ledger.Entries[0].CompletionID != completion.ID {
+				t.Errorf("ledger after removing the completion = %+v, want the earning and its reversal", ledger.Entries)
+			}
+			if earned, err := earnedPoints(ctx, db.Conn, user.ID); err != nil || earned != 0 {
+				t.Errorf("earned points after removing the completion = %d, %v; want 0", earned, err)
+			}
+		})
+	}
+}
+
+// TestCompleteDeleteRepeat covers getting around a daily cap by deleting
+// each completion and completing again.
+func TestCompleteDeleteRepeat(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	task := newTestTask(t, db, user.ID, &Task{Points: 50, MaxCompletions: 1})
+
+	for round := 0; round < 3; round++ {
+		completion, err := CompleteTask(ctx, db, user.ID, task.ID)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if round < 2 {
+			if err := DeleteCompletion(ctx, db, user.ID, completion.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ledger, err := GetLedger(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.Balance != 50 {
+		t.Errorf("balance = %d, want the 50 points of the one completion left", ledger.Balance)
+	}
+}
+
+func TestGroupRewardsTakeGroupPoints(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	member := newTestUser(t, db, "member")
+	group := newTestGroup(t, db, owner, map[*User]Role{member: RoleMember})
+	reward, err := CreateReward(ctx, db, owner.ID, "Pizza night", 10, &group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	available := func() int {
+		t.Helper()
+		ledger, err := GetLedger(db, member.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ledger.Available(reward)
+	}
+
+	// Points from a personal task the member made up do not count.
+	personal := newTestTask(t, db, member.ID, &Task{Points: 1000000})
+	if _, err := CompleteTask(ctx, db, member.ID, personal.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemReward(ctx, db, member.ID, reward.ID); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("redeeming with personal points: got %v, want ErrInsufficientPoints", err)
+	}
+	if got := available(); got != 0 {
+		t.Errorf("available for the group reward = %d, want 0", got)
+	}
+
+	// Points from the group's tasks do, once approved.
+	reviewed := newTestTask(t, db, owner.ID, &Task{Points: 10, GroupID: &group.ID, RequiresApproval: true})
+	completion, err := CompleteTask(ctx, db, member.ID, reviewed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReviewCompletion(ctx, db, owner.ID, completion.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := available(); got != 10 {
+		t.Errorf("available after an approved group completion = %d, want 10", got)
+	}
+	if _, err := RedeemReward(ctx, db, member.ID, reward.ID); err != nil {
+		t.Fatalf("redeeming with group points: %v", err)
+	}
+	if _, err := RedeemReward(ctx, db, member.ID, reward.ID); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("redeeming twice: got %v, want ErrInsufficientPoints", err)
+	}
+}
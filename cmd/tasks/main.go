@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"io"
 	"net/http"
 	"log"
+	"os"
 	"time"
 	"html/template"
 	"strconv"
+	"strings"
 )
 
 // Constants for UI configuration
@@ -18,129 +23,631 @@ const (
 )
 
 type AppState struct {
-	db          *Database
-	tasks       []*Task
-	completions []*Completion
+	config Config
+	db     *Database
 }
 
 type Completion struct {
-	ID          int
-	TaskID      int
-	CompletedAt time.Time
-	Points      int
-	TaskName    string
+	ID          int       `json:"id"`
+	TaskID      int       `json:"task_id"`
+	// UserID is the user who completed the task, and Username their name.
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	CompletedAt time.Time `json:"completed_at"`
+	Points      int       `json:"points"`
+	TaskName    string    `json:"task_name"`
+	// Status is pending until an approver reviews a completion of a task
+	// that requires approval; only approved completions count.
+	Status     CompletionStatus `json:"status"`
+	ReviewedBy *int             `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time       `json:"reviewed_at,omitempty"`
+	// NextDueAt is set when completing a repeating task moved its due date.
+	NextDueAt *time.Time `json:"next_due_at,omitempty"`
+	// NewBadges are the badges this completion earned.
+	NewBadges []*Badge `json:"new_badges,omitempty"`
 }
 
 func main() {
-	database, err := NewDatabase()
+	config, args, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	configureLogging(config)
+
+	// `openapi` works against its own temporary database.
+	if len(args) > 0 && args[0] == "openapi" {
+		if err := runOpenAPICommand(context.Background(), args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	database, err := NewDatabase(config)
 	if (err != nil) {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Close()
 
 	ctx := context.Background()
+
+	// `migrate` manages the schema explicitly; every other invocation
+	// brings the schema up to date before serving.
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(ctx, database, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := database.Migrate(ctx); err != nil {
 		log.Fatal(err)
 	}
 
-	if err := database.Initialize(ctx); err != nil {
-		log.Fatal(err)
+	// `export` and `import` move one account's data in and out,
+	// `export-completions` writes its completion history for spreadsheets,
+	// `import-tasks` reads files from other task managers, and
+	// `create-user` and `claim-legacy-data` set up accounts by hand.
+	dataCommands := map[string]func(context.Context, *Database, []string, io.Writer) error{
+		"export":             runExportCommand,
+		"import":             runImportCommand,
+		"export-completions": runExportCompletionsCommand,
+		"import-tasks":       runImportTasksCommand,
+		"create-user":        runCreateUserCommand,
+		"claim-legacy-data":  runClaimLegacyDataCommand,
+	}
+	if len(args) > 0 && dataCommands[args[0]] != nil {
+		if err := dataCommands[args[0]](ctx, database, args[1:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	appState := &AppState{
-		db:          database,
-		tasks:       make([]*Task, 0),
-		completions: make([]*Completion, 0),
+		config: config,
+		db:     database,
 	}
 
-	// Initialize tasks and completions
-	refreshData(appState)
-
 	// Start the HTTP server
 	runServer(appState)
 }
 
 func runServer(appState *AppState) {
-	// Serve static HTML
-	http.HandleFunc("/", handleHome(appState))
-	
-	// Task endpoints
-	http.HandleFunc("/tasks", handleTasks(appState))
-	http.HandleFunc("/task/add", handleAddTask(appState))
-	http.HandleFunc("/task/complete/", handleCompleteTask(appState))
-	http.HandleFunc("/task/delete/", handleDeleteTask(appState))
-	
-	// Completion endpoints
-	http.HandleFunc("/completions", handleCompletions(appState))
-	http.HandleFunc("/completion/delete/", handleDeleteCompletion(appState))
+	go runTrashPurger(context.Background(), appState)
+
+	server := &http.Server{
+		Addr:         appState.config.ListenAddress,
+		Handler:      newRouter(appState),
+		ReadTimeout:  appState.config.ReadTimeout,
+		WriteTimeout: appState.config.WriteTimeout,
+		IdleTimeout:  appState.config.IdleTimeout,
+	}
 
-	log.Printf("Server starting at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Printf("Server starting on %s", appState.config.ListenAddress)
+	log.Fatal(server.ListenAndServe())
 }
 
-func handleHome(_ *AppState) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		tmpl := `
+// homePage is the data rendered into the home page shell.
+type homePage struct {
+	User *User
+	// CSRFToken is sent back with every htmx request and form post.
+	CSRFToken string
+	Projects []*Project
+	Tags     []*Tag
+	// OwnedGroups are the groups new tasks can be shared with.
+	OwnedGroups []*Group
+}
+
+var homeTemplate = template.Must(template.New("home").Parse(`
 <!DOCTYPE html>
 <html>
 <head>
 	<title>Tasks</title>
+	<meta name="csrf-token" content="{{.CSRFToken}}">
 	<script src="https://unpkg.com/htmx.org@1.9.10"></script>
 	<style>
 		.completed { text-decoration: line-through; }
+		.notes { color: #555; font-size: 0.9em; }
+		.tag { background: #eef; border-radius: 3px; padding: 0 4px; font-size: 0.85em; }
+		.due { font-size: 0.85em; }
+		.due.overdue { color: #b00; font-weight: bold; }
+		.due.today { color: #b60; }
+		.priority-high { border-left: 4px solid #b00; padding-left: 4px; }
+		.priority-medium { border-left: 4px solid #e90; padding-left: 4px; }
+		.priority-low { border-left: 4px solid #9bd; padding-left: 4px; }
+		.repeat, .rules, .group { font-size: 0.85em; color: #555; }
+		.error { color: #b00; }
+		.goal-history .met { color: #30a14e; }
+		.goal-history .missed { color: #b00; }
 	</style>
 </head>
 <body>
 	<h1>Tasks</h1>
+	<form method="post" action="/logout">
+		Signed in as {{.User.Username}}. <a href="/groups">Groups</a> <a href="/tokens">API tokens</a> <a href="/api/v1/export">Export data</a>
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<button type="submit">Sign out</button>
+	</form>
+
+	<h2>Agenda</h2>
+	<div hx-get="/agenda" hx-trigger="load, taskChange from:body">
+		<!-- Due now and later load here -->
+	</div>
+
+	<form id="task-filter">
+		<select name="tag">
+			<option value="">All tags</option>
+			{{range .Tags}}<option value="{{.Name}}">{{.Name}} ({{.TaskCount}})</option>{{end}}
+		</select>
+		<select name="project">
+			<option value="">All projects</option>
+			{{range .Projects}}<option value="{{.ID}}">{{.Name}}</option>{{end}}
+		</select>
+		<select name="due">
+			<option value="">Any due date</option>
+			<option value="overdue">Overdue</option>
+			<option value="today">Due today</option>
+			<option value="upcoming">Upcoming</option>
+			<option value="none">No due date</option>
+		</select>
+		<select name="sort">
+			<option value="created">Oldest first</option>
+			<option value="due">Due soonest</option>
+			<option value="priority">Highest priority</option>
+			<option value="points">Most points</option>
+		</select>
+	</form>
 	
-	<div hx-get="/tasks" hx-trigger="load, taskChange from:body">
+	<div hx-get="/tasks" hx-trigger="load, taskChange from:body, change from:#task-filter" hx-include="#task-filter">
 		<!-- Tasks load here -->
 	</div>
 
-	<form hx-post="/task/add" hx-trigger="submit" hx-target="#tasks">
+	<form hx-post="/task/add" hx-trigger="submit" hx-target="#add-task-error">
 		<input type="text" name="name" required>
 		<input type="number" name="points" value="1">
+		<select name="project">
+			<option value="">No project</option>
+			{{range .Projects}}<option value="{{.ID}}">{{.Name}}</option>{{end}}
+		</select>
+		<input type="text" name="tags" placeholder="tags, comma separated">
+		<input type="datetime-local" name="due" title="Due">
+		<select name="priority">
+			<option value="none">No priority</option>
+			<option value="low">Low</option>
+			<option value="medium">Medium</option>
+			<option value="high">High</option>
+		</select>
+		<input type="text" name="repeat" placeholder="repeat: daily, weekly on mon,thu, every 3 days">
+		{{if .OwnedGroups}}
+		<select name="group">
+			<option value="">Just me</option>
+			{{range .OwnedGroups}}<option value="{{.ID}}">Shared with {{.Name}}</option>{{end}}
+		</select>
+		{{end}}
+		<details>
+			<summary>Limits</summary>
+			<label>Max <input type="number" name="max_completions" min="0" placeholder="unlimited"></label>
+			<select name="completion_period">
+				<option value="day">per day</option>
+				<option value="week">per week</option>
+			</select>
+			<label>Cooldown <input type="number" name="cooldown_minutes" min="0" placeholder="0"> min</label>
+			<label><input type="checkbox" name="one_shot"> One-shot (archived after completion)</label>
+			<label><input type="checkbox" name="requires_approval"> Completions by group members need approval</label>
+		</details>
 		<button type="submit">Add Task</button>
+		<span id="add-task-error" class="error"></span>
 	</form>
 
+	<details>
+		<summary>Projects</summary>
+		{{range .Projects}}
+		<div>
+			{{.Name}}
+			<button hx-delete="/project/{{.ID}}" hx-swap="none"
+			        hx-confirm="Delete project {{.Name}}? Its tasks are kept.">Delete</button>
+		</div>
+		{{end}}
+		<form hx-post="/project/add" hx-target="#add-project-error">
+			<input type="text" name="name" placeholder="New project" required>
+			<button type="submit">Add Project</button>
+			<span id="add-project-error" class="error"></span>
+		</form>
+	</details>
+
+	<h2>Awaiting approval</h2>
+	<div hx-get="/completions/pending" hx-trigger="load, taskChange from:body">
+		<!-- Pending completions load here -->
+	</div>
+
+	<h2>Goals</h2>
+	<div hx-get="/goals" hx-trigger="load, taskChange from:body">
+		<!-- Goal progress loads here -->
+	</div>
+
+	<h2>Stats</h2>
+	<div hx-get="/stats" hx-trigger="load, taskChange from:body">
+		<!-- Statistics load here -->
+	</div>
+
+	<h2>Charts</h2>
+	<div hx-get="/charts" hx-trigger="load, taskChange from:body">
+		<!-- Charts load here -->
+	</div>
+
+	<h2>Achievements</h2>
+	<div hx-get="/achievements" hx-trigger="load, taskChange from:body">
+		<!-- Level, streaks and badges load here -->
+	</div>
+
+	<h2>Points</h2>
+	<div hx-get="/points" hx-trigger="load, taskChange from:body">
+		<!-- Balance, rewards and ledger load here -->
+	</div>
+
 	<h2>Completions</h2>
+	<form class="completion-export" method="get" action="/api/v1/completions/export">
+		<label>From <input type="date" name="from"></label>
+		<label>To <input type="date" name="to"></label>
+		<select name="format">
+			<option value="csv">CSV</option>
+			<option value="tsv">TSV</option>
+		</select>
+		<select name="date_format">
+			<option value="datetime">2006-01-02 15:04:05</option>
+			<option value="date">2006-01-02</option>
+			<option value="us">01/02/2006 15:04</option>
+			<option value="eu">02/01/2006 15:04</option>
+			<option value="rfc3339">RFC 3339</option>
+			<option value="unix">Unix time</option>
+		</select>
+		<input type="hidden" name="tz" id="export-tz">
+		<button type="submit">Download</button>
+	</form>
 	<div hx-get="/completions" hx-trigger="load, taskChange from:body">
 		<!-- Completions load here -->
 	</div>
+
+	<details>
+		<summary>Archive</summary>
+		<div hx-get="/archive" hx-trigger="load, taskChange from:body">
+			<!-- Archived one-shot tasks load here -->
+		</div>
+	</details>
+
+	<details>
+		<summary>Trash</summary>
+		<div hx-get="/trash" hx-trigger="load, taskChange from:body">
+			<!-- Deleted tasks load here -->
+		</div>
+	</details>
+
+	<script>
+		// Prove to the server that htmx requests come from this page.
+		document.body.addEventListener("htmx:configRequest", function (event) {
+			event.detail.headers["X-CSRF-Token"] = document.querySelector('meta[name="csrf-token"]').content;
+		});
+
+		// Swap validation errors (422) into the page instead of dropping them.
+		document.body.addEventListener("htmx:beforeSwap", function (event) {
+			// 409 is a completion refused by the task's limits or a reward
+			// the balance cannot cover, and 403 an action the user's group
+			// role does not allow.
+			var status = event.detail.xhr.status;
+			if (status === 422 || status === 409 || status === 403) {
+				event.detail.shouldSwap = true;
+				event.detail.isError = false;
+			}
+		});
+
+		// Date completion exports in the browser's time zone.
+		document.getElementById("export-tz").value = Intl.DateTimeFormat().resolvedOptions().timeZone || "";
+	</script>
 </body>
-</html>`
-		writer.Write([]byte(tmpl))
+</html>`))
+
+func handleHome(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		session := sessionFromContext(request.Context())
+		if session == nil {
+			renderLogin(writer, http.StatusOK, "", "")
+			return
+		}
+
+		user := session.User
+		projects, err := GetProjects(appState.db, user.ID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tags, err := GetTags(appState.db, user.ID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		groups, err := GetGroups(appState.db, user.ID)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page := homePage{User: user, CSRFToken: session.CSRFToken, Projects: projects, Tags: tags}
+		for _, group := range groups {
+			if group.Role == RoleOwner {
+				page.OwnedGroups = append(page.OwnedGroups, group)
+			}
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		homeTemplate.Execute(writer, page)
 	}
 }
 
-func handleTasks(appState *AppState) http.HandlerFunc {
-    return func(writer http.ResponseWriter, request *http.Request) {
-        tasks, err := GetTasks(appState.db)
-        if err != nil {
-            http.Error(writer, err.Error(), 500)
-            return
-        }
-
-        tmpl := `
+// taskTemplates renders the task list and the per-row fragments that the
+// edit flow swaps in and out.
+var taskTemplates = template.Must(template.New("tasks").Funcs(templateFuncs).Parse(`
         <div id="tasks">
             {{range .}}
-            <div class="task">
+            {{if .Project}}<h3>{{.Project.Name}}</h3>{{else if ne (len $) 1}}<h3>No project</h3>{{end}}
+            {{range .Tasks}}{{template "task-row" .}}{{end}}
+            {{end}}
+        </div>
+{{define "task-row"}}
+            <div class="task priority-{{.Priority}}" id="task-{{.ID}}">
                 {{.Name}} ({{.Points}} pts)
+                {{range .Tags}}<span class="tag">{{.}}</span> {{end}}
+                {{if .DueAt}}<span class="due {{dueStatus .}}">due {{formatTime .DueAt}}</span>{{end}}
+                {{if .Recurrence}}<span class="repeat">repeats {{describeRecurrence .Recurrence}}</span>{{end}}
+                {{with describeRules .}}<span class="rules">{{.}}</span>{{end}}
+                {{if .GroupName}}<span class="group">shared with {{.GroupName}}</span>{{end}}
+                {{if .AssigneeName}}<span class="group">assigned to {{.AssigneeName}}</span>{{end}}
                 <button hx-post="/task/complete/{{.ID}}" 
-                        hx-swap="none"
+                        hx-target="#complete-error-{{.ID}}"
                         hx-trigger="click">Complete</button>
+                <button hx-get="/task/{{.ID}}/edit"
+                        hx-target="#task-{{.ID}}"
+                        hx-swap="outerHTML">Edit</button>
                 <button hx-delete="/task/delete/{{.ID}}"
                         hx-swap="none"
                         hx-trigger="click">Delete</button>
+                <span id="complete-error-{{.ID}}" class="error"></span>
+                {{if .Notes}}<div class="notes">{{.Notes}}</div>{{end}}
             </div>
-            {{end}}
-        </div>`
+{{end}}
+{{define "task-edit"}}
+            <form class="task" id="task-{{.Task.ID}}"
+                  hx-patch="/task/{{.Task.ID}}"
+                  hx-target="this"
+                  hx-swap="outerHTML">
+                <input type="text" name="name" value="{{.Task.Name}}" required>
+                <input type="number" name="points" value="{{.Task.Points}}" min="0">
+                {{if not .Task.GroupID}}
+                <select name="project">
+                    <option value="">No project</option>
+                    {{$projectID := 0}}{{if .Task.ProjectID}}{{$projectID = deref .Task.ProjectID}}{{end}}
+                    {{range .Projects}}<option value="{{.ID}}"{{if eq .ID $projectID}} selected{{end}}>{{.Name}}</option>{{end}}
+                </select>
+                {{end}}
+                <input type="text" name="tags" value="{{join .Task.Tags ", "}}" placeholder="tags, comma separated">
+                <input type="datetime-local" name="due" value="{{if .Task.DueAt}}{{dueInput .Task.DueAt}}{{end}}" title="Due">
+                <select name="priority">
+                    {{range $priority := priorities}}<option value="{{$priority}}"{{if eq $priority $.Task.Priority}} selected{{end}}>{{$priority}}</option>{{end}}
+                </select>
+                <input type="text" name="repeat" value="{{.Task.Recurrence}}" placeholder="repeat: daily, weekly on mon,thu, every 3 days">
+                <label>Max <input type="number" name="max_completions" value="{{.Task.MaxCompletions}}" min="0"></label>
+                <select name="completion_period">
+                    <option value="day">per day</option>
+                    <option value="week"{{if eq .Task.CompletionPeriod "week"}} selected{{end}}>per week</option>
+                </select>
+                <label>Cooldown <input type="number" name="cooldown_minutes" value="{{.Task.CooldownMinutes}}" min="0"> min</label>
+                <label><input type="checkbox" name="one_shot"{{if .Task.OneShot}} checked{{end}}> One-shot</label>
+                <label><input type="checkbox" name="requires_approval"{{if .Task.RequiresApproval}} checked{{end}}> Needs approval</label>
+                {{if .Members}}
+                <select name="assignee">
+                    <option value="">Anyone</option>
+                    {{$assigneeID := 0}}{{if .Task.AssigneeID}}{{$assigneeID = deref .Task.AssigneeID}}{{end}}
+                    {{range .Members}}{{if ne .Role "viewer"}}<option value="{{.UserID}}"{{if eq .UserID $assigneeID}} selected{{end}}>{{.Username}}</option>{{end}}{{end}}
+                </select>
+                {{end}}
+                <textarea name="notes" rows="2">{{.Task.Notes}}</textarea>
+                <button type="submit">Save</button>
+                <button type="button"
+                        hx-get="/task/{{.Task.ID}}"
+                        hx-target="#task-{{.Task.ID}}"
+                        hx-swap="outerHTML">Cancel</button>
+                {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+            </form>
+{{end}}`))
+
+// templateFuncs are shared helpers for the HTML templates.
+var templateFuncs = template.FuncMap{
+	"deref": func(value *int) int { return *value },
+	"join":  strings.Join,
+	"dueStatus": func(task *Task) string {
+		return string(dueStatus(task, time.Now()))
+	},
+	"formatTime": func(moment *time.Time) string {
+		return moment.Local().Format("2006-01-02 15:04")
+	},
+	"dueInput": func(moment *time.Time) string {
+		return moment.Local().Format("2006-01-02T15:04")
+	},
+	"describeRecurrence": describeRecurrence,
+	"describeRules":      describeRules,
+	"priorities": func() []Priority {
+		return []Priority{PriorityNone, PriorityLow, PriorityMedium, PriorityHigh}
+	},
+}
 
-        template := template.Must(template.New("tasks").Parse(tmpl))
-        template.Execute(writer, tasks)
+// readTaskForm copies the organising fields shared by the add and edit
+// forms onto task.
+func readTaskForm(request *http.Request, task *Task) error {
+	var err error
+	// The edit form of a group task offers no project and keeps its own.
+	if task.GroupID == nil || request.Form.Has("project") {
+		if task.ProjectID, err = parseProjectField(request.FormValue("project")); err != nil {
+			return err
+		}
+	}
+	if task.Tags, err = ParseTagList(request.FormValue("tags")); err != nil {
+		return err
+	}
+	if task.DueAt, err = parseDueField(request.FormValue("due")); err != nil {
+		return err
+	}
+	if task.Priority, err = ParsePriority(request.FormValue("priority")); err != nil {
+		return err
+	}
+	task.Recurrence = request.FormValue("repeat")
+	// Only the edit form of a group task offers an assignee.
+	if request.Form.Has("assignee") {
+		if task.AssigneeID, err = parseIDField("assignee", request.FormValue("assignee")); err != nil {
+			return err
+		}
+	}
+	return readRulesForm(request, task)
+}
+
+func handleTasks(appState *AppState) http.HandlerFunc {
+    return func(writer http.ResponseWriter, request *http.Request) {
+        filter, err := parseTaskFilter(request)
+        if err != nil {
+            http.Error(writer, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        userID := currentUserID(request)
+        tasks, err := GetTasks(appState.db, userID, filter)
+        if err != nil {
+            http.Error(writer, err.Error(), 500)
+            return
+        }
+        projects, err := GetProjects(appState.db, userID)
+        if err != nil {
+            http.Error(writer, err.Error(), 500)
+            return
+        }
+
+        writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+        taskTemplates.Execute(writer, groupTasksByProject(tasks, projects))
     }
 }
 
+// taskFromPath loads the task named by the {id} wildcard, writing the error
+// response itself when that fails.
+func taskFromPath(appState *AppState, writer http.ResponseWriter, request *http.Request) (*Task, bool) {
+	taskID, err := strconv.Atoi(request.PathValue("id"))
+	if err != nil {
+		http.Error(writer, "Invalid task ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	task, err := GetTask(appState.db, currentUserID(request), taskID)
+	if err != nil {
+		http.Error(writer, err.Error(), errorStatus(err))
+		return nil, false
+	}
+	return task, true
+}
+
+// handleTaskRow renders a single task row, used to cancel an edit.
+func handleTaskRow(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		task, ok := taskFromPath(appState, writer, request)
+		if !ok {
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		taskTemplates.ExecuteTemplate(writer, "task-row", task)
+	}
+}
+
+// renderTaskEditForm renders the inline edit form for the user making
+// request, with an error message and a 422 status when errorMessage is set.
+func renderTaskEditForm(appState *AppState, writer http.ResponseWriter, request *http.Request, task *Task, errorMessage string) {
+	// Projects are personal, so a group task, which may belong to another
+	// member, offers none. Group tasks can instead be assigned to one of
+	// the group's members.
+	var projects []*Project
+	var members []*GroupMember
+	var err error
+	if task.GroupID == nil {
+		projects, err = GetProjects(appState.db, currentUserID(request))
+	} else {
+		members, err = GetGroupMembers(request.Context(), appState.db, currentUserID(request), *task.GroupID)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if errorMessage != "" {
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	taskTemplates.ExecuteTemplate(writer, "task-edit", map[string]any{
+		"Task":     task,
+		"Projects": projects,
+		"Members":  members,
+		"Error":    errorMessage,
+	})
+}
+
+// handleEditTaskForm swaps a task row for its inline edit form.
+func handleEditTaskForm(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		task, ok := taskFromPath(appState, writer, request)
+		if !ok {
+			return
+		}
+		renderTaskEditForm(appState, writer, request, task, "")
+	}
+}
+
+// handleUpdateTask saves the inline edit form. Validation failures re-render
+// the form with the message and a 422 status, which the home page lets
+// htmx swap in.
+func handleUpdateTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		task, ok := taskFromPath(appState, writer, request)
+		if !ok {
+			return
+		}
+
+		task.Name = request.FormValue("name")
+		task.Notes = request.FormValue("notes")
+		points, err := strconv.Atoi(request.FormValue("points"))
+		if err != nil {
+			renderTaskEditForm(appState, writer, request, task, "points must be a whole number")
+			return
+		}
+		task.Points = points
+
+		var validationError *ValidationError
+		err = readTaskForm(request, task)
+		if err == nil {
+			err = UpdateTaskWithTags(request.Context(), appState.db, currentUserID(request), task)
+		}
+		if errors.As(err, &validationError) {
+			renderTaskEditForm(appState, writer, request, task, err.Error())
+			return
+		}
+		if err == nil {
+			// Reload for the names of the group and assignee.
+			task, err = GetTask(appState.db, currentUserID(request), task.ID)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Header().Set("HX-Trigger", "taskChange")
+		taskTemplates.ExecuteTemplate(writer, "task-row", task)
+	}
+}
+
 func handleAddTask(appState *AppState) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method != "POST" {
@@ -148,12 +655,18 @@ func handleAddTask(appState *AppState) http.HandlerFunc {
 			return
 		}
 
-		name := request.FormValue("name")
 		points, _ := strconv.Atoi(request.FormValue("points"))
-		
-		_, err := appState.db.InsertTask(request.Context(), name, &points, "")
+		task := &Task{UserID: currentUserID(request), Name: request.FormValue("name"), Points: points}
+
+		err := readTaskForm(request, task)
+		if err == nil {
+			task.GroupID, err = parseGroupField(request.FormValue("group"))
+		}
+		if err == nil {
+			err = SaveNewTask(request.Context(), appState.db, task)
+		}
 		if err != nil {
-			http.Error(writer, err.Error(), 500)
+			http.Error(writer, err.Error(), errorStatus(err))
 			return
 		}
 
@@ -176,8 +689,8 @@ func handleCompleteTask(appState *AppState) http.HandlerFunc {
             return
         }
 
-        if err := CompleteTask(request.Context(), appState.db, taskID); err != nil {
-            http.Error(writer, err.Error(), http.StatusInternalServerError)
+        if _, err := CompleteTask(request.Context(), appState.db, currentUserID(request), taskID); err != nil {
+            http.Error(writer, err.Error(), errorStatus(err))
             return
         }
 
@@ -201,9 +714,9 @@ func handleDeleteTask(appState *AppState) http.HandlerFunc {
 		}
 
 		// Delete the task
-		err = appState.db.DeleteTask(request.Context(), taskID)
+		err = appState.db.DeleteTask(request.Context(), currentUserID(request), taskID)
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			http.Error(writer, err.Error(), errorStatus(err))
 			return
 		}
 
@@ -215,7 +728,7 @@ func handleDeleteTask(appState *AppState) http.HandlerFunc {
 
 func handleCompletions(appState *AppState) http.HandlerFunc {
     return func(writer http.ResponseWriter, request *http.Request) {
-        completions, err := GetCompletions(appState.db)
+        completions, err := GetCompletions(appState.db, currentUserID(request))
         if err != nil {
             http.Error(writer, err.Error(), 500)
             return
@@ -253,9 +766,9 @@ func handleDeleteCompletion(appState *AppState) http.HandlerFunc {
 		}
 
 		// Delete the completion
-		err = appState.db.DeleteCompletion(request.Context(), completionID)
+		err = appState.db.DeleteCompletion(request.Context(), currentUserID(request), completionID)
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			http.Error(writer, err.Error(), errorStatus(err))
 			return
 		}
 
@@ -266,20 +779,3 @@ func handleDeleteCompletion(appState *AppState) http.HandlerFunc {
 }
 
 // Additional handlers follow similar pattern
-
-func refreshData(appState *AppState) {
-	// Refresh tasks and completions
-	tasks, err := GetTasks(appState.db)
-	if err != nil {
-		log.Printf("Failed to load tasks: %v", err)
-		return
-	}
-	appState.tasks = tasks
-
-	completions, err := GetCompletions(appState.db)
-	if err != nil {
-		log.Printf("Failed to load completions: %v", err)
-		return
-	}
-	appState.completions = completions
-}
@@ -0,0 +1,701 @@
+// Versioned schema migrations for the task tracker database.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migration is a single numbered schema change. Up is applied when
+// migrating forward and Down reverses it. Both may contain several
+// statements separated by semicolons.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the Up script so that edits to an already applied
+// migration can be detected.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(m.Up)))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationState describes a migration as seen by `migrate status`.
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Drifted is set when the stored checksum differs from the one
+	// compiled into this binary.
+	Drifted bool
+	// Unknown is set for versions recorded in the database that this
+	// binary does not know about, e.g. after a downgrade.
+	Unknown bool
+}
+
+// migrations lists every schema change in order. Append new entries with
+// the next version number; never edit an entry that has shipped.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tasks and completions",
+		// IF NOT EXISTS lets databases created before versioned
+		// migrations adopt this baseline without losing data.
+		Up: `
+			CREATE TABLE IF NOT EXISTS tasks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				points INTEGER NOT NULL DEFAULT 0,
+				notes TEXT,
+				created_at DATETIME NOT NULL,
+				deleted BOOLEAN NOT NULL DEFAULT 0
+			);
+			CREATE TABLE IF NOT EXISTS completions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task_id INTEGER NOT NULL,
+				completed_at DATETIME NOT NULL,
+				points INTEGER NOT NULL,
+				FOREIGN KEY(task_id) REFERENCES tasks(id)
+			);`,
+		Down: `
+			DROP TABLE IF EXISTS completions;
+			DROP TABLE IF EXISTS tasks;`,
+	},
+	{
+		Version: 2,
+		Name:    "index completions by task and time",
+		Up: `
+			CREATE INDEX IF NOT EXISTS idx_completions_task_id ON completions(task_id);
+			CREATE INDEX IF NOT EXISTS idx_completions_completed_at ON completions(completed_at);`,
+		Down: `
+			DROP INDEX IF EXISTS idx_completions_completed_at;
+			DROP INDEX IF EXISTS idx_completions_task_id;`,
+	},
+	{
+		Version: 3,
+		Name:    "track deletion time and purged task names",
+		// Tasks deleted before this migration start their retention
+		// period now rather than being purged straight away.
+		Up: `
+			ALTER TABLE tasks ADD COLUMN deleted_at DATETIME;
+			UPDATE tasks SET deleted_at = CURRENT_TIMESTAMP WHERE deleted = 1;
+			ALTER TABLE completions ADD COLUMN task_name TEXT;`,
+		Down: `
+			ALTER TABLE completions DROP COLUMN task_name;
+			ALTER TABLE tasks DROP COLUMN deleted_at;`,
+	},
+	{
+		Version: 4,
+		Name:    "add projects and tags",
+		Up: `
+			CREATE TABLE projects (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			CREATE UNIQUE INDEX idx_projects_name ON projects(name);
+			ALTER TABLE tasks ADD COLUMN project_id INTEGER;
+			CREATE INDEX idx_tasks_project_id ON tasks(project_id);
+			CREATE TABLE tags (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL
+			);
+			CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+			CREATE TABLE task_tags (
+				task_id INTEGER NOT NULL REFERENCES tasks(id),
+				tag_id INTEGER NOT NULL REFERENCES tags(id),
+				PRIMARY KEY (task_id, tag_id)
+			);
+			CREATE INDEX idx_task_tags_tag_id ON task_tags(tag_id);`,
+		Down: `
+			DROP TABLE task_tags;
+			DROP TABLE tags;
+			DROP INDEX idx_tasks_project_id;
+			ALTER TABLE tasks DROP COLUMN project_id;
+			DROP TABLE projects;`,
+	},
+	{
+		Version: 5,
+		Name:    "add task due dates and priorities",
+		Up: `
+			ALTER TABLE tasks ADD COLUMN due_at DATETIME;
+			ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+			ALTER TABLE tasks DROP COLUMN priority;
+			ALTER TABLE tasks DROP COLUMN due_at;`,
+	},
+	{
+		Version: 6,
+		Name:    "add task recurrence rules",
+		Up: `
+			ALTER TABLE tasks ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';`,
+		Down: `
+			ALTER TABLE tasks DROP COLUMN recurrence;`,
+	},
+	{
+		Version: 7,
+		Name:    "add completion rules and task archiving",
+		Up: `
+			ALTER TABLE tasks ADD COLUMN max_completions INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasks ADD COLUMN completion_period TEXT NOT NULL DEFAULT '';
+			ALTER TABLE tasks ADD COLUMN cooldown_minutes INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasks ADD COLUMN one_shot BOOLEAN NOT NULL DEFAULT 0;
+			ALTER TABLE tasks ADD COLUMN archived_at DATETIME;`,
+		Down: `
+			ALTER TABLE tasks DROP COLUMN archived_at;
+			ALTER TABLE tasks DROP COLUMN one_shot;
+			ALTER TABLE tasks DROP COLUMN cooldown_minutes;
+			ALTER TABLE tasks DROP COLUMN completion_period;
+			ALTER TABLE tasks DROP COLUMN max_completions;`,
+	},
+	{
+		Version: 8,
+		Name:    "add users and per-user ownership",
+		// Existing rows keep a NULL owner until claim-legacy-data gives
+		// them to an account. Project and tag names become
+		// unique per user rather than globally.
+		Up: `
+			CREATE TABLE users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL,
+				password_hash TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			CREATE UNIQUE INDEX idx_users_username ON users(username COLLATE NOCASE);
+			ALTER TABLE tasks ADD COLUMN user_id INTEGER;
+			CREATE INDEX idx_tasks_user_id ON tasks(user_id);
+			ALTER TABLE completions ADD COLUMN user_id INTEGER;
+			CREATE INDEX idx_completions_user_id ON completions(user_id);
+			ALTER TABLE projects ADD COLUMN user_id INTEGER;
+			DROP INDEX idx_projects_name;
+			CREATE UNIQUE INDEX idx_projects_user_name ON projects(user_id, name);
+			ALTER TABLE tags ADD COLUMN user_id INTEGER;
+			DROP INDEX idx_tags_name;
+			CREATE UNIQUE INDEX idx_tags_user_name ON tags(user_id, name);`,
+		Down: `
+			DROP INDEX idx_tags_user_name;
+			CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+			ALTER TABLE tags DROP COLUMN user_id;
+			DROP INDEX idx_projects_user_name;
+			CREATE UNIQUE INDEX idx_projects_name ON projects(name);
+			ALTER TABLE projects DROP COLUMN user_id;
+			DROP INDEX idx_completions_user_id;
+			ALTER TABLE completions DROP COLUMN user_id;
+			DROP INDEX idx_tasks_user_id;
+			ALTER TABLE tasks DROP COLUMN user_id;
+			DROP TABLE users;`,
+	},
+	{
+		Version: 9,
+		Name:    "add login sessions",
+		Up: `
+			CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				csrf_token TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			);
+			CREATE INDEX idx_sessions_user_id ON sessions(user_id);`,
+		Down: `
+			DROP TABLE sessions;`,
+	},
+	{
+		Version: 10,
+		Name:    "add personal API tokens",
+		Up: `
+			CREATE TABLE api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL,
+				scope TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME
+			);
+			CREATE UNIQUE INDEX idx_api_tokens_token_hash ON api_tokens(token_hash);
+			CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);`,
+		Down: `
+			DROP TABLE api_tokens;`,
+	},
+	{
+		Version: 11,
+		Name:    "add groups with shared tasks",
+		// A task with a group_id belongs to that group rather than to its
+		// creator alone. Invites are deleted once accepted.
+		Up: `
+			CREATE TABLE task_groups (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			CREATE TABLE group_members (
+				group_id INTEGER NOT NULL REFERENCES task_groups(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				role TEXT NOT NULL,
+				joined_at DATETIME NOT NULL,
+				PRIMARY KEY (group_id, user_id)
+			);
+			CREATE INDEX idx_group_members_user_id ON group_members(user_id);
+			CREATE TABLE group_invites (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				group_id INTEGER NOT NULL REFERENCES task_groups(id),
+				token_hash TEXT NOT NULL,
+				role TEXT NOT NULL,
+				created_by INTEGER NOT NULL REFERENCES users(id),
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			);
+			CREATE UNIQUE INDEX idx_group_invites_token_hash ON group_invites(token_hash);
+			ALTER TABLE tasks ADD COLUMN group_id INTEGER;
+			CREATE INDEX idx_tasks_group_id ON tasks(group_id);`,
+		Down: `
+			DROP INDEX idx_tasks_group_id;
+			ALTER TABLE tasks DROP COLUMN group_id;
+			DROP TABLE group_invites;
+			DROP TABLE group_members;
+			DROP TABLE task_groups;`,
+	},
+	{
+		Version: 12,
+		Name:    "add task assignment and completion approval",
+		// Existing completions were never reviewed and stay approved.
+		Up: `
+			ALTER TABLE tasks ADD COLUMN requires_approval BOOLEAN NOT NULL DEFAULT 0;
+			ALTER TABLE tasks ADD COLUMN assignee_id INTEGER;
+			ALTER TABLE completions ADD COLUMN status TEXT NOT NULL DEFAULT 'approved';
+			ALTER TABLE completions ADD COLUMN reviewed_by INTEGER;
+			ALTER TABLE completions ADD COLUMN reviewed_at DATETIME;
+			CREATE INDEX idx_completions_status ON completions(status);`,
+		Down: `
+			DROP INDEX idx_completions_status;
+			ALTER TABLE completions DROP COLUMN reviewed_at;
+			ALTER TABLE completions DROP COLUMN reviewed_by;
+			ALTER TABLE completions DROP COLUMN status;
+			ALTER TABLE tasks DROP COLUMN assignee_id;
+			ALTER TABLE tasks DROP COLUMN requires_approval;`,
+	},
+	{
+		Version: 13,
+		Name:    "add points ledger and rewards",
+		// Points already earned are carried over from approved
+		// completions. user_id stays NULL on data from before accounts,
+		// like the completions it comes from.
+		Up: `
+			CREATE TABLE point_entries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER REFERENCES users(id),
+				kind TEXT NOT NULL,
+				points INTEGER NOT NULL,
+				reason TEXT NOT NULL,
+				completion_id INTEGER,
+				redemption_id INTEGER,
+				created_by INTEGER,
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX idx_point_entries_user_id ON point_entries(user_id);
+			CREATE TABLE rewards (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				group_id INTEGER REFERENCES task_groups(id),
+				name TEXT NOT NULL,
+				cost INTEGER NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			CREATE TABLE redemptions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				reward_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				reward_name TEXT NOT NULL,
+				cost INTEGER NOT NULL,
+				redeemed_at DATETIME NOT NULL
+			);
+			INSERT INTO point_entries (user_id, kind, points, reason, completion_id, created_at)
+				SELECT user_id, 'earn', points, 'completed ' || COALESCE((SELECT name FROM tasks WHERE tasks.id = completions.task_id), task_name, 'a task'), id, completed_at
+				FROM completions
+				WHERE status = 'approved' AND points != 0;`,
+		Down: `
+			DROP TABLE redemptions;
+			DROP TABLE rewards;
+			DROP TABLE point_entries;`,
+	},
+	{
+		Version: 14,
+		Name:    "add achievement badges",
+		// Badges without a user_id are built in and apply to everyone.
+		Up: `
+			CREATE TABLE badges (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER REFERENCES users(id),
+				name TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				kind TEXT NOT NULL,
+				threshold INTEGER NOT NULL,
+				task_id INTEGER REFERENCES tasks(id)
+			);
+			CREATE INDEX idx_badges_user_id ON badges(user_id);
+			CREATE TABLE user_badges (
+				badge_id INTEGER NOT NULL REFERENCES badges(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				awarded_at DATETIME NOT NULL,
+				PRIMARY KEY (badge_id, user_id)
+			);
+			INSERT INTO badges (name, description, kind, threshold) VALUES
+				('First steps', 'Complete a task', 'completions', 1),
+				('Getting things done', 'Complete 10 tasks', 'completions', 10),
+				('Centurion', 'Complete 100 tasks', 'completions', 100),
+				('On a roll', 'Complete something 3 days in a row', 'streak', 3),
+				('Week streak', 'Complete something 7 days in a row', 'streak', 7),
+				('Month streak', 'Complete something 30 days in a row', 'streak', 30),
+				('Hundred club', 'Earn 100 points', 'points', 100),
+				('Thousand club', 'Earn 1000 points', 'points', 1000);`,
+		Down: `
+			DROP TABLE user_badges;
+			DROP TABLE badges;`,
+	},
+	{
+		Version: 15,
+		Name:    "add goals",
+		// Progress is computed from completions, so only the targets are
+		// stored.
+		Up: `
+			CREATE TABLE goals (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				kind TEXT NOT NULL,
+				target INTEGER NOT NULL,
+				period TEXT NOT NULL,
+				task_id INTEGER REFERENCES tasks(id),
+				created_at DATETIME NOT NULL
+			);
+			CREATE INDEX idx_goals_user_id ON goals(user_id);`,
+		Down: `
+			DROP TABLE goals;`,
+	},
+	{
+		Version: 16,
+		Name:    "add calendar object names",
+		// Both are NULL for tasks created here; CalDAV clients choose their
+		// own resource name and UID, which must be given back unchanged.
+		Up: `
+			ALTER TABLE tasks ADD COLUMN ical_uid TEXT;
+			ALTER TABLE tasks ADD COLUMN ical_name TEXT;`,
+		Down: `
+			ALTER TABLE tasks DROP COLUMN ical_name;
+			ALTER TABLE tasks DROP COLUMN ical_uid;`,
+	},
+	{
+		Version: 17,
+		Name:    "store completion times in UTC",
+		// The driver wrote times as Go prints them, in the server's zone
+		// ("2024-03-01 10:00:00.5 +0100 CET m=+0.01"), and older rows may
+		// hold SQLite's own "2024-03-01 09:00:00". Both become UTC with
+		// nine fraction digits, which sorts and compares as text. Either
+		// form reads back as the same time, so there is nothing to undo.
+		// Migration 20 replaces this with one format for every column.
+		Up: `
+			UPDATE completions SET completed_at =
+				strftime('%Y-%m-%d %H:%M:%S', substr(completed_at, 1, 19)
+					|| substr(completed_at, 19 + instr(substr(completed_at, 20), ' ') + 1, 3) || ':'
+					|| substr(completed_at, 19 + instr(substr(completed_at, 20), ' ') + 4, 2))
+				|| '.' || substr(CASE WHEN substr(completed_at, 20, 1) = '.'
+					THEN substr(completed_at, 21, instr(substr(completed_at, 20), ' ') - 2) ELSE '' END || '000000000', 1, 9)
+			WHERE instr(substr(completed_at, 20), ' ') > 0;
+			UPDATE completions SET completed_at =
+				strftime('%Y-%m-%d %H:%M:%S', completed_at) || '.' || substr(substr(strftime('%f', completed_at), 4) || '000000000', 1, 9)
+			WHERE completed_at NOT GLOB '*.[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]'
+				AND strftime('%S', completed_at) IS NOT NULL;`,
+		Down: ``,
+	},
+	{
+		Version: 18,
+		Name:    "track the group points belong to",
+		// Group rewards are paid only from points earned on the group's
+		// tasks. Spending on rewards that were removed since cannot be
+		// traced back to their group and stays personal.
+		Up: `
+			ALTER TABLE point_entries ADD COLUMN group_id INTEGER REFERENCES task_groups(id);
+			UPDATE point_entries SET group_id = (
+				SELECT task.group_id FROM completions completion JOIN tasks task ON task.id = completion.task_id
+				WHERE completion.id = point_entries.completion_id)
+			WHERE kind = 'earn';
+			UPDATE point_entries SET group_id = (
+				SELECT reward.group_id FROM redemptions redemption JOIN rewards reward ON reward.id = redemption.reward_id
+				WHERE redemption.id = point_entries.redemption_id)
+			WHERE kind = 'spend';`,
+		Down: `
+			ALTER TABLE point_entries DROP COLUMN group_id;`,
+	},
+	{
+		Version: 19,
+		Name:    "clear references to purged tasks",
+		// Purging used to leave completions, goals and badges pointing at
+		// the removed task. Completions keep their task name under task ID
+		// 0, as purging now does; goals and badges for the task go. The
+		// tasks are gone, so there is nothing to undo.
+		Up: `
+			UPDATE completions SET task_id = 0
+			WHERE task_id != 0 AND task_id NOT IN (SELECT id FROM tasks);
+			DELETE FROM goals WHERE task_id NOT IN (SELECT id FROM tasks);
+			DELETE FROM user_badges WHERE badge_id IN (SELECT id FROM badges WHERE task_id NOT IN (SELECT id FROM tasks));
+			DELETE FROM badges WHERE task_id NOT IN (SELECT id FROM tasks);`,
+		Down: ``,
+	},
+	{
+		Version: 20,
+		Name:    "store every time in one format",
+		// Times are now written as "2006-01-02 15:04:05.999999999-07:00"
+		// (the _time_format=sqlite connection setting). Rows written as Go
+		// prints times ("2024-03-01 10:00:00.5 +0100 CET m=+0.01") keep
+		// their offset; rows without one (completions since migration 17,
+		// and SQLite's CURRENT_TIMESTAMP) are UTC. Down returns
+		// completions to the form migration 17 left, which the SQL of
+		// earlier versions compares as text; the driver reads the other
+		// columns either way.
+		Up: rewriteTimeColumns(`
+			UPDATE TABLE SET COLUMN = substr(COLUMN, 1, 19) || substr(COLUMN, 20, instr(substr(COLUMN, 20), ' ') - 1)
+				|| substr(COLUMN, 20 + instr(substr(COLUMN, 20), ' '), 3) || ':' || substr(COLUMN, 23 + instr(substr(COLUMN, 20), ' '), 2)
+			WHERE instr(substr(COLUMN, 20), ' ') > 0;
+			UPDATE TABLE SET COLUMN = CASE WHEN substr(COLUMN, 20, 1) = '.' THEN rtrim(rtrim(COLUMN, '0'), '.') ELSE COLUMN END || '+00:00'
+			WHERE length(COLUMN) >= 19 AND substr(COLUMN, 20) NOT GLOB '*[-+ ]*';`),
+		Down: `
+			UPDATE completions SET completed_at =
+				strftime('%Y-%m-%d %H:%M:%S', completed_at) || '.' || substr(substr(strftime('%f', completed_at), 4) || '000000000', 1, 9);`,
+	},
+}
+
+// timeColumns lists every DATETIME column as "table.column".
+var timeColumns = []string{
+	"tasks.created_at", "tasks.deleted_at", "tasks.due_at", "tasks.archived_at",
+	"completions.completed_at", "completions.reviewed_at",
+	"projects.created_at", "users.created_at",
+	"sessions.created_at", "sessions.expires_at", "api_tokens.created_at", "api_tokens.last_used_at",
+	"task_groups.created_at", "group_members.joined_at", "group_invites.created_at", "group_invites.expires_at",
+	"point_entries.created_at", "rewards.created_at", "redemptions.redeemed_at",
+	"user_badges.awarded_at", "goals.created_at", "schema_migrations.applied_at",
+}
+
+// rewriteTimeColumns repeats script for each of timeColumns, with TABLE
+// and COLUMN replaced by its names.
+func rewriteTimeColumns(script string) string {
+	var rewritten strings.Builder
+	for _, name := range timeColumns {
+		table, column, _ := strings.Cut(name, ".")
+		rewritten.WriteString(strings.NewReplacer("TABLE", table, "COLUMN", column).Replace(script))
+	}
+	return rewritten.String()
+}
+
+// latestMigrationVersion returns the highest version known to this binary.
+func latestMigrationVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func findMigration(version int) (Migration, bool) {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// ensureMigrationTable creates the bookkeeping table if needed.
+func (db *Database) ensureMigrationTable(ctx context.Context) error {
+	_, err := db.Conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		);`)
+	return err
+}
+
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (db *Database) appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	if err := db.ensureMigrationTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var record appliedMigration
+		if err := rows.Scan(&record.version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[record.version] = record
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus reports every known and recorded migration in version order.
+func (db *Database) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, migration := range migrations {
+		state := MigrationState{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = record.appliedAt
+			state.Drifted = record.checksum != migration.Checksum()
+		}
+		states = append(states, state)
+	}
+	for version, record := range applied {
+		if _, ok := findMigration(version); !ok {
+			states = append(states, MigrationState{
+				Version:   version,
+				Name:      record.name,
+				Applied:   true,
+				AppliedAt: record.appliedAt,
+				Unknown:   true,
+			})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// checkDrift refuses to continue when an applied migration no longer
+// matches its definition, since later migrations may depend on it.
+func checkDrift(states []MigrationState) error {
+	for _, state := range states {
+		if state.Drifted {
+			return fmt.Errorf("migration %d (%s) was modified after it was applied", state.Version, state.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies pending migrations up to and including target.
+// A target of 0 means the latest version.
+func (db *Database) MigrateUp(ctx context.Context, target int) error {
+	if target == 0 {
+		target = latestMigrationVersion()
+	}
+
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkDrift(states); err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		if state.Applied || state.Unknown || state.Version > target {
+			continue
+		}
+		migration, _ := findMigration(state.Version)
+		if err := db.applyMigration(ctx, migration, true); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations.
+func (db *Database) MigrateDown(ctx context.Context, steps int) error {
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkDrift(states); err != nil {
+		return err
+	}
+
+	for i := len(states) - 1; i >= 0 && steps > 0; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+		if state.Unknown {
+			return fmt.Errorf("cannot revert unknown migration %d (%s)", state.Version, state.Name)
+		}
+		migration, _ := findMigration(state.Version)
+		if err := db.applyMigration(ctx, migration, false); err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// Migrate brings the schema up to the latest version.
+func (db *Database) Migrate(ctx context.Context) error {
+	return db.MigrateUp(ctx, 0)
+}
+
+func (db *Database) applyMigration(ctx context.Context, migration Migration, up bool) error {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+	if err := execScript(ctx, transaction, script); err != nil {
+		return err
+	}
+
+	if up {
+		_, err = transaction.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+			migration.Version, migration.Name, migration.Checksum(), time.Now())
+	} else {
+		_, err = transaction.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// execScript runs each semicolon separated statement in script. Migration
+// scripts must not contain semicolons inside string literals or triggers.
+func execScript(ctx context.Context, transaction *sql.Tx, script string) error {
+	for _, statement := range strings.Split(script, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := transaction.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,835 @@
+// OpenAPI 3 document generated from the route table, and a validation mode
+// that checks real handler responses against it.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of the OpenAPI schema object used by this API.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// openAPIComponents maps component names to the Go types they are derived
+// from. Register new request and response types here.
+var openAPIComponents = map[string]reflect.Type{
+	"Task":               reflect.TypeOf(Task{}),
+	"Completion":         reflect.TypeOf(Completion{}),
+	"TaskInput":          reflect.TypeOf(taskRequest{}),
+	"CompletionInput":    reflect.TypeOf(completionRequest{}),
+	"Project":            reflect.TypeOf(Project{}),
+	"ProjectInput":       reflect.TypeOf(projectRequest{}),
+	"Tag":                reflect.TypeOf(Tag{}),
+	"TagsInput":          reflect.TypeOf(tagsRequest{}),
+	"Agenda":             reflect.TypeOf(Agenda{}),
+	"User":               reflect.TypeOf(User{}),
+	"RegisterInput":      reflect.TypeOf(registerRequest{}),
+	"APIToken":           reflect.TypeOf(APIToken{}),
+	"TokenInput":         reflect.TypeOf(tokenRequest{}),
+	"Group":              reflect.TypeOf(Group{}),
+	"GroupInput":         reflect.TypeOf(groupRequest{}),
+	"GroupMember":        reflect.TypeOf(GroupMember{}),
+	"GroupInvite":        reflect.TypeOf(GroupInvite{}),
+	"InviteInput":        reflect.TypeOf(inviteRequest{}),
+	"AcceptInviteInput":  reflect.TypeOf(acceptInviteRequest{}),
+	"MemberRoleInput":    reflect.TypeOf(memberRoleRequest{}),
+	"Ledger":             reflect.TypeOf(Ledger{}),
+	"LedgerEntry":        reflect.TypeOf(LedgerEntry{}),
+	"GroupBalance":       reflect.TypeOf(GroupBalance{}),
+	"AdjustmentInput":    reflect.TypeOf(adjustmentRequest{}),
+	"Reward":             reflect.TypeOf(Reward{}),
+	"RewardInput":        reflect.TypeOf(rewardRequest{}),
+	"Redemption":         reflect.TypeOf(Redemption{}),
+	"Achievements":       reflect.TypeOf(Achievements{}),
+	"Badge":              reflect.TypeOf(Badge{}),
+	"BadgeInput":         reflect.TypeOf(badgeRequest{}),
+	"Stats":              reflect.TypeOf(Stats{}),
+	"Goal":               reflect.TypeOf(Goal{}),
+	"GoalInput":          reflect.TypeOf(goalRequest{}),
+	"GoalProgress":       reflect.TypeOf(GoalProgress{}),
+	"GoalPeriod":         reflect.TypeOf(GoalPeriod{}),
+	"Export":             reflect.TypeOf(Export{}),
+	"ExportedCompletion": reflect.TypeOf(ExportedCompletion{}),
+	"ExportedRedemption": reflect.TypeOf(ExportedRedemption{}),
+	"ImportReport":       reflect.TypeOf(ImportReport{}),
+	"SourceImportReport": reflect.TypeOf(SourceImportReport{}),
+	"ImportedTask":       reflect.TypeOf(ImportedTask{}),
+	"Error":              reflect.TypeOf(apiErrorEnvelope{}),
+	"ErrorDetail":        reflect.TypeOf(APIError{}),
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaForType derives a schema from a Go type using its json tags. Struct
+// types registered as components are referenced rather than inlined.
+func schemaForType(goType reflect.Type, inline bool) *Schema {
+	if goType.Kind() == reflect.Pointer {
+		schema := schemaForType(goType.Elem(), false)
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	}
+	if goType == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch goType.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaForType(goType.Elem(), false)}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		if !inline {
+			for name, componentType := range openAPIComponents {
+				if componentType == goType {
+					return &Schema{Ref: "#/components/schemas/" + name}
+				}
+			}
+		}
+		return schemaForStruct(goType)
+	}
+	return &Schema{}
+}
+
+func schemaForStruct(goType reflect.Type) *Schema {
+	closed := false
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: &closed}
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaForType(field.Type, false)
+		if field.Type.Kind() != reflect.Pointer && !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// schemaForResponse resolves a route's response name to a schema.
+func schemaForResponse(name string) *Schema {
+	if element, ok := strings.CutPrefix(name, "[]"); ok {
+		return &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/" + element}}
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func openAPIComponentSchemas() map[string]*Schema {
+	schemas := map[string]*Schema{
+		// The document itself is free-form JSON.
+		"OpenAPIDocument": {Type: "object"},
+	}
+	for name, goType := range openAPIComponents {
+		schemas[name] = schemaForType(goType, true)
+	}
+	return schemas
+}
+
+// BuildOpenAPIDocument generates the OpenAPI 3 document for every route in
+// appRoutes.
+func BuildOpenAPIDocument() map[string]any {
+	paths := map[string]map[string]any{}
+	for _, route := range appRoutes() {
+		operation := map[string]any{
+			"summary":     route.summary,
+			"operationId": operationID(route),
+		}
+
+		var parameters []map[string]any
+		for _, segment := range strings.Split(route.path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				parameters = append(parameters, map[string]any{
+					"name":     strings.Trim(segment, "{}"),
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]string{"type": "integer"},
+				})
+			}
+		}
+		for _, name := range route.query {
+			parameters = append(parameters, map[string]any{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		if route.request != "" {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaForResponse(route.request)},
+				},
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(route.status)}
+		switch route.response {
+		case responseEmpty:
+		case responseHTML:
+			success["content"] = map[string]any{"text/html": map[string]any{"schema": &Schema{Type: "string"}}}
+		case responseSVG:
+			success["content"] = map[string]any{"image/svg+xml": map[string]any{"schema": &Schema{Type: "string"}}}
+		case responseICS:
+			success["content"] = map[string]any{"text/calendar": map[string]any{"schema": &Schema{Type: "string"}}}
+		case responseCSV:
+			success["content"] = map[string]any{
+				"text/csv":                  map[string]any{"schema": &Schema{Type: "string"}},
+				"text/tab-separated-values": map[string]any{"schema": &Schema{Type: "string"}},
+			}
+		default:
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": schemaForResponse(route.response)}}
+		}
+		responses := map[string]any{fmt.Sprint(route.status): success}
+		if route.isAPI() {
+			responses["default"] = map[string]any{
+				"description": "Error",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaForResponse("Error")},
+				},
+			}
+		} else {
+			responses["4XX"] = map[string]any{"description": "Plain text error or re-rendered form"}
+		}
+		operation["responses"] = responses
+		switch {
+		case route.public:
+			operation["security"] = []any{}
+		case route.requiredScope() == scopeNone:
+			operation["security"] = []any{map[string][]string{"sessionCookie": {}}}
+		case route.isAPI():
+			operation["security"] = []any{
+				map[string][]string{"sessionCookie": {}},
+				map[string][]string{"bearerToken": {string(route.requiredScope())}},
+			}
+		}
+
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]any{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Task Tracker",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": openAPIComponentSchemas(),
+			"securitySchemes": map[string]any{
+				"sessionCookie": map[string]string{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+				"bearerToken":   map[string]string{"type": "http", "scheme": "bearer", "description": "Personal API token with the read, complete or full scope"},
+			},
+		},
+		"security": []any{map[string][]string{"sessionCookie": {}}},
+	}
+}
+
+// operationID turns "GET /api/v1/tasks/{id}" into "getApiV1TasksById".
+func operationID(route route) string {
+	var builder strings.Builder
+	builder.WriteString(strings.ToLower(route.method))
+	for _, segment := range strings.FieldsFunc(route.path, func(r rune) bool { return r == '/' || r == '.' || r == '_' }) {
+		if strings.HasPrefix(segment, "{") {
+			builder.WriteString("By")
+			segment = strings.Trim(segment, "{}")
+		}
+		builder.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return builder.String()
+}
+
+func handleOpenAPI(_ *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, http.StatusOK, BuildOpenAPIDocument())
+	}
+}
+
+// validateSchema checks a decoded JSON value against schema. Objects with
+// declared properties are closed: undocumented fields are reported as drift.
+func validateSchema(schema *Schema, value any, location string, components map[string]*Schema) error {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved, ok := components[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", location, schema.Ref)
+		}
+		return validateSchema(resolved, value, location, components)
+	}
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", location)
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object", location)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", location, name)
+			}
+		}
+		if schema.Properties == nil {
+			return nil
+		}
+		for name, property := range object {
+			propertySchema, ok := schema.Properties[name]
+			if !ok {
+				return fmt.Errorf("%s: undocumented property %q", location, name)
+			}
+			if err := validateSchema(propertySchema, property, location+"."+name, components); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array", location)
+		}
+		for index, item := range items {
+			if err := validateSchema(schema.Items, item, fmt.Sprintf("%s[%d]", location, index), components); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", location)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+				return fmt.Errorf("%s: invalid date-time %q", location, text)
+			}
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return fmt.Errorf("%s: expected integer", location)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected number", location)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", location)
+		}
+	}
+	return nil
+}
+
+// validationStep is one request issued by `openapi validate`. A step with
+// method "AUTH" sends no request: it signs in with its path,
+// "username:password", and later steps send that session's cookie and CSRF
+// token. An empty path signs out, and a body of "omit-csrf" keeps the
+// session but drops the token. A "TOKEN" step instead creates an API token
+// with the scope in its path for the signed in user and sends it as a
+// bearer token from then on. "$token" in a path or body is replaced by the
+// "token" field of the most recent JSON response that had one.
+type validationStep struct {
+	method string
+	path   string
+	body   string
+}
+
+// openAPIValidationSteps exercises every route at least once against a
+// fresh database. IDs assume the rows created by earlier steps.
+var openAPIValidationSteps = []validationStep{
+	{"GET", "/api/openapi.json", ""},
+	{"GET", "/", ""},
+	{"POST", "/api/v1/users", `{"username": "validator", "password": "correct horse"}`},
+	{"POST", "/api/v1/users", `{"username": "Validator", "password": "correct horse"}`},
+	{"POST", "/api/v1/users", `{"username": "x", "password": "short"}`},
+	{"GET", "/register", ""},
+	{"POST", "/register", "username=second&password=battery staple"},
+	{"POST", "/register", "username=second&password=battery staple"},
+	{"GET", "/api/v1/tasks", ""},
+	{"GET", "/api/v1/users/me", ""},
+	{"POST", "/login", "username=validator&password=wrong+password"},
+	{"POST", "/login", "username=validator&password=correct+horse"},
+	{"AUTH", "validator:correct horse", ""},
+	{"GET", "/api/v1/users/me", ""},
+	{"GET", "/", ""},
+	{"AUTH", "validator:correct horse", "omit-csrf"},
+	{"POST", "/task/add", "name=Forged"},
+	{"POST", "/api/v1/tasks", `{"name": "Forged"}`},
+	{"AUTH", "validator:correct horse", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Dishes", "points": 5, "notes": "after dinner"}`},
+	{"POST", "/api/v1/tasks", `{"name": "", "points": 1}`},
+	{"POST", "/api/v1/projects", `{"name": "Kitchen"}`},
+	{"POST", "/api/v1/projects", `{"name": "Kitchen"}`},
+	{"GET", "/api/v1/projects", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Wipe counters", "project_id": 1, "tags": ["daily", "quick"]}`},
+	{"POST", "/api/v1/tasks", `{"name": "Nowhere", "project_id": 99}`},
+	{"PUT", "/api/v1/tasks/2/tags", `{"tags": ["daily"]}`},
+	{"GET", "/api/v1/tasks?tag=daily&project=1", ""},
+	{"GET", "/api/v1/tags", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Descale kettle", "due_at": "2030-01-01T09:00:00Z", "priority": 3}`},
+	{"POST", "/api/v1/tasks", `{"name": "Bad priority", "priority": 7}`},
+	{"PATCH", "/api/v1/tasks/3", `{"due_at": "tomorrow"}`},
+	{"GET", "/api/v1/tasks?due=upcoming&sort=due", ""},
+	{"GET", "/api/v1/tasks?sort=sideways", ""},
+	{"GET", "/tasks?due=overdue&sort=priority&order=asc", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Water plants", "recurrence": "every 3 days", "due_at": "2024-01-01T08:00:00Z"}`},
+	{"POST", "/api/v1/tasks", `{"name": "Standup", "recurrence": "FREQ=WEEKLY;BYDAY=MO,XX"}`},
+	{"POST", "/api/v1/tasks/4/complete", ""},
+	{"PATCH", "/api/v1/tasks/4", `{"recurrence": "weekly on mon,thu"}`},
+	{"GET", "/api/v1/agenda", ""},
+	{"GET", "/agenda", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Dog walk", "max_completions": 1, "completion_period": "day", "cooldown_minutes": 60}`},
+	{"POST", "/api/v1/tasks", `{"name": "Forever", "one_shot": true, "recurrence": "daily"}`},
+	{"POST", "/api/v1/tasks/5/complete", ""},
+	{"POST", "/api/v1/tasks/5/complete", ""},
+	{"POST", "/task/complete/5", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Fix fence", "one_shot": true}`},
+	{"POST", "/api/v1/tasks/6/complete", ""},
+	{"GET", "/api/v1/tasks?archived=true", ""},
+	{"GET", "/archive", ""},
+	{"POST", "/api/v1/tasks/6/unarchive", ""},
+	{"POST", "/api/v1/tasks/6/unarchive", ""},
+	{"POST", "/api/v1/tasks/6/complete", ""},
+	{"POST", "/archive/6/restore", ""},
+	{"POST", "/project/add", "name=Garden"},
+	{"GET", "/tasks?project=1", ""},
+	{"GET", "/api/v1/tasks", ""},
+	{"GET", "/api/v1/tasks/1", ""},
+	{"GET", "/api/v1/tasks/999", ""},
+	{"AUTH", "second:battery staple", ""},
+	{"GET", "/api/v1/tasks/1", ""},
+	{"AUTH", "validator:correct horse", ""},
+	{"PATCH", "/api/v1/tasks/1", `{"name": "Dishes and pans", "points": 8, "notes": "after dinner"}`},
+	{"PATCH", "/api/v1/tasks/1", `{"name": ""}`},
+	{"POST", "/api/v1/tasks/1/complete", ""},
+	{"POST", "/api/v1/completions", `{"task_id": 1, "completed_at": "2024-01-01T09:00:00Z"}`},
+	{"GET", "/api/v1/completions", ""},
+	{"GET", "/api/v1/completions/1", ""},
+	{"DELETE", "/api/v1/completions/2", ""},
+	{"DELETE", "/api/v1/completions", ""},
+	{"POST", "/task/add", "name=Laundry&points=3"},
+	{"GET", "/tasks", ""},
+	{"GET", "/task/2/edit", ""},
+	{"PATCH", "/task/2", "name=Laundry&points=4&notes=whites"},
+	{"PATCH", "/task/2", "name=&points=4"},
+	{"GET", "/task/2", ""},
+	{"POST", "/task/complete/2", ""},
+	{"GET", "/completions", ""},
+	{"DELETE", "/completion/delete/7", ""},
+	{"DELETE", "/task/delete/2", ""},
+	{"DELETE", "/api/v1/tasks/1", ""},
+	{"GET", "/trash", ""},
+	{"GET", "/api/v1/trash", ""},
+	{"POST", "/api/v1/trash/1/restore", ""},
+	{"POST", "/api/v1/trash/1/restore", ""},
+	{"POST", "/trash/2/restore", ""},
+	{"DELETE", "/task/delete/2", ""},
+	{"DELETE", "/trash/2?completions=delete", ""},
+	{"DELETE", "/api/v1/tasks/1", ""},
+	{"DELETE", "/api/v1/trash/1?completions=bogus", ""},
+	{"DELETE", "/api/v1/trash/1", ""},
+	{"DELETE", "/project/2", ""},
+	{"DELETE", "/api/v1/projects/1", ""},
+	{"GET", "/tokens", ""},
+	{"POST", "/tokens", "name=cron&scope=complete"},
+	{"POST", "/tokens", "name=cron&scope=admin"},
+	{"POST", "/api/v1/tokens", `{"name": "backup script", "scope": "read"}`},
+	{"POST", "/api/v1/tokens", `{"name": "", "scope": "full"}`},
+	{"GET", "/api/v1/tokens", ""},
+	{"TOKEN", "read", ""},
+	{"GET", "/api/v1/tasks", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Not allowed"}`},
+	{"GET", "/api/v1/tokens", ""},
+	{"TOKEN", "complete", ""},
+	{"POST", "/api/v1/tasks/3/complete", ""},
+	{"GET", "/api/v1/tasks", ""},
+	{"AUTH", "validator:correct horse", ""},
+	{"POST", "/tokens/1/revoke", ""},
+	{"DELETE", "/api/v1/tokens/2", ""},
+	{"DELETE", "/api/v1/tokens/2", ""},
+	{"POST", "/api/v1/groups", `{"name": "Household"}`},
+	{"POST", "/api/v1/groups", `{"name": " "}`},
+	{"GET", "/api/v1/groups", ""},
+	{"POST", "/api/v1/tasks", `{"name": "Take out bins", "points": 2, "group_id": 1, "requires_approval": true}`},
+	{"POST", "/api/v1/tasks", `{"name": "Not ours", "group_id": 99}`},
+	{"POST", "/api/v1/groups/1/invites", `{"role": "owner"}`},
+	{"POST", "/api/v1/groups/1/invites", `{"role": "member"}`},
+	{"AUTH", "second:battery staple", ""},
+	{"GET", "/api/v1/tasks/8", ""},
+	{"POST", "/api/v1/invites/accept", `{"token": "$token"}`},
+	{"POST", "/api/v1/invites/accept", `{"token": "$token"}`},
+	{"GET", "/api/v1/groups/1/members", ""},
+	{"POST", "/api/v1/tasks/8/complete", ""},
+	{"POST", "/task/complete/8", ""},
+	{"POST", "/task/complete/8", ""},
+	{"GET", "/completions/pending", ""},
+	{"POST", "/api/v1/completions/9/approve", ""},
+	{"PATCH", "/api/v1/tasks/8", `{"name": "Renamed"}`},
+	{"DELETE", "/api/v1/groups/1/members/1", ""},
+	{"POST", "/api/v1/groups/1/invites", `{"role": "viewer"}`},
+	{"AUTH", "validator:correct horse", ""},
+	{"POST", "/api/v1/tasks/8/complete", ""},
+	{"GET", "/api/v1/completions/pending", ""},
+	{"POST", "/api/v1/completions/9/approve", ""},
+	{"POST", "/api/v1/completions/9/reject", ""},
+	{"POST", "/completion/reject/10", ""},
+	{"POST", "/completion/approve/11", ""},
+	{"POST", "/api/v1/completions/11/reject", ""},
+	{"PATCH", "/api/v1/tasks/8", `{"assignee_id": 99}`},
+	{"PATCH", "/api/v1/tasks/8", `{"assignee_id": 2}`},
+	{"GET", "/api/v1/completions", ""},
+	{"PATCH", "/api/v1/groups/1/members/2", `{"role": "admin"}`},
+	{"PATCH", "/api/v1/groups/1/members/1", `{"role": "viewer"}`},
+	{"AUTH", "second:battery staple", ""},
+	{"DELETE", "/api/v1/completions/12", ""},
+	{"AUTH", "validator:correct horse", ""},
+	{"PATCH", "/api/v1/groups/1/members/2", `{"role": "viewer"}`},
+	{"AUTH", "second:battery staple", ""},
+	{"POST", "/task/complete/8", ""},
+	{"DELETE", "/api/v1/groups/1/members/2", ""},
+	{"GET", "/api/v1/tasks/8", ""},
+	{"AUTH", "validator:correct horse", ""},
+	{"GET", "/groups", ""},
+	{"POST", "/groups", "name=Flatmates"},
+	{"POST", "/groups/2/invites", "role=admin"},
+	{"POST", "/api/v1/groups/2/invites", `{"role": "member"}`},
+	{"AUTH", "second:battery staple", ""},
+	{"GET", "/invite?token=$token", ""},
+	{"POST", "/invite", "token=$token"},
+	{"POST", "/invite", "token=$token"},
+	{"AUTH", "validator:correct horse", ""},
+	{"POST", "/groups/2/members/2/role", "role=viewer"},
+	{"POST", "/groups/2/members/2/role", "role=owner"},
+	{"POST", "/groups/2/members/2/remove", ""},
+	{"PATCH", "/api/v1/tasks/3", `{"points": 14}`},
+	{"POST", "/api/v1/tasks/3/complete", ""},
+	{"GET", "/api/v1/points", ""},
+	{"POST", "/api/v1/points/adjustments", `{"points": 10, "reason": "starting bonus"}`},
+	{"POST", "/api/v1/points/adjustments", `{"points": -2, "reason": "counted twice"}`},
+	{"POST", "/api/v1/points/adjustments", `{"points": -100001, "reason": "too much"}`},
+	{"POST", "/api/v1/points/adjustments", `{"points": 5, "reason": " "}`},
+	{"POST", "/api/v1/points/adjustments", `{"user_id": 2, "points": 5, "reason": "not my member"}`},
+	{"POST", "/api/v1/rewards", `{"name": "Movie night", "cost": 10}`},
+	{"POST", "/api/v1/rewards", `{"name": "New bike", "cost": 100000}`},
+	{"POST", "/api/v1/rewards", `{"name": "Free lunch", "cost": 0}`},
+	{"POST", "/api/v1/rewards", `{"name": "Pizza", "cost": 1, "group_id": 1}`},
+	{"GET", "/api/v1/rewards", ""},
+	{"POST", "/api/v1/rewards/1/redeem", ""},
+	{"POST", "/api/v1/rewards/2/redeem", ""},
+	{"DELETE", "/api/v1/rewards/2", ""},
+	{"DELETE", "/api/v1/rewards/2", ""},
+	{"GET", "/points", ""},
+	{"POST", "/rewards", "name=Nap&cost=2"},
+	{"POST", "/rewards/4/redeem", ""},
+	{"DELETE", "/rewards/4", ""},
+	{"POST", "/points/adjust", "points=-1&reason=typo"},
+	{"AUTH", "second:battery staple", ""},
+	{"POST", "/api/v1/rewards/1/redeem", ""},
+	{"GET", "/api/v1/points", ""},
+	{"AUTH", "validator:correct horse", ""},
+	{"GET", "/api/v1/achievements", ""},
+	{"GET", "/api/v1/badges", ""},
+	{"POST", "/api/v1/badges", `{"name": "Kettle master", "kind": "completions", "threshold": 1, "task_id": 3}`},
+	{"POST", "/api/v1/badges", `{"name": "Big spender", "kind": "points", "threshold": 5, "task_id": 3}`},
+	{"POST", "/api/v1/badges", `{"name": "Marathon", "kind": "distance", "threshold": 5}`},
+	{"POST", "/api/v1/tasks/3/complete", ""},
+	{"DELETE", "/api/v1/badges/1", ""},
+	{"DELETE", "/api/v1/badges/9", ""},
+	{"GET", "/achievements", ""},
+	{"POST", "/badges", "name=Regular&kind=streak&threshold=2"},
+	{"POST", "/badges", "name=Nope&kind=streak&threshold=none"},
+	{"DELETE", "/badges/10", ""},
+	{"GET", "/api/v1/stats", ""},
+	{"GET", "/api/v1/stats?period=week&top=1", ""},
+	{"GET", "/api/v1/stats?period=month&from=2024-01-01&to=2024-12-31", ""},
+	{"GET", "/api/v1/stats?period=fortnight", ""},
+	{"GET", "/api/v1/stats?from=2024-02-01&to=2024-01-01", ""},
+	{"GET", "/api/v1/stats?from=1990-01-01", ""},
+	{"GET", "/stats?period=week", ""},
+	{"GET", "/charts", ""},
+	{"GET", "/charts?period=month&year=2024", ""},
+	{"GET", "/charts/points.svg?period=week", ""},
+	{"GET", "/charts/tasks.svg", ""},
+	{"GET", "/charts/heatmap.svg?year=2025", ""},
+	{"GET", "/charts/heatmap.svg?year=last", ""},
+	{"POST", "/api/v1/goals", `{"kind": "points", "target": 50, "period": "day"}`},
+	{"POST", "/api/v1/goals", `{"kind": "completions", "target": 3, "period": "week", "task_id": 3}`},
+	{"POST", "/api/v1/goals", `{"kind": "completions", "target": 0, "period": "week"}`},
+	{"POST", "/api/v1/goals", `{"kind": "points", "target": 5, "period": "year"}`},
+	{"GET", "/api/v1/goals", ""},
+	{"DELETE", "/api/v1/goals/1", ""},
+	{"DELETE", "/api/v1/goals/1", ""},
+	{"GET", "/goals", ""},
+	{"POST", "/goals", "kind=completions&target=2&period=day&task=3"},
+	{"POST", "/goals", "kind=points&target=lots&period=day"},
+	{"DELETE", "/goals/3", ""},
+	{"GET", "/api/v1/completions/export", ""},
+	{"GET", "/api/v1/completions/export?format=tsv&from=2024-01-01&to=2030-12-31&task=3&tz=Europe/Berlin&date_format=unix", ""},
+	{"GET", "/api/v1/completions/export?format=xlsx", ""},
+	{"GET", "/api/v1/completions/export?tz=Mars/Olympus", ""},
+	{"GET", "/api/v1/completions/export?from=2024-02-01&to=2024-01-01", ""},
+	{"GET", "/api/v1/export", ""},
+	{"POST", "/api/v1/import", `{"format": "go_tasks", "version": 1, "projects": [{"id": 7, "name": "Imported", "created_at": "2024-01-01T00:00:00Z"}],
+		"tasks": [{"id": 5, "name": "Water plants", "points": 2, "project_id": 7, "tags": ["garden"], "created_at": "2024-01-01T00:00:00Z"}],
+		"completions": [{"id": 9, "task_id": 5, "completed_at": "2024-01-02T08:00:00Z", "points": 2},
+			{"id": 10, "task_id": 6, "task_name": "Gone", "completed_at": "2024-01-03T08:00:00Z", "points": 1}],
+		"point_entries": [{"id": 1, "user_id": 1, "kind": "earn", "points": 2, "reason": "completed Water plants", "completion_id": 9, "created_at": "2024-01-02T08:00:00Z"}],
+		"goals": [{"id": 1, "kind": "completions", "target": 1, "period": "week", "task_id": 6, "created_at": "2024-01-01T00:00:00Z"}]}`},
+	{"POST", "/api/v1/import", `{"format": "go_tasks", "version": 99}`},
+	{"POST", "/api/v1/import?mode=overwrite", `{"format": "go_tasks", "version": 1}`},
+	{"POST", "/api/v1/import?mode=replace", `{"format": "go_tasks", "version": 1, "tasks": [{"id": 1, "name": "Fresh start", "created_at": "2024-01-01T00:00:00Z"}]}`},
+	{"POST", "/api/v1/import/todotxt?dry_run=true", "(A) 2024-01-01 Call mum +family @phone due:2024-01-05\nx 2024-01-03 2024-01-01 Pay rent +home"},
+	{"POST", "/api/v1/import/todotxt", "(B) Water plants @home\nx 2024-01-03 Pay rent"},
+	{"POST", "/api/v1/import/taskwarrior", `[{"description": "Fix bike", "status": "completed", "end": "20240105T101500Z", "priority": "H", "tags": ["garage"],
+		"annotations": [{"entry": "20240101T090000Z", "description": "new chain"}]}, {"description": "Old", "status": "deleted"}]`},
+	{"POST", "/api/v1/import/todoist-json", `{"items": [{"id": "1", "content": "Read book", "priority": 4, "labels": ["leisure"], "checked": 0}], "notes": [{"item_id": "1", "content": "chapter 3"}]}`},
+	{"POST", "/api/v1/import/todoist-csv?dry_run=1", "TYPE,CONTENT,DESCRIPTION,PRIORITY\ntask,Plan trip @travel,,1\nnote,Book hotel,,"},
+	{"POST", "/api/v1/import/omnifocus", "whatever"},
+	{"POST", "/api/v1/import/taskwarrior", "not json"},
+	{"POST", "/api/v1/tokens", `{"name": "calendar", "scope": "read"}`},
+	{"GET", "/calendar/$token.ics", ""},
+	{"GET", "/calendar/tt_unknown.ics", ""},
+	{"POST", "/api/v1/tokens", `{"name": "cron", "scope": "complete"}`},
+	{"GET", "/calendar/$token.ics", ""},
+	{"POST", "/api/v1/tokens", `{"name": "script", "scope": "full"}`},
+	{"GET", "/calendar/$token.ics", ""},
+	{"POST", "/logout", ""},
+}
+
+// RunOpenAPIValidation serves openAPIValidationSteps through the real
+// router backed by a temporary database and checks each response against
+// the generated document. It fails if any route is left unexercised.
+func RunOpenAPIValidation(ctx context.Context, output io.Writer) error {
+	directory, err := os.MkdirTemp("", "tasks-openapi-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(directory)
+
+	config := DefaultConfig()
+	config.DatabasePath = filepath.Join(directory, "validate.db")
+	database, err := NewDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	if err := database.Migrate(ctx); err != nil {
+		return err
+	}
+
+	appState := &AppState{config: config, db: database}
+	router := newRouter(appState)
+
+	// Full strength password hashing would dominate the run time.
+	defer func(iterations int) { passwordIterations = iterations }(passwordIterations)
+	passwordIterations = 1000
+
+	routesByPattern := map[string]route{}
+	for _, route := range appRoutes() {
+		routesByPattern[route.muxPattern()] = route
+	}
+	components := openAPIComponentSchemas()
+	exercised := map[string]bool{}
+
+	var failures []string
+	var sessionToken, csrfToken, bearer, lastToken string
+	var signedIn *User
+	for _, step := range openAPIValidationSteps {
+		if step.method == "TOKEN" {
+			token, err := CreateAPIToken(ctx, database, signedIn.ID, "validation", TokenScope(step.path))
+			if err != nil {
+				return err
+			}
+			sessionToken, csrfToken, bearer = "", "", token.Token
+			continue
+		}
+		if step.method == "AUTH" {
+			bearer = ""
+			sessionToken, csrfToken = "", ""
+			if step.path == "" {
+				continue
+			}
+			username, password, _ := strings.Cut(step.path, ":")
+			user, err := AuthenticateUser(ctx, database, username, password)
+			if err != nil {
+				return fmt.Errorf("signing in as %s: %v", username, err)
+			}
+			token, session, err := CreateSession(ctx, database, user, config.SessionLifetime)
+			if err != nil {
+				return err
+			}
+			sessionToken, signedIn = token, user
+			if step.body != "omit-csrf" {
+				csrfToken = session.CSRFToken
+			}
+			continue
+		}
+
+		step.path = strings.ReplaceAll(step.path, "$token", url.QueryEscape(lastToken))
+		step.body = strings.ReplaceAll(step.body, "$token", lastToken)
+		var body io.Reader
+		if step.body != "" {
+			body = strings.NewReader(step.body)
+		}
+		request := httptest.NewRequest(step.method, step.path, body).WithContext(ctx)
+		if strings.HasPrefix(step.body, "{") {
+			request.Header.Set("Content-Type", "application/json")
+		} else if step.body != "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if sessionToken != "" {
+			request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionToken})
+		}
+		if csrfToken != "" {
+			request.Header.Set(csrfHeaderName, csrfToken)
+		}
+		if bearer != "" {
+			request.Header.Set("Authorization", "Bearer "+bearer)
+		}
+
+		_, pattern := router.Handler(request)
+		route, ok := routesByPattern[pattern]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s %s: no documented route", step.method, step.path))
+			continue
+		}
+		exercised[route.method+" "+route.path] = true
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if err := validateResponse(route, recorder, components); err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %v", step.method, step.path, err))
+			continue
+		}
+		var withToken struct {
+			Token string `json:"token"`
+		}
+		if json.Unmarshal(recorder.Body.Bytes(), &withToken) == nil && withToken.Token != "" {
+			lastToken = withToken.Token
+		}
+		fmt.Fprintf(output, "ok   %-6s %-32s %d\n", step.method, step.path, recorder.Code)
+	}
+
+	for _, route := range appRoutes() {
+		if !exercised[route.method+" "+route.path] {
+			failures = append(failures, fmt.Sprintf("%s %s: not exercised", route.method, route.path))
+		}
+	}
+
+	for _, failure := range failures {
+		fmt.Fprintf(output, "FAIL %s\n", failure)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d OpenAPI validation failure(s)", len(failures))
+	}
+	return nil
+}
+
+func validateResponse(route route, recorder *httptest.ResponseRecorder, components map[string]*Schema) error {
+	contentType := recorder.Header().Get("Content-Type")
+
+	if recorder.Code != route.status {
+		switch {
+		case recorder.Code < 400 || (!route.isAPI() && recorder.Code >= 500):
+			return fmt.Errorf("undocumented status %d: %s", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+		case !route.isAPI():
+			return nil
+		}
+		return validateJSONBody(schemaForResponse("Error"), recorder, contentType, components)
+	}
+
+	switch route.response {
+	case responseEmpty:
+		if recorder.Body.Len() > 0 {
+			return fmt.Errorf("expected empty body, got %d bytes", recorder.Body.Len())
+		}
+		return nil
+	case responseHTML:
+		if !strings.HasPrefix(contentType, "text/html") {
+			return fmt.Errorf("expected text/html, got %q", contentType)
+		}
+		return nil
+	case responseSVG:
+		if contentType != "image/svg+xml" || !strings.HasPrefix(recorder.Body.String(), "<svg") {
+			return fmt.Errorf("expected an SVG document, got %q", contentType)
+		}
+		return nil
+	case responseICS:
+		if !strings.HasPrefix(contentType, "text/calendar") || !strings.HasPrefix(recorder.Body.String(), "BEGIN:VCALENDAR\r\n") {
+			return fmt.Errorf("expected an iCalendar document, got %q", contentType)
+		}
+		return nil
+	case responseCSV:
+		if !strings.HasPrefix(contentType, "text/csv") && !strings.HasPrefix(contentType, "text/tab-separated-values") {
+			return fmt.Errorf("expected CSV or TSV, got %q", contentType)
+		}
+		if !strings.HasPrefix(recorder.Body.String(), "completion_id") {
+			return fmt.Errorf("expected a header row, got %q", recorder.Body.String())
+		}
+		return nil
+	}
+	return validateJSONBody(schemaForResponse(route.response), recorder, contentType, components)
+}
+
+func validateJSONBody(schema *Schema, recorder *httptest.ResponseRecorder, contentType string, components map[string]*Schema) error {
+	if !strings.HasPrefix(contentType, "application/json") {
+		return fmt.Errorf("expected application/json, got %q", contentType)
+	}
+	var value any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return validateSchema(schema, value, "body", components)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIValidation runs the same walk as `openapi validate`: every
+// route is exercised and every response must match the document.
+func TestOpenAPIValidation(t *testing.T) {
+	var output bytes.Buffer
+	if err := RunOpenAPIValidation(context.Background(), &output); err != nil {
+		var failures []string
+		for _, line := range strings.Split(output.String(), "\n") {
+			if strings.HasPrefix(line, "FAIL") {
+				failures = append(failures, line)
+			}
+		}
+		t.Fatalf("%v\n%s", err, strings.Join(failures, "\n"))
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	components := openAPIComponentSchemas()
+	object := &Schema{Type: "object", Required: []string{"id"}, Properties: map[string]*Schema{"id": {Type: "integer"}}}
+	tests := []struct {
+		name    string
+		schema  *Schema
+		body    string
+		wantErr string
+	}{
+		{"integer", &Schema{Type: "integer"}, `3`, ""},
+		{"fraction is not an integer", &Schema{Type: "integer"}, `3.5`, "expected integer"},
+		{"null not allowed", &Schema{Type: "string"}, `null`, "null is not allowed"},
+		{"nullable", &Schema{Type: "string", Nullable: true}, `null`, ""},
+		{"date-time", &Schema{Type: "string", Format: "date-time"}, `"2024-01-02T03:04:05Z"`, ""},
+		{"bad date-time", &Schema{Type: "string", Format: "date-time"}, `"yesterday"`, "invalid date-time"},
+		{"array items", &Schema{Type: "array", Items: &Schema{Type: "boolean"}}, `[true, 1]`, "body[1]: expected boolean"},
+		{"object", object, `{"id": 1}`, ""},
+		{"missing property", object, `{}`, `missing required property "id"`},
+		{"undocumented property", object, `{"id": 1, "surprise": true}`, `undocumented property "surprise"`},
+		{"wrong property type", object, `{"id": "one"}`, "body.id: expected integer"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(test.body), &value); err != nil {
+				t.Fatal(err)
+			}
+			err := validateSchema(test.schema, value, "body", components)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
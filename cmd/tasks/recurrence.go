@@ -0,0 +1,489 @@
+// Recurring tasks: schedule rules, next due dates and the due now / later
+// agenda.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the RRULE FREQ of a recurrence.
+type Frequency string
+
+const (
+	FrequencyDaily   Frequency = "DAILY"
+	FrequencyWeekly  Frequency = "WEEKLY"
+	FrequencyMonthly Frequency = "MONTHLY"
+	FrequencyYearly  Frequency = "YEARLY"
+)
+
+var frequencyUnits = map[Frequency]string{
+	FrequencyDaily:   "day",
+	FrequencyWeekly:  "week",
+	FrequencyMonthly: "month",
+	FrequencyYearly:  "year",
+}
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// maxInterval bounds INTERVAL; searching for the next occurrence walks
+// through the periods in between.
+const maxInterval = 999
+
+// monthlySearchLimit is how many INTERVAL steps nextMonthly tries. Rules
+// that pass check find a month with their day within twelve steps; the
+// rest covers leap years.
+const monthlySearchLimit = 12 * 8
+
+// Recurrence is the supported subset of an RFC 5545 RRULE: FREQ, INTERVAL,
+// BYDAY (plain weekdays, for DAILY and WEEKLY), BYMONTHDAY (a single day,
+// negative counting from the end of the month) and UNTIL.
+type Recurrence struct {
+	Frequency Frequency
+	Interval  int
+	Weekdays  []time.Weekday
+	MonthDay  int
+	Until     *time.Time
+}
+
+// ParseRecurrence reads either an RRULE (with or without the "RRULE:"
+// prefix) or one of the shorthands typed into the task form: daily,
+// weekdays, weekly, monthly, yearly, "weekly on mon,thu" and
+// "every 3 days". An empty value means the task does not repeat.
+func ParseRecurrence(value string) (*Recurrence, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	upper := strings.ToUpper(value)
+	if strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"))
+	}
+	return parseRecurrenceShorthand(strings.ToLower(value))
+}
+
+func parseRRule(value string) (*Recurrence, error) {
+	recurrence := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, field, found := strings.Cut(part, "=")
+		if !found {
+			return nil, &ValidationError{fmt.Sprintf("invalid RRULE part %q", part)}
+		}
+
+		var err error
+		switch key {
+		case "FREQ":
+			recurrence.Frequency = Frequency(field)
+			if _, ok := frequencyUnits[recurrence.Frequency]; !ok {
+				return nil, &ValidationError{fmt.Sprintf("unsupported FREQ %q: use DAILY, WEEKLY, MONTHLY or YEARLY", field)}
+			}
+		case "INTERVAL":
+			recurrence.Interval, err = strconv.Atoi(field)
+			if err != nil || recurrence.Interval < 1 || recurrence.Interval > maxInterval {
+				return nil, &ValidationError{fmt.Sprintf("invalid INTERVAL %q: use 1 to %d", field, maxInterval)}
+			}
+		case "BYDAY":
+			if recurrence.Weekdays, err = parseWeekdays(strings.Split(field, ",")); err != nil {
+				return nil, err
+			}
+		case "BYMONTHDAY":
+			recurrence.MonthDay, err = strconv.Atoi(field)
+			if err != nil || recurrence.MonthDay == 0 || recurrence.MonthDay < -31 || recurrence.MonthDay > 31 {
+				return nil, &ValidationError{fmt.Sprintf("invalid BYMONTHDAY %q: use a single day from 1 to 31 or -1 to -31", field)}
+			}
+		case "UNTIL":
+			until, err := parseUntil(field)
+			if err != nil {
+				return nil, err
+			}
+			recurrence.Until = &until
+		case "WKST":
+			if field != "MO" {
+				return nil, &ValidationError{"only WKST=MO is supported"}
+			}
+		default:
+			return nil, &ValidationError{fmt.Sprintf("unsupported RRULE part %q", key)}
+		}
+	}
+	return recurrence, recurrence.check()
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if until, err := time.Parse("20060102T150405Z", value); err == nil {
+		return until, nil
+	}
+	if until, err := time.ParseInLocation("20060102T150405", value, time.Local); err == nil {
+		return until, nil
+	}
+	// A date-only UNTIL includes the whole day.
+	if until, err := time.ParseInLocation("20060102", value, time.Local); err == nil {
+		return until.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, &ValidationError{fmt.Sprintf("invalid UNTIL %q", value)}
+}
+
+func parseRecurrenceShorthand(value string) (*Recurrence, error) {
+	recurrence := &Recurrence{Interval: 1}
+	rule, days, hasDays := strings.Cut(value, " on ")
+
+	switch rule {
+	case "daily":
+		recurrence.Frequency = FrequencyDaily
+	case "weekdays":
+		recurrence.Frequency = FrequencyDaily
+		recurrence.Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	case "weekly":
+		recurrence.Frequency = FrequencyWeekly
+	case "monthly":
+		recurrence.Frequency = FrequencyMonthly
+	case "yearly":
+		recurrence.Frequency = FrequencyYearly
+	default:
+		fields := strings.Fields(rule)
+		if len(fields) != 3 || fields[0] != "every" {
+			return nil, &ValidationError{fmt.Sprintf("unknown repeat rule %q: try daily, weekly on mon,thu, every 3 days or an RRULE", value)}
+		}
+		interval, err := strconv.Atoi(fields[1])
+		if err != nil || interval < 1 || interval > maxInterval {
+			return nil, &ValidationError{fmt.Sprintf("invalid interval in %q: use 1 to %d", value, maxInterval)}
+		}
+		recurrence.Interval = interval
+		unit := strings.TrimSuffix(fields[2], "s")
+		for frequency, name := range frequencyUnits {
+			if name == unit {
+				recurrence.Frequency = frequency
+			}
+		}
+		if recurrence.Frequency == "" {
+			return nil, &ValidationError{fmt.Sprintf("unknown unit %q: use days, weeks, months or years", fields[2])}
+		}
+	}
+
+	if hasDays {
+		var codes []string
+		for _, name := range strings.FieldsFunc(days, func(r rune) bool { return r == ',' || r == ' ' }) {
+			codes = append(codes, weekdayCode(name))
+		}
+		weekdays, err := parseWeekdays(codes)
+		if err != nil {
+			return nil, err
+		}
+		recurrence.Weekdays = weekdays
+	}
+	return recurrence, recurrence.check()
+}
+
+// weekdayCode turns a weekday name or an abbreviation of at least two
+// letters, such as "thu" or "Thursday", into its RRULE code.
+func weekdayCode(name string) string {
+	if len(name) >= 2 {
+		for index, code := range weekdayCodes {
+			if strings.HasPrefix(strings.ToLower(time.Weekday(index).String()), strings.ToLower(name)) {
+				return code
+			}
+		}
+	}
+	return name
+}
+
+// parseWeekdays reads RRULE weekday codes and returns the days in week
+// order starting on Monday.
+func parseWeekdays(codes []string) ([]time.Weekday, error) {
+	selected := make(map[time.Weekday]bool)
+	for _, code := range codes {
+		found := false
+		for index, known := range weekdayCodes {
+			if code == known {
+				selected[time.Weekday(index)] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, &ValidationError{fmt.Sprintf("unknown weekday %q", code)}
+		}
+	}
+
+	var weekdays []time.Weekday
+	for offset := 1; offset <= 7; offset++ {
+		if day := time.Weekday(offset % 7); selected[day] {
+			weekdays = append(weekdays, day)
+		}
+	}
+	return weekdays, nil
+}
+
+func (r *Recurrence) check() error {
+	if r.Frequency == "" {
+		return &ValidationError{"RRULE needs a FREQ"}
+	}
+	if len(r.Weekdays) > 0 && r.Frequency != FrequencyDaily && r.Frequency != FrequencyWeekly {
+		return &ValidationError{"BYDAY is only supported with DAILY and WEEKLY rules"}
+	}
+	if r.MonthDay != 0 && r.Frequency != FrequencyMonthly {
+		return &ValidationError{"BYMONTHDAY is only supported with MONTHLY rules"}
+	}
+	// Every month has day 28. Later days are missing from some months, and
+	// an INTERVAL of whole years only ever visits one month, which may be
+	// one of them.
+	if (r.MonthDay > 28 || r.MonthDay < -28) && r.Interval%12 == 0 {
+		return &ValidationError{fmt.Sprintf("BYMONTHDAY=%d needs an INTERVAL that is not a multiple of 12: use FREQ=YEARLY or a day from -28 to 28", r.MonthDay)}
+	}
+	return nil
+}
+
+// String returns the canonical RRULE that is stored on the task.
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + string(r.Frequency)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.Weekdays) > 0 {
+		codes := make([]string, len(r.Weekdays))
+		for index, day := range r.Weekdays {
+			codes[index] = weekdayCodes[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.MonthDay != 0 {
+		parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", r.MonthDay))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Describe returns a short English summary such as "every 2 weeks on Mon, Thu".
+func (r *Recurrence) Describe() string {
+	unit := frequencyUnits[r.Frequency]
+	description := "every " + unit
+	if r.Interval > 1 {
+		description = fmt.Sprintf("every %d %ss", r.Interval, unit)
+	}
+	if len(r.Weekdays) > 0 {
+		names := make([]string, len(r.Weekdays))
+		for index, day := range r.Weekdays {
+			names[index] = day.String()[:3]
+		}
+		description += " on " + strings.Join(names, ", ")
+	}
+	switch {
+	case r.MonthDay > 0:
+		description += fmt.Sprintf(" on day %d", r.MonthDay)
+	case r.MonthDay == -1:
+		description += " on the last day"
+	case r.MonthDay < 0:
+		description += fmt.Sprintf(" on day %d from the end", -r.MonthDay)
+	}
+	if r.Until != nil {
+		description += " until " + r.Until.Local().Format("2006-01-02")
+	}
+	return description
+}
+
+// NormalizeRecurrence parses value and returns the canonical RRULE, or ""
+// for a task that does not repeat.
+func NormalizeRecurrence(value string) (string, error) {
+	recurrence, err := ParseRecurrence(value)
+	if err != nil || recurrence == nil {
+		return "", err
+	}
+	return recurrence.String(), nil
+}
+
+// Next returns the first occurrence strictly after moment, keeping its time
+// of day. The week, month or year containing moment counts as the first
+// period for INTERVAL. ok is false once the rule has passed UNTIL.
+func (r *Recurrence) Next(moment time.Time) (next time.Time, ok bool, err error) {
+	moment = moment.In(time.Local)
+	switch r.Frequency {
+	case FrequencyDaily:
+		next = moment.AddDate(0, 0, r.Interval)
+		// Stepping by INTERVAL cycles through the weekdays within a week
+		// of steps, so a rule that never lands on BYDAY has no next date.
+		for steps := 1; len(r.Weekdays) > 0 && !r.onWeekday(next); steps++ {
+			if steps == 7 {
+				return time.Time{}, false, nil
+			}
+			next = next.AddDate(0, 0, r.Interval)
+		}
+	case FrequencyWeekly:
+		next = r.nextWeekly(moment)
+	case FrequencyMonthly:
+		if next, err = r.nextMonthly(moment); err != nil {
+			return time.Time{}, false, err
+		}
+	case FrequencyYearly:
+		next = moment.AddDate(r.Interval, 0, 0)
+	}
+	if r.Until != nil && next.After(*r.Until) {
+		return time.Time{}, false, nil
+	}
+	return next, true, nil
+}
+
+func (r *Recurrence) onWeekday(moment time.Time) bool {
+	for _, day := range r.Weekdays {
+		if moment.Weekday() == day {
+			return true
+		}
+	}
+	return false
+}
+
+// weekStart returns midnight on the Monday of moment's week.
+func weekStart(moment time.Time) time.Time {
+	offset := (int(moment.Weekday()) + 6) % 7
+	return startOfDay(moment).AddDate(0, 0, -offset)
+}
+
+func (r *Recurrence) nextWeekly(moment time.Time) time.Time {
+	if len(r.Weekdays) == 0 {
+		return moment.AddDate(0, 0, 7*r.Interval)
+	}
+
+	// Walk forward day by day, only accepting selected weekdays in weeks
+	// that are a multiple of INTERVAL away from moment's week.
+	firstWeek := weekStart(moment)
+	for next := moment.AddDate(0, 0, 1); ; next = next.AddDate(0, 0, 1) {
+		weeks := int(weekStart(next).Sub(firstWeek).Hours()+12) / (24 * 7)
+		if weeks%r.Interval == 0 && r.onWeekday(next) {
+			return next
+		}
+	}
+}
+
+func (r *Recurrence) nextMonthly(moment time.Time) (time.Time, error) {
+	day := r.MonthDay
+	if day == 0 {
+		day = moment.Day()
+	}
+
+	year, month, _ := moment.Date()
+	hour, minute, second := moment.Clock()
+	for attempt := 0; attempt < monthlySearchLimit; attempt++ {
+		step := attempt * r.Interval
+		first := time.Date(year, month+time.Month(step), 1, hour, minute, second, 0, time.Local)
+		length := first.AddDate(0, 1, -1).Day()
+		target := day
+		if target < 0 {
+			target = length + day + 1
+		}
+		// Months without the day are skipped, as RFC 5545 requires.
+		if target < 1 || target > length {
+			continue
+		}
+		if next := first.AddDate(0, 0, target-1); next.After(moment) {
+			return next, nil
+		}
+	}
+	// Only rules saved before check rejected them get here.
+	return time.Time{}, &ValidationError{fmt.Sprintf("repeat rule %s never occurs again: edit the task's repeat rule", r)}
+}
+
+// nextDueAt computes the due date of a recurring task after it is completed
+// at completedAt. Tasks with a due date stay on their schedule, skipping
+// occurrences missed before completedAt; tasks without one are next due one
+// period after completion. ok is false once the rule has ended.
+func nextDueAt(recurrence *Recurrence, dueAt *time.Time, completedAt time.Time) (time.Time, bool, error) {
+	if dueAt == nil {
+		return recurrence.Next(completedAt)
+	}
+
+	next, ok, err := recurrence.Next(*dueAt)
+	for err == nil && ok && !next.After(completedAt) {
+		next, ok, err = recurrence.Next(next)
+	}
+	return next, ok, err
+}
+
+// describeRecurrence is used by templates to label repeating tasks.
+func describeRecurrence(value string) string {
+	recurrence, err := ParseRecurrence(value)
+	if err != nil || recurrence == nil {
+		return value
+	}
+	return recurrence.Describe()
+}
+
+// Agenda splits dated and repeating tasks into what needs doing today and
+// what comes later.
+type Agenda struct {
+	// Now holds overdue tasks, tasks due today and repeating tasks that
+	// have never been given a due date.
+	Now []*Task `json:"now"`
+	// Later holds tasks due after today.
+	Later []*Task `json:"later"`
+}
+
+// GetAgenda builds userID's agenda from their active tasks.
+func GetAgenda(db *Database, userID int) (*Agenda, error) {
+	tasks, err := GetTasks(db, userID, TaskFilter{Sort: SortDue})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	agenda := &Agenda{Now: []*Task{}, Later: []*Task{}}
+	for _, task := range tasks {
+		switch dueStatus(task, now) {
+		case DueOverdue, DueToday:
+			agenda.Now = append(agenda.Now, task)
+		case DueUpcoming:
+			agenda.Later = append(agenda.Later, task)
+		default:
+			if task.Recurrence != "" {
+				agenda.Now = append(agenda.Now, task)
+			}
+		}
+	}
+	return agenda, nil
+}
+
+var agendaTemplate = template.Must(template.New("agenda").Funcs(templateFuncs).Parse(`
+        <div id="agenda">
+            <h3>Due now</h3>
+            {{range .Now}}{{template "agenda-item" .}}{{else}}<p>Nothing due.</p>{{end}}
+            <h3>Later</h3>
+            {{range .Later}}{{template "agenda-item" .}}{{else}}<p>Nothing scheduled.</p>{{end}}
+        </div>
+        {{define "agenda-item"}}
+            <div class="task">
+                {{.Name}} ({{.Points}} pts)
+                {{if .DueAt}}<span class="due {{dueStatus .}}">due {{formatTime .DueAt}}</span>{{end}}
+                {{if .Recurrence}}<span class="repeat">repeats {{describeRecurrence .Recurrence}}</span>{{end}}
+                <button hx-post="/task/complete/{{.ID}}"
+                        hx-target="#agenda-error-{{.ID}}">Complete</button>
+                <span id="agenda-error-{{.ID}}" class="error"></span>
+            </div>
+        {{end}}`))
+
+func handleAgenda(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		agenda, err := GetAgenda(appState.db, currentUserID(request))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		agendaTemplate.Execute(writer, agenda)
+	}
+}
+
+func handleAPIAgenda(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		agenda, err := GetAgenda(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, agenda)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func localTime(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.Local)
+}
+
+func TestNormalizeRecurrence(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"daily", "FREQ=DAILY", false},
+		{"weekdays", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", false},
+		{"weekly on thu,mon", "FREQ=WEEKLY;BYDAY=MO,TH", false},
+		{"every 3 days", "FREQ=DAILY;INTERVAL=3", false},
+		{"every 2 months", "FREQ=MONTHLY;INTERVAL=2", false},
+		{"RRULE:FREQ=MONTHLY;BYMONTHDAY=-1", "FREQ=MONTHLY;BYMONTHDAY=-1", false},
+		{"FREQ=MONTHLY;INTERVAL=6;BYMONTHDAY=31", "FREQ=MONTHLY;INTERVAL=6;BYMONTHDAY=31", false},
+		{"FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=28", "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=28", false},
+		{"FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=31", "", true},
+		{"FREQ=MONTHLY;INTERVAL=24;BYMONTHDAY=-30", "", true},
+		{"FREQ=DAILY;INTERVAL=1000", "", true},
+		{"every 1000 days", "", true},
+		{"FREQ=DAILY;INTERVAL=0", "", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", "", true},
+		{"FREQ=WEEKLY;BYMONTHDAY=3", "", true},
+		{"FREQ=MONTHLY;BYDAY=MO", "", true},
+		{"FREQ=HOURLY", "", true},
+		{"FREQ=DAILY;COUNT=3", "", true},
+		{"fortnightly", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := NormalizeRecurrence(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NormalizeRecurrence(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("NormalizeRecurrence(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		moment time.Time
+		want   time.Time
+		wantOK bool
+	}{
+		{"daily", "FREQ=DAILY", localTime(2024, 1, 31, 9), localTime(2024, 2, 1, 9), true},
+		{"weekdays skip the weekend", "weekdays", localTime(2024, 3, 1, 9), localTime(2024, 3, 4, 9), true},
+		{"weekly", "FREQ=WEEKLY", localTime(2024, 3, 1, 9), localTime(2024, 3, 8, 9), true},
+		{"weekly on days", "FREQ=WEEKLY;BYDAY=MO,TH", localTime(2024, 3, 4, 9), localTime(2024, 3, 7, 9), true},
+		{"every other week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", localTime(2024, 3, 4, 9), localTime(2024, 3, 18, 9), true},
+		{"monthly keeps the day", "FREQ=MONTHLY", localTime(2024, 1, 15, 9), localTime(2024, 2, 15, 9), true},
+		{"day 31 skips short months", "FREQ=MONTHLY;BYMONTHDAY=31", localTime(2024, 3, 31, 9), localTime(2024, 5, 31, 9), true},
+		{"last day of a leap February", "FREQ=MONTHLY;BYMONTHDAY=-1", localTime(2024, 1, 31, 9), localTime(2024, 2, 29, 9), true},
+		{"half-yearly day 31 from April", "FREQ=MONTHLY;INTERVAL=6;BYMONTHDAY=31", localTime(2024, 4, 10, 9), localTime(2024, 10, 31, 9), true},
+		{"yearly", "FREQ=YEARLY", localTime(2024, 6, 1, 9), localTime(2025, 6, 1, 9), true},
+		{"until ends the rule", "FREQ=DAILY;UNTIL=20240301", localTime(2024, 3, 1, 9), time.Time{}, false},
+		{"daily never on its weekday", "FREQ=DAILY;INTERVAL=7;BYDAY=TU", localTime(2024, 3, 4, 9), time.Time{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recurrence, err := ParseRecurrence(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := recurrence.Next(test.moment)
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if ok != test.wantOK || !got.Equal(test.want) {
+				t.Errorf("Next(%s) = %s, %v; want %s, %v", test.moment, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+// TestNextMonthlyUnreachableDay covers a rule check rejects, as it may
+// still be stored from before: it must fail rather than search forever.
+func TestNextMonthlyUnreachableDay(t *testing.T) {
+	recurrence := &Recurrence{Frequency: FrequencyMonthly, Interval: 12, MonthDay: 31}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := recurrence.Next(localTime(2024, 4, 10, 9))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Next found an occurrence of day 31 in April")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next did not return")
+	}
+}
+
+func TestNextDueAt(t *testing.T) {
+	weekly, err := ParseRecurrence("FREQ=WEEKLY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := localTime(2024, 3, 1, 9)
+	tests := []struct {
+		name        string
+		dueAt       *time.Time
+		completedAt time.Time
+		want        time.Time
+	}{
+		{"on time", &due, localTime(2024, 3, 1, 8), localTime(2024, 3, 8, 9)},
+		{"missed weeks are skipped", &due, localTime(2024, 3, 20, 12), localTime(2024, 3, 22, 9)},
+		{"no due date counts from completion", nil, localTime(2024, 3, 20, 12), localTime(2024, 3, 27, 12)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok, err := nextDueAt(weekly, test.dueAt, test.completedAt)
+			if err != nil || !ok || !got.Equal(test.want) {
+				t.Errorf("nextDueAt = %s, %v, %v; want %s", got, ok, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Route table shared by runServer and the OpenAPI document.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Response kinds for routes that do not return a JSON schema.
+const (
+	responseHTML  = "html"
+	responseSVG   = "svg"
+	responseCSV   = "csv"
+	responseICS   = "ics"
+	responseEmpty = ""
+)
+
+// route describes one endpoint. runServer registers every entry and the
+// OpenAPI document is generated from the same list, so adding a handler
+// here is all it takes to publish it.
+type route struct {
+	method string
+	// path is the documented path with {wildcards}.
+	path string
+	// pattern overrides the ServeMux pattern for the htmx endpoints that
+	// match any method and check it themselves.
+	pattern string
+	summary string
+	handler func(*AppState) http.HandlerFunc
+	// query lists the optional query string parameters.
+	query []string
+	// request names the component schema of the JSON request body.
+	request string
+	status  int
+	// response names a component schema, "[]Name" for arrays, or one of
+	// responseHTML, responseSVG and responseEmpty.
+	response string
+	// public routes are served without signing in.
+	public bool
+	// tokenScope is the API token scope the route requires when it is not
+	// the default from requiredScope.
+	tokenScope TokenScope
+}
+
+func (r route) muxPattern() string {
+	if r.pattern != "" {
+		return r.pattern
+	}
+	return r.method + " " + r.path
+}
+
+// requiredScope is the API token scope needed to call the route: read for
+// GET requests and full for the rest, unless tokenScope says otherwise.
+func (r route) requiredScope() TokenScope {
+	switch {
+	case r.tokenScope != "":
+		return r.tokenScope
+	case r.method == "GET":
+		return ScopeRead
+	}
+	return ScopeFull
+}
+
+// isAPI reports whether the route speaks JSON and uses the error envelope.
+func (r route) isAPI() bool {
+	return strings.HasPrefix(r.path, "/api/")
+}
+
+func appRoutes() []route {
+	return []route{
+		// Static HTML and htmx fragments
+		{method: "GET", path: "/", pattern: "/", summary: "Home page, or the login form when signed out", handler: handleHome, status: 200, response: responseHTML, public: true},
+		{method: "GET", path: "/tasks", pattern: "/tasks", summary: "Task list fragment grouped by project", handler: handleTasks, query: []string{"tag", "project", "archived", "due", "sort", "order"}, status: 200, response: responseHTML},
+		{method: "POST", path: "/task/add", summary: "Add a task from the form", handler: handleAddTask, status: 200, response: responseEmpty},
+		{method: "GET", path: "/task/{id}", summary: "Task row fragment", handler: handleTaskRow, status: 200, response: responseHTML},
+		{method: "GET", path: "/task/{id}/edit", summary: "Inline task edit form", handler: handleEditTaskForm, status: 200, response: responseHTML},
+		{method: "PATCH", path: "/task/{id}", summary: "Save the inline task edit form", handler: handleUpdateTask, status: 200, response: responseHTML},
+		{method: "POST", path: "/task/complete/{id}", summary: "Complete a task", handler: handleCompleteTask, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/task/delete/{id}", summary: "Delete a task", handler: handleDeleteTask, status: 200, response: responseEmpty},
+		{method: "GET", path: "/completions", pattern: "/completions", summary: "Completion list fragment", handler: handleCompletions, status: 200, response: responseHTML},
+		{method: "DELETE", path: "/completion/delete/{id}", summary: "Delete a completion", handler: handleDeleteCompletion, status: 200, response: responseEmpty},
+		{method: "GET", path: "/completions/pending", summary: "Completions awaiting approval fragment", handler: handlePendingCompletions, status: 200, response: responseHTML},
+		{method: "POST", path: "/completion/approve/{id}", summary: "Approve a pending completion", handler: handleReviewCompletion(true), status: 200, response: responseEmpty},
+		{method: "POST", path: "/completion/reject/{id}", summary: "Reject a pending completion", handler: handleReviewCompletion(false), status: 200, response: responseEmpty},
+		{method: "GET", path: "/points", summary: "Points balance, rewards and ledger fragment", handler: handlePoints, status: 200, response: responseHTML},
+		{method: "POST", path: "/points/adjust", summary: "Adjust your own balance from the form", handler: handleAdjustPoints, status: 200, response: responseEmpty},
+		{method: "POST", path: "/rewards", summary: "Add a reward from the form", handler: handleAddReward, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/rewards/{id}", summary: "Remove a reward", handler: handleDeleteReward, status: 200, response: responseEmpty},
+		{method: "POST", path: "/rewards/{id}/redeem", summary: "Spend points on a reward", handler: handleRedeemReward, status: 200, response: responseEmpty},
+		{method: "GET", path: "/achievements", summary: "Level, streaks and badges fragment", handler: handleAchievements, status: 200, response: responseHTML},
+		{method: "POST", path: "/badges", summary: "Add a badge from the form", handler: handleAddBadge, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/badges/{id}", summary: "Remove one of your badges", handler: handleDeleteBadge, status: 200, response: responseEmpty},
+		{method: "GET", path: "/stats", summary: "Statistics fragment", handler: handleStats, query: []string{"period", "from", "to"}, status: 200, response: responseHTML},
+		{method: "GET", path: "/goals", summary: "Goals with progress and history fragment", handler: handleGoals, status: 200, response: responseHTML},
+		{method: "POST", path: "/goals", summary: "Add a goal from the form", handler: handleAddGoal, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/goals/{id}", summary: "Remove a goal", handler: handleDeleteGoal, status: 200, response: responseEmpty},
+		{method: "GET", path: "/charts", summary: "Charts fragment with the SVGs inline", handler: handleCharts, query: []string{"period", "from", "to", "year"}, status: 200, response: responseHTML},
+		{method: "GET", path: "/charts/points.svg", summary: "Line chart of points per day, week or month", handler: handleStatsChart(pointsChartSVG), query: []string{"period", "from", "to"}, status: 200, response: responseSVG},
+		{method: "GET", path: "/charts/tasks.svg", summary: "Bar chart of points per task", handler: handleStatsChart(tasksChartSVG), query: []string{"period", "from", "to"}, status: 200, response: responseSVG},
+		{method: "GET", path: "/charts/heatmap.svg", summary: "Calendar heatmap of completions in a year", handler: handleHeatmap, query: []string{"year"}, status: 200, response: responseSVG},
+		{method: "POST", path: "/project/add", summary: "Add a project from the form", handler: handleAddProject, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/project/{id}", summary: "Delete a project", handler: handleDeleteProject, status: 200, response: responseEmpty},
+		{method: "GET", path: "/calendar/{feed}", summary: "iCalendar feed of tasks and completions; {feed} is a read token followed by .ics", handler: handleCalendarFeed, status: 200, response: responseICS, public: true},
+		{method: "GET", path: "/trash", summary: "Trash fragment", handler: handleTrash, status: 200, response: responseHTML},
+		{method: "GET", path: "/archive", summary: "Archived tasks fragment", handler: handleArchive, status: 200, response: responseHTML},
+		{method: "POST", path: "/archive/{id}/restore", summary: "Return an archived task to the list", handler: handleUnarchiveTask, status: 200, response: responseEmpty},
+		{method: "GET", path: "/agenda", summary: "Due now and later fragment", handler: handleAgenda, status: 200, response: responseHTML},
+		{method: "POST", path: "/trash/{id}/restore", summary: "Restore a deleted task", handler: handleRestoreTask, status: 200, response: responseEmpty},
+		{method: "DELETE", path: "/trash/{id}", summary: "Permanently purge a deleted task", handler: handlePurgeTask, query: []string{"completions"}, status: 200, response: responseEmpty},
+		{method: "POST", path: "/login", summary: "Sign in from the login form", handler: handleLogin, status: 303, response: responseEmpty, public: true},
+		{method: "POST", path: "/logout", summary: "Sign out", handler: handleLogout, status: 303, response: responseEmpty},
+		{method: "GET", path: "/tokens", summary: "API token management page", handler: handleTokensPage, status: 200, response: responseHTML},
+		{method: "POST", path: "/tokens", summary: "Create an API token from the form", handler: handleCreateToken, status: 200, response: responseHTML},
+		{method: "POST", path: "/tokens/{id}/revoke", summary: "Revoke an API token", handler: handleRevokeToken, status: 303, response: responseEmpty},
+		{method: "GET", path: "/register", summary: "Registration form", handler: handleRegisterPage, status: 200, response: responseHTML, public: true},
+		{method: "POST", path: "/register", summary: "Create an account from the form", handler: handleRegister, status: 303, response: responseEmpty, public: true},
+		{method: "GET", path: "/groups", summary: "Group management page", handler: handleGroupsPage, status: 200, response: responseHTML},
+		{method: "POST", path: "/groups", summary: "Create a group from the form", handler: handleCreateGroup, status: 303, response: responseEmpty},
+		{method: "POST", path: "/groups/{id}/invites", summary: "Create an invite link, shown once on the page", handler: handleCreateInvite, status: 200, response: responseHTML},
+		{method: "POST", path: "/groups/{id}/members/{member}/role", summary: "Change a member's role", handler: handleSetMemberRole, status: 303, response: responseEmpty},
+		{method: "POST", path: "/groups/{id}/members/{member}/remove", summary: "Remove a member, or leave the group", handler: handleRemoveMember, status: 303, response: responseEmpty},
+		{method: "GET", path: "/invite", summary: "Invite link landing page", handler: handleInvitePage, query: []string{"token"}, status: 200, response: responseHTML, public: true},
+		{method: "POST", path: "/invite", summary: "Accept an invite from the landing page", handler: handleAcceptInvite, status: 303, response: responseEmpty},
+
+		// JSON API
+		{method: "GET", path: "/api/openapi.json", summary: "This OpenAPI document", handler: handleOpenAPI, status: 200, response: "OpenAPIDocument", public: true},
+		{method: "POST", path: "/api/v1/users", summary: "Register an account", handler: handleAPIRegister, request: "RegisterInput", status: 201, response: "User", public: true},
+		{method: "GET", path: "/api/v1/users/me", summary: "The signed in user", handler: handleAPICurrentUser, status: 200, response: "User"},
+		{method: "GET", path: "/api/v1/tokens", summary: "List API tokens (sessions only)", handler: handleAPIListTokens, status: 200, response: "[]APIToken", tokenScope: scopeNone},
+		{method: "POST", path: "/api/v1/tokens", summary: "Create an API token, returning its secret once (sessions only)", handler: handleAPICreateToken, request: "TokenInput", status: 201, response: "APIToken", tokenScope: scopeNone},
+		{method: "DELETE", path: "/api/v1/tokens/{id}", summary: "Revoke an API token (sessions only)", handler: handleAPIRevokeToken, status: 204, response: responseEmpty, tokenScope: scopeNone},
+		{method: "GET", path: "/api/v1/groups", summary: "List the groups you belong to", handler: handleAPIListGroups, status: 200, response: "[]Group"},
+		{method: "POST", path: "/api/v1/groups", summary: "Create a group you own", handler: handleAPICreateGroup, request: "GroupInput", status: 201, response: "Group"},
+		{method: "GET", path: "/api/v1/groups/{id}/members", summary: "List a group's members", handler: handleAPIListMembers, status: 200, response: "[]GroupMember"},
+		{method: "PATCH", path: "/api/v1/groups/{id}/members/{member}", summary: "Change a member's role (owner only)", handler: handleAPISetMemberRole, request: "MemberRoleInput", status: 200, response: "[]GroupMember"},
+		{method: "DELETE", path: "/api/v1/groups/{id}/members/{member}", summary: "Remove a member, or leave the group", handler: handleAPIRemoveMember, status: 204, response: responseEmpty},
+		{method: "POST", path: "/api/v1/groups/{id}/invites", summary: "Create a one-time invite, returning its token once", handler: handleAPICreateInvite, request: "InviteInput", status: 201, response: "GroupInvite"},
+		{method: "POST", path: "/api/v1/invites/accept", summary: "Join a group with an invite token", handler: handleAPIAcceptInvite, request: "AcceptInviteInput", status: 200, response: "Group"},
+		{method: "GET", path: "/api/v1/tasks", summary: "List tasks", handler: handleAPIListTasks, query: []string{"tag", "project", "archived", "due", "sort", "order"}, status: 200, response: "[]Task"},
+		{method: "POST", path: "/api/v1/tasks", summary: "Create a task", handler: handleAPICreateTask, request: "TaskInput", status: 201, response: "Task"},
+		{method: "GET", path: "/api/v1/tasks/{id}", summary: "Get a task", handler: handleAPIGetTask, status: 200, response: "Task"},
+		{method: "PATCH", path: "/api/v1/tasks/{id}", summary: "Update a task", handler: handleAPIUpdateTask, request: "TaskInput", status: 200, response: "Task"},
+		{method: "DELETE", path: "/api/v1/tasks/{id}", summary: "Delete a task", handler: handleAPIDeleteTask, status: 204, response: responseEmpty},
+		{method: "POST", path: "/api/v1/tasks/{id}/complete", summary: "Complete a task now", handler: handleAPICompleteTask, status: 201, response: "Completion", tokenScope: ScopeComplete},
+		{method: "PUT", path: "/api/v1/tasks/{id}/tags", summary: "Replace the tags of a task", handler: handleAPISetTaskTags, request: "TagsInput", status: 200, response: "Task"},
+		{method: "GET", path: "/api/v1/tags", summary: "List tags with task counts", handler: handleAPIListTags, status: 200, response: "[]Tag"},
+		{method: "POST", path: "/api/v1/tasks/{id}/unarchive", summary: "Return an archived task to the list", handler: handleAPIUnarchiveTask, status: 200, response: "Task"},
+		{method: "GET", path: "/api/v1/agenda", summary: "Tasks due now and later", handler: handleAPIAgenda, status: 200, response: "Agenda"},
+		{method: "GET", path: "/api/v1/projects", summary: "List projects", handler: handleAPIListProjects, status: 200, response: "[]Project"},
+		{method: "POST", path: "/api/v1/projects", summary: "Create a project", handler: handleAPICreateProject, request: "ProjectInput", status: 201, response: "Project"},
+		{method: "DELETE", path: "/api/v1/projects/{id}", summary: "Delete a project, keeping its tasks", handler: handleAPIDeleteProject, status: 204, response: responseEmpty},
+		{method: "GET", path: "/api/v1/completions", summary: "List completions", handler: handleAPIListCompletions, status: 200, response: "[]Completion"},
+		{method: "POST", path: "/api/v1/completions", summary: "Record a completion at a given time", handler: handleAPICreateCompletion, request: "CompletionInput", status: 201, response: "Completion"},
+		{method: "DELETE", path: "/api/v1/completions", summary: "Clear all completions", handler: handleAPIClearCompletions, status: 204, response: responseEmpty},
+		{method: "GET", path: "/api/v1/completions/export", summary: "Download approved completions as CSV or TSV", handler: handleAPIExportCompletions, query: []string{"format", "from", "to", "task", "tz", "date_format"}, status: 200, response: responseCSV},
+		{method: "GET", path: "/api/v1/completions/pending", summary: "List completions awaiting approval", handler: handleAPIListPendingCompletions, status: 200, response: "[]Completion"},
+		{method: "POST", path: "/api/v1/completions/{id}/approve", summary: "Approve a pending completion (group admins and owners)", handler: handleAPIReviewCompletion(true), status: 200, response: "Completion"},
+		{method: "POST", path: "/api/v1/completions/{id}/reject", summary: "Reject a pending completion (group admins and owners)", handler: handleAPIReviewCompletion(false), status: 200, response: "Completion"},
+		{method: "GET", path: "/api/v1/completions/{id}", summary: "Get a completion", handler: handleAPIGetCompletion, status: 200, response: "Completion"},
+		{method: "DELETE", path: "/api/v1/completions/{id}", summary: "Delete a completion", handler: handleAPIDeleteCompletion, status: 204, response: responseEmpty},
+		{method: "GET", path: "/api/v1/points", summary: "Your points balance and ledger", handler: handleAPIGetPoints, status: 200, response: "Ledger"},
+		{method: "POST", path: "/api/v1/points/adjustments", summary: "Adjust your balance, or a group member's as an admin", handler: handleAPIAdjustPoints, request: "AdjustmentInput", status: 201, response: "LedgerEntry"},
+		{method: "GET", path: "/api/v1/rewards", summary: "List the rewards you can redeem", handler: handleAPIListRewards, status: 200, response: "[]Reward"},
+		{method: "POST", path: "/api/v1/rewards", summary: "Add a personal or group reward", handler: handleAPICreateReward, request: "RewardInput", status: 201, response: "Reward"},
+		{method: "DELETE", path: "/api/v1/rewards/{id}", summary: "Remove a reward", handler: handleAPIDeleteReward, status: 204, response: responseEmpty},
+		{method: "POST", path: "/api/v1/rewards/{id}/redeem", summary: "Spend points on a reward", handler: handleAPIRedeemReward, status: 201, response: "Redemption"},
+		{method: "GET", path: "/api/v1/achievements", summary: "Your level, streaks and badges", handler: handleAPIGetAchievements, status: 200, response: "Achievements"},
+		{method: "GET", path: "/api/v1/badges", summary: "List built-in and your own badges, awarded first", handler: handleAPIListBadges, status: 200, response: "[]Badge"},
+		{method: "POST", path: "/api/v1/badges", summary: "Add a badge of your own", handler: handleAPICreateBadge, request: "BadgeInput", status: 201, response: "Badge"},
+		{method: "DELETE", path: "/api/v1/badges/{id}", summary: "Remove one of your badges", handler: handleAPIDeleteBadge, status: 204, response: responseEmpty},
+		{method: "GET", path: "/api/v1/stats", summary: "Completion and point statistics per day, week or month, task and tag", handler: handleAPIStats, query: []string{"period", "from", "to", "top"}, status: 200, response: "Stats"},
+		{method: "GET", path: "/api/v1/goals", summary: "Your goals with this period's progress and past periods", handler: handleAPIListGoals, status: 200, response: "[]GoalProgress"},
+		{method: "POST", path: "/api/v1/goals", summary: "Set a goal", handler: handleAPICreateGoal, request: "GoalInput", status: 201, response: "Goal"},
+		{method: "DELETE", path: "/api/v1/goals/{id}", summary: "Remove a goal", handler: handleAPIDeleteGoal, status: 204, response: responseEmpty},
+		{method: "GET", path: "/api/v1/export", summary: "Download your data as a versioned JSON document", handler: handleAPIExport, status: 200, response: "Export"},
+		{method: "POST", path: "/api/v1/import", summary: "Import an export document (mode=merge|replace)", handler: handleAPIImport, query: []string{"mode"}, request: "Export", status: 200, response: "ImportReport"},
+		{method: "POST", path: "/api/v1/import/{source}", summary: "Import a Todoist, Taskwarrior or todo.txt export sent as the body (dry_run=true to preview)", handler: handleAPIImportFrom, query: []string{"dry_run"}, status: 200, response: "SourceImportReport"},
+		{method: "GET", path: "/api/v1/trash", summary: "List deleted tasks", handler: handleAPIListTrash, status: 200, response: "[]Task"},
+		{method: "POST", path: "/api/v1/trash/{id}/restore", summary: "Restore a deleted task", handler: handleAPIRestoreTask, status: 200, response: "Task"},
+		{method: "DELETE", path: "/api/v1/trash/{id}", summary: "Permanently purge a deleted task (completions=keep|delete)", handler: handleAPIPurgeTask, query: []string{"completions"}, status: 204, response: responseEmpty},
+	}
+}
+
+// newRouter registers every route on a fresh ServeMux. Every handler sees
+// the request's session, and all but the public routes require one.
+func newRouter(appState *AppState) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range appRoutes() {
+		handler := route.handler(appState)
+		if !route.public {
+			handler = requireUser(appState, route.requiredScope(), handler)
+		}
+		mux.HandleFunc(route.muxPattern(), withSession(appState, handler))
+	}
+
+	// CalDAV uses WebDAV methods and its own sign in, so it stays out of
+	// appRoutes and the OpenAPI document.
+	mux.HandleFunc(davRoot, handleCalDAV(appState))
+	mux.Handle("/.well-known/caldav", http.RedirectHandler(davRoot, http.StatusMovedPermanently))
+
+	// Keep unknown /api paths from falling through to the home page.
+	mux.HandleFunc("/api/", handleAPINotFound())
+	return mux
+}
@@ -0,0 +1,288 @@
+// Completion rules: per-task caps, cooldowns and one-shot tasks that are
+// archived once completed.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompletionPeriod is the window that MaxCompletions counts over.
+type CompletionPeriod string
+
+const (
+	// PeriodDay counts completions since local midnight. It is also used
+	// when no period is set.
+	PeriodDay CompletionPeriod = "day"
+	// PeriodWeek counts completions since midnight on Monday.
+	PeriodWeek CompletionPeriod = "week"
+)
+
+func ParseCompletionPeriod(value string) (CompletionPeriod, error) {
+	switch period := CompletionPeriod(value); period {
+	case "", PeriodDay, PeriodWeek:
+		return period, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("invalid completion period %q: use day or week", value)}
+}
+
+// start returns the beginning of the period containing now.
+func (p CompletionPeriod) start(now time.Time) time.Time {
+	if p == PeriodWeek {
+		return weekStart(now)
+	}
+	return startOfDay(now)
+}
+
+// CompletionRuleError reports a completion refused by one of the task's
+// rules. RetryAt is set when waiting will make the completion possible.
+type CompletionRuleError struct {
+	Message string
+	RetryAt *time.Time
+}
+
+func (e *CompletionRuleError) Error() string {
+	return e.Message
+}
+
+// validateRules checks the rule fields of a task.
+func (t *Task) validateRules() error {
+	if t.MaxCompletions < 0 {
+		return &ValidationError{"max completions cannot be negative"}
+	}
+	if _, err := ParseCompletionPeriod(string(t.CompletionPeriod)); err != nil {
+		return err
+	}
+	if t.CooldownMinutes < 0 {
+		return &ValidationError{"cooldown cannot be negative"}
+	}
+	if t.OneShot && t.Recurrence != "" {
+		return &ValidationError{"a one-shot task cannot repeat"}
+	}
+	return nil
+}
+
+// checkCompletionRules refuses a completion of task at the moment at that
+// would break one of its rules: the cap counts the period containing at,
+// and the cooldown applies on both sides of it, so back-dated completions
+// are held to the same limits. It must run inside the transaction that
+// records the completion, after the task row has been locked for writing.
+func checkCompletionRules(ctx context.Context, transaction *sql.Tx, task *Task, at time.Time) error {
+	if task.ArchivedAt != nil {
+		return &CompletionRuleError{Message: fmt.Sprintf("%s is archived and cannot be completed", task.Name)}
+	}
+	if task.MaxCompletions == 0 && task.CooldownMinutes == 0 {
+		return nil
+	}
+
+	// Completion times are compared in Go, where the period can start at
+	// midnight in the server's time zone.
+	// Pending completions count too, so waiting for approval cannot be used
+	// to get around the limits.
+	rows, err := transaction.QueryContext(ctx, "SELECT completed_at FROM completions WHERE task_id = ? AND status != 'rejected'", task.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	period := task.CompletionPeriod
+	if period == "" {
+		period = PeriodDay
+	}
+	periodStart := period.start(at)
+	periodEnd := periodStart.AddDate(0, 0, 1)
+	if period == PeriodWeek {
+		periodEnd = periodStart.AddDate(0, 0, 7)
+	}
+	cooldown := time.Duration(task.CooldownMinutes) * time.Minute
+	var inPeriod int
+	var blocking time.Time
+	for rows.Next() {
+		var completedAt time.Time
+		if err := rows.Scan(&completedAt); err != nil {
+			return err
+		}
+		if !completedAt.Before(periodStart) && completedAt.Before(periodEnd) {
+			inPeriod++
+		}
+		if distance := completedAt.Sub(at).Abs(); distance < cooldown && (blocking.IsZero() || completedAt.After(blocking)) {
+			blocking = completedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if task.MaxCompletions > 0 && inPeriod >= task.MaxCompletions {
+		retryAt := periodEnd
+		return &CompletionRuleError{
+			Message: fmt.Sprintf("%s has already been completed %d time(s) this %s", task.Name, inPeriod, period),
+			RetryAt: &retryAt,
+		}
+	}
+
+	if !blocking.IsZero() {
+		retryAt := blocking.Add(cooldown)
+		return &CompletionRuleError{
+			Message: fmt.Sprintf("%s can be completed again in %s", task.Name, formatMinutes(retryAt.Sub(at))),
+			RetryAt: &retryAt,
+		}
+	}
+	return nil
+}
+
+// describeRules summarises a task's rules for the task list, e.g.
+// "max 2 per day, 4h cooldown".
+func describeRules(task *Task) string {
+	var rules []string
+	if task.MaxCompletions > 0 {
+		period := task.CompletionPeriod
+		if period == "" {
+			period = PeriodDay
+		}
+		rules = append(rules, fmt.Sprintf("max %d per %s", task.MaxCompletions, period))
+	}
+	if task.CooldownMinutes > 0 {
+		rules = append(rules, formatMinutes(time.Duration(task.CooldownMinutes)*time.Minute)+" cooldown")
+	}
+	if task.OneShot {
+		rules = append(rules, "one-shot")
+	}
+	if task.RequiresApproval {
+		rules = append(rules, "needs approval")
+	}
+	return strings.Join(rules, ", ")
+}
+
+// formatMinutes renders a duration rounded up to whole minutes, e.g. "1h30m".
+func formatMinutes(duration time.Duration) string {
+	text := strings.TrimSuffix((duration + time.Minute - 1).Truncate(time.Minute).String(), "0s")
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// readRulesForm copies the rule fields of the add and edit forms onto task.
+func readRulesForm(request *http.Request, task *Task) error {
+	var err error
+	if task.MaxCompletions, err = parseCountField("max completions", request.FormValue("max_completions")); err != nil {
+		return err
+	}
+	if task.CompletionPeriod, err = ParseCompletionPeriod(request.FormValue("completion_period")); err != nil {
+		return err
+	}
+	if task.CooldownMinutes, err = parseCountField("cooldown", request.FormValue("cooldown_minutes")); err != nil {
+		return err
+	}
+	task.OneShot = request.FormValue("one_shot") != ""
+	task.RequiresApproval = request.FormValue("requires_approval") != ""
+	return nil
+}
+
+// parseCountField reads an optional whole number form value.
+func parseCountField(label, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, &ValidationError{fmt.Sprintf("invalid %s: %s", label, value)}
+	}
+	return count, nil
+}
+
+// UnarchiveTask returns an archived task userID owns, directly or through
+// its group, to the task list so it can be completed again.
+func UnarchiveTask(ctx context.Context, db *Database, userID, taskID int) error {
+	if err := authorizeTask(ctx, db.Conn, userID, taskID, RoleOwner, "unarchive tasks"); err != nil {
+		return err
+	}
+	result, err := db.Conn.ExecContext(ctx,
+		"UPDATE tasks SET archived_at = NULL WHERE id = ? AND deleted = 0 AND archived_at IS NOT NULL", taskID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w in archive: %d", ErrTaskNotFound, taskID)
+	}
+	return nil
+}
+
+var archiveTemplate = template.Must(template.New("archive").Parse(`
+        <div id="archive">
+            {{range .}}
+            <div class="task">
+                {{.Name}} ({{.Points}} pts) - archived {{if .ArchivedAt}}{{.ArchivedAt.Format "2006-01-02 15:04"}}{{end}}
+                <button hx-post="/archive/{{.ID}}/restore"
+                        hx-swap="none">Unarchive</button>
+            </div>
+            {{else}}
+            <p>No archived tasks.</p>
+            {{end}}
+        </div>`))
+
+func handleArchive(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tasks, err := GetTasks(appState.db, currentUserID(request), TaskFilter{Archived: true})
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		archiveTemplate.Execute(writer, tasks)
+	}
+}
+
+func handleUnarchiveTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := UnarchiveTask(request.Context(), appState.db, currentUserID(request), taskID); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+func handleAPIUnarchiveTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		userID := currentUserID(request)
+		if err := UnarchiveTask(request.Context(), appState.db, userID, taskID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		task, err := GetTask(appState.db, userID, taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, task)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompletionRules(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	threeDaysAgo := startOfDay(now).AddDate(0, 0, -3).Add(9 * time.Hour)
+
+	// A step completes the task now with CompleteTask, or at the given
+	// moment with CreateCompletion.
+	type step struct {
+		at      time.Time
+		wantErr bool
+	}
+	tests := []struct {
+		name  string
+		task  Task
+		steps []step
+	}{
+		{"no rules", Task{}, []step{{}, {}, {}}},
+		{"daily cap", Task{MaxCompletions: 1}, []step{{}, {wantErr: true}}},
+		{"weekly cap", Task{MaxCompletions: 2, CompletionPeriod: PeriodWeek}, []step{{}, {}, {wantErr: true}}},
+		{"cooldown", Task{CooldownMinutes: 60}, []step{{}, {wantErr: true}}},
+		{"back-dated into the cooldown", Task{CooldownMinutes: 60}, []step{{}, {at: now.Add(-30 * time.Minute), wantErr: true}}},
+		{"back-dated before the cooldown", Task{CooldownMinutes: 60}, []step{{}, {at: now.Add(-3 * time.Hour)}}},
+		{"cooldown after a back-dated completion", Task{CooldownMinutes: 60}, []step{{at: threeDaysAgo}, {at: threeDaysAgo.Add(-time.Minute), wantErr: true}}},
+		{"cap on a back-dated day", Task{MaxCompletions: 1}, []step{{at: threeDaysAgo}, {at: threeDaysAgo.Add(time.Hour), wantErr: true}, {}}},
+		{"one-shot is archived", Task{OneShot: true}, []step{{}, {wantErr: true}}},
+		{"archived refuses manual completions", Task{OneShot: true}, []step{{}, {at: threeDaysAgo, wantErr: true}}},
+		{"future completion", Task{}, []step{{at: now.Add(time.Hour), wantErr: true}}},
+	}
+
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := newTestTask(t, db, user.ID, &test.task)
+			for index, step := range test.steps {
+				var err error
+				if step.at.IsZero() {
+					_, err = CompleteTask(ctx, db, user.ID, task.ID)
+				} else {
+					_, err = CreateCompletion(ctx, db, user.ID, task.ID, step.at)
+				}
+				if (err != nil) != step.wantErr {
+					t.Fatalf("step %d: error = %v, want error %v", index, err, step.wantErr)
+				}
+				var ruleError *CompletionRuleError
+				var validationError *ValidationError
+				if err != nil && !errors.As(err, &ruleError) && !errors.As(err, &validationError) {
+					t.Fatalf("step %d: got %T %v, want a rule or validation error", index, err, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompletionRuleRetryAt(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	task := newTestTask(t, db, user.ID, &Task{CooldownMinutes: 90})
+
+	first, err := CompleteTask(ctx, db, user.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CompleteTask(ctx, db, user.ID, task.ID)
+	var ruleError *CompletionRuleError
+	if !errors.As(err, &ruleError) || ruleError.RetryAt == nil {
+		t.Fatalf("got %v, want a CompletionRuleError with RetryAt", err)
+	}
+	if want := first.CompletedAt.Add(90 * time.Minute); !ruleError.RetryAt.Equal(want) {
+		t.Errorf("RetryAt = %s, want %s", ruleError.RetryAt, want)
+	}
+}
+
+func TestBackdatedCompletionsEarnNothing(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	task := newTestTask(t, db, user.ID, &Task{Points: 10})
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantPoints int
+	}{
+		{"a week ago", time.Now().AddDate(0, 0, -7), 0},
+		{"an hour ago", time.Now().Add(-time.Hour), 0},
+		{"a minute ago", time.Now().Add(-time.Minute), 10},
+	}
+	balance := 0
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			completion, err := CreateCompletion(ctx, db, user.ID, task.ID, test.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if completion.Points != test.wantPoints {
+				t.Errorf("completion earned %d points, want %d", completion.Points, test.wantPoints)
+			}
+			balance += test.wantPoints
+			ledger, err := GetLedger(db, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ledger.Balance != balance {
+				t.Errorf("balance = %d, want %d", ledger.Balance, balance)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// Cookie sessions for signed in users and CSRF protection for the
+// requests they make.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "tasks_session"
+	// csrfHeaderName carries the CSRF token on htmx and API requests.
+	// Plain HTML forms send it in the csrf_token field instead.
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// Session is a signed in browser. The cookie holds a random token; only its
+// SHA-256 hash is stored, so a leaked database cannot be used to sign in.
+type Session struct {
+	ID        string
+	User      *User
+	CSRFToken string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// randomToken returns size random bytes, base64url encoded.
+func randomToken(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession signs user in for lifetime and returns the cookie token.
+// The user's expired sessions are cleared out at the same time.
+func CreateSession(ctx context.Context, db *Database, user *User, lifetime time.Duration) (string, *Session, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        hashToken(token),
+		User:      user,
+		CSRFToken: csrfToken,
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer transaction.Rollback()
+
+	if err := deleteExpiredSessions(ctx, transaction, user.ID, now); err != nil {
+		return "", nil, err
+	}
+	if _, err := transaction.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, csrf_token, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		session.ID, user.ID, session.CSRFToken, session.CreatedAt, session.ExpiresAt); err != nil {
+		return "", nil, err
+	}
+	return token, session, transaction.Commit()
+}
+
+// deleteExpiredSessions compares expiry times in Go because the stored
+// text does not sort reliably.
+func deleteExpiredSessions(ctx context.Context, transaction *sql.Tx, userID int, now time.Time) error {
+	rows, err := transaction.QueryContext(ctx, "SELECT id, expires_at FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &expiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if !now.Before(expiresAt) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range expired {
+		if _, err := transaction.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSession looks up the session for a cookie token. Unknown and expired
+// tokens return sql.ErrNoRows.
+func GetSession(ctx context.Context, db *Database, token string) (*Session, error) {
+	session := &Session{User: &User{}}
+	err := db.Conn.QueryRowContext(ctx, `
+		SELECT session.id, session.csrf_token, session.created_at, session.expires_at,
+			users.id, users.username, users.created_at
+		FROM sessions session
+		JOIN users ON users.id = session.user_id
+		WHERE session.id = ?`, hashToken(token)).Scan(
+		&session.ID, &session.CSRFToken, &session.CreatedAt, &session.ExpiresAt,
+		&session.User.ID, &session.User.Username, &session.User.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		DeleteSession(ctx, db, token)
+		return nil, sql.ErrNoRows
+	}
+	return session, nil
+}
+
+// ExtendSession pushes the expiry of a session to lifetime from now.
+func ExtendSession(ctx context.Context, db *Database, session *Session, lifetime time.Duration) error {
+	expiresAt := time.Now().Add(lifetime)
+	if _, err := db.Conn.ExecContext(ctx, "UPDATE sessions SET expires_at = ? WHERE id = ?", expiresAt, session.ID); err != nil {
+		return err
+	}
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// DeleteSession signs out the session for a cookie token.
+func DeleteSession(ctx context.Context, db *Database, token string) error {
+	_, err := db.Conn.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", hashToken(token))
+	return err
+}
+
+type sessionContextKey struct{}
+
+// sessionFromContext returns the session of a signed in browser, or nil.
+func sessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return session
+}
+
+// setSessionCookie stores token in an HttpOnly, SameSite=Lax cookie that
+// expires with the session.
+func setSessionCookie(appState *AppState, writer http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   appState.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(appState *AppState, writer http.ResponseWriter) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   appState.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// withSession loads the session named by the request's cookie, if any, and
+// makes it and its user available to the handler. Sessions past half their
+// lifetime are extended so active users stay signed in.
+func withSession(appState *AppState, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		cookie, err := request.Cookie(sessionCookieName)
+		if err != nil || cookie.Value == "" {
+			next(writer, request)
+			return
+		}
+
+		session, err := GetSession(request.Context(), appState.db, cookie.Value)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to load session: %v", err)
+			}
+			clearSessionCookie(appState, writer)
+			next(writer, request)
+			return
+		}
+
+		lifetime := appState.config.SessionLifetime
+		if time.Until(session.ExpiresAt) < lifetime/2 {
+			if err := ExtendSession(request.Context(), appState.db, session, lifetime); err != nil {
+				log.Printf("Failed to extend session: %v", err)
+			} else {
+				setSessionCookie(appState, writer, cookie.Value, session.ExpiresAt)
+			}
+		}
+
+		ctx := context.WithValue(request.Context(), sessionContextKey{}, session)
+		next(writer, request.WithContext(contextWithUser(ctx, session.User)))
+	}
+}
+
+// requireUser refuses requests without a signed in user, and unsafe
+// requests whose CSRF token does not match the session's. API requests may
+// instead present an API token whose scope allows required.
+func requireUser(appState *AppState, required TokenScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		isAPI := strings.HasPrefix(request.URL.Path, "/api/")
+		if secret, ok := bearerToken(request); ok && isAPI {
+			token, user, err := AuthenticateAPIToken(request.Context(), appState.db, secret)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeAPIError(writer, http.StatusUnauthorized, "unauthorized", "invalid or revoked API token")
+				return
+			}
+			if err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+			if !token.Scope.allows(required) {
+				message := fmt.Sprintf("a %s token cannot %s %s", token.Scope, request.Method, request.URL.Path)
+				if required == scopeNone {
+					message = "API tokens cannot be used here; sign in instead"
+				}
+				writeAPIError(writer, http.StatusForbidden, "insufficient_scope", message)
+				return
+			}
+			// Tokens are not sent automatically by browsers, so they need
+			// no CSRF check.
+			next(writer, request.WithContext(contextWithUser(request.Context(), user)))
+			return
+		}
+
+		session := sessionFromContext(request.Context())
+		if session == nil {
+			message := "sign in required"
+			if isAPI {
+				writeAPIError(writer, http.StatusUnauthorized, "unauthorized", message)
+				return
+			}
+			// Send htmx back to the home page, which shows the login form.
+			writer.Header().Set("HX-Redirect", "/")
+			http.Error(writer, message, http.StatusUnauthorized)
+			return
+		}
+
+		if !isSafeMethod(request.Method) && !validCSRFToken(request, session) {
+			message := "missing or invalid CSRF token"
+			if isAPI {
+				writeAPIError(writer, http.StatusForbidden, "csrf_failed", message)
+				return
+			}
+			http.Error(writer, message, http.StatusForbidden)
+			return
+		}
+
+		next(writer, request)
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCSRFToken(request *http.Request, session *Session) bool {
+	token := request.Header.Get(csrfHeaderName)
+	if token == "" {
+		token = request.FormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// signIn starts a session for user and sets its cookie.
+func signIn(appState *AppState, writer http.ResponseWriter, request *http.Request, user *User) error {
+	token, session, err := CreateSession(request.Context(), appState.db, user, appState.config.SessionLifetime)
+	if err != nil {
+		return err
+	}
+	setSessionCookie(appState, writer, token, session.ExpiresAt)
+	return nil
+}
+
+// loginTemplate is what handleHome shows to signed out visitors.
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>Sign in - Task Tracker</title>
+</head>
+<body>
+	<h1>Sign in</h1>
+	<form method="post" action="/login">
+		<label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" required></label>
+		<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+		<button type="submit">Sign in</button>
+	</form>
+	{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+	<p>New here? <a href="/register">Create an account</a>.</p>
+</body>
+</html>`))
+
+func renderLogin(writer http.ResponseWriter, status int, username, message string) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+	loginTemplate.Execute(writer, map[string]string{"Username": username, "Error": message})
+}
+
+func handleLogin(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		username := request.FormValue("username")
+		user, err := AuthenticateUser(request.Context(), appState.db, username, request.FormValue("password"))
+		if errors.Is(err, ErrInvalidCredentials) {
+			renderLogin(writer, http.StatusUnauthorized, username, err.Error())
+			return
+		}
+		if err == nil {
+			err = signIn(appState, writer, request, user)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+	}
+}
+
+func handleLogout(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if cookie, err := request.Cookie(sessionCookieName); err == nil {
+			if err := DeleteSession(request.Context(), appState.db, cookie.Value); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		clearSessionCookie(appState, writer)
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+
+	token, session, err := CreateSession(ctx, db, user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == token || session.ID != hashToken(token) {
+		t.Error("the session is stored under the cookie token rather than its hash")
+	}
+	loaded, err := GetSession(ctx, db, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.User.ID != user.ID || loaded.CSRFToken != session.CSRFToken {
+		t.Errorf("GetSession = %+v, want the session just created", loaded)
+	}
+	if _, err := GetSession(ctx, db, session.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("the stored hash signed in: %v", err)
+	}
+
+	expiredToken, _, err := CreateSession(ctx, db, user, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSession(ctx, db, expiredToken); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired session: got %v, want sql.ErrNoRows", err)
+	}
+
+	if err := DeleteSession(ctx, db, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSession(ctx, db, token); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted session: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSessionRequests(t *testing.T) {
+	appState := newTestAppState(t)
+	newTestUser(t, appState.db, "alice")
+
+	send := func(method, path, body, cookie string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if strings.HasPrefix(body, "{") {
+			request.Header.Set("Content-Type", "application/json")
+		} else if body != "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if cookie != "" {
+			request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		}
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		newRouter(appState).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if recorder := send("POST", "/login", "username=alice&password=wrong+password", "", nil); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d, want 401", recorder.Code)
+	}
+	login := send("POST", "/login", "username=alice&password=correct+horse+battery", "", nil)
+	var cookie *http.Cookie
+	for _, candidate := range login.Result().Cookies() {
+		if candidate.Name == sessionCookieName {
+			cookie = candidate
+		}
+	}
+	if login.Code != http.StatusSeeOther || cookie == nil {
+		t.Fatalf("login: got %d without a session cookie", login.Code)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie %+v is not HttpOnly, Secure and SameSite=Lax", cookie)
+	}
+	session, err := GetSession(context.Background(), appState.db, cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf := url.Values{"name": {"Dishes"}, "csrf_token": {session.CSRFToken}}.Encode()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		cookie     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"signed out API", "GET", "/api/v1/tasks", "", "", nil, http.StatusUnauthorized},
+		{"signed out page", "POST", "/task/add", "name=Dishes", "", nil, http.StatusUnauthorized},
+		{"unknown cookie", "GET", "/api/v1/tasks", "", "forged", nil, http.StatusUnauthorized},
+		{"reading needs no CSRF token", "GET", "/api/v1/tasks", "", cookie.Value, nil, http.StatusOK},
+		{"API write without CSRF token", "POST", "/api/v1/tasks", `{"name": "Dishes"}`, cookie.Value, nil, http.StatusForbidden},
+		{"API write with a wrong CSRF token", "POST", "/api/v1/tasks", `{"name": "Dishes"}`, cookie.Value,
+			map[string]string{csrfHeaderName: "guess"}, http.StatusForbidden},
+		{"API write with the CSRF header", "POST", "/api/v1/tasks", `{"name": "Dishes"}`, cookie.Value,
+			map[string]string{csrfHeaderName: session.CSRFToken}, http.StatusCreated},
+		{"form without CSRF field", "POST", "/task/add", "name=Dishes", cookie.Value, nil, http.StatusForbidden},
+		{"form with the CSRF field", "POST", "/task/add", csrf, cookie.Value, nil, http.StatusOK},
+		{"sign out", "POST", "/logout", "csrf_token=" + url.QueryEscape(session.CSRFToken), cookie.Value, nil, http.StatusSeeOther},
+		{"signed out again", "GET", "/api/v1/tasks", "", cookie.Value, nil, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if recorder := send(test.method, test.path, test.body, test.cookie, test.headers); recorder.Code != test.wantStatus {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.wantStatus)
+		}
+	}
+}
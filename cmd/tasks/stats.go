@@ -0,0 +1,458 @@
+// Statistics over the completion history: totals per day, week or month,
+// per task and per tag, with averages and a comparison against the period
+// before.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// StatsPeriod is the size of the buckets statistics are grouped into.
+type StatsPeriod string
+
+const (
+	StatsDay   StatsPeriod = "day"
+	StatsWeek  StatsPeriod = "week"
+	StatsMonth StatsPeriod = "month"
+)
+
+// maxStatsBuckets keeps a request from asking for decades of days.
+const maxStatsBuckets = 1000
+
+// ParseStatsPeriod reads the period query parameter, defaulting to days.
+func ParseStatsPeriod(value string) (StatsPeriod, error) {
+	switch period := StatsPeriod(value); period {
+	case "":
+		return StatsDay, nil
+	case StatsDay, StatsWeek, StatsMonth:
+		return period, nil
+	}
+	return StatsDay, &ValidationError{fmt.Sprintf("invalid period %q: use day, week or month", value)}
+}
+
+// start returns the beginning of the period containing moment. Weeks start
+// on Monday, as they do for completion limits.
+func (period StatsPeriod) start(moment time.Time) time.Time {
+	switch period {
+	case StatsWeek:
+		return weekStart(moment)
+	case StatsMonth:
+		day := startOfDay(moment)
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return startOfDay(moment)
+}
+
+// add moves start, the beginning of a period, by count periods.
+func (period StatsPeriod) add(start time.Time, count int) time.Time {
+	switch period {
+	case StatsWeek:
+		return start.AddDate(0, 0, 7*count)
+	case StatsMonth:
+		return start.AddDate(0, count, 0)
+	}
+	return start.AddDate(0, 0, count)
+}
+
+// defaultBuckets is how far back statistics go when no range is given.
+func (period StatsPeriod) defaultBuckets() int {
+	switch period {
+	case StatsWeek, StatsMonth:
+		return 12
+	}
+	return 30
+}
+
+// StatsRange is a span of whole periods, from From up to but not including
+// To, in the server's time zone.
+type StatsRange struct {
+	Period StatsPeriod
+	From   time.Time
+	To     time.Time
+}
+
+// bucketStart is the start of the period index periods after From. It
+// counts from From each time, as a day added to a day's start is not
+// always the start of the next day where daylight saving begins at
+// midnight.
+func (statsRange StatsRange) bucketStart(index int) time.Time {
+	return statsRange.Period.add(statsRange.From, index)
+}
+
+// buckets counts the periods in the range.
+func (statsRange StatsRange) buckets() int {
+	count := 0
+	for statsRange.bucketStart(count).Before(statsRange.To) {
+		count++
+	}
+	return count
+}
+
+// previous is the range of the same number of periods just before this one.
+func (statsRange StatsRange) previous() StatsRange {
+	return StatsRange{Period: statsRange.Period, From: statsRange.Period.add(statsRange.From, -statsRange.buckets()), To: statsRange.From}
+}
+
+// parseStatsRange reads the period, from and to query parameters. from and
+// to are dates (YYYY-MM-DD), both included and widened to whole periods;
+// the default range ends with the current period.
+func parseStatsRange(query url.Values, now time.Time) (StatsRange, error) {
+	period, err := ParseStatsPeriod(query.Get("period"))
+	if err != nil {
+		return StatsRange{}, err
+	}
+	statsRange := StatsRange{Period: period, To: period.add(period.start(now), 1)}
+	if value := query.Get("to"); value != "" {
+		to, err := time.ParseInLocation(time.DateOnly, value, now.Location())
+		if err != nil {
+			return StatsRange{}, &ValidationError{"invalid to date, use YYYY-MM-DD: " + value}
+		}
+		statsRange.To = period.add(period.start(to), 1)
+	}
+	statsRange.From = period.add(statsRange.To, -period.defaultBuckets())
+	if value := query.Get("from"); value != "" {
+		from, err := time.ParseInLocation(time.DateOnly, value, now.Location())
+		if err != nil {
+			return StatsRange{}, &ValidationError{"invalid from date, use YYYY-MM-DD: " + value}
+		}
+		statsRange.From = period.start(from)
+	}
+	if !statsRange.From.Before(statsRange.To) {
+		return StatsRange{}, &ValidationError{"from must not be after to"}
+	}
+	if period.add(statsRange.From, maxStatsBuckets).Before(statsRange.To) {
+		return StatsRange{}, &ValidationError{fmt.Sprintf("a range can span at most %d %ss", maxStatsBuckets, period)}
+	}
+	return statsRange, nil
+}
+
+// historyEntry is one approved completion as the statistics see it.
+type historyEntry struct {
+	TaskID      int
+	TaskName    string
+	CompletedAt time.Time
+	Points      int
+}
+
+// loadHistory returns userID's own approved completions from from up to
+// but not including to, oldest first, with completion times in the
+// server's time zone.
+func loadHistory(ctx context.Context, db *Database, userID int, from, to time.Time) ([]historyEntry, error) {
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT completion.task_id, `+completionTaskNameSQL+`, completion.completed_at, completion.points
+		FROM completions completion
+		LEFT JOIN tasks task ON completion.task_id = task.id
+		WHERE completion.user_id = ? AND `+approvedCompletionsSQL+`
+			AND julianday(completion.completed_at) >= julianday(?) AND julianday(completion.completed_at) < julianday(?)
+		ORDER BY julianday(completion.completed_at), completion.id`, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []historyEntry{}
+	for rows.Next() {
+		var entry historyEntry
+		if err := rows.Scan(&entry.TaskID, &entry.TaskName, &entry.CompletedAt, &entry.Points); err != nil {
+			return nil, err
+		}
+		entry.CompletedAt = entry.CompletedAt.In(time.Local)
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// taskTagNames maps each of userID's completed tasks to its current tags.
+func taskTagNames(ctx context.Context, db *Database, userID int) (map[int][]string, error) {
+	rows, err := db.Conn.QueryContext(ctx, `
+		SELECT task_tags.task_id, tags.name
+		FROM task_tags
+		JOIN tags ON tags.id = task_tags.tag_id
+		WHERE task_tags.task_id IN (SELECT task_id FROM completions WHERE user_id = ?)
+		ORDER BY tags.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[int][]string{}
+	for rows.Next() {
+		var taskID int
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return nil, err
+		}
+		tags[taskID] = append(tags[taskID], name)
+	}
+	return tags, rows.Err()
+}
+
+// Totals counts completions and the points they earned.
+type Totals struct {
+	Completions int `json:"completions"`
+	Points      int `json:"points"`
+}
+
+func (totals *Totals) add(entry historyEntry) {
+	totals.Completions++
+	totals.Points += entry.Points
+}
+
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Totals Totals    `json:"totals"`
+}
+
+type TaskStats struct {
+	TaskID   int    `json:"task_id"`
+	TaskName string `json:"task_name"`
+	Totals   Totals `json:"totals"`
+}
+
+type TagStats struct {
+	Tag    string `json:"tag"`
+	Totals Totals `json:"totals"`
+}
+
+type StatsAverages struct {
+	CompletionsPerPeriod float64 `json:"completions_per_period"`
+	PointsPerPeriod      float64 `json:"points_per_period"`
+	PointsPerCompletion  float64 `json:"points_per_completion"`
+}
+
+// StatsChange compares a range with the one before it. The percentages are
+// null when the previous range had nothing to compare against.
+type StatsChange struct {
+	Previous           Totals   `json:"previous"`
+	CompletionsPercent *float64 `json:"completions_percent"`
+	PointsPercent      *float64 `json:"points_percent"`
+}
+
+// Stats summarises a user's approved completions over a range.
+type Stats struct {
+	Period   StatsPeriod    `json:"period"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Totals   Totals         `json:"totals"`
+	Averages StatsAverages  `json:"averages"`
+	Change   StatsChange    `json:"change"`
+	Buckets  []*StatsBucket `json:"buckets"`
+	// Tasks are ordered by points, so the first few are the top tasks.
+	Tasks []*TaskStats `json:"tasks"`
+	Tags  []*TagStats  `json:"tags"`
+}
+
+// percentChange rounds to one decimal place.
+func percentChange(previous, current int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &change
+}
+
+func ratio(total, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return math.Round(float64(total)/float64(count)*100) / 100
+}
+
+// GetStats summarises userID's own approved completions over statsRange.
+// Tags are the tasks' current tags, so a completion counts toward each tag
+// its task has.
+func GetStats(ctx context.Context, db *Database, userID int, statsRange StatsRange) (*Stats, error) {
+	previous := statsRange.previous()
+	history, err := loadHistory(ctx, db, userID, previous.From, statsRange.To)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := taskTagNames(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Period: statsRange.Period, From: statsRange.From, To: statsRange.To, Buckets: []*StatsBucket{}, Tasks: []*TaskStats{}, Tags: []*TagStats{}}
+	for index, count := 0, statsRange.buckets(); index < count; index++ {
+		stats.Buckets = append(stats.Buckets, &StatsBucket{Start: statsRange.bucketStart(index)})
+	}
+	// Completions of purged tasks share task ID 0, so tasks are told
+	// apart by name as well.
+	type taskKey struct {
+		id   int
+		name string
+	}
+	tasks := map[taskKey]*TaskStats{}
+	tagStats := map[string]*TagStats{}
+	for _, entry := range history {
+		if entry.CompletedAt.Before(statsRange.From) {
+			stats.Change.Previous.add(entry)
+			continue
+		}
+		stats.Totals.add(entry)
+		// The last bucket starting at or before the completion holds it;
+		// its start is not looked up, as that need not match the start
+		// of the completion's period where midnight does not exist.
+		if index := sort.Search(len(stats.Buckets), func(i int) bool {
+			return stats.Buckets[i].Start.After(entry.CompletedAt)
+		}); index > 0 {
+			stats.Buckets[index-1].Totals.add(entry)
+		}
+		key := taskKey{entry.TaskID, entry.TaskName}
+		if tasks[key] == nil {
+			tasks[key] = &TaskStats{TaskID: entry.TaskID, TaskName: entry.TaskName}
+			stats.Tasks = append(stats.Tasks, tasks[key])
+		}
+		tasks[key].Totals.add(entry)
+		for _, tag := range tags[entry.TaskID] {
+			if tagStats[tag] == nil {
+				tagStats[tag] = &TagStats{Tag: tag}
+				stats.Tags = append(stats.Tags, tagStats[tag])
+			}
+			tagStats[tag].Totals.add(entry)
+		}
+	}
+
+	sort.SliceStable(stats.Tasks, func(i, j int) bool {
+		left, right := stats.Tasks[i].Totals, stats.Tasks[j].Totals
+		if left.Points != right.Points {
+			return left.Points > right.Points
+		}
+		return left.Completions > right.Completions
+	})
+	sort.SliceStable(stats.Tags, func(i, j int) bool { return stats.Tags[i].Totals.Points > stats.Tags[j].Totals.Points })
+
+	stats.Averages = StatsAverages{
+		CompletionsPerPeriod: ratio(stats.Totals.Completions, len(stats.Buckets)),
+		PointsPerPeriod:      ratio(stats.Totals.Points, len(stats.Buckets)),
+		PointsPerCompletion:  ratio(stats.Totals.Points, stats.Totals.Completions),
+	}
+	stats.Change.CompletionsPercent = percentChange(stats.Change.Previous.Completions, stats.Totals.Completions)
+	stats.Change.PointsPercent = percentChange(stats.Change.Previous.Points, stats.Totals.Points)
+	return stats, nil
+}
+
+// bucketLabel names a bucket in the Stats panel and on charts.
+func bucketLabel(period StatsPeriod, start time.Time) string {
+	switch period {
+	case StatsWeek:
+		return "week of " + start.Format("Jan 2")
+	case StatsMonth:
+		return start.Format("Jan 2006")
+	}
+	return start.Format("Mon Jan 2")
+}
+
+// statsTopTasks is how many tasks the Stats panel lists.
+const statsTopTasks = 5
+
+var statsTemplate = template.Must(template.New("stats").Funcs(templateFuncs).Funcs(template.FuncMap{
+	"bucketLabel": bucketLabel,
+	"percent": func(change *float64) string {
+		if change == nil {
+			return "n/a"
+		}
+		return fmt.Sprintf("%+.1f%%", *change)
+	},
+	"top": func(tasks []*TaskStats) []*TaskStats {
+		return tasks[:min(len(tasks), statsTopTasks)]
+	},
+}).Parse(`
+        <div id="stats">
+            <form hx-get="/stats" hx-target="#stats" hx-swap="outerHTML" hx-trigger="change">
+                <select name="period">
+                    <option value="day"{{if eq .Period "day"}} selected{{end}}>Last 30 days</option>
+                    <option value="week"{{if eq .Period "week"}} selected{{end}}>Last 12 weeks</option>
+                    <option value="month"{{if eq .Period "month"}} selected{{end}}>Last 12 months</option>
+                </select>
+            </form>
+            <p>
+                <strong>{{.Totals.Completions}}</strong> completions, <strong>{{.Totals.Points}}</strong> points
+            </p>
+            <p>
+                Against the {{len .Buckets}} {{.Period}}s before ({{.Change.Previous.Completions}} completions, {{.Change.Previous.Points}} points):
+                completions {{percent .Change.CompletionsPercent}}, points {{percent .Change.PointsPercent}}
+            </p>
+            <p>
+                Averages: {{printf "%.2f" .Averages.CompletionsPerPeriod}} completions and {{printf "%.2f" .Averages.PointsPerPeriod}} points per {{.Period}},
+                {{printf "%.2f" .Averages.PointsPerCompletion}} points per completion
+            </p>
+            {{if .Tasks}}
+            <h3>Top tasks</h3>
+            <table>
+                <tr><th>Task</th><th>Completions</th><th>Points</th></tr>
+                {{range top .Tasks}}<tr><td>{{.TaskName}}</td><td>{{.Totals.Completions}}</td><td>{{.Totals.Points}}</td></tr>{{end}}
+            </table>
+            {{end}}
+            {{if .Tags}}
+            <h3>By tag</h3>
+            <table>
+                <tr><th>Tag</th><th>Completions</th><th>Points</th></tr>
+                {{range .Tags}}<tr><td>{{.Tag}}</td><td>{{.Totals.Completions}}</td><td>{{.Totals.Points}}</td></tr>{{end}}
+            </table>
+            {{end}}
+            <details>
+                <summary>Per {{.Period}}</summary>
+                <table>
+                    <tr><th>{{.Period}}</th><th>Completions</th><th>Points</th></tr>
+                    {{range .Buckets}}<tr><td>{{bucketLabel $.Period .Start}}</td><td>{{.Totals.Completions}}</td><td>{{.Totals.Points}}</td></tr>{{end}}
+                </table>
+            </details>
+        </div>`))
+
+func handleStats(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		statsRange, err := parseStatsRange(request.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+		stats, err := GetStats(request.Context(), appState.db, currentUserID(request), statsRange)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statsTemplate.Execute(writer, stats)
+	}
+}
+
+// handleAPIStats serves the statistics as JSON. top limits the task list,
+// which is ordered by points.
+func handleAPIStats(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		statsRange, err := parseStatsRange(query, time.Now())
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		top := 0
+		if value := query.Get("top"); value != "" {
+			if top, err = strconv.Atoi(value); err != nil || top < 1 {
+				writeAPIErrorFrom(writer, &ValidationError{"invalid top: " + value})
+				return
+			}
+		}
+
+		stats, err := GetStats(request.Context(), appState.db, currentUserID(request), statsRange)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if top > 0 && top < len(stats.Tasks) {
+			stats.Tasks = stats.Tasks[:top]
+		}
+		writeJSON(writer, http.StatusOK, stats)
+	}
+}
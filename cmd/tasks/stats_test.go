@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseStatsRange(t *testing.T) {
+	now := localTime(2024, 3, 6, 15) // a Wednesday
+	tests := []struct {
+		query    string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  bool
+	}{
+		{"", localTime(2024, 2, 6, 0), localTime(2024, 3, 7, 0), false},
+		{"period=week", localTime(2023, 12, 18, 0), localTime(2024, 3, 11, 0), false},
+		{"period=month&from=2024-01-15", localTime(2024, 1, 1, 0), localTime(2024, 4, 1, 0), false},
+		{"period=week&from=2024-02-01&to=2024-02-14", localTime(2024, 1, 29, 0), localTime(2024, 2, 19, 0), false},
+		{"from=2024-03-01&to=2024-03-01", localTime(2024, 3, 1, 0), localTime(2024, 3, 2, 0), false},
+		{"from=2024-03-02&to=2024-03-01", time.Time{}, time.Time{}, true},
+		{"from=2020-01-01", time.Time{}, time.Time{}, true},
+		{"period=year", time.Time{}, time.Time{}, true},
+		{"to=yesterday", time.Time{}, time.Time{}, true},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseStatsRange(query, now)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseStatsRange(%q) error = %v, want error %v", test.query, err, test.wantErr)
+			continue
+		}
+		if err == nil && (!got.From.Equal(test.wantFrom) || !got.To.Equal(test.wantTo)) {
+			t.Errorf("parseStatsRange(%q) = %s to %s, want %s to %s", test.query, got.From, got.To, test.wantFrom, test.wantTo)
+		}
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	if got := percentChange(0, 5); got != nil {
+		t.Errorf("percentChange(0, 5) = %v, want nil", *got)
+	}
+	tests := []struct {
+		previous, current int
+		want              float64
+	}{
+		{4, 5, 25},
+		{3, 1, -66.7},
+		{5, 5, 0},
+	}
+	for _, test := range tests {
+		if got := percentChange(test.previous, test.current); got == nil || *got != test.want {
+			t.Errorf("percentChange(%d, %d) = %v, want %v", test.previous, test.current, got, test.want)
+		}
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+	dishes := newTestTask(t, db, alice.ID, &Task{Name: "Dishes", Points: 5, Tags: []string{"home"}})
+	post := newTestTask(t, db, alice.ID, &Task{Name: "Post", Points: 2})
+	today := startOfDay(time.Now()).Add(12 * time.Hour)
+	completeAt := func(user *User, task *Task, at time.Time) {
+		t.Helper()
+		completion, err := CompleteTask(ctx, db, user.ID, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Conn.ExecContext(ctx, "UPDATE completions SET completed_at = ? WHERE id = ?", at, completion.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	completeAt(alice, dishes, today)
+	completeAt(alice, dishes, today.Add(time.Hour))
+	completeAt(alice, post, today)
+	completeAt(alice, dishes, today.AddDate(0, 0, -3))
+	completeAt(alice, dishes, today.AddDate(0, 0, -10))
+	completeAt(bob, newTestTask(t, db, bob.ID, &Task{Points: 50}), today)
+
+	statsRange, err := parseStatsRange(url.Values{"from": {today.AddDate(0, 0, -6).Format(time.DateOnly)}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := GetStats(ctx, db, alice.ID, statsRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Totals != (Totals{Completions: 4, Points: 17}) {
+		t.Errorf("totals = %+v, want 4 completions for 17 points", stats.Totals)
+	}
+	if stats.Change.Previous != (Totals{Completions: 1, Points: 5}) {
+		t.Errorf("previous = %+v, want 1 completion for 5 points", stats.Change.Previous)
+	}
+	if stats.Change.PointsPercent == nil || *stats.Change.PointsPercent != 240 {
+		t.Errorf("points change = %v, want 240%%", stats.Change.PointsPercent)
+	}
+	if want := (StatsAverages{CompletionsPerPeriod: 0.57, PointsPerPeriod: 2.43, PointsPerCompletion: 4.25}); stats.Averages != want {
+		t.Errorf("averages = %+v, want %+v", stats.Averages, want)
+	}
+	if len(stats.Buckets) != 7 || stats.Buckets[6].Totals.Points != 12 || stats.Buckets[3].Totals.Points != 5 {
+		t.Errorf("buckets = %d, today %+v, three days ago %+v", len(stats.Buckets), stats.Buckets[6].Totals, stats.Buckets[3].Totals)
+	}
+	if len(stats.Tasks) != 2 || stats.Tasks[0].TaskName != "Dishes" || stats.Tasks[0].Totals.Points != 15 {
+		t.Errorf("top task = %+v, want Dishes with 15 points", stats.Tasks[0])
+	}
+	if len(stats.Tags) != 1 || stats.Tags[0].Tag != "home" || stats.Tags[0].Totals.Completions != 3 {
+		t.Errorf("tags = %+v, want home with 3 completions", stats.Tags)
+	}
+}
+
+func TestGetStatsWhereDaylightSavingStartsAtMidnight(t *testing.T) {
+	// Clocks in Santiago went from 00:00 to 01:00 on 8 September 2024.
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := time.Local
+	time.Local = santiago
+	t.Cleanup(func() { time.Local = local })
+
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	alice := newTestUser(t, db, "alice")
+	task := newTestTask(t, db, alice.ID, &Task{Name: "Dishes", Points: 5})
+	for _, at := range []time.Time{
+		time.Date(2024, time.September, 7, 12, 0, 0, 0, santiago),
+		time.Date(2024, time.September, 8, 12, 0, 0, 0, santiago),
+		time.Date(2024, time.September, 10, 0, 30, 0, 0, santiago),
+	} {
+		completion, err := CompleteTask(ctx, db, alice.ID, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Conn.ExecContext(ctx, "UPDATE completions SET completed_at = ? WHERE id = ?", at, completion.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		period string
+		want   map[int]int
+	}{
+		// Daily buckets start on 1 September, weekly ones on Monday 26 August.
+		{"day", map[int]int{6: 1, 7: 1, 9: 1}},
+		{"week", map[int]int{1: 2, 2: 1}},
+	}
+	for _, test := range tests {
+		statsRange, err := parseStatsRange(url.Values{"period": {test.period}, "from": {"2024-09-01"}, "to": {"2024-09-14"}}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := GetStats(ctx, db, alice.ID, statsRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := map[int]int{}
+		for index, bucket := range stats.Buckets {
+			if bucket.Totals.Completions > 0 {
+				counts[index] = bucket.Totals.Completions
+			}
+		}
+		if fmt.Sprint(counts) != fmt.Sprint(test.want) {
+			t.Errorf("%s buckets = %v, want %v", test.period, counts, test.want)
+		}
+	}
+}
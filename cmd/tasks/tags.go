@@ -0,0 +1,441 @@
+// Tags (many-to-many) and projects (one per task) for organising tasks.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrProjectNotFound is wrapped by errors reporting a missing project.
+var ErrProjectNotFound = errors.New("project not found")
+
+type Project struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Tag struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	TaskCount int    `json:"task_count"`
+}
+
+// NormalizeTags trims, de-duplicates and sorts tag names, rejecting names
+// that cannot round-trip through the comma separated form field.
+func NormalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if strings.Contains(name, ",") {
+			return nil, &ValidationError{fmt.Sprintf("tag %q cannot contain a comma", name)}
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// ParseTagList splits the comma separated tags typed into a form.
+func ParseTagList(value string) ([]string, error) {
+	return NormalizeTags(strings.Split(value, ","))
+}
+
+// loadTaskTags fills in Tags for each task with a single query.
+func loadTaskTags(db *Database, tasks []*Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Task, len(tasks))
+	placeholders := make([]string, 0, len(tasks))
+	args := make([]any, 0, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+		placeholders = append(placeholders, "?")
+		args = append(args, task.ID)
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT task_tags.task_id, tags.name
+		FROM task_tags
+		JOIN tags ON tags.id = task_tags.tag_id
+		WHERE task_tags.task_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY tags.name`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID int
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return err
+		}
+		byID[taskID].Tags = append(byID[taskID].Tags, name)
+	}
+	return rows.Err()
+}
+
+// SetTaskTags replaces the tags of a task userID owns, directly or as the
+// owner of its group, creating tags as needed and removing tags that are no
+// longer used by any task.
+func SetTaskTags(ctx context.Context, db *Database, userID, taskID int, names []string) error {
+	names, err := NormalizeTags(names)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	if err := authorizeTask(ctx, transaction, userID, taskID, RoleOwner, "tag tasks"); err != nil {
+		return err
+	}
+	var exists int
+	err = transaction.QueryRowContext(ctx, "SELECT 1 FROM tasks WHERE id = ? AND deleted = 0", taskID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := setTaskTags(ctx, transaction, userID, taskID, names); err != nil {
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// setTaskTags replaces the tags of taskID within transaction, using
+// userID's tags. names must already be normalized.
+func setTaskTags(ctx context.Context, transaction *sql.Tx, userID, taskID int, names []string) error {
+	if _, err := transaction.ExecContext(ctx, "DELETE FROM task_tags WHERE task_id = ?", taskID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := transaction.ExecContext(ctx, "INSERT OR IGNORE INTO tags (user_id, name) VALUES (?, ?)", userID, name); err != nil {
+			return err
+		}
+		if _, err := transaction.ExecContext(ctx, `
+			INSERT INTO task_tags (task_id, tag_id)
+			SELECT ?, id FROM tags WHERE user_id = ? AND name = ?`, taskID, userID, name); err != nil {
+			return err
+		}
+	}
+	_, err := transaction.ExecContext(ctx, "DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM task_tags)")
+	return err
+}
+
+// GetTags lists userID's tags with the number of active tasks using each.
+func GetTags(db *Database, userID int) ([]*Tag, error) {
+	rows, err := db.Conn.Query(`
+		SELECT tags.id, tags.name, COUNT(task.id)
+		FROM tags
+		LEFT JOIN task_tags ON task_tags.tag_id = tags.id
+		LEFT JOIN tasks task ON task.id = task_tags.task_id AND task.deleted = 0
+		WHERE tags.user_id = ?
+		GROUP BY tags.id
+		ORDER BY tags.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		tag := &Tag{}
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.TaskCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func CreateProject(ctx context.Context, db *Database, userID int, name string) (*Project, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{"project name cannot be empty"}
+	}
+
+	project := &Project{Name: name, CreatedAt: time.Now()}
+	result, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO projects (user_id, name, created_at) VALUES (?, ?, ?)", userID, project.Name, project.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, &ValidationError{fmt.Sprintf("project %q already exists", name)}
+		}
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	project.ID = int(id)
+	return project, nil
+}
+
+func GetProjects(db *Database, userID int) ([]*Project, error) {
+	rows, err := db.Conn.Query("SELECT id, name, created_at FROM projects WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+	for rows.Next() {
+		project := &Project{}
+		if err := rows.Scan(&project.ID, &project.Name, &project.CreatedAt); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+// DeleteProject removes one of userID's projects. Its tasks are kept
+// without a project.
+func DeleteProject(ctx context.Context, db *Database, userID, projectID int) error {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.ExecContext(ctx, "DELETE FROM projects WHERE id = ? AND user_id = ?", projectID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrProjectNotFound, projectID)
+	}
+
+	if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET project_id = NULL WHERE project_id = ?", projectID); err != nil {
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// checkProjectExists reports an unknown project, or one owned by someone
+// else, as a validation error since it comes from user input on a task.
+func checkProjectExists(ctx context.Context, transaction *sql.Tx, userID, projectID int) error {
+	var exists int
+	err := transaction.QueryRowContext(ctx, "SELECT 1 FROM projects WHERE id = ? AND user_id = ?", projectID, userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return &ValidationError{fmt.Sprintf("project not found: %d", projectID)}
+	}
+	return err
+}
+
+// taskGroup is one project heading on the home page.
+type taskGroup struct {
+	Project *Project
+	Tasks   []*Task
+}
+
+// groupTasksByProject keeps task order within each group, lists projects
+// alphabetically and puts tasks without a project last.
+func groupTasksByProject(tasks []*Task, projects []*Project) []taskGroup {
+	byProject := make(map[int][]*Task)
+	for _, task := range tasks {
+		projectID := 0
+		if task.ProjectID != nil {
+			projectID = *task.ProjectID
+		}
+		byProject[projectID] = append(byProject[projectID], task)
+	}
+
+	var groups []taskGroup
+	for _, project := range projects {
+		if tasks := byProject[project.ID]; len(tasks) > 0 {
+			groups = append(groups, taskGroup{Project: project, Tasks: tasks})
+		}
+	}
+	if tasks := byProject[0]; len(tasks) > 0 {
+		groups = append(groups, taskGroup{Tasks: tasks})
+	}
+	return groups
+}
+
+// parseTaskFilter reads ?tag=, ?project=, ?archived= and the ordering
+// parameters from a request.
+func parseTaskFilter(request *http.Request) (TaskFilter, error) {
+	query := request.URL.Query()
+	filter := TaskFilter{Tag: strings.TrimSpace(query.Get("tag"))}
+	if value := query.Get("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, &ValidationError{"invalid archived: " + value}
+		}
+		filter.Archived = archived
+	}
+	if value := query.Get("project"); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, &ValidationError{"invalid project: " + value}
+		}
+		filter.ProjectID = projectID
+	}
+	return filter, parseTaskOrdering(query, &filter)
+}
+
+// parseProjectField reads a project select value, where "" means none.
+func parseProjectField(value string) (*int, error) {
+	return parseIDField("project", value)
+}
+
+// parseIDField reads an optional ID from a select, where "" means none.
+func parseIDField(label, value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, &ValidationError{fmt.Sprintf("invalid %s: %s", label, value)}
+	}
+	return &id, nil
+}
+
+func handleAddProject(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if _, err := CreateProject(request.Context(), appState.db, currentUserID(request), request.FormValue("name")); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// The project list is rendered into the page, so reload it.
+		writer.Header().Set("HX-Refresh", "true")
+		writer.Write([]byte(""))
+	}
+}
+
+func handleDeleteProject(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := DeleteProject(request.Context(), appState.db, currentUserID(request), projectID); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writer.Header().Set("HX-Refresh", "true")
+		writer.Write([]byte(""))
+	}
+}
+
+type projectRequest struct {
+	Name string `json:"name"`
+}
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func handleAPIListProjects(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projects, err := GetProjects(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, projects)
+	}
+}
+
+func handleAPICreateProject(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body projectRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		project, err := CreateProject(request.Context(), appState.db, currentUserID(request), body.Name)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, project)
+	}
+}
+
+func handleAPIDeleteProject(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := DeleteProject(request.Context(), appState.db, currentUserID(request), projectID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAPIListTags(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tags, err := GetTags(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, tags)
+	}
+}
+
+func handleAPISetTaskTags(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		var body tagsRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		userID := currentUserID(request)
+		if err := SetTaskTags(request.Context(), appState.db, userID, taskID, body.Tags); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		task, err := GetTask(appState.db, userID, taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, task)
+	}
+}
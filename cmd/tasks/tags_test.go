@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTagList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"home", "home"},
+		{" quick , home,quick,, ", "home quick"},
+		{"b,a,c", "a b c"},
+	}
+	for _, test := range tests {
+		got, err := ParseTagList(test.value)
+		if err != nil {
+			t.Fatalf("ParseTagList(%q): %v", test.value, err)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("ParseTagList(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+	if _, err := NormalizeTags([]string{"a,b"}); err == nil {
+		t.Error("NormalizeTags accepted a tag with a comma")
+	}
+}
+
+func TestTagsAndProjects(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+
+	kitchen, err := CreateProject(ctx, db, alice.ID, "Kitchen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validation *ValidationError
+	if _, err := CreateProject(ctx, db, alice.ID, " Kitchen "); !errors.As(err, &validation) {
+		t.Errorf("duplicate project: got %v, want a ValidationError", err)
+	}
+	if _, err := CreateProject(ctx, db, bob.ID, "Kitchen"); err != nil {
+		t.Errorf("project names are per user, but bob could not reuse Kitchen: %v", err)
+	}
+	if err := SaveNewTask(ctx, db, &Task{UserID: bob.ID, Name: "Borrowed", ProjectID: &kitchen.ID}); !errors.As(err, &validation) {
+		t.Errorf("using another user's project: got %v, want a ValidationError", err)
+	}
+
+	dishes := newTestTask(t, db, alice.ID, &Task{Name: "Dishes", ProjectID: &kitchen.ID, Tags: []string{"daily", "quick"}})
+	newTestTask(t, db, alice.ID, &Task{Name: "Laundry", Tags: []string{"weekly"}})
+	if err := SetTaskTags(ctx, db, alice.ID, dishes.ID, []string{"daily"}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(filter TaskFilter) string {
+		t.Helper()
+		tasks, err := GetTasks(db, alice.ID, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, task := range tasks {
+			names = append(names, task.Name+"["+strings.Join(task.Tags, ",")+"]")
+		}
+		return strings.Join(names, " ")
+	}
+	filters := []struct {
+		name   string
+		filter TaskFilter
+		want   string
+	}{
+		{"by tag", TaskFilter{Tag: "daily"}, "Dishes[daily]"},
+		{"by removed tag", TaskFilter{Tag: "quick"}, ""},
+		{"by project", TaskFilter{ProjectID: kitchen.ID}, "Dishes[daily]"},
+		{"by tag and project", TaskFilter{Tag: "weekly", ProjectID: kitchen.ID}, ""},
+	}
+	for _, test := range filters {
+		if got := names(test.filter); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	tags, err := GetTags(db, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tagNames []string
+	for _, tag := range tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+	if got := strings.Join(tagNames, " "); got != "daily weekly" {
+		t.Errorf("tags = %q, want the unused quick tag removed", got)
+	}
+
+	if err := DeleteProject(ctx, db, bob.ID, kitchen.ID); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("deleting another user's project: got %v, want ErrProjectNotFound", err)
+	}
+	if err := DeleteProject(ctx, db, alice.ID, kitchen.ID); err != nil {
+		t.Fatal(err)
+	}
+	task, err := GetTask(db, alice.ID, dishes.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ProjectID != nil {
+		t.Errorf("task kept project %d after it was deleted", *task.ProjectID)
+	}
+}
+
+func TestGroupTasksByProject(t *testing.T) {
+	garden, kitchen := &Project{ID: 2, Name: "Garden"}, &Project{ID: 1, Name: "Kitchen"}
+	tasks := []*Task{
+		{Name: "Loose"},
+		{Name: "Dishes", ProjectID: &kitchen.ID},
+		{Name: "Weeds", ProjectID: &garden.ID},
+		{Name: "Oven", ProjectID: &kitchen.ID},
+	}
+	var got []string
+	for _, group := range groupTasksByProject(tasks, []*Project{garden, kitchen}) {
+		heading := "none"
+		if group.Project != nil {
+			heading = group.Project.Name
+		}
+		for _, task := range group.Tasks {
+			got = append(got, heading+":"+task.Name)
+		}
+	}
+	if want := "Garden:Weeds Kitchen:Dishes Kitchen:Oven none:Loose"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+}
@@ -5,76 +5,252 @@ package main
 import (
 	"context"
 	"database/sql" // Add this import
+	"errors"
 	"fmt"
 	"time"
 )
 
+// ErrTaskNotFound is wrapped by every error reporting a missing or deleted task.
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrCompletionNotFound is wrapped by errors reporting a missing completion.
+var ErrCompletionNotFound = errors.New("completion not found")
+
+// ValidationError reports input that was rejected before touching the database.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
 type Task struct {
-	ID        int
-	Name      string
-	Points    int
-	Notes     string
-	CreatedAt time.Time
+	ID        int        `json:"id"`
+	// UserID is the owner of the task, or its creator for a group task.
+	UserID    int        `json:"user_id"`
+	// GroupID is set for tasks shared with a group; GroupName comes with it.
+	GroupID   *int       `json:"group_id"`
+	GroupName string     `json:"group_name,omitempty"`
+	Name      string     `json:"name"`
+	Points    int        `json:"points"`
+	Notes     string     `json:"notes"`
+	CreatedAt time.Time  `json:"created_at"`
+	// DeletedAt is only set for tasks in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ProjectID *int       `json:"project_id"`
+	Tags      []string   `json:"tags"`
+	DueAt     *time.Time `json:"due_at"`
+	Priority  Priority   `json:"priority"`
+	// Recurrence is the task's RRULE, or "" when it does not repeat.
+	Recurrence string `json:"recurrence"`
+	// MaxCompletions caps completions per CompletionPeriod; 0 is unlimited.
+	MaxCompletions   int              `json:"max_completions"`
+	CompletionPeriod CompletionPeriod `json:"completion_period"`
+	CooldownMinutes  int              `json:"cooldown_minutes"`
+	// OneShot tasks are archived by their first completion.
+	OneShot    bool       `json:"one_shot"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// RequiresApproval holds completions by group members for review.
+	RequiresApproval bool `json:"requires_approval"`
+	// AssigneeID is the only member who may complete the task, if set.
+	AssigneeID   *int   `json:"assignee_id"`
+	AssigneeName string `json:"assignee_name,omitempty"`
+}
+
+// TaskFilter narrows and orders GetTasks. Zero values match every task in
+// creation order.
+type TaskFilter struct {
+	Tag       string
+	ProjectID int
+	Due        DueView
+	Sort       TaskSort
+	Descending bool
+	// Archived lists archived tasks instead of active ones.
+	Archived bool
+}
+
+// taskColumns is selected by every query that builds a Task with scanTask.
+// Queries must alias the tasks table as "task".
+const taskColumns = `task.id, task.user_id, task.name, task.points, task.notes, task.created_at, task.deleted_at, task.project_id,
+	task.due_at, task.priority, task.recurrence, task.max_completions, task.completion_period, task.cooldown_minutes,
+	task.one_shot, task.archived_at, task.group_id, (SELECT name FROM task_groups WHERE task_groups.id = task.group_id),
+	task.requires_approval, task.assignee_id, (SELECT username FROM users WHERE users.id = task.assignee_id)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row rowScanner) (*Task, error) {
+	task := &Task{Tags: []string{}}
+	var deletedAt, dueAt, archivedAt sql.NullTime
+	var projectID, userID, groupID, assigneeID sql.NullInt64
+	var groupName, assigneeName sql.NullString
+	if err := row.Scan(&task.ID, &userID, &task.Name, &task.Points, &task.Notes, &task.CreatedAt, &deletedAt, &projectID,
+		&dueAt, &task.Priority, &task.Recurrence, &task.MaxCompletions, &task.CompletionPeriod, &task.CooldownMinutes,
+		&task.OneShot, &archivedAt, &groupID, &groupName, &task.RequiresApproval, &assigneeID, &assigneeName); err != nil {
+		return nil, err
+	}
+	task.UserID = int(userID.Int64)
+	if groupID.Valid {
+		id := int(groupID.Int64)
+		task.GroupID = &id
+		task.GroupName = groupName.String
+	}
+	if assigneeID.Valid {
+		id := int(assigneeID.Int64)
+		task.AssigneeID = &id
+		task.AssigneeName = assigneeName.String
+	}
+	if archivedAt.Valid {
+		task.ArchivedAt = &archivedAt.Time
+	}
+	if dueAt.Valid {
+		task.DueAt = &dueAt.Time
+	}
+	if deletedAt.Valid {
+		task.DeletedAt = &deletedAt.Time
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		task.ProjectID = &id
+	}
+	return task, nil
 }
 
 func (t *Task) Validate() error {
 	if t.Name == "" {
-		return fmt.Errorf("task name cannot be empty")
+		return &ValidationError{"task name cannot be empty"}
 	}
 	if t.Points < 0 {
-		return fmt.Errorf("points cannot be negative")
+		return &ValidationError{"points cannot be negative"}
 	}
-	return nil
+	if t.Priority < PriorityNone || t.Priority > PriorityHigh {
+		return &ValidationError{fmt.Sprintf("priority must be between %d and %d", PriorityNone, PriorityHigh)}
+	}
+	if t.DueAt != nil && t.DueAt.IsZero() {
+		return &ValidationError{"due date is invalid"}
+	}
+	return t.validateRules()
 }
 
-func AddTask(ctx context.Context, db *Database, name string, points *int, notes string) (*Task, error) {
+func AddTask(ctx context.Context, db *Database, userID int, name string, points *int, notes string) (*Task, error) {
 	pointsValue := 0
 	if points != nil {
 		pointsValue = *points
 	}
 
 	task := &Task{
-		Name:      name,
-		Points:    pointsValue,
-		Notes:     notes,
-		CreatedAt: time.Now(),
+		UserID: userID,
+		Name:   name,
+		Points: pointsValue,
+		Notes:  notes,
 	}
-
-	if err := task.Validate(); err != nil {
+	if err := SaveNewTask(ctx, db, task); err != nil {
 		return nil, err
 	}
+	return task, nil
+}
 
+// SaveNewTask validates and inserts task for task.UserID together with its
+// project and tags, filling in ID and CreatedAt. A task with a GroupID is
+// shared with that group, which only its owner may do.
+func SaveNewTask(ctx context.Context, db *Database, task *Task) error {
 	transaction, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer transaction.Rollback()
 
+	if err := insertTask(ctx, transaction, task); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+// insertTask is SaveNewTask inside a transaction the caller commits, for
+// callers that record more than the task itself.
+func insertTask(ctx context.Context, transaction *sql.Tx, task *Task) error {
+	task.CreatedAt = time.Now()
+	if task.Tags == nil {
+		task.Tags = []string{}
+	}
+
+	if err := task.Validate(); err != nil {
+		return err
+	}
+	tags, err := NormalizeTags(task.Tags)
+	if err != nil {
+		return err
+	}
+	task.Tags = tags
+	if task.Recurrence, err = NormalizeRecurrence(task.Recurrence); err != nil {
+		return err
+	}
+
+	if task.ProjectID != nil {
+		if err := checkProjectExists(ctx, transaction, task.UserID, *task.ProjectID); err != nil {
+			return err
+		}
+	}
+	if err := checkGroupField(ctx, transaction, task.UserID, task.GroupID); err != nil {
+		return err
+	}
+	if err := checkAssignee(ctx, transaction, task); err != nil {
+		return err
+	}
+
 	// Use prepared statements to prevent SQL injection
-	statement, err := transaction.PrepareContext(ctx, `INSERT INTO tasks (name, points, notes, created_at) VALUES (?, ?, ?, ?)`)
+	statement, err := transaction.PrepareContext(ctx, `
+		INSERT INTO tasks (user_id, name, points, notes, created_at, project_id, due_at, priority, recurrence,
+			max_completions, completion_period, cooldown_minutes, one_shot, group_id, requires_approval, assignee_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer statement.Close()
 
-	executionResult, err := statement.ExecContext(ctx, task.Name, task.Points, task.Notes, task.CreatedAt)
+	executionResult, err := statement.ExecContext(ctx, task.UserID, task.Name, task.Points, task.Notes, task.CreatedAt, task.ProjectID,
+		task.DueAt, task.Priority, task.Recurrence, task.MaxCompletions, task.CompletionPeriod, task.CooldownMinutes, task.OneShot,
+		task.GroupID, task.RequiresApproval, task.AssigneeID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	insertedID, err := executionResult.LastInsertId()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	task.ID = int(insertedID)
 
-	return task, transaction.Commit()
+	return setTaskTags(ctx, transaction, task.UserID, task.ID, task.Tags)
 }
 
-func GetTasks(db *Database) ([]*Task, error) {
+// GetTasks lists the active tasks userID can see, their own and their
+// groups', that match filter.
+func GetTasks(db *Database, userID int, filter TaskFilter) ([]*Task, error) {
+	visible, args := visibleTasksSQL(userID, RoleViewer)
 	// Only return non-deleted tasks
-	query := `SELECT id, name, points, notes, created_at FROM tasks WHERE deleted = 0`
-	rows, err := db.Conn.Query(query)
+	query := `SELECT ` + taskColumns + ` FROM tasks task WHERE task.deleted = 0 AND ` + visible
+	if filter.Archived {
+		query += ` AND task.archived_at IS NOT NULL`
+	} else {
+		query += ` AND task.archived_at IS NULL`
+	}
+	if filter.Tag != "" {
+		query += ` AND task.id IN (
+			SELECT task_tags.task_id FROM task_tags
+			JOIN tags ON tags.id = task_tags.tag_id
+			WHERE tags.name = ?)`
+		args = append(args, filter.Tag)
+	}
+	if filter.ProjectID != 0 {
+		query += ` AND task.project_id = ?`
+		args = append(args, filter.ProjectID)
+	}
+	query += ` ORDER BY task.id`
+
+	rows, err := db.Conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -82,64 +258,198 @@ func GetTasks(db *Database) ([]*Task, error) {
 
 	var tasks []*Task
 	for rows.Next() {
-		task := &Task{}
-		err := rows.Scan(&task.ID, &task.Name, &task.Points, &task.Notes, &task.CreatedAt)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
 	}
-	return tasks, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Due dates are compared in Go because the driver stores times as
+	// text that does not sort reliably across time zones.
+	tasks = filterTasksByDue(tasks, filter.Due, time.Now())
+	sortTasks(tasks, filter.Sort, filter.Descending)
+	return tasks, loadTaskTags(db, tasks)
 }
 
-func CompleteTask(ctx context.Context, db *Database, taskID int) error {
+// CompleteTask records a completion of one of userID's tasks, or of a
+// group task they are at least a member for, attributed to that user. The
+// completion is left pending when the task requires approval.
+func CompleteTask(ctx context.Context, db *Database, userID, taskID int) (*Completion, error) {
 	transaction, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer transaction.Rollback()
 
+	completion, err := recordCompletion(ctx, transaction, userID, taskID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return completion, transaction.Commit()
+}
+
+// backdateGrace is how far before now a completion may be dated and still
+// earn points; older ones are recorded for the history only, so that past
+// days cannot be filled in to collect points.
+const backdateGrace = 5 * time.Minute
+
+// recordCompletion records userID completing taskID at completedAt within
+// transaction, enforcing the task's rules, crediting its points and
+// moving a repeating task on once approved, and archiving a one-shot task.
+func recordCompletion(ctx context.Context, transaction *sql.Tx, userID, taskID int, completedAt time.Time) (*Completion, error) {
+	// Touch the row first so that this transaction holds the write lock
+	// before the completion rules read the history; concurrent completions
+	// of any task then run one after another.
+	if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET id = id WHERE id = ?", taskID); err != nil {
+		return nil, err
+	}
+	role, err := taskRole(ctx, transaction, userID, taskID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify task exists
-	var points int
-	err = transaction.QueryRowContext(ctx, "SELECT points FROM tasks WHERE id = ?", taskID).Scan(&points)
+	task, err := scanTask(transaction.QueryRowContext(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks task
+		WHERE task.id = ? AND task.deleted = 0`, taskID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("task not found: %d", taskID)
+			return nil, fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
 		}
-		return err
+		return nil, err
+	}
+	status, err := completionStatus(task, userID, role)
+	if err != nil {
+		return nil, err
+	}
+	completion := &Completion{TaskID: taskID, UserID: userID, CompletedAt: completedAt, Points: task.Points, TaskName: task.Name, Status: status}
+	if completedAt.Before(time.Now().Add(-backdateGrace)) {
+		completion.Points = 0
+	}
+	if err := checkCompletionRules(ctx, transaction, task, completion.CompletedAt); err != nil {
+		return nil, err
 	}
 
 	// Record completion
-	statement, err := transaction.PrepareContext(ctx, `INSERT INTO completions (task_id, completed_at, points) VALUES (?, ?, ?)`)
+	statement, err := transaction.PrepareContext(ctx, `INSERT INTO completions (task_id, user_id, completed_at, points, status) VALUES (?, ?, ?, ?, ?)`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer statement.Close()
 
-	_, err = statement.ExecContext(ctx, taskID, time.Now(), points)
+	result, err := statement.ExecContext(ctx, taskID, userID, completion.CompletedAt, completion.Points, completion.Status)
 	if err != nil {
+		return nil, err
+	}
+
+	completionID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	completion.ID = int(completionID)
+	if err := transaction.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&completion.Username); err != nil {
+		return nil, err
+	}
+	if completion.Status == StatusApproved {
+		if err := recordEarning(ctx, transaction, completion); err != nil {
+			return nil, err
+		}
+		if completion.NewBadges, err = awardBadges(ctx, transaction, userID); err != nil {
+			return nil, err
+		}
+		// A pending completion leaves the occurrence due until it is
+		// approved, so that rejecting it skips nothing.
+		if err := advanceRecurrence(ctx, transaction, task, completion); err != nil {
+			return nil, err
+		}
+	}
+
+	if task.OneShot {
+		if _, err := transaction.ExecContext(ctx, "UPDATE tasks SET archived_at = ? WHERE id = ?", completion.CompletedAt, taskID); err != nil {
+			return nil, err
+		}
+	}
+	return completion, nil
+}
+
+// advanceRecurrence moves a repeating task's due date to its next
+// occurrence after completion. A rule that has reached its UNTIL date is
+// removed, leaving an ordinary task without a due date.
+func advanceRecurrence(ctx context.Context, transaction *sql.Tx, task *Task, completion *Completion) error {
+	recurrence, err := ParseRecurrence(task.Recurrence)
+	if err != nil || recurrence == nil {
 		return err
 	}
 
-	return transaction.Commit()
+	next, ok, err := nextDueAt(recurrence, task.DueAt, completion.CompletedAt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		_, err = transaction.ExecContext(ctx, "UPDATE tasks SET due_at = NULL, recurrence = '' WHERE id = ?", task.ID)
+		return err
+	}
+
+	completion.NextDueAt = &next
+	_, err = transaction.ExecContext(ctx, "UPDATE tasks SET due_at = ? WHERE id = ?", next, task.ID)
+	return err
 }
 
-func GetCompletions(db *Database) ([]*Completion, error) {
+// completionTaskNameSQL labels completions of deleted tasks, and of purged
+// tasks whose name was kept on the completion row.
+const completionTaskNameSQL = `
+            CASE
+                WHEN task.id IS NULL THEN COALESCE(completion.task_name, 'unknown task') || ' (purged)'
+                WHEN task.deleted = 1 THEN task.name || ' (deleted)'
+                ELSE task.name
+            END`
+
+// completionColumns is selected by every query that builds a Completion
+// with scanCompletion. Queries must alias completions as "completion" and
+// left join tasks as "task".
+const completionColumns = `completion.id, completion.task_id, completion.user_id,
+            (SELECT username FROM users WHERE users.id = completion.user_id),
+            completion.completed_at, completion.points, ` + completionTaskNameSQL + `,
+            completion.status, completion.reviewed_by, completion.reviewed_at`
+
+func scanCompletion(row rowScanner) (*Completion, error) {
+    completion := &Completion{}
+    var userID, reviewedBy sql.NullInt64
+    var username sql.NullString
+    var reviewedAt sql.NullTime
+    if err := row.Scan(&completion.ID, &completion.TaskID, &userID, &username, &completion.CompletedAt,
+        &completion.Points, &completion.TaskName, &completion.Status, &reviewedBy, &reviewedAt); err != nil {
+        return nil, err
+    }
+    completion.CompletedAt = completion.CompletedAt.Local()
+    completion.UserID = int(userID.Int64)
+    completion.Username = username.String
+    if reviewedBy.Valid {
+        id := int(reviewedBy.Int64)
+        completion.ReviewedBy = &id
+    }
+    if reviewedAt.Valid {
+        completion.ReviewedAt = &reviewedAt.Time
+    }
+    return completion, nil
+}
+
+// GetCompletions lists the approved completions recorded by userID and
+// those of their groups' tasks, newest first.
+func GetCompletions(db *Database, userID int) ([]*Completion, error) {
     query := `
-        SELECT 
-            completion.id,
-            completion.task_id,
-            completion.completed_at,
-            completion.points,
-            CASE WHEN task.deleted = 1 
-                THEN task.name || ' (deleted)' 
-                ELSE task.name 
-            END as task_name
+        SELECT `+completionColumns+`
         FROM completions completion
         LEFT JOIN tasks task ON completion.task_id = task.id
-        ORDER BY completion.completed_at DESC
+        WHERE `+approvedCompletionsSQL+` AND `+visibleCompletionsSQL+`
+        ORDER BY julianday(completion.completed_at) DESC, completion.id DESC
     `
-    rows, err := db.Conn.Query(query)
+    rows, err := db.Conn.Query(query, userID, userID)
     if err != nil {
         return nil, err
     }
@@ -147,13 +457,7 @@ func GetCompletions(db *Database) ([]*Completion, error) {
 
     var completions []*Completion
     for rows.Next() {
-        completion := &Completion{}
-        err := rows.Scan(
-            &completion.ID,
-            &completion.TaskID,
-            &completion.CompletedAt,
-            &completion.Points,
-            &completion.TaskName)
+        completion, err := scanCompletion(rows)
         if err != nil {
             return nil, err
         }
@@ -162,16 +466,20 @@ func GetCompletions(db *Database) ([]*Completion, error) {
     return completions, nil
 }
 
-// ClearCompletions removes all task completion records from the database and updates tasks if needed
-func ClearCompletions(ctx context.Context, db *Database) error {
+// ClearCompletions removes all of userID's completion records and the
+// points they earned
+func ClearCompletions(ctx context.Context, db *Database, userID int) error {
 	transaction, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer transaction.Rollback()
 
+	if err := removeEarnings(ctx, transaction, "user_id = ?", userID); err != nil {
+		return err
+	}
 	// Clear the completions table
-	_, err = transaction.ExecContext(ctx, `DELETE FROM completions`)
+	_, err = transaction.ExecContext(ctx, `DELETE FROM completions WHERE user_id = ?`, userID)
 	if err != nil {
 		return err
 	}
@@ -179,15 +487,20 @@ func ClearCompletions(ctx context.Context, db *Database) error {
 	return transaction.Commit()
 }
 
-func DeleteTask(ctx context.Context, db *Database, taskID int) error {
+func DeleteTask(ctx context.Context, db *Database, userID, taskID int) error {
 	transaction, err := db.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer transaction.Rollback()
 
-	// Mark task as deleted instead of removing it
-	result, err := transaction.ExecContext(ctx, "UPDATE tasks SET deleted = 1 WHERE id = ?", taskID)
+	if err := authorizeTask(ctx, transaction, userID, taskID, RoleOwner, "delete tasks"); err != nil {
+		return err
+	}
+
+	// Mark task as deleted instead of removing it; it stays in the trash
+	// until restored or purged.
+	result, err := transaction.ExecContext(ctx, "UPDATE tasks SET deleted = 1, deleted_at = ? WHERE id = ? AND deleted = 0", time.Now(), taskID)
 	if err != nil {
 		return err
 	}
@@ -197,20 +510,61 @@ func DeleteTask(ctx context.Context, db *Database, taskID int) error {
 		return err
 	}
 	if rows == 0 {
-		return fmt.Errorf("task not found: %d", taskID)
+		return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
 	}
 
 	return transaction.Commit()
 }
 
-func DeleteCompletion(ctx context.Context, db *Database, completionID int) error {
-	_, err := db.Conn.ExecContext(ctx, "DELETE FROM completions WHERE id = ?", completionID)
-	return err
+// DeleteCompletion removes one of userID's completions, or anyone's
+// completion of a task in a group where userID is at least an admin. The
+// points it earned are taken back.
+func DeleteCompletion(ctx context.Context, db *Database, userID, completionID int) error {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	var completedBy int
+	var role sql.NullString
+	err = transaction.QueryRowContext(ctx, `
+		SELECT completion.user_id, member.role
+		FROM completions completion
+		LEFT JOIN tasks task ON task.id = completion.task_id
+		LEFT JOIN group_members member ON member.group_id = task.group_id AND member.user_id = ?
+		WHERE completion.id = ?`, userID, completionID).Scan(&completedBy, &role)
+	if err == sql.ErrNoRows || (err == nil && completedBy != userID && !role.Valid) {
+		return fmt.Errorf("%w: %d", ErrCompletionNotFound, completionID)
+	}
+	if err != nil {
+		return err
+	}
+	if completedBy != userID && !Role(role.String).atLeast(RoleAdmin) {
+		return permissionDenied(Role(role.String), "delete other people's completions")
+	}
+
+	if err := removeEarnings(ctx, transaction, "id = ?", completionID); err != nil {
+		return err
+	}
+	result, err := transaction.ExecContext(ctx, "DELETE FROM completions WHERE id = ?", completionID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrCompletionNotFound, completionID)
+	}
+	return transaction.Commit()
 }
 
 // Update function signature to match usage
-func CreateTask(db *Database, name string, points *int, notes string) error {
-    id, err := db.InsertTask(context.Background(), name, points, notes)
+func CreateTask(db *Database, userID int, name string, points *int, notes string) error {
+    id, err := db.InsertTask(context.Background(), userID, name, points, notes)
     if err != nil {
         return err
     }
@@ -218,13 +572,18 @@ func CreateTask(db *Database, name string, points *int, notes string) error {
     return nil
 }
 
-func UpdateTaskNotes(ctx context.Context, db *Database, taskID int, notes string) error {
+// UpdateTaskNotes replaces the notes of a task userID owns, directly or
+// through its group.
+func UpdateTaskNotes(ctx context.Context, db *Database, userID, taskID int, notes string) error {
     transaction, err := db.Conn.BeginTx(ctx, nil)
     if err != nil {
         return err
     }
     defer transaction.Rollback()
 
+    if err := authorizeTask(ctx, transaction, userID, taskID, RoleOwner, "edit tasks"); err != nil {
+        return err
+    }
     result, err := transaction.ExecContext(ctx, 
         "UPDATE tasks SET notes = ? WHERE id = ? AND deleted = 0", 
         notes, taskID)
@@ -237,16 +596,17 @@ func UpdateTaskNotes(ctx context.Context, db *Database, taskID int, notes string
         return err
     }
     if rows == 0 {
-        return fmt.Errorf("task not found: %d", taskID)
+        return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
     }
 
     return transaction.Commit()
 }
 
-// Add new function to update task points
-func UpdateTaskPoints(ctx context.Context, db *Database, taskID int, points int) error {
+// UpdateTaskPoints sets the points of a task userID owns, directly or
+// through its group.
+func UpdateTaskPoints(ctx context.Context, db *Database, userID, taskID int, points int) error {
     if points < 0 {
-        return fmt.Errorf("points cannot be negative")
+        return &ValidationError{"points cannot be negative"}
     }
 
     transaction, err := db.Conn.BeginTx(ctx, nil)
@@ -255,6 +615,9 @@ func UpdateTaskPoints(ctx context.Context, db *Database, taskID int, points int)
     }
     defer transaction.Rollback()
 
+    if err := authorizeTask(ctx, transaction, userID, taskID, RoleOwner, "edit tasks"); err != nil {
+        return err
+    }
     result, err := transaction.ExecContext(ctx, 
         "UPDATE tasks SET points = ? WHERE id = ? AND deleted = 0", 
         points, taskID)
@@ -267,45 +630,168 @@ func UpdateTaskPoints(ctx context.Context, db *Database, taskID int, points int)
         return err
     }
     if rows == 0 {
-        return fmt.Errorf("task not found: %d", taskID)
+        return fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
     }
 
     return transaction.Commit()
 }
 
-func GetTask(db *Database, taskID int) (*Task, error) {
-    task := &Task{}
-    err := db.Conn.QueryRow(`
-        SELECT id, name, points, notes, created_at 
-        FROM tasks 
-        WHERE id = ? AND deleted = 0`, taskID).Scan(
-        &task.ID, &task.Name, &task.Points, &task.Notes, &task.CreatedAt)
+// UpdateTask saves the editable fields of task, including its project and
+// group, after validating them. userID must own the task or its group.
+// Tags are saved separately with SetTaskTags.
+func UpdateTask(ctx context.Context, db *Database, userID int, task *Task) error {
+    transaction, err := db.Conn.BeginTx(ctx, nil)
     if err != nil {
-        return nil, err
+        return err
     }
-    return task, nil
+    defer transaction.Rollback()
+
+    if err := updateTask(ctx, transaction, userID, task); err != nil {
+        return err
+    }
+    return transaction.Commit()
 }
 
-func CreateCompletion(db *Database, taskID int, taskName string, points int, completedAt time.Time) (*Completion, error) {
-    result, err := db.Conn.Exec(`
-        INSERT INTO completions (task_id, completed_at, points)
-        VALUES (?, ?, ?)`,
+// UpdateTaskWithTags saves task like UpdateTask and replaces its tags
+// with task.Tags in the same transaction, so that a rejected tag leaves
+// the task unchanged too.
+func UpdateTaskWithTags(ctx context.Context, db *Database, userID int, task *Task) error {
+    tags, err := NormalizeTags(task.Tags)
+    if err != nil {
+        return err
+    }
 
-        taskID, completedAt, points)
+    transaction, err := db.Conn.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer transaction.Rollback()
+
+    if err := updateTask(ctx, transaction, userID, task); err != nil {
+        return err
+    }
+    if err := authorizeTask(ctx, transaction, userID, task.ID, RoleOwner, "tag tasks"); err != nil {
+        return err
+    }
+    if err := setTaskTags(ctx, transaction, userID, task.ID, tags); err != nil {
+        return err
+    }
+    task.Tags = tags
+    return transaction.Commit()
+}
+
+// updateTask is UpdateTask inside a transaction the caller commits.
+func updateTask(ctx context.Context, transaction *sql.Tx, userID int, task *Task) error {
+    if err := task.Validate(); err != nil {
+        return err
+    }
+    recurrence, err := NormalizeRecurrence(task.Recurrence)
+    if err != nil {
+        return err
+    }
+    task.Recurrence = recurrence
+
+    if err := authorizeTask(ctx, transaction, userID, task.ID, RoleOwner, "edit tasks"); err != nil {
+        return err
+    }
+    if task.ProjectID != nil {
+        if err := checkProjectExists(ctx, transaction, task.UserID, *task.ProjectID); err != nil {
+            return err
+        }
+    }
+    if err := checkGroupField(ctx, transaction, userID, task.GroupID); err != nil {
+        return err
+    }
+    if err := checkAssignee(ctx, transaction, task); err != nil {
+        return err
+    }
+
+    result, err := transaction.ExecContext(ctx, `
+        UPDATE tasks SET name = ?, points = ?, notes = ?, project_id = ?, due_at = ?, priority = ?, recurrence = ?,
+            max_completions = ?, completion_period = ?, cooldown_minutes = ?, one_shot = ?, group_id = ?,
+            requires_approval = ?, assignee_id = ?
+        WHERE id = ? AND deleted = 0`,
+        task.Name, task.Points, task.Notes, task.ProjectID, task.DueAt, task.Priority, task.Recurrence,
+        task.MaxCompletions, task.CompletionPeriod, task.CooldownMinutes, task.OneShot, task.GroupID,
+        task.RequiresApproval, task.AssigneeID, task.ID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return fmt.Errorf("%w: %d", ErrTaskNotFound, task.ID)
+    }
+    return nil
+}
+
+// GetTask returns a task userID can see, personal or shared.
+func GetTask(db *Database, userID, taskID int) (*Task, error) {
+    visible, args := visibleTasksSQL(userID, RoleViewer)
+    task, err := scanTask(db.Conn.QueryRow(`
+        SELECT `+taskColumns+`
+        FROM tasks task
+        WHERE task.id = ? AND task.deleted = 0 AND `+visible, append([]any{taskID}, args...)...))
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+    }
     if err != nil {
         return nil, err
     }
+    return task, loadTaskTags(db, []*Task{task})
+}
 
-    id, err := result.LastInsertId()
+// GetCompletion returns a single completion, whatever its status, with its
+// task name.
+func GetCompletion(db *Database, userID, completionID int) (*Completion, error) {
+    completion, err := scanCompletion(db.Conn.QueryRow(`
+        SELECT `+completionColumns+`
+        FROM completions completion
+        LEFT JOIN tasks task ON completion.task_id = task.id
+        WHERE completion.id = ? AND `+visibleCompletionsSQL, completionID, userID, userID))
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %d", ErrCompletionNotFound, completionID)
+    }
     if err != nil {
         return nil, err
     }
+    return completion, nil
+}
 
-    return &Completion{
-        ID:          int(id),
-        TaskID:      taskID,
-        CompletedAt: completedAt,
-        Points:      points,
-        TaskName:    taskName,
-    }, nil
+// CreateCompletion records userID completing a task at completedAt, such
+// as a completion they forgot to tick off. It follows the same rules as
+// CompleteTask, judged at completedAt, and earns nothing when back-dated
+// by more than backdateGrace.
+func CreateCompletion(ctx context.Context, db *Database, userID, taskID int, completedAt time.Time) (*Completion, error) {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	completion, err := createCompletion(ctx, transaction, userID, taskID, completedAt)
+	if err != nil {
+		return nil, err
+	}
+	return completion, transaction.Commit()
+}
+
+// createCompletion is CreateCompletion inside a transaction the caller
+// commits.
+func createCompletion(ctx context.Context, transaction *sql.Tx, userID, taskID int, completedAt time.Time) (*Completion, error) {
+	if err := checkCompletedAt(completedAt); err != nil {
+		return nil, err
+	}
+	return recordCompletion(ctx, transaction, userID, taskID, completedAt)
+}
+
+// checkCompletedAt refuses completions dated in the future.
+func checkCompletedAt(completedAt time.Time) error {
+	if completedAt.After(time.Now().Add(backdateGrace)) {
+		return &ValidationError{"completed_at cannot be in the future"}
+	}
+	return nil
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTaskEditsFollowGroupRoles(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	owner := newTestUser(t, db, "owner")
+	admin := newTestUser(t, db, "admin")
+	member := newTestUser(t, db, "member")
+	stranger := newTestUser(t, db, "stranger")
+	group := newTestGroup(t, db, owner, map[*User]Role{admin: RoleAdmin, member: RoleMember})
+	task := newTestTask(t, db, owner.ID, &Task{Name: "Dishes", Points: 5, GroupID: &group.ID})
+
+	edits := map[string]func(userID int) error{
+		"UpdateTaskNotes": func(userID int) error {
+			return UpdateTaskNotes(ctx, db, userID, task.ID, "rinse first")
+		},
+		"UpdateTaskPoints": func(userID int) error {
+			return UpdateTaskPoints(ctx, db, userID, task.ID, 7)
+		},
+		"UpdateTask": func(userID int) error {
+			edited := *task
+			edited.Name = "Dishes and pans"
+			return UpdateTask(ctx, db, userID, &edited)
+		},
+		"UpdateTaskWithTags": func(userID int) error {
+			edited := *task
+			edited.Tags = []string{"kitchen"}
+			return UpdateTaskWithTags(ctx, db, userID, &edited)
+		},
+	}
+	tests := []struct {
+		name     string
+		user     *User
+		allowed  bool
+		notFound bool
+	}{
+		{"group owner", owner, true, false},
+		{"admin", admin, false, false},
+		{"member", member, false, false},
+		{"outsider", stranger, false, true},
+	}
+	for editName, edit := range edits {
+		for _, test := range tests {
+			t.Run(editName+"/"+test.name, func(t *testing.T) {
+				err := edit(test.user.ID)
+				var permissionError *PermissionError
+				switch {
+				case test.allowed && err != nil:
+					t.Errorf("unexpected error: %v", err)
+				case test.notFound && !errors.Is(err, ErrTaskNotFound):
+					t.Errorf("got %v, want ErrTaskNotFound", err)
+				case !test.allowed && !test.notFound && !errors.As(err, &permissionError):
+					t.Errorf("got %v, want a permission error", err)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateTaskWithTagsIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	task := newTestTask(t, db, user.ID, &Task{Name: "Dishes", Points: 5})
+
+	edited := *task
+	edited.Name = "Dishes and pans"
+	edited.Tags = []string{"a,b"}
+	var validationError *ValidationError
+	if err := UpdateTaskWithTags(ctx, db, user.ID, &edited); !errors.As(err, &validationError) {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	saved, err := GetTask(db, user.ID, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "Dishes" {
+		t.Errorf("name = %q after a rejected tag, want Dishes", saved.Name)
+	}
+
+	edited.Tags = []string{"kitchen", " kitchen ", "evening"}
+	if err := UpdateTaskWithTags(ctx, db, user.ID, &edited); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = GetTask(db, user.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "Dishes and pans" || strings.Join(saved.Tags, ",") != "evening,kitchen" {
+		t.Errorf("got %q tagged %v", saved.Name, saved.Tags)
+	}
+}
+
+func TestTaskValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    Task
+		wantErr bool
+	}{
+		{"named", Task{Name: "Laundry", Points: 3}, false},
+		{"no name", Task{Points: 3}, true},
+		{"negative points", Task{Name: "Laundry", Points: -1}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.task.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
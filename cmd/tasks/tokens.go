@@ -0,0 +1,359 @@
+// Personal API tokens: long-lived, scoped credentials for scripts that
+// call the JSON API with `Authorization: Bearer`.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTokenNotFound is wrapped by errors reporting a missing API token.
+var ErrTokenNotFound = errors.New("API token not found")
+
+// TokenScope limits what an API token may do.
+type TokenScope string
+
+const (
+	// ScopeRead allows GET requests only.
+	ScopeRead TokenScope = "read"
+	// ScopeComplete allows completing tasks and nothing else.
+	ScopeComplete TokenScope = "complete"
+	// ScopeFull allows every API request except managing tokens.
+	ScopeFull TokenScope = "full"
+	// scopeNone marks routes that refuse API tokens altogether.
+	scopeNone TokenScope = "none"
+)
+
+func ParseTokenScope(value string) (TokenScope, error) {
+	switch scope := TokenScope(value); scope {
+	case ScopeRead, ScopeComplete, ScopeFull:
+		return scope, nil
+	}
+	return "", &ValidationError{fmt.Sprintf("invalid scope %q: use read, complete or full", value)}
+}
+
+// allows reports whether a token with scope s may call a route requiring
+// required.
+func (s TokenScope) allows(required TokenScope) bool {
+	if required == scopeNone {
+		return false
+	}
+	return s == ScopeFull || s == required
+}
+
+// tokenPrefix makes tokens recognisable in configs and secret scanners.
+const tokenPrefix = "tt_"
+
+// lastUsedInterval limits how often using a token is written back.
+const lastUsedInterval = time.Minute
+
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scope      TokenScope `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Token is the secret itself. It is only returned when the token is
+	// created; afterwards only its hash is kept.
+	Token string `json:"token,omitempty"`
+	// UserID is the owner the token acts as.
+	UserID int `json:"-"`
+}
+
+// CreateAPIToken issues a new token for userID. The returned token is the
+// only copy of the secret.
+func CreateAPIToken(ctx context.Context, db *Database, userID int, name string, scope TokenScope) (*APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{"token name cannot be empty"}
+	}
+	scope, err := ParseTokenScope(string(scope))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &APIToken{
+		Name:      name,
+		Scope:     scope,
+		CreatedAt: time.Now(),
+		Token:     tokenPrefix + secret,
+		UserID:    userID,
+	}
+	result, err := db.Conn.ExecContext(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		userID, token.Name, hashToken(token.Token), token.Scope, token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	token.ID = int(id)
+	return token, nil
+}
+
+// GetAPITokens lists userID's tokens, newest first, without their secrets.
+func GetAPITokens(db *Database, userID int) ([]*APIToken, error) {
+	rows, err := db.Conn.Query(`
+		SELECT id, name, scope, created_at, last_used_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*APIToken{}
+	for rows.Next() {
+		token := &APIToken{UserID: userID}
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &token.Scope, &token.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken deletes one of userID's tokens. Requests using it are
+// refused from then on.
+func RevokeAPIToken(ctx context.Context, db *Database, userID, tokenID int) error {
+	result, err := db.Conn.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrTokenNotFound, tokenID)
+	}
+	return nil
+}
+
+// AuthenticateAPIToken looks up the token presented by a request, with its
+// owner, and records that it was used. Unknown tokens return sql.ErrNoRows.
+func AuthenticateAPIToken(ctx context.Context, db *Database, secret string) (*APIToken, *User, error) {
+	token := &APIToken{}
+	user := &User{}
+	var lastUsedAt sql.NullTime
+	err := db.Conn.QueryRowContext(ctx, `
+		SELECT api_tokens.id, api_tokens.name, api_tokens.scope, api_tokens.created_at, api_tokens.last_used_at,
+			users.id, users.username, users.created_at
+		FROM api_tokens
+		JOIN users ON users.id = api_tokens.user_id
+		WHERE api_tokens.token_hash = ?`, hashToken(secret)).Scan(
+		&token.ID, &token.Name, &token.Scope, &token.CreatedAt, &lastUsedAt,
+		&user.ID, &user.Username, &user.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.UserID = user.ID
+
+	now := time.Now()
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) >= lastUsedInterval {
+		if _, err := db.Conn.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+			log.Printf("Failed to record API token use: %v", err)
+		}
+		lastUsedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	token.LastUsedAt = &lastUsedAt.Time
+	return token, user, nil
+}
+
+// bearerToken returns the token from an `Authorization: Bearer` header.
+func bearerToken(request *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+var tokensTemplate = template.Must(template.New("tokens").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>API tokens - Task Tracker</title>
+</head>
+<body>
+	<h1>API tokens</h1>
+	<p><a href="/">Back to tasks</a></p>
+	{{if .NewToken}}
+	<p>Copy the token for <strong>{{.NewToken.Name}}</strong> now; it will not be shown again:</p>
+	<pre>{{.NewToken.Token}}</pre>
+	{{if .FeedURL}}<p>Calendar apps can subscribe to your tasks and completions at <code>{{.FeedURL}}</code>.</p>{{end}}
+	{{end}}
+	<form method="post" action="/tokens">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<label>Name <input type="text" name="name" value="{{.Name}}" required></label>
+		<label>Scope
+			<select name="scope">
+				<option value="read">read only</option>
+				<option value="complete">complete tasks only</option>
+				<option value="full">full access</option>
+			</select>
+		</label>
+		<button type="submit">Create token</button>
+	</form>
+	{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+	<table>
+		<tr><th>Name</th><th>Scope</th><th>Created</th><th>Last used</th><th></th></tr>
+		{{range .Tokens}}
+		<tr>
+			<td>{{.Name}}</td>
+			<td>{{.Scope}}</td>
+			<td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+			<td>{{if .LastUsedAt}}{{formatTime .LastUsedAt}}{{else}}never{{end}}</td>
+			<td>
+				<form method="post" action="/tokens/{{.ID}}/revoke">
+					<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+					<button type="submit">Revoke</button>
+				</form>
+			</td>
+		</tr>
+		{{else}}
+		<tr><td colspan="5">No tokens yet.</td></tr>
+		{{end}}
+	</table>
+</body>
+</html>`))
+
+// tokensPage is the data rendered into the tokens page.
+type tokensPage struct {
+	CSRFToken string
+	Tokens    []*APIToken
+	NewToken  *APIToken
+	// FeedURL is the calendar feed address for a new read token.
+	FeedURL string
+	Name    string
+	Error   string
+}
+
+func renderTokensPage(appState *AppState, writer http.ResponseWriter, request *http.Request, status int, page tokensPage) {
+	session := sessionFromContext(request.Context())
+	tokens, err := GetAPITokens(appState.db, session.User.ID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.CSRFToken = session.CSRFToken
+	page.Tokens = tokens
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+	tokensTemplate.Execute(writer, page)
+}
+
+func handleTokensPage(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		renderTokensPage(appState, writer, request, http.StatusOK, tokensPage{})
+	}
+}
+
+// handleCreateToken shows the new token on the re-rendered page, the only
+// time it is visible.
+func handleCreateToken(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := request.FormValue("name")
+		token, err := CreateAPIToken(request.Context(), appState.db, currentUserID(request), name,
+			TokenScope(request.FormValue("scope")))
+		var validationError *ValidationError
+		if errors.As(err, &validationError) {
+			renderTokensPage(appState, writer, request, http.StatusUnprocessableEntity, tokensPage{Name: name, Error: err.Error()})
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page := tokensPage{NewToken: token}
+		if token.Scope == ScopeRead {
+			page.FeedURL = feedURL(request, token.Token)
+		}
+		renderTokensPage(appState, writer, request, http.StatusOK, page)
+	}
+}
+
+func handleRevokeToken(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tokenID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid token ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := RevokeAPIToken(request.Context(), appState.db, currentUserID(request), tokenID); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Redirect(writer, request, "/tokens", http.StatusSeeOther)
+	}
+}
+
+type tokenRequest struct {
+	Name  string     `json:"name"`
+	Scope TokenScope `json:"scope"`
+}
+
+func handleAPIListTokens(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tokens, err := GetAPITokens(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, tokens)
+	}
+}
+
+func handleAPICreateToken(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body tokenRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		token, err := CreateAPIToken(request.Context(), appState.db, currentUserID(request), body.Name, body.Scope)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, token)
+	}
+}
+
+func handleAPIRevokeToken(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tokenID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		if err := RevokeAPIToken(request.Context(), appState.db, currentUserID(request), tokenID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenScopes(t *testing.T) {
+	appState := newTestAppState(t)
+	user := newTestUser(t, appState.db, "alice")
+	task := newTestTask(t, appState.db, user.ID, &Task{})
+	tokens := map[TokenScope]string{}
+	for _, scope := range []TokenScope{ScopeRead, ScopeComplete, ScopeFull} {
+		tokens[scope] = newTestToken(t, appState.db, user, scope)
+	}
+	completePath := fmt.Sprintf("/api/v1/tasks/%d/complete", task.ID)
+
+	tests := []struct {
+		scope      TokenScope
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{ScopeRead, "GET", "/api/v1/tasks", "", http.StatusOK},
+		{ScopeRead, "POST", "/api/v1/tasks", `{"name": "Nope"}`, http.StatusForbidden},
+		{ScopeRead, "POST", completePath, "", http.StatusForbidden},
+		{ScopeComplete, "GET", "/api/v1/tasks", "", http.StatusForbidden},
+		{ScopeComplete, "POST", completePath, "", http.StatusCreated},
+		{ScopeComplete, "DELETE", "/api/v1/completions", "", http.StatusForbidden},
+		{ScopeFull, "POST", "/api/v1/tasks", `{"name": "Yes"}`, http.StatusCreated},
+		{ScopeFull, "GET", "/api/v1/tokens", "", http.StatusForbidden},
+		{ScopeFull, "POST", "/api/v1/tokens", `{"name": "more", "scope": "full"}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		recorder := serveAPI(appState, tokens[test.scope], test.method, test.path, test.body)
+		if recorder.Code != test.wantStatus {
+			t.Errorf("%s token, %s %s: got %d %s, want %d", test.scope, test.method, test.path, recorder.Code, recorder.Body, test.wantStatus)
+		}
+	}
+}
+
+func TestTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	appState := newTestAppState(t)
+	alice := newTestUser(t, appState.db, "alice")
+	bob := newTestUser(t, appState.db, "bob")
+	token, err := CreateAPIToken(ctx, appState.db, alice.ID, "cron", ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder := serveAPI(appState, token.Token, "GET", "/api/v1/tasks", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("got %d before revoking", recorder.Code)
+	}
+	listed, err := GetAPITokens(appState.db, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Token != "" || listed[0].LastUsedAt == nil {
+		t.Errorf("GetAPITokens = %+v, want one token without its secret and with a last use", listed)
+	}
+
+	if err := RevokeAPIToken(ctx, appState.db, bob.ID, token.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("revoking someone else's token: got %v, want ErrTokenNotFound", err)
+	}
+	if err := RevokeAPIToken(ctx, appState.db, alice.ID, token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serveAPI(appState, token.Token, "GET", "/api/v1/tasks", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got %d after revoking, want 401", recorder.Code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		wantOK bool
+	}{
+		{"Bearer tt_abc", "tt_abc", true},
+		{"bearer  tt_abc ", "tt_abc", true},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearer ", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+		request.Header.Set("Authorization", test.header)
+		got, ok := bearerToken(request)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("bearerToken(%q) = %q, %v; want %q, %v", test.header, got, ok, test.want, test.wantOK)
+		}
+	}
+}
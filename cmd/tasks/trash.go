@@ -0,0 +1,337 @@
+// Trash: listing, restoring and purging soft-deleted tasks.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PurgePolicy decides what happens to the completions of a purged task.
+type PurgePolicy string
+
+const (
+	// PurgeKeepCompletions keeps the history, labelled with the task's
+	// last name, so point totals do not change.
+	PurgeKeepCompletions PurgePolicy = "keep"
+	// PurgeDeleteCompletions removes the history along with the task.
+	PurgeDeleteCompletions PurgePolicy = "delete"
+)
+
+func ParsePurgePolicy(value string) (PurgePolicy, error) {
+	switch PurgePolicy(value) {
+	case PurgeKeepCompletions, PurgeDeleteCompletions:
+		return PurgePolicy(value), nil
+	}
+	return "", &ValidationError{fmt.Sprintf("unknown purge policy %q: use keep or delete", value)}
+}
+
+// GetDeletedTasks lists userID's trash, including deleted tasks of the
+// groups they own, most recently deleted first.
+func GetDeletedTasks(db *Database, userID int) ([]*Task, error) {
+	visible, args := visibleTasksSQL(userID, RoleOwner)
+	return queryDeletedTasks(db, "AND "+visible, args...)
+}
+
+// queryDeletedTasks lists deleted tasks matching an extra condition.
+func queryDeletedTasks(db *Database, condition string, args ...any) ([]*Task, error) {
+	rows, err := db.Conn.Query(`
+		SELECT `+taskColumns+`
+		FROM tasks task
+		WHERE task.deleted = 1 `+condition+`
+		ORDER BY julianday(task.deleted_at) DESC, task.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, loadTaskTags(db, tasks)
+}
+
+// RestoreTask moves one of userID's tasks, or of their groups, out of the
+// trash.
+func RestoreTask(ctx context.Context, db *Database, userID, taskID int) error {
+	if err := authorizeTask(ctx, db.Conn, userID, taskID, RoleOwner, "restore tasks"); err != nil {
+		return err
+	}
+	result, err := db.Conn.ExecContext(ctx,
+		"UPDATE tasks SET deleted = 0, deleted_at = NULL WHERE id = ? AND deleted = 1", taskID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w in trash: %d", ErrTaskNotFound, taskID)
+	}
+	return nil
+}
+
+// PurgeTask permanently removes one of userID's tasks, or of their groups,
+// that is already in the trash.
+func PurgeTask(ctx context.Context, db *Database, userID, taskID int, policy PurgePolicy) error {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	if err := authorizeTask(ctx, transaction, userID, taskID, RoleOwner, "purge tasks"); err != nil {
+		return err
+	}
+
+	if err := purgeTask(ctx, transaction, taskID, policy); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+func purgeTask(ctx context.Context, transaction *sql.Tx, taskID int, policy PurgePolicy) error {
+	var name string
+	err := transaction.QueryRowContext(ctx, "SELECT name FROM tasks WHERE id = ? AND deleted = 1", taskID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w in trash: %d", ErrTaskNotFound, taskID)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case PurgeDeleteCompletions:
+		if err = removeEarnings(ctx, transaction, "task_id = ?", taskID); err == nil {
+			_, err = transaction.ExecContext(ctx, "DELETE FROM completions WHERE task_id = ?", taskID)
+		}
+	default:
+		// Kept completions keep the name but no longer point at the task;
+		// task ID 0 marks them as completions of a purged task.
+		_, err = transaction.ExecContext(ctx, "UPDATE completions SET task_name = ?, task_id = 0 WHERE task_id = ?", name, taskID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Goals and badges for the task could never be met again.
+	for _, statement := range []string{
+		"DELETE FROM goals WHERE task_id = ?",
+		"DELETE FROM user_badges WHERE badge_id IN (SELECT id FROM badges WHERE task_id = ?)",
+		"DELETE FROM badges WHERE task_id = ?",
+		"DELETE FROM task_tags WHERE task_id = ?",
+	} {
+		if _, err := transaction.ExecContext(ctx, statement, taskID); err != nil {
+			return err
+		}
+	}
+	_, err = transaction.ExecContext(ctx, "DELETE FROM tasks WHERE id = ?", taskID)
+	return err
+}
+
+// PurgeExpiredTasks purges every task that has been in the trash longer
+// than retention, whoever owns it, and returns how many were removed.
+func PurgeExpiredTasks(ctx context.Context, db *Database, retention time.Duration, policy PurgePolicy) (int, error) {
+	tasks, err := queryDeletedTasks(db, "")
+	if err != nil {
+		return 0, err
+	}
+
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback()
+
+	cutoff := time.Now().Add(-retention)
+	purged := 0
+	for _, task := range tasks {
+		if task.DeletedAt == nil || task.DeletedAt.After(cutoff) {
+			continue
+		}
+		if err := purgeTask(ctx, transaction, task.ID, policy); err != nil {
+			return 0, err
+		}
+		purged++
+	}
+	return purged, transaction.Commit()
+}
+
+// runTrashPurger purges expired tasks now and then hourly. A zero
+// retention disables automatic purging.
+func runTrashPurger(ctx context.Context, appState *AppState) {
+	retention := appState.config.TrashRetention
+	if retention <= 0 {
+		return
+	}
+	policy := PurgePolicy(appState.config.TrashPurgePolicy)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		purged, err := PurgeExpiredTasks(ctx, appState.db, retention, policy)
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d task(s) from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var trashTemplate = template.Must(template.New("trash").Parse(`
+        <div id="trash">
+            {{range .}}
+            <div class="task">
+                {{.Name}} ({{.Points}} pts) - deleted {{if .DeletedAt}}{{.DeletedAt.Format "2006-01-02 15:04"}}{{else}}earlier{{end}}
+                <button hx-post="/trash/{{.ID}}/restore"
+                        hx-swap="none">Restore</button>
+                <button hx-delete="/trash/{{.ID}}?completions=keep"
+                        hx-swap="none"
+                        hx-confirm="Permanently delete {{.Name}}? Its completions are kept.">Purge</button>
+                <button hx-delete="/trash/{{.ID}}?completions=delete"
+                        hx-swap="none"
+                        hx-confirm="Permanently delete {{.Name}} and all of its completions?">Purge with history</button>
+            </div>
+            {{else}}
+            <p>The trash is empty.</p>
+            {{end}}
+        </div>`))
+
+func handleTrash(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tasks, err := GetDeletedTasks(appState.db, currentUserID(request))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		trashTemplate.Execute(writer, tasks)
+	}
+}
+
+func handleRestoreTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := RestoreTask(request.Context(), appState.db, currentUserID(request), taskID); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+func handlePurgeTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, err := strconv.Atoi(request.PathValue("id"))
+		if err != nil {
+			http.Error(writer, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+		policy, err := ParsePurgePolicy(request.URL.Query().Get("completions"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := PurgeTask(request.Context(), appState.db, currentUserID(request), taskID, policy); err != nil {
+			http.Error(writer, err.Error(), errorStatus(err))
+			return
+		}
+
+		writer.Header().Set("HX-Trigger", "taskChange")
+		writer.Write([]byte(""))
+	}
+}
+
+func handleAPIListTrash(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		tasks, err := GetDeletedTasks(appState.db, currentUserID(request))
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		if tasks == nil {
+			tasks = []*Task{}
+		}
+		writeJSON(writer, http.StatusOK, tasks)
+	}
+}
+
+func handleAPIRestoreTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		userID := currentUserID(request)
+		if err := RestoreTask(request.Context(), appState.db, userID, taskID); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+
+		task, err := GetTask(appState.db, userID, taskID)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, task)
+	}
+}
+
+// handleAPIPurgeTask defaults to keeping completions when ?completions= is
+// omitted, matching the automatic purge default.
+func handleAPIPurgeTask(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		taskID, ok := pathID(writer, request)
+		if !ok {
+			return
+		}
+
+		policy := PurgeKeepCompletions
+		if value := request.URL.Query().Get("completions"); value != "" {
+			parsed, err := ParsePurgePolicy(value)
+			if err != nil {
+				writeAPIErrorFrom(writer, err)
+				return
+			}
+			policy = parsed
+		}
+
+		if err := PurgeTask(request.Context(), appState.db, currentUserID(request), taskID, policy); err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrashLifecycle(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+	countCompletions := func(completionID int) (count int, taskName string) {
+		t.Helper()
+		if err := db.Conn.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(task_name), '') FROM completions WHERE id = ? AND task_id = 0",
+			completionID).Scan(&count, &taskName); err != nil {
+			t.Fatal(err)
+		}
+		return count, taskName
+	}
+	references := func(taskID int) (count int) {
+		t.Helper()
+		if err := db.Conn.QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM completions WHERE task_id = ?) + (SELECT COUNT(*) FROM goals WHERE task_id = ?)
+				+ (SELECT COUNT(*) FROM badges WHERE task_id = ?)`, taskID, taskID, taskID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	inTrash := func(taskID int) bool {
+		t.Helper()
+		tasks, err := GetDeletedTasks(db, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			if task.ID == taskID {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name           string
+		policy         PurgePolicy
+		wantKept       int
+		wantKeptByName string
+	}{
+		{"keep completions", PurgeKeepCompletions, 1, "Sweep"},
+		{"delete completions", PurgeDeleteCompletions, 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := newTestTask(t, db, user.ID, &Task{Name: "Sweep"})
+			completion, err := CompleteTask(ctx, db, user.ID, task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateGoal(ctx, db, user.ID, &Goal{Kind: GoalCompletions, Target: 3, Period: StatsWeek, TaskID: &task.ID}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateBadge(ctx, db, user.ID, &Badge{Name: "Sweeper", Kind: BadgeCompletions, Threshold: 1, TaskID: &task.ID}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := PurgeTask(ctx, db, user.ID, task.ID, test.policy); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("purging a task outside the trash: got %v, want ErrTaskNotFound", err)
+			}
+			if err := DeleteTask(ctx, db, user.ID, task.ID); err != nil {
+				t.Fatal(err)
+			}
+			if !inTrash(task.ID) {
+				t.Fatal("deleted task is not in the trash")
+			}
+			if err := RestoreTask(ctx, db, user.ID, task.ID); err != nil {
+				t.Fatal(err)
+			}
+			if inTrash(task.ID) {
+				t.Fatal("restored task is still in the trash")
+			}
+			if err := RestoreTask(ctx, db, user.ID, task.ID); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("restoring twice: got %v, want ErrTaskNotFound", err)
+			}
+
+			if err := DeleteTask(ctx, db, user.ID, task.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := PurgeTask(ctx, db, user.ID, task.ID, test.policy); err != nil {
+				t.Fatal(err)
+			}
+			if inTrash(task.ID) {
+				t.Error("purged task is still in the trash")
+			}
+			if _, err := GetTask(db, user.ID, task.ID); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("GetTask after purging: got %v, want ErrTaskNotFound", err)
+			}
+			if count, name := countCompletions(completion.ID); count != test.wantKept || name != test.wantKeptByName {
+				t.Errorf("%d completions named %q left, want %d named %q", count, name, test.wantKept, test.wantKeptByName)
+			}
+			if count := references(task.ID); count != 0 {
+				t.Errorf("%d completions, goals and badges still refer to the purged task", count)
+			}
+			goals, err := GetGoalProgress(ctx, db, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(goals) != 0 {
+				t.Errorf("%d goals left for the purged task", len(goals))
+			}
+		})
+	}
+}
+
+func TestPurgeExpiredTasks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	user := newTestUser(t, db, "alice")
+
+	ages := []time.Duration{time.Hour, 23 * time.Hour, 25 * time.Hour, 30 * 24 * time.Hour}
+	for _, age := range ages {
+		task := newTestTask(t, db, user.ID, &Task{})
+		if err := DeleteTask(ctx, db, user.ID, task.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Conn.ExecContext(ctx, "UPDATE tasks SET deleted_at = ? WHERE id = ?", time.Now().Add(-age), task.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := PurgeExpiredTasks(ctx, db, 24*time.Hour, PurgeKeepCompletions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("purged %d tasks, want the 2 deleted over a day ago", purged)
+	}
+	left, err := GetDeletedTasks(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 {
+		t.Errorf("%d tasks left in the trash, want 2", len(left))
+	}
+}
+
+func TestParsePurgePolicy(t *testing.T) {
+	for value, wantErr := range map[string]bool{"keep": false, "delete": false, "": true, "shred": true} {
+		if _, err := ParsePurgePolicy(value); (err != nil) != wantErr {
+			t.Errorf("ParsePurgePolicy(%q) error = %v, want error %v", value, err, wantErr)
+		}
+	}
+}
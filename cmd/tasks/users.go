@@ -0,0 +1,304 @@
+// User accounts: registration, password hashing and the request's current
+// user.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrInvalidCredentials is returned for an unknown username or a wrong
+// password, without saying which.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// passwordIterations is the PBKDF2-SHA256 work factor for new hashes.
+// Stored hashes record their own count, so raising it only affects
+// passwords set afterwards.
+var passwordIterations = 600_000
+
+const minPasswordLength = 8
+
+// hashPassword returns "pbkdf2-sha256$iterations$salt$key" with a random
+// salt, all base64 encoded.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, passwordIterations, sha256.Size, sha256.New)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword reports whether password matches a hash from hashPassword.
+func verifyPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// validateUsername allows 3 to 32 letters, digits, dots, dashes and
+// underscores so names are safe in URLs and logs.
+func validateUsername(username string) error {
+	if len(username) < 3 || len(username) > 32 {
+		return &ValidationError{"username must be 3 to 32 characters"}
+	}
+	for _, character := range username {
+		switch {
+		case character >= 'a' && character <= 'z', character >= 'A' && character <= 'Z',
+			character >= '0' && character <= '9', character == '.', character == '-', character == '_':
+		default:
+			return &ValidationError{"username may only contain letters, digits, '.', '-' and '_'"}
+		}
+	}
+	return nil
+}
+
+// CreateUser registers a new account.
+func CreateUser(ctx context.Context, db *Database, username, password string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if len(password) < minPasswordLength {
+		return nil, &ValidationError{fmt.Sprintf("password must be at least %d characters", minPasswordLength)}
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Username: username, CreatedAt: time.Now()}
+	result, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)", user.Username, hash, user.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, &ValidationError{fmt.Sprintf("username %q is taken", username)}
+		}
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	user.ID = int(id)
+	return user, nil
+}
+
+// legacyTables hold rows recorded before accounts existed, which have no
+// user_id until an account claims them.
+var legacyTables = []string{"tasks", "completions", "projects", "tags", "point_entries"}
+
+// ClaimLegacyData gives userID everything recorded before accounts were
+// introduced, returning how many rows of each table it took. This is an
+// explicit step, run from the command line by whoever runs the server, so
+// that registering first on an upgraded install does not hand over the
+// old data.
+func ClaimLegacyData(ctx context.Context, db *Database, userID int) (map[string]int64, error) {
+	transaction, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	claimed := make(map[string]int64)
+	for _, table := range legacyTables {
+		result, err := transaction.ExecContext(ctx, "UPDATE "+table+" SET user_id = ? WHERE user_id IS NULL", userID)
+		if err != nil {
+			return nil, err
+		}
+		if claimed[table], err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+	return claimed, transaction.Commit()
+}
+
+// ErrRegistrationClosed is returned when the registration setting is off.
+var ErrRegistrationClosed = errors.New("registration is closed: ask whoever runs this server for an account")
+
+// AuthenticateUser checks a username and password.
+func AuthenticateUser(ctx context.Context, db *Database, username, password string) (*User, error) {
+	user := &User{}
+	var hash string
+	err := db.Conn.QueryRowContext(ctx,
+		"SELECT id, username, password_hash, created_at FROM users WHERE username = ? COLLATE NOCASE", username).Scan(
+		&user.ID, &user.Username, &hash, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Spend the same time as a real check so response times do not
+		// reveal which usernames exist.
+		verifyPassword(password, dummyPasswordHash())
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyPassword(password, hash) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("not a real password")
+	return hash
+})
+
+func GetUser(db *Database, userID int) (*User, error) {
+	user := &User{}
+	err := db.Conn.QueryRow("SELECT id, username, created_at FROM users WHERE id = ?", userID).Scan(
+		&user.ID, &user.Username, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+type userContextKey struct{}
+
+func contextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// userFromContext returns the authenticated user. Handlers behind
+// requireUser can rely on it being set.
+func userFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey{}).(*User)
+	return user
+}
+
+// currentUserID is shorthand for the authenticated user's ID.
+func currentUserID(request *http.Request) int {
+	if user := userFromContext(request.Context()); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+var registerTemplate = template.Must(template.New("register").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>Register - Task Tracker</title>
+</head>
+<body>
+	<h1>Create an account</h1>
+	{{if not .Closed}}
+	<form method="post" action="/register">
+		<label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" required></label>
+		<label>Password <input type="password" name="password" autocomplete="new-password" required></label>
+		<button type="submit">Register</button>
+	</form>
+	{{end}}
+	{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+	<p>Already registered? <a href="/">Sign in</a>.</p>
+</body>
+</html>`))
+
+func handleRegisterPage(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if !appState.config.Registration {
+			registerTemplate.Execute(writer, map[string]string{"Closed": "true", "Error": ErrRegistrationClosed.Error()})
+			return
+		}
+		registerTemplate.Execute(writer, map[string]string{})
+	}
+}
+
+func handleRegister(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !appState.config.Registration {
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			writer.WriteHeader(http.StatusForbidden)
+			registerTemplate.Execute(writer, map[string]string{"Closed": "true", "Error": ErrRegistrationClosed.Error()})
+			return
+		}
+		username := request.FormValue("username")
+		user, err := CreateUser(request.Context(), appState.db, username, request.FormValue("password"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			var validationError *ValidationError
+			if errors.As(err, &validationError) {
+				status = http.StatusUnprocessableEntity
+			}
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			writer.WriteHeader(status)
+			registerTemplate.Execute(writer, map[string]string{"Username": username, "Error": err.Error()})
+			return
+		}
+
+		// Sign the new account straight in.
+		if err := signIn(appState, writer, request, user); err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+	}
+}
+
+type registerRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func handleAPIRegister(appState *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !appState.config.Registration {
+			writeAPIError(writer, http.StatusForbidden, "registration_closed", ErrRegistrationClosed.Error())
+			return
+		}
+		var body registerRequest
+		if !decodeJSONBody(writer, request, &body) {
+			return
+		}
+
+		user, err := CreateUser(request.Context(), appState.db, body.Username, body.Password)
+		if err != nil {
+			writeAPIErrorFrom(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, user)
+	}
+}
+
+func handleAPICurrentUser(_ *AppState) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, http.StatusOK, userFromContext(request.Context()))
+	}
+}
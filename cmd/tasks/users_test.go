@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateUserValidation(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	newTestUser(t, db, "alice")
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"valid", "bob", "correct horse", false},
+		{"surrounding spaces are trimmed", "  carol ", "correct horse", false},
+		{"short password", "dave", "short", true},
+		{"short username", "ed", "correct horse", true},
+		{"invalid characters", "eve smith", "correct horse", true},
+		{"taken", "alice", "correct horse", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CreateUser(ctx, db, test.username, test.password)
+			if (err != nil) != test.wantErr {
+				t.Errorf("CreateUser(%q) error = %v, want error %v", test.username, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     bool
+	}{
+		{"new hash", "correct horse", hash, true},
+		{"wrong password", "correct horse!", hash, false},
+		// PBKDF2-HMAC-SHA256 of "passwd" salted with "salt", one iteration
+		// (RFC 7914, section 11), so hashes stored before keep verifying.
+		{"known answer", "passwd", "pbkdf2-sha256$1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLxJypzM8Xm2RZkWZLOdd+8xfHG4RbHjC9UJESBB06GXgw", true},
+		{"other algorithm", "passwd", "bcrypt$1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLw", false},
+		{"no iterations", "passwd", "pbkdf2-sha256$0$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLw", false},
+	}
+	for _, test := range tests {
+		if got := verifyPassword(test.password, test.hash); got != test.want {
+			t.Errorf("%s: verifyPassword = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLegacyDataIsOnlyClaimedExplicitly(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	if _, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO tasks (name, points, notes, created_at) VALUES ('Old task', 3, '', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	countOwned := func(userID int) int {
+		var count int
+		if err := db.Conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM tasks WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	first := newTestUser(t, db, "first")
+	if owned := countOwned(first.ID); owned != 0 {
+		t.Fatalf("registering took %d legacy tasks", owned)
+	}
+
+	owner := newTestUser(t, db, "owner")
+	claimed, err := ClaimLegacyData(ctx, db, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed["tasks"] != 1 || countOwned(owner.ID) != 1 {
+		t.Errorf("claimed %v, owner has %d tasks; want the one legacy task", claimed, countOwned(owner.ID))
+	}
+	if claimed, err = ClaimLegacyData(ctx, db, first.ID); err != nil || claimed["tasks"] != 0 {
+		t.Errorf("second claim took %v (%v), want nothing left", claimed, err)
+	}
+}
+
+func TestRegistrationSetting(t *testing.T) {
+	tests := []struct {
+		name         string
+		registration bool
+		method       string
+		path         string
+		contentType  string
+		body         string
+		wantStatus   int
+	}{
+		{"form open", true, "POST", "/register", "application/x-www-form-urlencoded", "username=alice&password=correct+horse", http.StatusSeeOther},
+		{"form closed", false, "POST", "/register", "application/x-www-form-urlencoded", "username=alice&password=correct+horse", http.StatusForbidden},
+		{"API open", true, "POST", "/api/v1/users", "application/json", `{"username":"alice","password":"correct horse"}`, http.StatusCreated},
+		{"API closed", false, "POST", "/api/v1/users", "application/json", `{"username":"alice","password":"correct horse"}`, http.StatusForbidden},
+		{"page closed", false, "GET", "/register", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Registration = test.registration
+			appState := &AppState{config: config, db: newTestDatabase(t)}
+
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			newRouter(appState).ServeHTTP(recorder, request)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body)
+			}
+			if !test.registration && strings.Contains(recorder.Body.String(), `action="/register"`) {
+				t.Error("the closed registration page still shows the form")
+			}
+		})
+	}
+}